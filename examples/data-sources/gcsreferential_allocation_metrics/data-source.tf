@@ -0,0 +1,5 @@
+data "gcsreferential_allocation_metrics" "billing" {}
+
+output "total_allocations" {
+  value = data.gcsreferential_allocation_metrics.billing.total_allocations
+}
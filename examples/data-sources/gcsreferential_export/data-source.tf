@@ -0,0 +1,8 @@
+data "gcsreferential_export" "example" {
+  pools = ["examplepoolmaarc"]
+}
+
+resource "local_file" "backup" {
+  filename = "referential-backup.json"
+  content  = data.gcsreferential_export.example.content
+}
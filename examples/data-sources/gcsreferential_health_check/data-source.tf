@@ -0,0 +1,5 @@
+data "gcsreferential_health_check" "preflight" {}
+
+output "referential_healthy" {
+  value = data.gcsreferential_health_check.preflight.healthy
+}
@@ -0,0 +1,10 @@
+data "gcsreferential_id_pool_members" "payments" {
+  name = "examplepoolmaarc"
+  match_metadata = {
+    team = "payments"
+  }
+}
+
+output "payments_ids" {
+  value = { for member in data.gcsreferential_id_pool_members.payments.members : member.name => member.value }
+}
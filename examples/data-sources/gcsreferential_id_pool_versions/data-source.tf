@@ -0,0 +1,8 @@
+data "gcsreferential_id_pool_versions" "example" {
+  name  = "examplepoolmaarc"
+  limit = 5
+}
+
+output "previous_generation" {
+  value = data.gcsreferential_id_pool_versions.example.versions[1].generation
+}
@@ -0,0 +1,11 @@
+data "gcsreferential_network" "example" {
+  base_cidr = "10.5.0.0/16"
+}
+
+resource "google_compute_route" "reserved" {
+  for_each    = toset(data.gcsreferential_network.example.reserved_cidrs)
+  name        = "to-${replace(replace(each.value, ".", "-"), "/", "-")}"
+  network     = "default"
+  dest_range  = each.value
+  next_hop_ip = "10.0.0.2"
+}
@@ -0,0 +1,9 @@
+data "gcsreferential_network_free_subnets" "example" {
+  base_cidr     = "10.20.0.0/16"
+  prefix_length = 24
+  limit         = 10
+}
+
+output "free_subnets" {
+  value = data.gcsreferential_network_free_subnets.example.free_subnets
+}
@@ -0,0 +1,8 @@
+data "gcsreferential_network_owner" "example" {
+  base_cidr = "10.20.0.0/16"
+  netmask   = "10.20.3.0/24"
+}
+
+output "owner" {
+  value = data.gcsreferential_network_owner.example.id
+}
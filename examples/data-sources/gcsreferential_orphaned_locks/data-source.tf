@@ -0,0 +1,7 @@
+data "gcsreferential_orphaned_locks" "example" {
+  older_than_minutes = 60
+}
+
+output "orphaned_locks" {
+  value = [for lock in data.gcsreferential_orphaned_locks.example.locks : "${lock.path} (${lock.owner}, ${lock.age_minutes} minutes)"]
+}
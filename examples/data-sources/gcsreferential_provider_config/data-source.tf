@@ -0,0 +1,9 @@
+data "gcsreferential_provider_config" "example" {}
+
+output "referential" {
+  value = {
+    bucket             = data.gcsreferential_provider_config.example.referential_bucket
+    lock_bucket        = data.gcsreferential_provider_config.example.lock_bucket
+    credentials_source = data.gcsreferential_provider_config.example.credentials_source
+  }
+}
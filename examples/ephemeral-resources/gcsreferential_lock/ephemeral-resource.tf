@@ -0,0 +1,12 @@
+ephemeral "gcsreferential_lock" "db_migration" {
+  name = "db-migration"
+}
+
+resource "terraform_data" "migration" {
+  provisioner "local-exec" {
+    command = "./migrate.sh"
+    environment = {
+      LOCK_ID = ephemeral.gcsreferential_lock.db_migration.lock_id
+    }
+  }
+}
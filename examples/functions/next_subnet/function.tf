@@ -0,0 +1,7 @@
+locals {
+  reserved = ["10.5.0.0/24", "10.5.1.0/26"]
+}
+
+output "next_subnet" {
+  value = provider::gcsreferential::next_subnet("10.5.0.0/16", 24, local.reserved)
+}
@@ -0,0 +1,3 @@
+output "port_8080_used" {
+  value = provider::gcsreferential::pool_value_used("my-referential-bucket", "ports", 8080)
+}
@@ -3,3 +3,30 @@ resource "gcsreferential_id_pool" "example" {
   start_from = each.value.start
   end_to     = each.value.end
 }
+resource "gcsreferential_id_pool" "legacy" {
+  name       = "legacy-descending"
+  start_from = 1
+  end_to     = 9999
+  direction  = "desc"
+}
+resource "gcsreferential_id_pool" "migrated" {
+  name       = "migrated-from-ipam"
+  start_from = 1
+  end_to     = 4096
+  import_members_json = jsonencode({
+    "svc-api"    = 12
+    "svc-worker" = 13
+  })
+}
+
+# Changing name renames the pool object in place, the id_request referencing
+# the name follow it and keep their id.
+resource "gcsreferential_id_pool" "vlans" {
+  name       = "vlans-eu"
+  start_from = 100
+  end_to     = 4000
+}
+resource "gcsreferential_id_request" "vlan" {
+  id   = "frontend"
+  pool = gcsreferential_id_pool.vlans.name
+}
@@ -0,0 +1,9 @@
+# Declares every reservation of the pool in one place, the other members of the pool are released.
+resource "gcsreferential_id_pool_reservations" "vlans" {
+  pool = "platform-vlans"
+  members = {
+    "payments" = { preferred_value = 120 }
+    "billing"  = {}
+    "search"   = {}
+  }
+}
@@ -12,3 +12,15 @@ resource "gcsreferential_id_request" "example2" {
   pool     = gcsreferential_id_pool.example.name
   id       = each.key
 }
+resource "gcsreferential_id_request" "with_overflow" {
+  pools = ["primary-pool", "overflow-pool"]
+  id    = "maarc-overflow"
+}
+resource "gcsreferential_id_request" "best_effort" {
+  pool          = gcsreferential_id_pool.example.name
+  id            = "maarc-best-effort"
+  on_exhaustion = "skip"
+}
+output "best_effort_id" {
+  value = gcsreferential_id_request.best_effort.requested_id != null ? gcsreferential_id_request.best_effort.requested_id : "none"
+}
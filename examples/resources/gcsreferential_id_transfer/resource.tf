@@ -0,0 +1,6 @@
+# Moves the reservation of "payments" from the legacy pool to the platform pool, keeping its id.
+resource "gcsreferential_id_transfer" "payments" {
+  id        = "payments"
+  from_pool = "legacy-vlans"
+  to_pool   = "platform-vlans"
+}
@@ -15,3 +15,35 @@ resource "gcsreferential_network_request" "network_request2" {
   base_cidr     = "10.6.0.0/18"
   id            = "test"
 }
+
+# The /24 starts a /20 held by this network_request, the rest of the /20 is kept for the zone.
+resource "gcsreferential_network_request" "zone_a" {
+  prefix_length    = 24
+  alignment_prefix = 20
+  base_cidr        = "10.5.0.0/16"
+  id               = "zone-a"
+}
+
+# Four /24 forming a single aligned /22 that can be summarized in routing.
+resource "gcsreferential_network_request" "team" {
+  prefix_length = 24
+  subnet_count  = 4
+  summarizable  = true
+  base_cidr     = "10.5.0.0/16"
+  id            = "team"
+}
+
+# Reserved in 10.7.0.0/16 until it is full, then in 10.8.0.0/16.
+resource "gcsreferential_network_request" "spill" {
+  prefix_length = 24
+  base_cidrs    = ["10.7.0.0/16", "10.8.0.0/16"]
+  id            = "spill"
+}
+
+# The largest free subnet from a /20 down to a /24, its prefix_length is the one chosen.
+resource "gcsreferential_network_request" "opportunistic" {
+  min_prefix_length = 20
+  max_prefix_length = 24
+  base_cidr         = "10.5.0.0/16"
+  id                = "opportunistic"
+}
@@ -0,0 +1,4 @@
+resource "gcsreferential_sequence" "build_number" {
+  name       = "release-builds"
+  start_from = 1000
+}
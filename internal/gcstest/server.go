@@ -0,0 +1,425 @@
+// Package gcstest provides an in-memory fake of the subset of the GCS JSON and XML APIs used by the connector,
+// so the provider logic can be unit tested without a real bucket. The fake is wired through STORAGE_EMULATOR_HOST,
+// which the storage client honors.
+package gcstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Object is an object stored by the fake server.
+type Object struct {
+	Data       []byte
+	Generation int64
+	Metadata   map[string]string
+	Updated    time.Time
+	// Deleted is the time the version became noncurrent, zero for the live version.
+	Deleted time.Time
+	// KeySHA256 is the hash of the customer-supplied encryption key of the object, empty when unused.
+	KeySHA256 string
+}
+
+// Server is an in-memory fake GCS server.
+type Server struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	objects map[string]*Object
+	// noncurrent holds the replaced or deleted versions of each object, as on a bucket with object versioning.
+	noncurrent map[string][]*Object
+	// folders holds the folders of the buckets with hierarchical namespace, keyed by bucket and folder path ending with "/".
+	folders map[string]bool
+	// readOnly holds the buckets where the uploads are refused, as for credentials without storage.objects.create.
+	readOnly map[string]bool
+	// retained holds the objects whose deletion is refused, as under a retention policy not met yet.
+	retained map[string]bool
+	// failingDownloads holds the number of coming reads of an object answered with an error status, and that status.
+	failingDownloads map[string]*failingDownload
+	nextGeneration   int64
+	requests         atomic.Int64
+}
+
+// NewServer starts a fake GCS server and points the storage client of the current test at it.
+func NewServer(tb testing.TB) *Server {
+	s := &Server{objects: make(map[string]*Object), noncurrent: make(map[string][]*Object), folders: make(map[string]bool), readOnly: make(map[string]bool), retained: make(map[string]bool), failingDownloads: make(map[string]*failingDownload), nextGeneration: 1000}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	tb.Cleanup(s.Close)
+	tb.Setenv("STORAGE_EMULATOR_HOST", s.URL)
+	tb.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	return s
+}
+
+// Requests returns the number of requests served so far.
+func (s *Server) Requests() int64 {
+	return s.requests.Load()
+}
+
+// ResetRequests resets the request counter.
+func (s *Server) ResetRequests() {
+	s.requests.Store(0)
+}
+
+// Put stores an object as if it was written by another client and returns its generation.
+func (s *Server) Put(bucket string, name string, data []byte) int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.put(bucket, name, data, nil, "").Generation
+}
+
+// PutFolder creates a folder as on a bucket with hierarchical namespace, where a folder exists even when empty.
+func (s *Server) PutFolder(bucket string, folder string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.folders[key(bucket, strings.TrimSuffix(folder, "/")+"/")] = true
+}
+
+// SetReadOnly makes the uploads to a bucket fail with a permission error.
+func (s *Server) SetReadOnly(bucket string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.readOnly[bucket] = true
+}
+
+// SetRetained makes the deletions of an object fail as under a retention policy not met yet.
+func (s *Server) SetRetained(bucket string, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.retained[key(bucket, name)] = true
+}
+
+type failingDownload struct {
+	remaining int
+	status    int
+}
+
+// FailDownloads makes the next count reads of an object fail with the given HTTP status, as a transient outage
+// of the storage backend would.
+func (s *Server) FailDownloads(bucket string, name string, count int, status int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.failingDownloads[key(bucket, name)] = &failingDownload{remaining: count, status: status}
+}
+
+// Get returns a copy of a stored object.
+func (s *Server) Get(bucket string, name string) (Object, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	obj, ok := s.objects[key(bucket, name)]
+	if !ok {
+		return Object{}, false
+	}
+	return *obj, true
+}
+
+// SetUpdated changes the time an object was written, to simulate an old object.
+func (s *Server) SetUpdated(bucket string, name string, updated time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if obj, ok := s.objects[key(bucket, name)]; ok {
+		obj.Updated = updated.UTC()
+	}
+}
+
+// Delete removes an object as if it was deleted by another client.
+func (s *Server) Delete(bucket string, name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.remove(key(bucket, name))
+}
+
+func key(bucket string, name string) string {
+	return bucket + "/" + name
+}
+
+func (s *Server) put(bucket string, name string, data []byte, metadata map[string]string, keySHA256 string) *Object {
+	s.remove(key(bucket, name))
+	s.nextGeneration++
+	obj := &Object{Data: data, Generation: s.nextGeneration, Metadata: metadata, Updated: time.Now().UTC(), KeySHA256: keySHA256}
+	s.objects[key(bucket, name)] = obj
+	return obj
+}
+
+// remove makes the live version of an object noncurrent.
+func (s *Server) remove(objectKey string) {
+	if obj, ok := s.objects[objectKey]; ok {
+		obj.Deleted = time.Now().UTC()
+		s.noncurrent[objectKey] = append(s.noncurrent[objectKey], obj)
+		delete(s.objects, objectKey)
+	}
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	path := r.URL.EscapedPath()
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		s.handleUpload(w, r, strings.TrimSuffix(strings.TrimPrefix(path, "/upload/storage/v1/b/"), "/o"))
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		if bucket, ok := strings.CutSuffix(strings.TrimPrefix(path, "/storage/v1/b/"), "/o"); ok && r.Method == http.MethodGet {
+			s.handleList(w, r, bucket)
+			return
+		}
+		parts := strings.SplitN(strings.TrimPrefix(path, "/storage/v1/b/"), "/o/", 2)
+		if len(parts) != 2 {
+			writeError(w, http.StatusNotImplemented, "not implemented")
+			return
+		}
+		name, _ := url.PathUnescape(parts[1])
+		s.handleObject(w, r, parts[0], name)
+	default:
+		parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+		if len(parts) != 2 || r.Method != http.MethodGet {
+			writeError(w, http.StatusNotImplemented, "not implemented")
+			return
+		}
+		name, _ := url.PathUnescape(parts[1])
+		s.handleDownload(w, r, parts[0], name)
+	}
+}
+
+func (s *Server) handleObject(w http.ResponseWriter, r *http.Request, bucket string, name string) {
+	obj, ok := s.objects[key(bucket, name)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object: "+key(bucket, name))
+		return
+	}
+	if !checkPreconditions(w, r, obj) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		// As on GCS, the metadata of an encrypted object is readable without its key, not with another one.
+		if r.Header.Get(keySHA256Header) != "" && !checkEncryptionKey(w, r, obj) {
+			return
+		}
+		writeJSON(w, objectResource(bucket, name, obj))
+	case http.MethodDelete:
+		if s.retained[key(bucket, name)] {
+			writeErrorReason(w, http.StatusForbidden, "retentionPolicyNotMet", "Object '"+key(bucket, name)+"' is subject to bucket's retention policy or object retention and cannot be deleted or overwritten")
+			return
+		}
+		s.remove(key(bucket, name))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusNotImplemented, "not implemented")
+	}
+}
+
+// handleList lists the objects of a bucket matching the prefix query, with their noncurrent versions when requested.
+// With a delimiter, the names containing it after the prefix are rolled up into prefixes, the folders of the bucket
+// too when includeFoldersAsPrefixes is set. Pagination is not supported.
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request, bucket string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	versions := query.Get("versions") == "true"
+	items := []map[string]interface{}{}
+	prefixes := map[string]bool{}
+	// list adds an object to the items, or its prefix when the delimiter rolls it up.
+	list := func(name string, obj *Object) {
+		if !strings.HasPrefix(name, prefix) {
+			return
+		}
+		if delimiter != "" {
+			if i := strings.Index(name[len(prefix):], delimiter); i >= 0 {
+				prefixes[name[:len(prefix)+i+len(delimiter)]] = true
+				return
+			}
+		}
+		items = append(items, objectResource(bucket, name, obj))
+	}
+	for objectKey, obj := range s.objects {
+		if name, ok := strings.CutPrefix(objectKey, bucket+"/"); ok {
+			list(name, obj)
+		}
+	}
+	if versions {
+		for objectKey, objs := range s.noncurrent {
+			if name, ok := strings.CutPrefix(objectKey, bucket+"/"); ok {
+				for _, obj := range objs {
+					list(name, obj)
+				}
+			}
+		}
+	}
+	if delimiter != "" && query.Get("includeFoldersAsPrefixes") == "true" {
+		for folderKey := range s.folders {
+			if folder, ok := strings.CutPrefix(folderKey, bucket+"/"); ok && strings.HasPrefix(folder, prefix) && folder != prefix {
+				if i := strings.Index(folder[len(prefix):], delimiter); i >= 0 {
+					prefixes[folder[:len(prefix)+i+len(delimiter)]] = true
+				}
+			}
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i]["name"] != items[j]["name"] {
+			return items[i]["name"].(string) < items[j]["name"].(string)
+		}
+		return items[i]["generation"].(string) < items[j]["generation"].(string)
+	})
+	sortedPrefixes := make([]string, 0, len(prefixes))
+	for p := range prefixes {
+		sortedPrefixes = append(sortedPrefixes, p)
+	}
+	sort.Strings(sortedPrefixes)
+	writeJSON(w, map[string]interface{}{"kind": "storage#objects", "items": items, "prefixes": sortedPrefixes})
+}
+
+func (s *Server) handleDownload(w http.ResponseWriter, r *http.Request, bucket string, name string) {
+	if failing, ok := s.failingDownloads[key(bucket, name)]; ok && failing.remaining > 0 {
+		failing.remaining--
+		writeError(w, failing.status, "injected failure reading "+key(bucket, name))
+		return
+	}
+	obj, ok := s.objects[key(bucket, name)]
+	if !ok {
+		writeError(w, http.StatusNotFound, "No such object: "+key(bucket, name))
+		return
+	}
+	if !checkEncryptionKey(w, r, obj) {
+		return
+	}
+	w.Header().Set("X-Goog-Generation", strconv.FormatInt(obj.Generation, 10))
+	w.Header().Set("X-Goog-Metageneration", "1")
+	w.Header().Set("Content-Length", strconv.Itoa(len(obj.Data)))
+	w.Header().Set("Last-Modified", obj.Updated.Format(http.TimeFormat))
+	_, _ = w.Write(obj.Data)
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request, bucket string) {
+	if s.readOnly[bucket] {
+		writeErrorReason(w, http.StatusForbidden, "forbidden", "caller does not have storage.objects.create access to the Google Cloud Storage object")
+		return
+	}
+	if r.URL.Query().Get("uploadType") != "multipart" {
+		writeError(w, http.StatusNotImplemented, "only multipart uploads are supported")
+		return
+	}
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	reader := multipart.NewReader(r.Body, params["boundary"])
+	var meta struct {
+		Name     string            `json:"name"`
+		Metadata map[string]string `json:"metadata"`
+	}
+	metaPart, err := reader.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := json.NewDecoder(metaPart).Decode(&meta); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dataPart, err := reader.NextPart()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := io.ReadAll(dataPart)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	existing := s.objects[key(bucket, meta.Name)]
+	if !checkPreconditions(w, r, existing) {
+		return
+	}
+	writeJSON(w, objectResource(bucket, meta.Name, s.put(bucket, meta.Name, data, meta.Metadata, r.Header.Get(keySHA256Header))))
+}
+
+// checkPreconditions applies the ifGenerationMatch condition, 0 meaning the object must not exist.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, obj *Object) bool {
+	condition := r.URL.Query().Get("ifGenerationMatch")
+	if condition == "" {
+		return true
+	}
+	expected, _ := strconv.ParseInt(condition, 10, 64)
+	current := int64(0)
+	if obj != nil {
+		current = obj.Generation
+	}
+	if expected != current {
+		writeError(w, http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+		return false
+	}
+	return true
+}
+
+// keySHA256Header is the header carrying the hash of the customer-supplied encryption key of a request.
+const keySHA256Header = "X-Goog-Encryption-Key-Sha256"
+
+// checkEncryptionKey rejects the read of an object with another customer-supplied encryption key than its own.
+func checkEncryptionKey(w http.ResponseWriter, r *http.Request, obj *Object) bool {
+	requested := r.Header.Get(keySHA256Header)
+	switch {
+	case requested == obj.KeySHA256:
+		return true
+	case obj.KeySHA256 == "":
+		writeErrorReason(w, http.StatusBadRequest, "resourceNotEncryptedWithCustomerEncryptionKey", "The target object is not encrypted by a customer-supplied encryption key.")
+	case requested == "":
+		writeErrorReason(w, http.StatusBadRequest, "resourceIsEncryptedWithCustomerEncryptionKey", "The target object is encrypted by a customer-supplied encryption key.")
+	default:
+		writeErrorReason(w, http.StatusBadRequest, "customerEncryptionKeySha256IsInvalid", "The provided encryption key is incorrect.")
+	}
+	return false
+}
+
+func objectResource(bucket string, name string, obj *Object) map[string]interface{} {
+	resource := map[string]interface{}{
+		"kind":           "storage#object",
+		"bucket":         bucket,
+		"name":           name,
+		"generation":     strconv.FormatInt(obj.Generation, 10),
+		"metageneration": "1",
+		"size":           strconv.Itoa(len(obj.Data)),
+		"updated":        obj.Updated.Format(time.RFC3339Nano),
+		"timeCreated":    obj.Updated.Format(time.RFC3339Nano),
+		"metadata":       obj.Metadata,
+	}
+	if !obj.Deleted.IsZero() {
+		resource["timeDeleted"] = obj.Deleted.Format(time.RFC3339Nano)
+	}
+	return resource
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, message string) {
+	writeErrorReason(w, code, fmt.Sprintf("http%d", code), message)
+}
+
+func writeErrorReason(w http.ResponseWriter, code int, reason string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    code,
+			"message": message,
+			"errors":  []map[string]string{{"message": message, "reason": reason}},
+		},
+	})
+}
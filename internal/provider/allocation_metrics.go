@@ -0,0 +1,81 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// allocationMetricsName is the name of the metrics object of a referential, next to the folders of the resources.
+const allocationMetricsName = "allocation_metrics"
+
+// allocationMetrics is the metrics object counting the allocations ever made in a referential, when allocation_metrics
+// is set on the provider. The counters only grow: a released id or subnet is not subtracted.
+type allocationMetrics struct {
+	IdAllocations     int64      `json:"id_allocations"`
+	SubnetAllocations int64      `json:"subnet_allocations"`
+	UpdatedAt         *time.Time `json:"updated_at,omitempty"`
+}
+
+// allocationMetricsPath returns the path of the metrics object, namespaced by the tenant if any.
+func (p *GCSReferentialProviderModel) allocationMetricsPath() string {
+	return p.resourceDir(allocationMetricsName)
+}
+
+// readAllocationMetrics reads the metrics object without lock, all counters are zero while it does not exist.
+func readAllocationMetrics(ctx context.Context, p *GCSReferentialProviderModel) (allocationMetrics, error) {
+	var metrics allocationMetrics
+	gcpConnector := p.genericConnector(p.allocationMetricsPath())
+	if err := gcpConnector.Read(ctx, &metrics); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		return allocationMetrics{}, err
+	}
+	return metrics, nil
+}
+
+// recordAllocations adds count allocations of the given kind to the metrics object under its own lock, when
+// allocation_metrics is set. It is called once the allocations are written: a failure only logs and adds a warning,
+// the allocations stand and the metrics miss them.
+func recordAllocations(ctx context.Context, p *GCSReferentialProviderModel, kind string, count int64, diags *diag.Diagnostics) {
+	if !p.AllocationMetrics.ValueBool() || count == 0 {
+		return
+	}
+	gcpConnector := p.genericConnector(p.allocationMetricsPath())
+	warn := func(format string, args ...any) {
+		message := objectDetail(&gcpConnector, format, args...)
+		tflog.Warn(ctx, message)
+		diags.AddWarning("allocation metrics warning", message)
+	}
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		warn("Cannot acquire the lock of the allocation metrics, %d allocations are not counted: %s", count, err.Error())
+		return
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, "allocation metrics", diags)
+
+	var metrics allocationMetrics
+	if err := gcpConnector.Read(ctx, &metrics); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		warn("Cannot read the allocation metrics, %d allocations are not counted: %s", count, err.Error())
+		return
+	}
+	switch kind {
+	case allocationKindId:
+		metrics.IdAllocations += count
+	case allocationKindSubnet:
+		metrics.SubnetAllocations += count
+	default:
+		warn("Unknown kind of allocation %q, %d allocations are not counted", kind, count)
+		return
+	}
+	now := time.Now().UTC()
+	metrics.UpdatedAt = &now
+	if err := gcpConnector.Write(ctx, &metrics); err != nil {
+		warn("Cannot write the allocation metrics, %d allocations are not counted: %s", count, err.Error())
+		return
+	}
+	tflog.Debug(ctx, fmt.Sprintf("Counted %d %s allocations in the allocation metrics", count, kind))
+}
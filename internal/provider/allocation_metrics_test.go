@@ -0,0 +1,68 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestRecordAllocations(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+
+	// Nothing is counted without allocation_metrics.
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a"}); result.diags.HasError() {
+		t.Fatal(result.diags)
+	}
+	if _, ok := server.Get(testBucket, p.allocationMetricsPath()); ok {
+		t.Fatal("expected no metrics object without allocation_metrics")
+	}
+	if metrics, err := readAllocationMetrics(ctx, p); err != nil || metrics.IdAllocations != 0 || metrics.UpdatedAt != nil {
+		t.Fatalf("expected the metrics to start at zero, got %+v: %v", metrics, err)
+	}
+
+	p.AllocationMetrics = types.BoolValue(true)
+	for _, member := range []string{"b", "c"} {
+		if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: member}); result.diags.HasError() || result.diags.WarningsCount() != 0 {
+			t.Fatal(result.diags)
+		}
+	}
+	// An adopted id is not a new allocation, as made by another run.
+	p.Batchers = newPoolBatchers()
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "b", adoptExisting: true}); result.diags.HasError() {
+		t.Fatal(result.diags)
+	}
+	r := &networkRequestResource{providerData: p}
+	var diags diag.Diagnostics
+	data := networkRequestResourceModel{Id: types.StringValue("net"), PrefixLength: types.Int64Value(24)}
+	if !r.reserveInBaseCidr(ctx, p, "10.0.0.0/16", &data, &diags) || diags.HasError() {
+		t.Fatal(diags)
+	}
+	metrics, err := readAllocationMetrics(ctx, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.IdAllocations != 2 || metrics.SubnetAllocations != 1 || metrics.UpdatedAt == nil {
+		t.Fatalf("expected 2 ids and 1 subnet counted, got %+v", metrics)
+	}
+
+	// A metrics object that cannot be read only warns, the allocation stands.
+	server.Put(testBucket, p.allocationMetricsPath(), []byte("not json"))
+	result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "d"})
+	if result.diags.HasError() || result.diags.WarningsCount() != 1 {
+		t.Fatalf("expected a single warning, got %v", result.diags)
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Members["d"] != result.id {
+		t.Fatalf("expected the allocation of d to be written, got %v", stored.Members)
+	}
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultAllocationWebhookTimeout is the time given to the allocation webhook to answer when
+// allocation_webhook_timeout_seconds is not set.
+const defaultAllocationWebhookTimeout = 10 * time.Second
+
+// maxAllocationWebhookMessage is the size of the body of a refusal kept in the error, the rest is dropped.
+const maxAllocationWebhookMessage = 4096
+
+// Kinds of the allocations submitted to the allocation webhook.
+const (
+	allocationKindId     = "id"
+	allocationKindSubnet = "subnet"
+)
+
+// errAllocationRefused is returned by approveAllocation when the webhook answered with another status than 200.
+var errAllocationRefused = errors.New("the allocation was refused by the allocation_webhook_url")
+
+// allocationProposal is the body POSTed to the allocation_webhook_url before an allocation is written: an id of a
+// pool for an id_request, a subnet of a base_cidr for a network_request.
+type allocationProposal struct {
+	Kind      string `json:"kind"`
+	Bucket    string `json:"bucket"`
+	Tenant    string `json:"tenant,omitempty"`
+	RequestId string `json:"request_id"`
+	Pool      string `json:"pool,omitempty"`
+	// Id is the id proposed in Pool, a pointer since 0 is a valid id.
+	Id           *int64 `json:"id,omitempty"`
+	BaseCidr     string `json:"base_cidr,omitempty"`
+	Netmask      string `json:"netmask,omitempty"`
+	AlignedBlock string `json:"aligned_block,omitempty"`
+}
+
+// idProposal returns the proposal of the allocation of id in poolName to the id_request member.
+func (p *GCSReferentialProviderModel) idProposal(poolName string, member string, id int64) allocationProposal {
+	return allocationProposal{Kind: allocationKindId, Bucket: p.ReferentialBucket.ValueString(), Tenant: p.Tenant.ValueString(), RequestId: member, Pool: poolName, Id: &id}
+}
+
+// subnetProposal returns the proposal of the reservation of id in networkConfig, just allocated in baseCidr.
+func (p *GCSReferentialProviderModel) subnetProposal(networkConfig *NetworkConfig, baseCidr string, id string) allocationProposal {
+	proposal := allocationProposal{Kind: allocationKindSubnet, Bucket: p.ReferentialBucket.ValueString(), RequestId: id, BaseCidr: baseCidr, Netmask: networkConfig.Subnets[id]}
+	if block, ok := networkConfig.AlignedBlocks[id]; ok {
+		proposal.AlignedBlock = block
+	}
+	return proposal
+}
+
+// approveAllocation POSTs proposal to the allocation_webhook_url of the provider and returns nil when it answers 200,
+// or directly when no webhook is configured. It is called under the lock of the object about to be written, the
+// answer is bounded by allocation_webhook_timeout_seconds.
+func (p *GCSReferentialProviderModel) approveAllocation(ctx context.Context, proposal allocationProposal) error {
+	webhookUrl := p.AllocationWebhookUrl.ValueString()
+	if webhookUrl == "" {
+		return nil
+	}
+	timeout := defaultAllocationWebhookTimeout
+	if !p.AllocationWebhookTimeoutSeconds.IsNull() {
+		timeout = time.Duration(p.AllocationWebhookTimeoutSeconds.ValueInt64()) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	body, err := json.Marshal(proposal)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("the allocation_webhook_url did not answer within %s: %w", timeout, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, maxAllocationWebhookMessage))
+	return fmt.Errorf("%w (HTTP %d): %s", errAllocationRefused, resp.StatusCode, strings.TrimSpace(string(message)))
+}
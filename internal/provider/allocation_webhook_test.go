@@ -0,0 +1,96 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+// newTestWebhook returns a webhook approving the proposals of approve and refusing the others with a 403.
+func newTestWebhook(t *testing.T, approve func(proposal allocationProposal) bool) (*httptest.Server, *[]allocationProposal) {
+	var proposals []allocationProposal
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var proposal allocationProposal
+		if err := json.NewDecoder(r.Body).Decode(&proposal); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proposals = append(proposals, proposal)
+		if !approve(proposal) {
+			http.Error(w, "change freeze until monday", http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &proposals
+}
+
+func TestAllocateInBatch_allocationWebhook(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	webhook, proposals := newTestWebhook(t, func(proposal allocationProposal) bool { return proposal.RequestId != "refused" })
+	p.AllocationWebhookUrl = types.StringValue(webhook.URL)
+	createTestIdPool(t, p, "pool", 1, 10)
+
+	approved := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "approved"})
+	if approved.diags.HasError() || approved.id == 0 {
+		t.Fatalf("expected the approved allocation to be written, got %d: %v", approved.id, approved.diags)
+	}
+	refused := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "refused"})
+	if !refused.diags.HasError() || !strings.Contains(refused.diags[0].Detail(), "change freeze until monday") {
+		t.Fatalf("expected the refusal of the webhook to be reported, got %v", refused.diags)
+	}
+	if len(*proposals) != 2 || (*proposals)[0].Kind != allocationKindId || (*proposals)[0].Pool != "pool" || *(*proposals)[0].Id != int64(approved.id) {
+		t.Fatalf("unexpected proposals: %+v", *proposals)
+	}
+
+	var pool StoredIdPool
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Read(ctx, &pool); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.Members["refused"]; ok || len(pool.Members) != 1 {
+		t.Fatalf("expected only the approved member in the pool, got %v", pool.Members)
+	}
+}
+
+func TestApproveAllocation(t *testing.T) {
+	p := newTestProviderData()
+	ctx := context.Background()
+	if err := p.approveAllocation(ctx, allocationProposal{Kind: allocationKindSubnet}); err != nil {
+		t.Fatalf("expected every allocation to be approved without webhook, got: %v", err)
+	}
+
+	webhook, _ := newTestWebhook(t, func(proposal allocationProposal) bool { return proposal.Netmask == "10.0.0.0/24" })
+	p.AllocationWebhookUrl = types.StringValue(webhook.URL)
+	if err := p.approveAllocation(ctx, allocationProposal{Kind: allocationKindSubnet, Netmask: "10.0.0.0/24"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.approveAllocation(ctx, allocationProposal{Kind: allocationKindSubnet, Netmask: "10.0.1.0/24"}); !errors.Is(err, errAllocationRefused) {
+		t.Fatalf("expected the allocation to be refused, got: %v", err)
+	}
+
+	answer := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-answer
+	}))
+	t.Cleanup(slow.Close)
+	t.Cleanup(func() { close(answer) })
+	p.AllocationWebhookUrl = types.StringValue(slow.URL)
+	p.AllocationWebhookTimeoutSeconds = types.Int64Value(1)
+	start := time.Now()
+	if err := p.approveAllocation(ctx, allocationProposal{Kind: allocationKindSubnet}); err == nil || errors.Is(err, errAllocationRefused) {
+		t.Fatalf("expected the webhook not to answer in time, got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the call to be bounded by the timeout, took %s", elapsed)
+	}
+}
@@ -1,21 +1,34 @@
 package connector
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"net/http"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/google/uuid"
+	"github.com/hashicorp/go-version"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/public-cloud-wl/tools/utils"
 	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
@@ -23,6 +36,35 @@ type GcpConnectorGeneric struct {
 	BucketName   string
 	FullFilePath string
 	Generation   int64
+	// LockPrefix is prepended to the lock object path, allowing locks to live outside of a retained prefix.
+	LockPrefix string
+	// LockBucket is the bucket holding the lock objects, BucketName when empty.
+	LockBucket string
+	// EncryptionKey is the customer-supplied AES-256 key of the objects and their locks, nil when unused.
+	EncryptionKey []byte
+	// ObjectMetadata is the custom metadata set on the object by Write, nil when unused.
+	ObjectMetadata map[string]string
+	// ContentHash is the hex SHA-256 of the canonical JSON of the object last read or written.
+	ContentHash string
+	// FailIfLocked makes WaitForlock return ErrLocked instead of waiting when another process holds the lock.
+	FailIfLocked bool
+	// SkipLock makes WaitForlock and Unlock no-ops, the operations are then only protected by the generation preconditions of Write.
+	SkipLock bool
+	// StorageEndpoint overrides the endpoint of the storage API, such as a private service connect one, empty for the default.
+	StorageEndpoint string
+	// ProviderVersion is the version of the running provider, compared to the min_provider_version of the objects.
+	// The check is skipped when it is empty or not a version, such as the "dev" builds.
+	ProviderVersion string
+	// MinProviderVersion is the oldest provider version able to round-trip the format written, stamped by Write
+	// as the min_provider_version of the object, nothing is stamped when empty.
+	MinProviderVersion string
+	// ObjectMinProviderVersion is the min_provider_version of the object last read, empty if it has none.
+	ObjectMinProviderVersion string
+	// LockTTL is the age after which a lock held by another process is broken by WaitForlock, 0 when the locks never
+	// expire. Unlock reports a lock broken after it expired with ErrLockLost rather than as a failure.
+	LockTTL time.Duration
+	// lockedAt is the time the lock held by the connector was taken by Lock, zero when unknown.
+	lockedAt time.Time
 }
 
 type GcpConnectorNetwork struct {
@@ -30,38 +72,330 @@ type GcpConnectorNetwork struct {
 	BaseCidrRange string
 }
 
+// ErrLockLost is returned by Unlock when the lock held expired after LockTTL and was broken by another process.
+var ErrLockLost = errors.New("the lock expired and was broken by another process")
+
+// ErrLockRetention is returned by Unlock when the lock object cannot be deleted because of a retention policy.
+var ErrLockRetention = errors.New("lock object is subject to a retention policy and cannot be deleted")
+
+// ErrGenerationConflict is returned by Write when the object generation no longer matches the one last read,
+// meaning the object was modified concurrently.
+var ErrGenerationConflict = errors.New("object was modified concurrently (generation conflict)")
+
+// ErrCorruptedObject is returned by Read when the object content does not decode into the expected document.
+var ErrCorruptedObject = errors.New("object content is corrupted")
+
+// ErrProviderTooOld is returned by Read and Write when the object has a min_provider_version newer than the running provider,
+// which could lose the fields of a format it does not know by writing the object again.
+var ErrProviderTooOld = errors.New("object requires a newer provider version")
+
+// minProviderVersionField is the field of the objects holding the oldest provider version able to round-trip their format.
+const minProviderVersionField = "min_provider_version"
+
+// ErrEncryptionKeyMismatch is returned when an object cannot be accessed with the configured customer-supplied
+// encryption key, because it was written with another key or without any.
+var ErrEncryptionKeyMismatch = errors.New("object is not encrypted with the configured encryption_key")
+
+// ErrUnsupportedSchemaVersion is returned by Read when the object was written with a schema_version newer than
+// the one known by this provider.
+var ErrUnsupportedSchemaVersion = errors.New("object schema_version is not supported by this provider version")
+
+// VersionedDocument is a document stored with a schema_version field, so that the objects written in an older
+// format are migrated transparently when read. Read migrates the documents decoded and Write stamps the current version.
+type VersionedDocument interface {
+	// CurrentSchemaVersion returns the version of the format written by the provider.
+	CurrentSchemaVersion() int
+	// MigrateSchema upgrades the document decoded from an object of the given version, 0 for the objects written
+	// without schema_version, to the current format and sets its schema_version to the current version.
+	MigrateSchema(fromVersion int) error
+}
+
+// ErrLocked is returned by WaitForlock with FailIfLocked when another process holds the lock.
+var ErrLocked = errors.New("resource is locked by another run")
+
+// ErrAccessTokenExpired is returned by the operations whose request was refused with a 401 while the storage clients
+// use the static GOOGLE_OAUTH_ACCESS_TOKEN, which has likely expired during the run.
+var ErrAccessTokenExpired = errors.New("the GOOGLE_OAUTH_ACCESS_TOKEN access token was refused as expired or invalid (HTTP 401)")
+
+// LockOwnerMetadataKey is the metadata of a lock object naming the process holding it, as host:pid.
+const LockOwnerMetadataKey = "owner"
+
+// ProviderVersionMetadataKey is the metadata stamped by Write with the version of the provider that last wrote the object.
+const ProviderVersionMetadataKey = "provider_version"
+
+// lockOwner identifies the current process in the lock objects.
+func lockOwner() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", hostname, os.Getpid())
+}
+
 type NetworkConfig struct {
 	Subnets map[string]string `json:"subnets"`
 }
 
 func NewGeneric(BucketName string, FullFilePath string) GcpConnectorGeneric {
-	c := GcpConnectorGeneric{BucketName, FullFilePath, -1}
+	c := GcpConnectorGeneric{BucketName: BucketName, FullFilePath: FullFilePath, Generation: -1}
 
 	return c
 }
 
 func NewNetwork(bucketName string, baseCidr string) GcpConnectorNetwork {
 	fileName := fmt.Sprintf("gcsreferential/cidr-reservation/baseCidr-%s.json", strings.Replace(strings.Replace(baseCidr, ".", "-", -1), "/", "-", -1))
-	return GcpConnectorNetwork{GcpConnectorGeneric{bucketName, fileName, -1}, baseCidr}
+	return GcpConnectorNetwork{NewGeneric(bucketName, fileName), baseCidr}
+}
+
+// isEncryptionKeyError reports whether err is the GCS refusal to serve an object with the supplied encryption key.
+func isEncryptionKeyError(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusBadRequest {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if strings.Contains(strings.ToLower(item.Reason), "encryptionkey") {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(gerr.Message+gerr.Body), "encryption key")
+}
+
+// wrapEncryptionKeyError wraps err with ErrEncryptionKeyMismatch when it is an encryption key refusal.
+func wrapEncryptionKeyError(err error) error {
+	if isEncryptionKeyError(err) {
+		return fmt.Errorf("%w: %s", ErrEncryptionKeyMismatch, err.Error())
+	}
+	return err
+}
+
+// object returns the handle of the given object, with the encryption key if any.
+func (gcp *GcpConnectorGeneric) object(bucket *storage.BucketHandle, name string) *storage.ObjectHandle {
+	objectHandle := bucket.Object(name)
+	if gcp.EncryptionKey != nil {
+		objectHandle = objectHandle.Key(gcp.EncryptionKey)
+	}
+	return objectHandle
+}
+
+// migrateDocument upgrades document, just decoded from content, from the schema_version stored in content.
+func migrateDocument(content []byte, document VersionedDocument) error {
+	var stored struct {
+		SchemaVersion int `json:"schema_version"`
+	}
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptedObject, err.Error())
+	}
+	if stored.SchemaVersion > document.CurrentSchemaVersion() {
+		return fmt.Errorf("%w: the object has schema_version %d and this provider supports up to %d, upgrade the provider",
+			ErrUnsupportedSchemaVersion, stored.SchemaVersion, document.CurrentSchemaVersion())
+	}
+	return document.MigrateSchema(stored.SchemaVersion)
+}
+
+// readMinProviderVersion returns the min_provider_version of the JSON object content, empty when it has none.
+func readMinProviderVersion(content []byte) string {
+	var stored struct {
+		MinProviderVersion string `json:"min_provider_version"`
+	}
+	if err := json.Unmarshal(content, &stored); err != nil {
+		return ""
+	}
+	return stored.MinProviderVersion
+}
+
+// checkProviderVersion fails with ErrProviderTooOld when the running provider is older than minProviderVersion.
+// It does not fail when either version cannot be compared, so that the development builds can read any object.
+func (gcp *GcpConnectorGeneric) checkProviderVersion(minProviderVersion string) error {
+	if minProviderVersion == "" || gcp.ProviderVersion == "" {
+		return nil
+	}
+	running, err := version.NewVersion(gcp.ProviderVersion)
+	if err != nil {
+		return nil
+	}
+	required, err := version.NewVersion(minProviderVersion)
+	if err != nil {
+		return nil
+	}
+	if running.LessThan(required) {
+		return fmt.Errorf("%w: gs://%s/%s requires provider %s or newer and this provider is %s, upgrade the provider",
+			ErrProviderTooOld, gcp.BucketName, gcp.FullFilePath, minProviderVersion, gcp.ProviderVersion)
+	}
+	return nil
+}
+
+// stampMinProviderVersion sets the min_provider_version field of the JSON object content, other documents are returned as is.
+func stampMinProviderVersion(content []byte, minProviderVersion string) ([]byte, error) {
+	if minProviderVersion == "" || !bytes.HasPrefix(bytes.TrimSpace(content), []byte("{")) {
+		return content, nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, err
+	}
+	stamp, err := json.Marshal(minProviderVersion)
+	if err != nil {
+		return nil, err
+	}
+	fields[minProviderVersionField] = stamp
+	return json.Marshal(fields)
+}
+
+// canonicalHash returns the hex SHA-256 of a JSON document re-encoded with sorted keys and without spaces,
+// so that the same content always has the same hash whatever its formatting.
+func canonicalHash(content []byte) (string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return "", err
+	}
+	canonical, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// isRetentionError reports whether err is the GCS refusal to delete an object still under retention.
+func isRetentionError(err error) bool {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) || gerr.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range gerr.Errors {
+		if item.Reason == "retentionPolicyNotMet" {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(gerr.Message), "retention")
 }
 
-func getStorageClient(ctx context.Context) (*storage.Client, error) {
+// IsPermissionDenied reports whether err is a GCS refusal because the credentials lack a permission on the bucket,
+// a retention refusal excluded.
+func IsPermissionDenied(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusForbidden && !isRetentionError(err)
+}
+
+// The sources of the credentials of the storage clients, as reported by CredentialsSource.
+const (
+	CredentialsSourceEmulator           = "emulator"
+	CredentialsSourceAccessToken        = "access_token"
+	CredentialsSourceCredentialsFile    = "credentials_file"
+	CredentialsSourceApplicationDefault = "application_default"
+)
+
+// CredentialsSource returns where the storage clients take their credentials from: none with the emulator of
+// STORAGE_EMULATOR_HOST, the GOOGLE_OAUTH_ACCESS_TOKEN token, the GOOGLE_APPLICATION_CREDENTIALS file, or else the
+// application default credentials of gcloud or of the metadata server.
+func CredentialsSource() string {
+	switch {
+	case os.Getenv("STORAGE_EMULATOR_HOST") != "":
+		return CredentialsSourceEmulator
+	case os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN") != "":
+		return CredentialsSourceAccessToken
+	case os.Getenv("GOOGLE_APPLICATION_CREDENTIALS") != "":
+		return CredentialsSourceCredentialsFile
+	default:
+		return CredentialsSourceApplicationDefault
+	}
+}
+
+// getStorageClient returns a storage client on the given endpoint, the default one when empty.
+func getStorageClient(ctx context.Context, endpoint string) (*storage.Client, error) {
+	var clientOptions []option.ClientOption
+	if endpoint != "" {
+		clientOptions = append(clientOptions, option.WithEndpoint(endpoint))
+	}
 	access_token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN")
 	if access_token != "" {
-		var tokenSource oauth2.TokenSource
-		var credOptions []option.ClientOption
-		tokenSource = oauth2.StaticTokenSource(&oauth2.Token{
+		tokenSource := oauth2.StaticTokenSource(&oauth2.Token{
 			AccessToken: access_token,
 		})
-		credOptions = append(credOptions, option.WithTokenSource(tokenSource))
-		return storage.NewClient(ctx, credOptions...)
-	} else {
-		return storage.NewClient(ctx)
+		clientOptions = append(clientOptions, option.WithHTTPClient(&http.Client{Transport: &accessTokenTransport{
+			static: &oauth2.Transport{Source: tokenSource, Base: http.DefaultTransport},
+		}}))
+	}
+	return storage.NewClient(ctx, clientOptions...)
+}
+
+var (
+	// accessTokenFallback is set once a provider enabled access_token_fallback, the access token being shared by
+	// every provider of the process.
+	accessTokenFallback atomic.Bool
+	// accessTokenRefused is set once a request was refused with the access token, the next requests then go
+	// directly to the fallback credentials when enabled.
+	accessTokenRefused atomic.Bool
+	fallbackOnce       sync.Once
+	fallbackTransport  http.RoundTripper
+	fallbackErr        error
+	// fallbackTokenSource returns the credentials used once the access token is refused, replaced by the tests.
+	fallbackTokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+		return google.DefaultTokenSource(ctx, storage.ScopeFullControl)
 	}
+)
+
+// EnableAccessTokenFallback makes the storage clients switch from the GOOGLE_OAUTH_ACCESS_TOKEN access token to the
+// application default credentials, or those of GOOGLE_APPLICATION_CREDENTIALS, once it is refused with a 401.
+func EnableAccessTokenFallback() {
+	accessTokenFallback.Store(true)
+}
+
+// accessTokenTransport sends the requests with the static access token and detects its expiry: a 401 is reported as
+// ErrAccessTokenExpired, or the request is sent again with the fallback credentials when the fallback is enabled.
+type accessTokenTransport struct {
+	static http.RoundTripper
+}
+
+func (t *accessTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if accessTokenFallback.Load() && accessTokenRefused.Load() {
+		return t.fallback(req)
+	}
+	resp, err := t.static.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+	accessTokenRefused.Store(true)
+	if !accessTokenFallback.Load() {
+		return nil, fmt.Errorf("%w, renew it or set access_token_fallback on the provider to switch to the application default credentials", ErrAccessTokenExpired)
+	}
+	tflog.Warn(req.Context(), "The GOOGLE_OAUTH_ACCESS_TOKEN access token was refused, switching to the application default credentials for the rest of the run")
+	if req.Body != nil && req.GetBody == nil {
+		// The body of the refused request cannot be sent again, the operation fails but the next ones use the fallback.
+		return nil, fmt.Errorf("%w, the next operations use the application default credentials", ErrAccessTokenExpired)
+	}
+	retried := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retried.Body = body
+	}
+	return t.fallback(retried)
+}
+
+// fallback sends req with the fallback credentials, created on the first use.
+func (t *accessTokenTransport) fallback(req *http.Request) (*http.Response, error) {
+	fallbackOnce.Do(func() {
+		var tokenSource oauth2.TokenSource
+		tokenSource, fallbackErr = fallbackTokenSource(context.Background())
+		if fallbackErr == nil {
+			fallbackTransport = &oauth2.Transport{Source: tokenSource, Base: http.DefaultTransport}
+		}
+	})
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("%w, and the application default credentials cannot be used: %s", ErrAccessTokenExpired, fallbackErr.Error())
+	}
+	return fallbackTransport.RoundTrip(req)
 }
 
 func (gcp *GcpConnectorGeneric) Read(ctx context.Context, data interface{}) error {
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return err
 	}
@@ -70,22 +404,40 @@ func (gcp *GcpConnectorGeneric) Read(ctx context.Context, data interface{}) erro
 	if err != nil {
 		return err
 	}
-	objectHandle := bucket.Object(gcp.FullFilePath)
-	attrs, err := objectHandle.Attrs(ctx)
-	if err == nil {
-		gcp.Generation = attrs.Generation
-	}
+	objectHandle := gcp.object(bucket, gcp.FullFilePath)
 	rc, err := objectHandle.NewReader(ctx)
 	if err != nil {
 		tflog.Debug(ctx, fmt.Sprintf("Bucket Object does not exist with error : %s (%s)", gcp.FullFilePath, err.Error()))
-		return err
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			// Forget the object read before, the next Write must create the object rather than match its generation.
+			gcp.Generation = -1
+			gcp.ObjectMinProviderVersion = ""
+		}
+		return wrapEncryptionKeyError(err)
 	}
 	defer rc.Close()
+	// Take the generation from the reader so it always matches the content read, even when the object
+	// was deleted and recreated in between (the soft-deleted version is never served by a plain reader).
+	gcp.Generation = rc.Attrs.Generation
 	slurp, err := io.ReadAll(rc)
 	if err != nil {
 		return err
 	}
+	gcp.ObjectMinProviderVersion = readMinProviderVersion(slurp)
+	if err := gcp.checkProviderVersion(gcp.ObjectMinProviderVersion); err != nil {
+		return err
+	}
 	err = json.Unmarshal(slurp, &data)
+	if err != nil {
+		return fmt.Errorf("%w: gs://%s/%s (generation %d): %s; the object may have been edited manually, fix it or restore a previous generation",
+			ErrCorruptedObject, gcp.BucketName, gcp.FullFilePath, gcp.Generation, err.Error())
+	}
+	if versioned, ok := data.(VersionedDocument); ok {
+		if err := migrateDocument(slurp, versioned); err != nil {
+			return fmt.Errorf("gs://%s/%s (generation %d): %w", gcp.BucketName, gcp.FullFilePath, gcp.Generation, err)
+		}
+	}
+	gcp.ContentHash, err = canonicalHash(slurp)
 	if err != nil {
 		return err
 	}
@@ -93,9 +445,27 @@ func (gcp *GcpConnectorGeneric) Read(ctx context.Context, data interface{}) erro
 	return nil
 }
 
+// writeMetadata returns the custom metadata of a write: the ObjectMetadata, stamped with the ProviderVersion when
+// it is known. It is nil when there is none, the ObjectMetadata shared by the connectors is never modified.
+func (gcp *GcpConnectorGeneric) writeMetadata() map[string]string {
+	if gcp.ProviderVersion == "" {
+		return gcp.ObjectMetadata
+	}
+	metadata := make(map[string]string, len(gcp.ObjectMetadata)+1)
+	for key, value := range gcp.ObjectMetadata {
+		metadata[key] = value
+	}
+	metadata[ProviderVersionMetadataKey] = gcp.ProviderVersion
+	return metadata
+}
+
 func (gcp *GcpConnectorGeneric) Write(ctx context.Context, data interface{}) error {
+	// The object last read may be in a format this provider would not round-trip.
+	if err := gcp.checkProviderVersion(gcp.ObjectMinProviderVersion); err != nil {
+		return err
+	}
 	// Creates a client.
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return err
 	}
@@ -104,71 +474,262 @@ func (gcp *GcpConnectorGeneric) Write(ctx context.Context, data interface{}) err
 	bucket := client.Bucket(gcp.BucketName)
 	var writer *storage.Writer
 	if gcp.Generation == -1 {
-		writer = bucket.Object(gcp.FullFilePath).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+		writer = gcp.object(bucket, gcp.FullFilePath).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
 	} else {
-		writer = bucket.Object(gcp.FullFilePath).If(storage.Conditions{GenerationMatch: gcp.Generation}).NewWriter(ctx)
+		writer = gcp.object(bucket, gcp.FullFilePath).If(storage.Conditions{GenerationMatch: gcp.Generation}).NewWriter(ctx)
+	}
+	writer.Metadata = gcp.writeMetadata()
+	if versioned, ok := data.(VersionedDocument); ok {
+		// The document in memory is in the current format, only its version is stamped.
+		if err := versioned.MigrateSchema(versioned.CurrentSchemaVersion()); err != nil {
+			return err
+		}
 	}
 	marshalled, err := json.Marshal(data)
 	if err != nil {
 		return err
 	}
+	marshalled, err = stampMinProviderVersion(marshalled, gcp.MinProviderVersion)
+	if err != nil {
+		return err
+	}
+	contentHash, err := canonicalHash(marshalled)
+	if err != nil {
+		return err
+	}
 	_, err = writer.Write(marshalled)
 	if err != nil {
 		return err
 	}
 	if err := writer.Close(); err != nil {
 		tflog.Error(ctx, "Failed to write file to GCP", map[string]interface{}{"error": err, "Generation": gcp.Generation, "Bucket": gcp.BucketName, "FilePath": gcp.FullFilePath})
-		return err
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: %s", ErrGenerationConflict, err.Error())
+		}
+		return wrapEncryptionKeyError(err)
 	}
 	// After successful close, update generation from the writer's attributes
 	gcp.Generation = writer.Attrs().Generation
+	gcp.ObjectMinProviderVersion = readMinProviderVersion(marshalled)
+	gcp.ContentHash = contentHash
 	tflog.Debug(ctx, fmt.Sprintf("THIS IS CURRENTLY WRITE : %s", string(marshalled)))
 	return nil
 }
 
 func (gcp *GcpConnectorGeneric) GetAttrs(ctx context.Context) (*storage.ObjectAttrs, error) {
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return nil, err
 	}
 	defer client.Close()
 
 	bucket := client.Bucket(gcp.BucketName)
-	objectHandle := bucket.Object(gcp.FullFilePath)
+	objectHandle := gcp.object(bucket, gcp.FullFilePath)
 
-	return objectHandle.Attrs(ctx)
+	attrs, err := objectHandle.Attrs(ctx)
+	if err != nil {
+		return nil, wrapEncryptionKeyError(err)
+	}
+	// On buckets with soft delete or versioning, never mistake a deleted version for the live object.
+	if !isLiveObject(attrs) {
+		return nil, storage.ErrObjectNotExist
+	}
+	return attrs, nil
+}
+
+// isLiveObject reports whether attrs describe the live version of an object.
+func isLiveObject(attrs *storage.ObjectAttrs) bool {
+	return attrs.Deleted.IsZero() && attrs.SoftDeleteTime.IsZero()
+}
+
+// ListVersions returns the versions of the object kept by the bucket, live one included, the most recent first.
+// Noncurrent versions are only kept on buckets with object versioning or soft delete.
+func (gcp *GcpConnectorGeneric) ListVersions(ctx context.Context) ([]*storage.ObjectAttrs, error) {
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var versions []*storage.ObjectAttrs
+	it := client.Bucket(gcp.BucketName).Objects(ctx, &storage.Query{Prefix: gcp.FullFilePath, Versions: true})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The prefix also matches the objects whose name extends this one, such as its lock.
+		if attrs.Name == gcp.FullFilePath {
+			versions = append(versions, attrs)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Generation > versions[j].Generation })
+	return versions, nil
+}
+
+// ListChildren returns the sorted names of the objects directly under the folder dir of the bucket, through the
+// storage API endpoint given, the default one when empty.
+// The listing is delimited so the subfolders are not walked into: on a bucket with hierarchical namespace
+// they are real folders, possibly empty, and on a flat bucket they may come with "dir/" placeholder objects,
+// both are skipped like the lock files stored next to the objects.
+func ListChildren(ctx context.Context, endpoint string, bucketName string, dir string) ([]string, error) {
+	client, err := getStorageClient(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var names []string
+	it := client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/", IncludeFoldersAsPrefixes: true})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		// A subfolder only comes with its prefix set.
+		if attrs.Prefix != "" {
+			continue
+		}
+		name := strings.TrimPrefix(attrs.Name, prefix)
+		if name == "" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ListLocks returns the lock objects of the bucket whose path starts with prefix, the whole bucket when empty,
+// sorted by path. endpoint is the storage API endpoint, the default one when empty.
+func ListLocks(ctx context.Context, endpoint string, bucketName string, prefix string) ([]*storage.ObjectAttrs, error) {
+	client, err := getStorageClient(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	var locks []*storage.ObjectAttrs
+	it := client.Bucket(bucketName).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(attrs.Name, ".lock") {
+			locks = append(locks, attrs)
+		}
+	}
+	sort.Slice(locks, func(i, j int) bool { return locks[i].Name < locks[j].Name })
+	return locks, nil
 }
 
 func (gcp *GcpConnectorGeneric) Delete(ctx context.Context) error {
 	// Creates a client.
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 	// Creates a Bucket instance.
 	bucket := client.Bucket(gcp.BucketName)
-	return bucket.Object(gcp.FullFilePath).Delete(ctx)
+	if err := gcp.object(bucket, gcp.FullFilePath).Delete(ctx); err != nil {
+		return err
+	}
+	// A recreation of the object must not be conditioned on the deleted generation.
+	gcp.Generation = -1
+	return nil
+}
+
+// DeleteIfUnchanged deletes the object only if it still has the generation last read or written, it returns
+// ErrGenerationConflict when the object was modified since. Without known generation it deletes the object as Delete.
+func (gcp *GcpConnectorGeneric) DeleteIfUnchanged(ctx context.Context) error {
+	if gcp.Generation == -1 {
+		return gcp.Delete(ctx)
+	}
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	bucket := client.Bucket(gcp.BucketName)
+	if err := gcp.object(bucket, gcp.FullFilePath).If(storage.Conditions{GenerationMatch: gcp.Generation}).Delete(ctx); err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+			return fmt.Errorf("%w: %s", ErrGenerationConflict, err.Error())
+		}
+		return err
+	}
+	gcp.Generation = -1
+	return nil
+}
+
+// GetBucketName returns the bucket holding the object.
+func (gcp *GcpConnectorGeneric) GetBucketName() string {
+	return gcp.BucketName
+}
+
+// GetFullFilePath returns the path of the object in the bucket.
+func (gcp *GcpConnectorGeneric) GetFullFilePath() string {
+	return gcp.FullFilePath
+}
+
+// GetContentHash returns the hash of the object last read or written, see ContentHash.
+func (gcp *GcpConnectorGeneric) GetContentHash() string {
+	return gcp.ContentHash
+}
+
+// GetGeneration returns the generation of the object last read or written, -1 when it does not exist.
+func (gcp *GcpConnectorGeneric) GetGeneration() int64 {
+	return gcp.Generation
+}
+
+// SetGeneration sets the generation expected by the next Write.
+func (gcp *GcpConnectorGeneric) SetGeneration(generation int64) {
+	gcp.Generation = generation
+}
+
+// GetLockBucketName returns the bucket holding the lock of the object.
+func (gcp *GcpConnectorGeneric) GetLockBucketName() string {
+	if gcp.LockBucket != "" {
+		return gcp.LockBucket
+	}
+	return gcp.BucketName
 }
 
 func (gcp *GcpConnectorGeneric) GetLockPath(ctx context.Context) string {
+	if gcp.LockPrefix != "" {
+		return fmt.Sprintf("%s/%s.lock", strings.TrimSuffix(gcp.LockPrefix, "/"), gcp.FullFilePath)
+	}
 	return fmt.Sprintf("%s.lock", gcp.FullFilePath)
 }
 
 func (gcp *GcpConnectorGeneric) Lock(ctx context.Context) (uuid.UUID, error) {
 	tflog.Debug(ctx, "ENTERING TO LOCK")
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return uuid.Nil, err
 	}
 	defer client.Close()
-	bucket := client.Bucket(gcp.BucketName)
+	bucket := client.Bucket(gcp.GetLockBucketName())
 	var writer *storage.Writer
 	lockPath := gcp.GetLockPath(ctx)
-	writer = bucket.Object(lockPath).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
+	writer = gcp.object(bucket, lockPath).If(storage.Conditions{DoesNotExist: true}).NewWriter(ctx)
 	if writer == nil {
 		return uuid.Nil, errors.New("Condition not met")
 	}
+	writer.Metadata = map[string]string{LockOwnerMetadataKey: lockOwner()}
 	lockId := uuid.New()
 	_, err = writer.Write([]byte(lockId.String()))
 	if err != nil {
@@ -180,28 +741,35 @@ func (gcp *GcpConnectorGeneric) Lock(ctx context.Context) (uuid.UUID, error) {
 		return uuid.Nil, err
 	}
 	tflog.Debug(ctx, fmt.Sprintf("LOCK GENERATED : %s", lockId))
+	gcp.lockedAt = time.Now()
 	return lockId, nil
 
 }
 
 func (gcp *GcpConnectorGeneric) Unlock(ctx context.Context, lockId uuid.UUID) error {
 	var err error
+	if gcp.SkipLock {
+		return nil
+	}
 	tflog.Debug(ctx, fmt.Sprintf("ENTERING TO UNLOCK : %s", lockId.String()))
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return err
 	}
 	defer client.Close()
 	lockPath := gcp.GetLockPath(ctx)
-	bucket := client.Bucket(gcp.BucketName)
-	objectHandle := bucket.Object(lockPath)
+	bucket := client.Bucket(gcp.GetLockBucketName())
+	objectHandle := gcp.object(bucket, lockPath)
 	_, err = objectHandle.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) && gcp.lockExpired() {
+		return fmt.Errorf("%w: the lock object no longer exists", ErrLockLost)
+	}
 	if err != nil {
-		return err
+		return wrapEncryptionKeyError(err)
 	}
 	rc, err := objectHandle.NewReader(ctx)
 	if err != nil {
-		return err
+		return wrapEncryptionKeyError(err)
 	}
 	defer rc.Close()
 	slurp, err := io.ReadAll(rc)
@@ -212,38 +780,157 @@ func (gcp *GcpConnectorGeneric) Unlock(ctx context.Context, lockId uuid.UUID) er
 	tflog.Debug(ctx, fmt.Sprintf("COMPARING LOCKID : %s and %s", lockId.String(), currentLockId))
 	if currentLockId == lockId.String() {
 		tflog.Debug(ctx, fmt.Sprintf("UNLOCKING LOCKID : %s", currentLockId))
+		err = gcp.object(bucket, lockPath).Delete(ctx)
+		if err == nil {
+			return nil
+		}
+		// A retained object will never be deletable before its retention expires, do not retry.
+		if isRetentionError(err) {
+			return fmt.Errorf("%w: %s", ErrLockRetention, err.Error())
+		}
 		// retry.
-		return utils.Retry(func() error { return bucket.Object(lockPath).Delete(ctx) }, 5)
+		return utils.Retry(func() error { return gcp.object(bucket, lockPath).Delete(ctx) }, 5)
 	} else {
 		tflog.Debug(ctx, fmt.Sprintf("LOCKID DOES NOT CORRESPOND: %s %s", currentLockId, lockId.String()))
+		if gcp.lockExpired() {
+			return fmt.Errorf("%w: the lock is now held with the lock id %s", ErrLockLost, currentLockId)
+		}
 		return errors.New("The lock id does not correspond, cannot unlock it")
 	}
 }
 
+// lockExpired reports whether the lock taken by Lock is older than LockTTL, and may then have been broken by another
+// process. It is never the case without LockTTL, or for a lock the connector did not take itself.
+func (gcp *GcpConnectorGeneric) lockExpired() bool {
+	return gcp.LockTTL > 0 && !gcp.lockedAt.IsZero() && time.Since(gcp.lockedAt) > gcp.LockTTL
+}
+
+// DeleteLockCreatedBefore deletes the lock object of the connector when it was written before the given time, whatever
+// the lock id it holds, and returns whether it deleted one. A lock taken since then is kept, the deletion is
+// conditioned on the generation read so that a lock taken in between is not removed either. No lock is not an error.
+func (gcp *GcpConnectorGeneric) DeleteLockCreatedBefore(ctx context.Context, before time.Time) (bool, error) {
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
+	if err != nil {
+		return false, err
+	}
+	defer client.Close()
+	objectHandle := gcp.object(client.Bucket(gcp.GetLockBucketName()), gcp.GetLockPath(ctx))
+	attrs, err := objectHandle.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, wrapEncryptionKeyError(err)
+	}
+	if !attrs.Created.Before(before) {
+		return false, nil
+	}
+	err = objectHandle.If(storage.Conditions{GenerationMatch: attrs.Generation}).Delete(ctx)
+	if err == nil {
+		return true, nil
+	}
+	var gerr *googleapi.Error
+	if errors.Is(err, storage.ErrObjectNotExist) || (errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed) {
+		// Released or taken again meanwhile.
+		return false, nil
+	}
+	if isRetentionError(err) {
+		return false, fmt.Errorf("%w: %s", ErrLockRetention, err.Error())
+	}
+	return false, err
+}
+
+const (
+	lockReadAttempts   = 3
+	lockReadRetryDelay = 200 * time.Millisecond
+)
+
+// isTransientReadError reports whether a failed read of an object may succeed when retried: a timeout, throttling or
+// server error, or a connection cut while reading. A missing object or an encryption key refusal is final.
+func isTransientReadError(err error) bool {
+	if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, ErrEncryptionKeyMismatch) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		return gerr.Code == http.StatusRequestTimeout || gerr.Code == http.StatusTooManyRequests || gerr.Code >= http.StatusInternalServerError
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
 // Get the current lock ID if there is one at string format and send error if there is no lock, error will be nil if there is a lock that can be retrieve.
+// A transient read error is retried a few times, shortly, so that a blip is not mistaken for a released lock.
 func (gcp *GcpConnectorGeneric) GetCurrentLockId(ctx context.Context) (uuid.UUID, error) {
-	var err error
-	client, err := getStorageClient(ctx)
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
 	if err != nil {
 		return uuid.Nil, err
 	}
 	defer client.Close()
-	lockPath := gcp.GetLockPath(ctx)
-	bucket := client.Bucket(gcp.BucketName)
-	if err != nil {
-		return uuid.Nil, err
+	// The retries are bounded here rather than left to the client, whose backoff would stall the wait for the lock.
+	objectHandle := gcp.object(client.Bucket(gcp.GetLockBucketName()), gcp.GetLockPath(ctx)).Retryer(storage.WithPolicy(storage.RetryNever))
+	for attempt := 1; ; attempt++ {
+		lockId, err := readLockId(ctx, objectHandle)
+		if err == nil || attempt == lockReadAttempts || !isTransientReadError(err) {
+			return lockId, err
+		}
+		tflog.Debug(ctx, fmt.Sprintf("Transient error reading the lock, retrying: %s", err.Error()))
+		select {
+		case <-ctx.Done():
+			return uuid.Nil, err
+		case <-time.After(lockReadRetryDelay):
+		}
 	}
-	objectHandle := bucket.Object(lockPath)
+}
+
+// readLockId reads the lock id held by the lock object.
+func readLockId(ctx context.Context, objectHandle *storage.ObjectHandle) (uuid.UUID, error) {
 	rc, err := objectHandle.NewReader(ctx)
 	if err != nil {
-		return uuid.Nil, err
+		return uuid.Nil, wrapEncryptionKeyError(err)
 	}
 	defer rc.Close()
 	slurp, err := io.ReadAll(rc)
 	if err != nil {
 		return uuid.Nil, err
 	}
-	return uuid.MustParse(string(slurp)), nil
+	lockId, err := uuid.Parse(string(slurp))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("invalid lock id in %s: %w", objectHandle.ObjectName(), err)
+	}
+	return lockId, nil
+}
+
+// lockedError returns ErrLocked with the lock object and, when it can be read, the process holding it.
+func (gcp *GcpConnectorGeneric) lockedError(ctx context.Context) error {
+	lockPath := fmt.Sprintf("gs://%s/%s", gcp.GetLockBucketName(), gcp.GetLockPath(ctx))
+	client, err := getStorageClient(ctx, gcp.StorageEndpoint)
+	if err == nil {
+		defer client.Close()
+		attrs, err := gcp.object(client.Bucket(gcp.GetLockBucketName()), gcp.GetLockPath(ctx)).Attrs(ctx)
+		if err == nil && attrs.Metadata[LockOwnerMetadataKey] != "" {
+			return fmt.Errorf("%w: %s is held by %s since %s", ErrLocked, lockPath, attrs.Metadata[LockOwnerMetadataKey], attrs.Created.Format(time.RFC3339))
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrLocked, lockPath)
+}
+
+const (
+	minLockBackoff = 1 * time.Second
+	maxLockBackoff = 10 * time.Second
+)
+
+// lockBackoff returns the base wait before the given try to get the lock, starting at 1: minLockBackoff multiplied by
+// backoffMultiplier at each try, capped at maxLockBackoff. A multiplier up to 1 keeps the wait at minLockBackoff.
+func lockBackoff(iteration int, backoffMultiplier float32) time.Duration {
+	backoff := float64(minLockBackoff) * math.Pow(float64(backoffMultiplier), float64(iteration-1))
+	if backoff < float64(minLockBackoff) || math.IsNaN(backoff) {
+		return minLockBackoff
+	}
+	if backoff > float64(maxLockBackoff) {
+		return maxLockBackoff
+	}
+	return time.Duration(backoff)
 }
 
 // Wait for lock to be relase and create a new one.
@@ -252,8 +939,10 @@ func (gcp *GcpConnectorGeneric) WaitForlock(ctx context.Context, timeout time.Du
 	numberOfIteration := 0
 	var err error
 	var lock uuid.UUID
-	const minBackoff = 1 * time.Second
-	const maxBackoff = 10 * time.Second
+	if gcp.SkipLock {
+		tflog.Debug(ctx, "Locking is skipped")
+		return uuid.Nil, nil
+	}
 	// Infinite loop break by return.
 	for {
 		if time.Since(startTime) > timeout {
@@ -261,6 +950,10 @@ func (gcp *GcpConnectorGeneric) WaitForlock(ctx context.Context, timeout time.Du
 			return uuid.Nil, fmt.Errorf("CANNOT WAIT MORE FOR LOCK")
 		}
 		lock, err = gcp.GetCurrentLockId(ctx)
+		if errors.Is(err, ErrEncryptionKeyMismatch) {
+			// The lock exists but will never be readable with this key, waiting is pointless.
+			return uuid.Nil, err
+		}
 		if err == nil {
 			tflog.Debug(ctx, fmt.Sprintf("CURRENT LOCKID : %s", lock.String()))
 			if len(existingLock) > 0 && lock == existingLock[0] {
@@ -268,6 +961,18 @@ func (gcp *GcpConnectorGeneric) WaitForlock(ctx context.Context, timeout time.Du
 				return lock, nil
 			}
 			tflog.Debug(ctx, fmt.Sprintf("LOCK WAS REQUEST BY ANOTHER PROCESS : %s", lock.String()))
+			if gcp.LockTTL > 0 {
+				broken, err := gcp.DeleteLockCreatedBefore(ctx, time.Now().Add(-gcp.LockTTL))
+				if err != nil {
+					tflog.Warn(ctx, fmt.Sprintf("Cannot break the lock %s of %s older than %s: %s", lock.String(), gcp.GetLockPath(ctx), gcp.LockTTL, err.Error()))
+				} else if broken {
+					tflog.Warn(ctx, fmt.Sprintf("Broke the lock %s of %s, held for more than %s", lock.String(), gcp.GetLockPath(ctx), gcp.LockTTL))
+					continue
+				}
+			}
+			if gcp.FailIfLocked {
+				return uuid.Nil, gcp.lockedError(ctx)
+			}
 		} else {
 			// There is no lock so try to get one.
 			tflog.Debug(ctx, "No lock detected so attempt to get one")
@@ -276,14 +981,16 @@ func (gcp *GcpConnectorGeneric) WaitForlock(ctx context.Context, timeout time.Du
 				tflog.Debug(ctx, fmt.Sprintf("LOCK RETRIEVED %s", lock.String()))
 				return lock, nil
 			}
+			var gerr *googleapi.Error
+			if gcp.FailIfLocked && errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed {
+				// Another process took the lock since it was checked.
+				return uuid.Nil, gcp.lockedError(ctx)
+			}
 			tflog.Debug(ctx, "THERE IS ERROR CREATING NEW LOCK, WAIT AGAIN")
 		}
 		// Backoff sleep.
 		numberOfIteration++
-		baseBackoff := time.Duration(numberOfIteration) * minBackoff
-		if baseBackoff > maxBackoff {
-			baseBackoff = maxBackoff
-		}
+		baseBackoff := lockBackoff(numberOfIteration, backoffMultiplier)
 
 		jitter := time.Duration(rand.Int63n(int64(baseBackoff / 2)))
 		sleepTime := baseBackoff - (baseBackoff / 4) + jitter
@@ -300,7 +1007,6 @@ func (gcp *GcpConnectorGeneric) WaitForlock(ctx context.Context, timeout time.Du
 
 		select {
 		case <-time.After(sleepTime):
-			time.Sleep(sleepTime)
 		case <-ctx.Done():
 			return uuid.Nil, fmt.Errorf("Context canceled while waiting for lock: %w", ctx.Err())
 		}
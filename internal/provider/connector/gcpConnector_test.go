@@ -0,0 +1,651 @@
+package connector
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+	"golang.org/x/oauth2"
+)
+
+func TestWrite_generationConflict(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	if err := gcp.Write(ctx, map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else modifies the object after our write.
+	server.Put("bucket", "path/object", []byte(`{}`))
+
+	err := gcp.Write(ctx, map[string]string{"a": "c"})
+	if !errors.Is(err, ErrGenerationConflict) {
+		t.Fatalf("expected a generation conflict, got: %v", err)
+	}
+}
+
+func TestRead_missThenWrite(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	if err := gcp.Write(ctx, map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]string
+	if err := gcp.Read(ctx, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Someone else deletes the object after our read.
+	server.Delete("bucket", "path/object")
+	if err := gcp.Read(ctx, &data); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected the object not to exist, got: %v", err)
+	}
+	if gcp.GetGeneration() != -1 {
+		t.Fatalf("expected the generation to be reset by the read miss, got %d", gcp.GetGeneration())
+	}
+	if err := gcp.Write(ctx, map[string]string{"a": "c"}); err != nil {
+		t.Fatalf("expected the write to create the object again, got: %v", err)
+	}
+
+	// The next write after a read miss must not overwrite an object created in between.
+	server.Delete("bucket", "path/object")
+	if err := gcp.Read(ctx, &data); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected the object not to exist, got: %v", err)
+	}
+	server.Put("bucket", "path/object", []byte(`{}`))
+	if err := gcp.Write(ctx, map[string]string{"a": "d"}); !errors.Is(err, ErrGenerationConflict) {
+		t.Fatalf("expected a generation conflict, got: %v", err)
+	}
+}
+
+func TestLock_lockBucket(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.LockBucket = "locks"
+
+	lockId, err := gcp.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Get("locks", "path/object.lock"); !ok {
+		t.Fatal("expected the lock in the lock bucket, with the path derived from the object")
+	}
+	if _, ok := server.Get("bucket", "path/object.lock"); ok {
+		t.Fatal("the lock must not be written in the data bucket")
+	}
+	if current, err := gcp.GetCurrentLockId(ctx); err != nil || current != lockId {
+		t.Fatalf("expected current lock %s, got %s: %v", lockId, current, err)
+	}
+	if err := gcp.Unlock(ctx, lockId); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := server.Get("locks", "path/object.lock"); ok {
+		t.Fatal("the lock must be deleted from the lock bucket")
+	}
+}
+
+func TestDeleteLockCreatedBefore(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	other := NewGeneric("bucket", "path/object-other")
+	stale := time.Now().Add(-time.Hour)
+
+	if deleted, err := gcp.DeleteLockCreatedBefore(ctx, time.Now()); err != nil || deleted {
+		t.Fatalf("expected no lock to delete, got %v: %v", deleted, err)
+	}
+	for _, lock := range []*GcpConnectorGeneric{&gcp, &other} {
+		if _, err := lock.Lock(ctx); err != nil {
+			t.Fatal(err)
+		}
+		server.SetUpdated("bucket", lock.GetLockPath(ctx), stale)
+	}
+	server.Put("bucket", "path/object", []byte("{}"))
+
+	// A lock taken after the given time is kept.
+	if deleted, err := gcp.DeleteLockCreatedBefore(ctx, stale.Add(-time.Minute)); err != nil || deleted {
+		t.Fatalf("expected a newer lock to be kept, got %v: %v", deleted, err)
+	}
+	if deleted, err := gcp.DeleteLockCreatedBefore(ctx, time.Now()); err != nil || !deleted {
+		t.Fatalf("expected the stale lock to be deleted, got %v: %v", deleted, err)
+	}
+	if _, ok := server.Get("bucket", "path/object.lock"); ok {
+		t.Fatal("expected the lock object to be deleted")
+	}
+	for _, name := range []string{"path/object-other.lock", "path/object"} {
+		if _, ok := server.Get("bucket", name); !ok {
+			t.Fatalf("expected %s to be kept", name)
+		}
+	}
+}
+
+func TestGetCurrentLockId_transientError(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	lockId, err := gcp.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.FailDownloads("bucket", "path/object.lock", 1, http.StatusServiceUnavailable)
+	if current, err := gcp.GetCurrentLockId(ctx); err != nil || current != lockId {
+		t.Fatalf("expected the read to be retried and return lock %s, got %s: %v", lockId, current, err)
+	}
+
+	server.FailDownloads("bucket", "path/object.lock", lockReadAttempts, http.StatusServiceUnavailable)
+	if _, err := gcp.GetCurrentLockId(ctx); err == nil {
+		t.Fatal("expected an error once the retries are exhausted")
+	}
+
+	if err := gcp.Unlock(ctx, lockId); err != nil {
+		t.Fatal(err)
+	}
+	server.ResetRequests()
+	if _, err := gcp.GetCurrentLockId(ctx); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected the lock not to exist, got: %v", err)
+	}
+	if requests := server.Requests(); requests != 1 {
+		t.Fatalf("a missing lock must not be retried, got %d requests", requests)
+	}
+}
+
+func TestRead_encryptionKey(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	key := make([]byte, 32)
+	key[0] = 1
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.EncryptionKey = key
+	if err := gcp.Write(ctx, map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var read map[string]string
+	if err := gcp.Read(ctx, &read); err != nil || read["a"] != "b" {
+		t.Fatalf("expected to read the object with its key, got %v: %v", read, err)
+	}
+
+	otherKey := make([]byte, 32)
+	for _, encryptionKey := range [][]byte{nil, otherKey} {
+		other := NewGeneric("bucket", "path/object")
+		other.EncryptionKey = encryptionKey
+		if err := other.Read(ctx, &read); !errors.Is(err, ErrEncryptionKeyMismatch) {
+			t.Errorf("expected an encryption key mismatch with key %v, got: %v", encryptionKey, err)
+		}
+	}
+}
+
+func TestListVersions(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	for _, value := range []string{"1", "2", "3"} {
+		if err := gcp.Write(ctx, map[string]string{"a": value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.Put("bucket", "path/object.lock", []byte("lock"))
+	server.Put("bucket", "path/other", []byte("{}"))
+
+	versions, err := gcp.ListVersions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(versions) != 3 {
+		t.Fatalf("expected 3 versions, got %d", len(versions))
+	}
+	if versions[0].Generation != gcp.Generation || !versions[0].Deleted.IsZero() {
+		t.Errorf("expected the live version first, got generation %d", versions[0].Generation)
+	}
+	if versions[1].Generation >= versions[0].Generation || versions[2].Generation >= versions[1].Generation || versions[2].Deleted.IsZero() {
+		t.Errorf("expected noncurrent versions from the most recent, got %d then %d", versions[1].Generation, versions[2].Generation)
+	}
+}
+
+func TestRead_corruptedObject(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	server.Put("bucket", "path/pool", []byte(`{"start_from":1,"end_to":10,"members":{"a":"3"}}`))
+
+	gcp := NewGeneric("bucket", "path/pool")
+	var pool struct {
+		Members map[string]int64 `json:"members"`
+	}
+	err := gcp.Read(ctx, &pool)
+	if !errors.Is(err, ErrCorruptedObject) {
+		t.Fatalf("expected a corrupted object error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "gs://bucket/path/pool") || !strings.Contains(err.Error(), "edited manually") {
+		t.Errorf("expected the object path and a hint in the error, got: %s", err)
+	}
+}
+
+func TestListChildren(t *testing.T) {
+	for _, hierarchical := range []bool{false, true} {
+		server := gcstest.NewServer(t)
+		ctx := context.Background()
+		server.Put("bucket", "dir/a", []byte("{}"))
+		server.Put("bucket", "dir/a.lock", []byte("lock"))
+		server.Put("bucket", "dir/b", []byte("{}"))
+		server.Put("bucket", "dir/sub/c", []byte("{}"))
+		server.Put("bucket", "other/d", []byte("{}"))
+		if hierarchical {
+			server.PutFolder("bucket", "dir/")
+			server.PutFolder("bucket", "dir/sub/")
+			server.PutFolder("bucket", "dir/empty/")
+		} else {
+			// Consoles and tools create placeholder objects for the folders of flat buckets.
+			server.Put("bucket", "dir/", nil)
+			server.Put("bucket", "dir/empty/", nil)
+		}
+
+		names, err := ListChildren(ctx, "", "bucket", "dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(names, ",") != "a,b" {
+			t.Errorf("hierarchical=%t: expected the objects a and b, got %v", hierarchical, names)
+		}
+	}
+}
+
+func TestListLocks(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	if _, err := gcp.Lock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	server.Put("bucket", "path/object", []byte("{}"))
+	server.Put("bucket", "other/a.lock", []byte("lock"))
+
+	locks, err := ListLocks(ctx, "", "bucket", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 2 || locks[0].Name != "other/a.lock" || locks[1].Name != "path/object.lock" {
+		t.Fatalf("expected the two locks sorted by path, got %d", len(locks))
+	}
+	if locks[1].Metadata[LockOwnerMetadataKey] != lockOwner() {
+		t.Errorf("expected the lock owner in its metadata, got %v", locks[1].Metadata)
+	}
+	locks, err = ListLocks(ctx, "", "bucket", "path/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 {
+		t.Errorf("expected the prefix to filter the locks, got %d", len(locks))
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	if err := gcp.Write(ctx, map[string]int{"a": 1, "b": 2}); err != nil {
+		t.Fatal(err)
+	}
+	written := gcp.ContentHash
+	if len(written) != 64 {
+		t.Fatalf("expected a hex SHA-256, got %q", written)
+	}
+
+	// The same content formatted differently has the same hash.
+	server.Put("bucket", "path/object", []byte("{ \"b\": 2,\n  \"a\": 1 }"))
+	var data map[string]int
+	if err := gcp.Read(ctx, &data); err != nil {
+		t.Fatal(err)
+	}
+	if gcp.ContentHash != written {
+		t.Errorf("expected the hash of the reformatted content to be %s, got %s", written, gcp.ContentHash)
+	}
+
+	server.Put("bucket", "path/object", []byte(`{"a": 1, "b": 3}`))
+	if err := gcp.Read(ctx, &data); err != nil {
+		t.Fatal(err)
+	}
+	if gcp.ContentHash == written {
+		t.Error("expected a modified content to change the hash")
+	}
+}
+
+func TestWrite_objectMetadata(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.ObjectMetadata = map[string]string{"managed-by": "terraform", "owner": "team"}
+	// The first write expects no object, the second one the generation of the first.
+	for _, value := range []string{"1", "2"} {
+		if err := gcp.Write(ctx, map[string]string{"a": value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	obj, ok := server.Get("bucket", "path/object")
+	if !ok {
+		t.Fatal("expected the object to be written")
+	}
+	if obj.Metadata["managed-by"] != "terraform" || obj.Metadata["owner"] != "team" {
+		t.Errorf("expected the object metadata on the object, got %v", obj.Metadata)
+	}
+	if _, ok := obj.Metadata[ProviderVersionMetadataKey]; ok {
+		t.Errorf("expected no provider version without ProviderVersion, got %v", obj.Metadata)
+	}
+
+	// The provider version is stamped next to the object metadata, which is left unchanged.
+	gcp.ProviderVersion = "1.4.0"
+	if err := gcp.Write(ctx, map[string]string{"a": "3"}); err != nil {
+		t.Fatal(err)
+	}
+	obj, _ = server.Get("bucket", "path/object")
+	if obj.Metadata[ProviderVersionMetadataKey] != "1.4.0" || obj.Metadata["owner"] != "team" {
+		t.Errorf("expected the provider version on the object, got %v", obj.Metadata)
+	}
+	if _, ok := gcp.ObjectMetadata[ProviderVersionMetadataKey]; ok {
+		t.Errorf("expected the object metadata of the connector to be left unchanged, got %v", gcp.ObjectMetadata)
+	}
+
+	server.Put("bucket", "path/object", []byte(`{}`))
+	if err := gcp.Write(ctx, map[string]string{"a": "4"}); !errors.Is(err, ErrGenerationConflict) {
+		t.Fatalf("expected the generation precondition to still apply, got: %v", err)
+	}
+}
+
+func TestWaitForlock_failIfLocked(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	holder := NewGeneric("bucket", "path/object")
+	lockId, err := holder.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.FailIfLocked = true
+	start := time.Now()
+	if _, err := gcp.WaitForlock(ctx, time.Minute, 0.5); !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), lockOwner()) {
+		t.Fatalf("expected ErrLocked naming the owner, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected to fail without waiting, took %s", elapsed)
+	}
+
+	if err := holder.Unlock(ctx, lockId); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := gcp.WaitForlock(ctx, time.Minute, 0.5); err != nil {
+		t.Fatalf("expected the free lock to be acquired, got %v", err)
+	}
+}
+
+func TestUnlock_retention(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	lockId, err := gcp.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetRetained("bucket", gcp.GetLockPath(ctx))
+	if err := gcp.Unlock(ctx, lockId); !errors.Is(err, ErrLockRetention) {
+		t.Fatalf("expected ErrLockRetention, got %v", err)
+	}
+}
+
+func TestUnlock_lockLost(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	lockId, err := gcp.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another process broke the lock and took its own.
+	server.Put("bucket", "path/object.lock", []byte("other-lock-id"))
+
+	// Without ttl, or before it elapsed, the lock was not legitimately broken.
+	if err := gcp.Unlock(ctx, lockId); err == nil || errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected a lock id mismatch error without ttl, got %v", err)
+	}
+	gcp.LockTTL = time.Hour
+	if err := gcp.Unlock(ctx, lockId); err == nil || errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected a lock id mismatch error before the ttl, got %v", err)
+	}
+
+	gcp.lockedAt = time.Now().Add(-2 * time.Hour)
+	if err := gcp.Unlock(ctx, lockId); !errors.Is(err, ErrLockLost) || !strings.Contains(err.Error(), "other-lock-id") {
+		t.Fatalf("expected ErrLockLost naming the lock id, got %v", err)
+	}
+	if object, ok := server.Get("bucket", "path/object.lock"); !ok || string(object.Data) != "other-lock-id" {
+		t.Fatal("expected the lock of the other process to be kept")
+	}
+	server.Delete("bucket", "path/object.lock")
+	if err := gcp.Unlock(ctx, lockId); !errors.Is(err, ErrLockLost) {
+		t.Fatalf("expected ErrLockLost once the lock is gone, got %v", err)
+	}
+}
+
+// versionedDocument records the schema versions it is migrated from.
+type versionedDocument struct {
+	SchemaVersion int    `json:"schema_version,omitempty"`
+	Value         string `json:"value"`
+	migratedFrom  []int
+}
+
+func (d *versionedDocument) CurrentSchemaVersion() int {
+	return 2
+}
+
+func (d *versionedDocument) MigrateSchema(fromVersion int) error {
+	d.migratedFrom = append(d.migratedFrom, fromVersion)
+	d.SchemaVersion = 2
+	return nil
+}
+
+func TestWaitForlock_skipLock(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	holder := NewGeneric("bucket", "path/object")
+	lockId, err := holder.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.SkipLock = true
+	server.ResetRequests()
+	skippedId, err := gcp.WaitForlock(ctx, time.Second, 2)
+	if err != nil {
+		t.Fatalf("expected the held lock to be ignored, got %v", err)
+	}
+	if err := gcp.Unlock(ctx, skippedId); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.Requests(); requests != 0 {
+		t.Fatalf("expected no request for a skipped lock, got %d", requests)
+	}
+	if current, err := holder.GetCurrentLockId(ctx); err != nil || current != lockId {
+		t.Fatalf("expected the lock of the holder to be kept, got %s, %v", current, err)
+	}
+}
+
+func TestStorageEndpoint(t *testing.T) {
+	server := gcstest.NewServer(t)
+	// Only the endpoint leads to the fake server.
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "token")
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	gcp.StorageEndpoint = server.URL + "/storage/v1/"
+
+	lockId, err := gcp.WaitForlock(ctx, time.Minute, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gcp.Write(ctx, map[string]string{"a": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var read map[string]string
+	if err := gcp.Read(ctx, &read); err != nil || read["a"] != "b" {
+		t.Fatalf("unexpected read through the endpoint: %v, %v", read, err)
+	}
+	if err := gcp.Unlock(ctx, lockId); err != nil {
+		t.Fatal(err)
+	}
+	names, err := ListChildren(ctx, gcp.StorageEndpoint, "bucket", "path")
+	if err != nil || len(names) != 1 || names[0] != "object" {
+		t.Fatalf("unexpected listing through the endpoint: %v, %v", names, err)
+	}
+	if server.Requests() == 0 {
+		t.Fatal("expected the requests to be sent to the endpoint")
+	}
+}
+
+func TestLockBackoff(t *testing.T) {
+	schedule := func(multiplier float32) []time.Duration {
+		var waits []time.Duration
+		for i := 1; i <= 5; i++ {
+			waits = append(waits, lockBackoff(i, multiplier))
+		}
+		return waits
+	}
+	expected := map[float32][]time.Duration{
+		1:   {time.Second, time.Second, time.Second, time.Second, time.Second},
+		2:   {time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, maxLockBackoff},
+		3:   {time.Second, 3 * time.Second, 9 * time.Second, maxLockBackoff, maxLockBackoff},
+		0.5: {time.Second, time.Second, time.Second, time.Second, time.Second},
+	}
+	for multiplier, waits := range expected {
+		if got := schedule(multiplier); !reflect.DeepEqual(got, waits) {
+			t.Errorf("unexpected schedule for multiplier %v: %v, expected %v", multiplier, got, waits)
+		}
+	}
+	if got := lockBackoff(1000, 2); got != maxLockBackoff {
+		t.Errorf("expected the wait to be capped, got %s", got)
+	}
+}
+
+func TestWaitForlock_wait(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	other := NewGeneric("bucket", "path/object")
+	otherLock, err := other.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = other.Unlock(ctx, otherLock)
+	}()
+
+	// The lock is released during the first wait, taken on the second try after lockBackoff(1) give or take its jitter.
+	start := time.Now()
+	if _, err := gcp.WaitForlock(ctx, time.Minute, 1); err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < minLockBackoff*3/4 || waited > minLockBackoff*3/2 {
+		t.Fatalf("expected a single wait of about %s, waited %s", minLockBackoff, waited)
+	}
+}
+
+func TestRead_schemaVersion(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+
+	server.Put("bucket", "path/object", []byte(`{"value":"legacy"}`))
+	var legacy versionedDocument
+	if err := gcp.Read(ctx, &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.migratedFrom) != 1 || legacy.migratedFrom[0] != 0 || legacy.SchemaVersion != 2 {
+		t.Fatalf("expected an object without schema_version to be migrated from 0, got %v", legacy.migratedFrom)
+	}
+
+	if err := gcp.Write(ctx, &versionedDocument{Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := server.Get("bucket", "path/object"); !strings.Contains(string(stored.Data), `"schema_version":2`) {
+		t.Fatalf("expected the current schema_version to be written, got %s", stored.Data)
+	}
+
+	server.Put("bucket", "path/object", []byte(`{"schema_version":3,"value":"future"}`))
+	var future versionedDocument
+	if err := gcp.Read(ctx, &future); !errors.Is(err, ErrUnsupportedSchemaVersion) {
+		t.Fatalf("expected a newer schema_version to be refused, got %v", err)
+	}
+}
+
+func TestReadWrite_minProviderVersion(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	writer := NewGeneric("bucket", "path/object")
+	writer.ProviderVersion = "1.3.0"
+	writer.MinProviderVersion = "1.2.0"
+	if err := writer.Write(ctx, &versionedDocument{Value: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := server.Get("bucket", "path/object"); !strings.Contains(string(stored.Data), `"min_provider_version":"1.2.0"`) {
+		t.Fatalf("expected the min_provider_version to be stamped, got %s", stored.Data)
+	}
+
+	for providerVersion, tooOld := range map[string]bool{"1.1.9": true, "1.2.0": false, "2.0.0": false, "dev": false, "": false} {
+		server.Put("bucket", "path/object", []byte(`{"min_provider_version":"1.2.0","value":"new"}`))
+		gcp := NewGeneric("bucket", "path/object")
+		gcp.ProviderVersion = providerVersion
+		var document versionedDocument
+		if err := gcp.Read(ctx, &document); errors.Is(err, ErrProviderTooOld) != tooOld {
+			t.Errorf("unexpected read error for provider %q: %v", providerVersion, err)
+		}
+		if err := gcp.Write(ctx, &document); errors.Is(err, ErrProviderTooOld) != tooOld {
+			t.Errorf("unexpected write error for provider %q: %v", providerVersion, err)
+		}
+	}
+}
+
+func TestRead_accessTokenExpired(t *testing.T) {
+	server := gcstest.NewServer(t)
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "expired-token")
+	defaultTokenSource := fallbackTokenSource
+	t.Cleanup(func() {
+		fallbackTokenSource = defaultTokenSource
+		accessTokenFallback.Store(false)
+		accessTokenRefused.Store(false)
+		fallbackOnce = sync.Once{}
+		fallbackTransport, fallbackErr = nil, nil
+	})
+	ctx := context.Background()
+	gcp := NewGeneric("bucket", "path/object")
+	if err := gcp.Write(ctx, map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FailDownloads("bucket", "path/object", 1, http.StatusUnauthorized)
+	var data map[string]string
+	if err := gcp.Read(ctx, &data); !errors.Is(err, ErrAccessTokenExpired) {
+		t.Fatalf("expected an access token expired error, got: %v", err)
+	}
+
+	fallbackTokenSource = func(ctx context.Context) (oauth2.TokenSource, error) {
+		return oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fallback-token"}), nil
+	}
+	EnableAccessTokenFallback()
+	accessTokenRefused.Store(false)
+	server.FailDownloads("bucket", "path/object", 1, http.StatusUnauthorized)
+	if err := gcp.Read(ctx, &data); err != nil || data["key"] != "value" {
+		t.Fatalf("expected the read to be sent again with the fallback credentials, got %v: %v", data, err)
+	}
+	if !accessTokenRefused.Load() {
+		t.Fatal("expected the next requests to go to the fallback credentials")
+	}
+}
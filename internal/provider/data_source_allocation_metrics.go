@@ -0,0 +1,93 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &AllocationMetricsDataSource{}
+
+const allocationMetricsDataSourceName = "allocation_metrics"
+
+func NewAllocationMetricsDataSource() datasource.DataSource {
+	return &AllocationMetricsDataSource{}
+}
+
+type AllocationMetricsDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type AllocationMetricsDataSourceModel struct {
+	IdAllocations     types.Int64  `tfsdk:"id_allocations"`
+	SubnetAllocations types.Int64  `tfsdk:"subnet_allocations"`
+	TotalAllocations  types.Int64  `tfsdk:"total_allocations"`
+	UpdatedAt         types.String `tfsdk:"updated_at"`
+}
+
+func (d *AllocationMetricsDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + allocationMetricsDataSourceName
+}
+
+func (d *AllocationMetricsDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source reads the totals of the allocations ever made in the referential, counted by the providers configured with allocation_metrics, for example for billing. " +
+			"The counters only grow, a released id or subnet is not subtracted, and they are all 0 until a first allocation is counted. The metrics object is read without lock and never written",
+
+		Attributes: map[string]schema.Attribute{
+			"id_allocations": schema.Int64Attribute{
+				MarkdownDescription: "The number of ids allocated to id_requests",
+				Computed:            true,
+			},
+			"subnet_allocations": schema.Int64Attribute{
+				MarkdownDescription: "The number of network_requests reserved",
+				Computed:            true,
+			},
+			"total_allocations": schema.Int64Attribute{
+				MarkdownDescription: "The sum of id_allocations and subnet_allocations",
+				Computed:            true,
+			},
+			"updated_at": schema.StringAttribute{
+				MarkdownDescription: "The last time, as a RFC3339 timestamp, an allocation was counted. Null when none was ever counted",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *AllocationMetricsDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *AllocationMetricsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	metrics, err := readAllocationMetrics(ctx, d.providerData)
+	if err != nil {
+		resp.Diagnostics.AddError("allocation_metrics read error", fmt.Sprintf("Cannot read the allocation metrics in %s: %s", d.providerData.ReferentialBucket.ValueString(), err.Error()))
+		return
+	}
+	data := AllocationMetricsDataSourceModel{
+		IdAllocations:     types.Int64Value(metrics.IdAllocations),
+		SubnetAllocations: types.Int64Value(metrics.SubnetAllocations),
+		TotalAllocations:  types.Int64Value(metrics.IdAllocations + metrics.SubnetAllocations),
+		UpdatedAt:         types.StringNull(),
+	}
+	if metrics.UpdatedAt != nil {
+		data.UpdatedAt = types.StringValue(metrics.UpdatedAt.Format(time.RFC3339))
+	}
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,129 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &ExportDataSource{}
+
+const exportDataSourceName = "export"
+
+func NewExportDataSource() datasource.DataSource {
+	return &ExportDataSource{}
+}
+
+type ExportDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type ExportDataSourceModel struct {
+	Pools   types.List   `tfsdk:"pools"`
+	Content types.String `tfsdk:"content"`
+}
+
+// exportDocument is the JSON document of the content attribute.
+type exportDocument struct {
+	Pools map[string]json.RawMessage `json:"pools"`
+}
+
+func (d *ExportDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + exportDataSourceName
+}
+
+func (d *ExportDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source exports the objects of id_pools as a single JSON document, for backup or migration. " +
+			"The objects are read without taking their lock: the export is a point-in-time snapshot of each pool, which may be stale as soon as it is read, " +
+			"and pools modified during the read may be exported at different moments",
+
+		Attributes: map[string]schema.Attribute{
+			"pools": schema.ListAttribute{
+				MarkdownDescription: "The names of the id_pools to export, every id_pool of the provider, for its tenant, when not set",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"content": schema.StringAttribute{
+				MarkdownDescription: "The exported JSON document, an object whose `pools` attribute holds the stored JSON of each id_pool by name",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *ExportDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+// exportIdPools returns the JSON document of the given pools, or of every pool when poolNames is nil.
+// The pools listed but deleted before being read are left out, a requested pool must exist.
+func exportIdPools(ctx context.Context, p *GCSReferentialProviderModel, poolNames []string) (string, error) {
+	all := poolNames == nil
+	if all {
+		var err error
+		poolNames, err = listIdPools(ctx, p)
+		if err != nil {
+			return "", fmt.Errorf("cannot list the pools: %w", err)
+		}
+	}
+	document := exportDocument{Pools: make(map[string]json.RawMessage, len(poolNames))}
+	for _, poolName := range poolNames {
+		gcpConnector := p.idPoolConnector(poolName)
+		var content json.RawMessage
+		err := gcpConnector.Read(ctx, &content)
+		if all && errors.Is(err, storage.ErrObjectNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot read pool %s: %w", poolName, err)
+		}
+		document.Pools[poolName] = content
+	}
+	content, err := json.Marshal(document)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+func (d *ExportDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data ExportDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	var poolNames []string
+	if !data.Pools.IsNull() {
+		poolNames = []string{}
+		resp.Diagnostics.Append(data.Pools.ElementsAs(ctx, &poolNames, false)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	content, err := exportIdPools(ctx, d.providerData, poolNames)
+	if err != nil {
+		resp.Diagnostics.AddError("export read error", err.Error())
+		return
+	}
+	data.Content = types.StringValue(content)
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestExportIdPools(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool-a", 1, 3)
+	createTestIdPool(t, p, "pool-b", 5, 6)
+	allocateTestMember(t, p, "pool-a", "member")
+
+	content, err := exportIdPools(ctx, p, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Pools map[string]StoredIdPool `json:"pools"`
+	}
+	if err := json.Unmarshal([]byte(content), &document); err != nil {
+		t.Fatalf("expected a JSON document, got %s: %s", content, err)
+	}
+	if len(document.Pools) != 2 || document.Pools["pool-b"].StartFrom != 5 {
+		t.Fatalf("expected both pools to be exported, got %s", content)
+	}
+	if _, ok := document.Pools["pool-a"].Members["member"]; !ok {
+		t.Errorf("expected the members of pool-a to be exported, got %s", content)
+	}
+
+	content, err = exportIdPools(ctx, p, []string{"pool-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	document.Pools = nil
+	if err := json.Unmarshal([]byte(content), &document); err != nil || len(document.Pools) != 1 {
+		t.Fatalf("expected only pool-b, got %s", content)
+	}
+	if _, err := exportIdPools(ctx, p, []string{"missing"}); err == nil {
+		t.Error("expected an error on a requested pool that does not exist")
+	}
+}
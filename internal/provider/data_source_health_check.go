@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+var _ datasource.DataSource = &HealthCheckDataSource{}
+
+const healthCheckDataSourceName = "health_check"
+
+func NewHealthCheckDataSource() datasource.DataSource {
+	return &HealthCheckDataSource{}
+}
+
+type HealthCheckDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type HealthCheckDataSourceModel struct {
+	Id      types.String `tfsdk:"id"`
+	Healthy types.Bool   `tfsdk:"healthy"`
+	Details types.String `tfsdk:"details"`
+}
+
+// healthCheckObject is the content of the temporary object written by the health check.
+type healthCheckObject struct {
+	CheckId string `json:"check_id"`
+}
+
+func (d *HealthCheckDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + healthCheckDataSourceName
+}
+
+func (d *HealthCheckDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source checks that the provider can read, write, lock and unlock objects in the referential bucket. " +
+			"It writes a temporary object under the healthcheck/ prefix, locks and unlocks it, then deletes it, even if one of the steps failed. " +
+			"Use it as a preflight before allocating ids or networks",
+
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The id of the health check run",
+				Computed:            true,
+			},
+			"healthy": schema.BoolAttribute{
+				MarkdownDescription: "True if every step of the health check succeeded",
+				Computed:            true,
+			},
+			"details": schema.StringAttribute{
+				MarkdownDescription: "The result of each step of the health check, with the error of the failed ones",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *HealthCheckDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *HealthCheckDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data HealthCheckDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	checkId := uuid.New().String()
+	gcpConnector := d.providerData.genericConnector(fmt.Sprintf("%s/healthcheck/%s.json", ProviderName, checkId))
+
+	details, err := runHealthCheck(ctx, &gcpConnector)
+	if err != nil {
+		tflog.Warn(ctx, fmt.Sprintf("Health check of bucket %s failed: %s", gcpConnector.BucketName, err.Error()))
+	}
+	data.Id = types.StringValue(checkId)
+	data.Healthy = types.BoolValue(err == nil)
+	data.Details = types.StringValue(details)
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// runHealthCheck writes, reads, locks, unlocks and deletes the object of gcpConnector. The object and its lock are
+// cleaned up whatever the step that failed. It returns a line per step and the first error met, cleanup included.
+func runHealthCheck(ctx context.Context, gcpConnector *connector.GcpConnectorGeneric) (string, error) {
+	var report []string
+	var errs []error
+	step := func(name string, err error) bool {
+		if err != nil {
+			report = append(report, fmt.Sprintf("%s: failed: %s", name, err.Error()))
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			return false
+		}
+		report = append(report, fmt.Sprintf("%s: ok", name))
+		return true
+	}
+
+	written := false
+	lockId := uuid.Nil
+	func() {
+		expected := healthCheckObject{CheckId: uuid.New().String()}
+		if !step("write", gcpConnector.Write(ctx, &expected)) {
+			return
+		}
+		written = true
+
+		var read healthCheckObject
+		err := gcpConnector.Read(ctx, &read)
+		if err == nil && read != expected {
+			err = errors.New("the object read back differs from the one written")
+		}
+		if !step("read", err) {
+			return
+		}
+
+		lockId, err = gcpConnector.Lock(ctx)
+		if !step("lock", err) {
+			return
+		}
+		err = gcpConnector.Unlock(ctx, lockId)
+		if step("unlock", err) {
+			lockId = uuid.Nil
+		}
+	}()
+
+	// Cleanup, also run when a previous step failed.
+	if lockId != uuid.Nil {
+		step("cleanup lock", gcpConnector.Unlock(ctx, lockId))
+	}
+	if written {
+		step("delete", gcpConnector.Delete(ctx))
+	}
+	return strings.Join(report, "\n"), errors.Join(errs...)
+}
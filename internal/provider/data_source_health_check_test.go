@@ -0,0 +1,49 @@
+package provider
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+func TestRunHealthCheck(t *testing.T) {
+	server := gcstest.NewServer(t)
+	gcpConnector := connector.NewGeneric(testBucket, "gcsreferential/healthcheck/test.json")
+
+	details, err := runHealthCheck(context.Background(), &gcpConnector)
+	if err != nil {
+		t.Fatalf("expected a healthy bucket, got %s\n%s", err, details)
+	}
+	for _, expected := range []string{"write: ok", "read: ok", "lock: ok", "unlock: ok", "delete: ok"} {
+		if !strings.Contains(details, expected) {
+			t.Errorf("expected %q in details:\n%s", expected, details)
+		}
+	}
+	if _, ok := server.Get(testBucket, gcpConnector.FullFilePath); ok {
+		t.Error("the health check object must be deleted")
+	}
+	if _, ok := server.Get(testBucket, gcpConnector.GetLockPath(context.Background())); ok {
+		t.Error("the health check lock must be deleted")
+	}
+}
+
+func TestRunHealthCheck_cleanupOnFailure(t *testing.T) {
+	server := gcstest.NewServer(t)
+	gcpConnector := connector.NewGeneric(testBucket, "gcsreferential/healthcheck/test.json")
+	// A leftover lock makes the lock step fail after the object was written.
+	server.Put(testBucket, gcpConnector.GetLockPath(context.Background()), []byte("00000000-0000-0000-0000-000000000001"))
+
+	details, err := runHealthCheck(context.Background(), &gcpConnector)
+	if err == nil {
+		t.Fatalf("expected the lock step to fail:\n%s", details)
+	}
+	if !strings.Contains(details, "lock: failed") || !strings.Contains(details, "delete: ok") {
+		t.Errorf("unexpected details:\n%s", details)
+	}
+	if _, ok := server.Get(testBucket, gcpConnector.FullFilePath); ok {
+		t.Error("the health check object must be deleted even when a step failed")
+	}
+}
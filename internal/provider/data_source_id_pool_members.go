@@ -0,0 +1,148 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &IdPoolMembersDataSource{}
+
+const idPoolMembersDataSourceName = "id_pool_members"
+
+func NewIdPoolMembersDataSource() datasource.DataSource {
+	return &IdPoolMembersDataSource{}
+}
+
+type IdPoolMembersDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type IdPoolMembersDataSourceModel struct {
+	Name          types.String `tfsdk:"name"`
+	MatchMetadata types.Map    `tfsdk:"match_metadata"`
+	Members       types.List   `tfsdk:"members"`
+}
+
+// idPoolMemberAttrTypes is the object type of a members element.
+var idPoolMemberAttrTypes = map[string]attr.Type{
+	"name":     types.StringType,
+	"value":    types.Int64Type,
+	"metadata": types.MapType{ElemType: types.StringType},
+}
+
+func (d *IdPoolMembersDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + idPoolMembersDataSourceName
+}
+
+func (d *IdPoolMembersDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source lists the members of an id_pool whose metadata matches a filter, for example the ids owned by a team in a shared pool. " +
+			"The metadata of a member is the one of its id_request",
+
+		Attributes: map[string]schema.Attribute{
+			"name": schema.StringAttribute{
+				MarkdownDescription: "The name of the id_pool",
+				Required:            true,
+			},
+			"match_metadata": schema.MapAttribute{
+				MarkdownDescription: "The metadata entries a member must all hold to be listed, for example `{ team = \"payments\" }`. The members without metadata, such as those of a pool written before the metadata were introduced, never match a non-empty filter. Default to every member",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"members": schema.ListNestedAttribute{
+				MarkdownDescription: "The matching members, sorted by name",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"name": schema.StringAttribute{
+							MarkdownDescription: "The name of the member, the id of its id_request",
+							Computed:            true,
+						},
+						"value": schema.Int64Attribute{
+							MarkdownDescription: "The id held by the member",
+							Computed:            true,
+						},
+						"metadata": schema.MapAttribute{
+							MarkdownDescription: "The metadata of the member, empty when it has none",
+							ElementType:         types.StringType,
+							Computed:            true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *IdPoolMembersDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *IdPoolMembersDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data IdPoolMembersDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	filter, diags := metadataValues(ctx, data.MatchMetadata)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := d.providerData.idPoolConnector(data.Name.ValueString())
+	cachedPool, err := getAndCacheIdPool(ctx, d.providerData, data.Name.ValueString(), &gcpConnector)
+	if err != nil {
+		resp.Diagnostics.AddError("id_pool_members read error", objectDetail(&gcpConnector, "Cannot read pool %s: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
+	pool := cachedPool.Pool
+
+	names := make([]string, 0, len(pool.Members))
+	for name := range pool.Members {
+		if pool.matchesMetadata(name, filter) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	elements := make([]attr.Value, 0, len(names))
+	for _, name := range names {
+		metadata := map[string]string{}
+		if record, ok := pool.Records[name]; ok && record.Metadata != nil {
+			metadata = record.Metadata
+		}
+		metadataValue, diags := types.MapValueFrom(ctx, types.StringType, metadata)
+		resp.Diagnostics.Append(diags...)
+		element, diags := types.ObjectValue(idPoolMemberAttrTypes, map[string]attr.Value{
+			"name":     types.StringValue(name),
+			"value":    types.Int64Value(int64(pool.Members[name])),
+			"metadata": metadataValue,
+		})
+		resp.Diagnostics.Append(diags...)
+		elements = append(elements, element)
+	}
+	membersList, diags := types.ListValue(types.ObjectType{AttrTypes: idPoolMemberAttrTypes}, elements)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Members = membersList
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,176 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+var _ datasource.DataSource = &IdPoolVersionsDataSource{}
+
+const idPoolVersionsDataSourceName = "id_pool_versions"
+
+// defaultIdPoolVersionsLimit is the number of versions returned when limit is not set.
+const defaultIdPoolVersionsLimit = 10
+
+func NewIdPoolVersionsDataSource() datasource.DataSource {
+	return &IdPoolVersionsDataSource{}
+}
+
+type IdPoolVersionsDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type IdPoolVersionsDataSourceModel struct {
+	Name       types.String `tfsdk:"name"`
+	Limit      types.Int64  `tfsdk:"limit"`
+	ObjectPath types.String `tfsdk:"object_path"`
+	Versions   types.List   `tfsdk:"versions"`
+}
+
+// idPoolVersionAttrTypes is the object type of a versions element.
+var idPoolVersionAttrTypes = map[string]attr.Type{
+	"generation":       types.Int64Type,
+	"live":             types.BoolType,
+	"updated":          types.StringType,
+	"deleted":          types.StringType,
+	"size":             types.Int64Type,
+	"provider_version": types.StringType,
+}
+
+func (d *IdPoolVersionsDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + idPoolVersionsDataSourceName
+}
+
+func (d *IdPoolVersionsDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source lists the recent versions of the object backing an id_pool, the most recent first, to inspect or restore a previous state of the pool. " +
+			"The noncurrent versions are only kept when object versioning or soft delete is enabled on the referential_bucket. " +
+			"To restore a version, copy it over the live object, for example with `gcloud storage cp gs://<bucket>/<object_path>#<generation> gs://<bucket>/<object_path>`, " +
+			"while no apply is running on the pool, then refresh the state",
+
+		Attributes: map[string]schema.Attribute{
+			"name": schema.StringAttribute{
+				MarkdownDescription: "The name of the id_pool",
+				Required:            true,
+			},
+			"limit": schema.Int64Attribute{
+				MarkdownDescription: fmt.Sprintf("The maximum number of versions returned, default to %d", defaultIdPoolVersionsLimit),
+				Optional:            true,
+			},
+			"object_path": schema.StringAttribute{
+				MarkdownDescription: "The path of the object backing the id_pool in the referential_bucket",
+				Computed:            true,
+			},
+			"versions": schema.ListNestedAttribute{
+				MarkdownDescription: "The versions of the id_pool object, the most recent first",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"generation": schema.Int64Attribute{
+							MarkdownDescription: "The generation of the version, to use to restore it",
+							Computed:            true,
+						},
+						"live": schema.BoolAttribute{
+							MarkdownDescription: "True for the current version of the object",
+							Computed:            true,
+						},
+						"updated": schema.StringAttribute{
+							MarkdownDescription: "The time the version was written, in RFC 3339 format",
+							Computed:            true,
+						},
+						"deleted": schema.StringAttribute{
+							MarkdownDescription: "The time the version was replaced or deleted, in RFC 3339 format, null for the live version",
+							Computed:            true,
+						},
+						"size": schema.Int64Attribute{
+							MarkdownDescription: "The size of the version in bytes",
+							Computed:            true,
+						},
+						"provider_version": schema.StringAttribute{
+							MarkdownDescription: "The version of the provider that wrote the version, from its `provider_version` metadata, to correlate an anomaly with a release. Null when the version was written by hand or by a release that did not stamp it",
+							Computed:            true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *IdPoolVersionsDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *IdPoolVersionsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data IdPoolVersionsDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	limit := int64(defaultIdPoolVersionsLimit)
+	if !data.Limit.IsNull() {
+		limit = data.Limit.ValueInt64()
+	}
+	if limit <= 0 {
+		resp.Diagnostics.AddError("id_pool_versions read error", "limit must be greater than 0")
+		return
+	}
+
+	gcpConnector := d.providerData.idPoolConnector(data.Name.ValueString())
+	versions, err := gcpConnector.ListVersions(ctx)
+	if err != nil {
+		resp.Diagnostics.AddError("id_pool_versions read error", fmt.Sprintf("Cannot list the versions of pool %s: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
+	if int64(len(versions)) > limit {
+		versions = versions[:limit]
+	}
+
+	elements := make([]attr.Value, 0, len(versions))
+	for _, version := range versions {
+		deleted := types.StringNull()
+		if !version.Deleted.IsZero() {
+			deleted = types.StringValue(version.Deleted.Format(time.RFC3339))
+		}
+		providerVersion := types.StringNull()
+		if stamped, ok := version.Metadata[connector.ProviderVersionMetadataKey]; ok {
+			providerVersion = types.StringValue(stamped)
+		}
+		element, diags := types.ObjectValue(idPoolVersionAttrTypes, map[string]attr.Value{
+			"generation":       types.Int64Value(version.Generation),
+			"live":             types.BoolValue(version.Deleted.IsZero()),
+			"updated":          types.StringValue(version.Updated.Format(time.RFC3339)),
+			"deleted":          deleted,
+			"size":             types.Int64Value(version.Size),
+			"provider_version": providerVersion,
+		})
+		resp.Diagnostics.Append(diags...)
+		elements = append(elements, element)
+	}
+	versionsList, diags := types.ListValue(types.ObjectType{AttrTypes: idPoolVersionAttrTypes}, elements)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Versions = versionsList
+	data.ObjectPath = types.StringValue(gcpConnector.FullFilePath)
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,104 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &NetworkDataSource{}
+
+const networkDataSourceName = "network"
+
+func NewNetworkDataSource() datasource.DataSource {
+	return &NetworkDataSource{}
+}
+
+type NetworkDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type NetworkDataSourceModel struct {
+	BaseCidr      types.String `tfsdk:"base_cidr"`
+	Subnets       types.Map    `tfsdk:"subnets"`
+	ReservedCidrs types.List   `tfsdk:"reserved_cidrs"`
+}
+
+func (d *NetworkDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + networkDataSourceName
+}
+
+func (d *NetworkDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source reads the reservations made by the network_request of a base_cidr, for example to generate the routes or firewall rules of every reserved subnet. " +
+			"A base_cidr without any network_request yet has no reservation",
+
+		Attributes: map[string]schema.Attribute{
+			"base_cidr": schema.StringAttribute{
+				MarkdownDescription: "The supernet of the network_request to read, for example 10.0.0.0/8",
+				Required:            true,
+			},
+			"subnets": schema.MapAttribute{
+				MarkdownDescription: "The reserved subnets as full cidr, by id of network_request",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"reserved_cidrs": schema.ListAttribute{
+				MarkdownDescription: "The distinct reserved subnets as full cidr, sorted by network address then prefix length, to iterate over the reserved networks in a stable order",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *NetworkDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *NetworkDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data NetworkDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := d.providerData.networkConnector(data.BaseCidr.ValueString())
+	var networkConfig NetworkConfig
+	err := gcpConnector.Read(ctx, &networkConfig)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		resp.Diagnostics.AddError("network read error", fmt.Sprintf("Cannot Read %s in %s: %s", data.BaseCidr.ValueString(), d.providerData.ReferentialBucket.ValueString(), err.Error()))
+		return
+	}
+	if networkConfig.Subnets == nil {
+		networkConfig.Subnets = make(map[string]string)
+	}
+
+	subnets, diags := types.MapValueFrom(ctx, types.StringType, networkConfig.Subnets)
+	resp.Diagnostics.Append(diags...)
+	reservedCidrs, diags := types.ListValueFrom(ctx, types.StringType, sortedReservedCidrs(&networkConfig))
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Subnets = subnets
+	data.ReservedCidrs = reservedCidrs
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
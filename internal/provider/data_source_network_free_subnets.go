@@ -0,0 +1,122 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &NetworkFreeSubnetsDataSource{}
+
+const networkFreeSubnetsDataSourceName = "network_free_subnets"
+
+// defaultNetworkFreeSubnetsLimit is the number of free subnets returned when limit is not set.
+const defaultNetworkFreeSubnetsLimit = 100
+
+func NewNetworkFreeSubnetsDataSource() datasource.DataSource {
+	return &NetworkFreeSubnetsDataSource{}
+}
+
+type NetworkFreeSubnetsDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type NetworkFreeSubnetsDataSourceModel struct {
+	BaseCidr     types.String `tfsdk:"base_cidr"`
+	PrefixLength types.Int64  `tfsdk:"prefix_length"`
+	Limit        types.Int64  `tfsdk:"limit"`
+	FreeSubnets  types.List   `tfsdk:"free_subnets"`
+}
+
+func (d *NetworkFreeSubnetsDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + networkFreeSubnetsDataSourceName
+}
+
+func (d *NetworkFreeSubnetsDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source lists the subnets of a given prefix length still free in a base_cidr, for example to plan the capacity left in a supernet. " +
+			"The subnets are the ones the next network_requests would receive, in that order, with the policies of the base_cidr. The network config object is read without lock and never written",
+
+		Attributes: map[string]schema.Attribute{
+			"base_cidr": schema.StringAttribute{
+				MarkdownDescription: "The supernet to look into, for example 10.0.0.0/8",
+				Required:            true,
+			},
+			"prefix_length": schema.Int64Attribute{
+				MarkdownDescription: "The prefix of the free subnets, for example 24 for /24 subnets",
+				Required:            true,
+			},
+			"limit": schema.Int64Attribute{
+				MarkdownDescription: fmt.Sprintf("The maximum number of free subnets returned, default to %d", defaultNetworkFreeSubnetsLimit),
+				Optional:            true,
+			},
+			"free_subnets": schema.ListAttribute{
+				MarkdownDescription: "The free subnets as full cidr, in the order they would be allocated. Empty when the base_cidr is exhausted",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *NetworkFreeSubnetsDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *NetworkFreeSubnetsDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data NetworkFreeSubnetsDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	limit := int64(defaultNetworkFreeSubnetsLimit)
+	if !data.Limit.IsNull() {
+		limit = data.Limit.ValueInt64()
+	}
+	if limit <= 0 {
+		resp.Diagnostics.AddAttributeError(path.Root("limit"), "network_free_subnets read error", "limit must be greater than 0")
+		return
+	}
+	if _, err := firstSubnet(data.BaseCidr.ValueString(), data.PrefixLength.ValueInt64()); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("prefix_length"), "Invalid prefix_length", err.Error())
+		return
+	}
+
+	gcpConnector := d.providerData.networkConnector(data.BaseCidr.ValueString())
+	var networkConfig NetworkConfig
+	err := gcpConnector.Read(ctx, &networkConfig)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		resp.Diagnostics.AddError("network_free_subnets read error", fmt.Sprintf("Cannot Read %s in %s: %s", data.BaseCidr.ValueString(), d.providerData.ReferentialBucket.ValueString(), err.Error()))
+		return
+	}
+	free, err := freeSubnets(&networkConfig, data.PrefixLength.ValueInt64(), data.BaseCidr.ValueString(), limit)
+	if err != nil {
+		resp.Diagnostics.AddError("network_free_subnets read error", fmt.Sprintf("Cannot list the free subnets of %s with prefix %d: %s", data.BaseCidr.ValueString(), data.PrefixLength.ValueInt64(), err.Error()))
+		return
+	}
+	freeList, diags := types.ListValueFrom(ctx, types.StringType, free)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.FreeSubnets = freeList
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
@@ -0,0 +1,106 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ datasource.DataSource = &NetworkOwnerDataSource{}
+
+const networkOwnerDataSourceName = "network_owner"
+
+func NewNetworkOwnerDataSource() datasource.DataSource {
+	return &NetworkOwnerDataSource{}
+}
+
+type NetworkOwnerDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type NetworkOwnerDataSourceModel struct {
+	BaseCidr types.String `tfsdk:"base_cidr"`
+	Netmask  types.String `tfsdk:"netmask"`
+	Id       types.String `tfsdk:"id"`
+}
+
+func (d *NetworkOwnerDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + networkOwnerDataSourceName
+}
+
+func (d *NetworkOwnerDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source finds the network_request owning a reserved subnet of a base_cidr, for example to know which id holds 10.20.3.0/24. " +
+			"The read fails when the subnet is not reserved",
+
+		Attributes: map[string]schema.Attribute{
+			"base_cidr": schema.StringAttribute{
+				MarkdownDescription: "The supernet the subnet was reserved in, for example 10.0.0.0/8",
+				Required:            true,
+			},
+			"netmask": schema.StringAttribute{
+				MarkdownDescription: "The reserved subnet as full cidr, for example 10.20.3.0/24",
+				Required:            true,
+			},
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The id of the network_request holding the subnet",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *NetworkOwnerDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *NetworkOwnerDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data NetworkOwnerDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := d.providerData.networkConnector(data.BaseCidr.ValueString())
+	var networkConfig NetworkConfig
+	err := gcpConnector.Read(ctx, &networkConfig)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		resp.Diagnostics.AddError("network_owner read error", fmt.Sprintf("Cannot Read %s in %s: %s", data.BaseCidr.ValueString(), d.providerData.ReferentialBucket.ValueString(), err.Error()))
+		return
+	}
+	owners, err := subnetOwners(&networkConfig, data.Netmask.ValueString())
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("netmask"), "Invalid netmask", err.Error())
+		return
+	}
+	switch len(owners) {
+	case 0:
+		resp.Diagnostics.AddError("network_owner read error", fmt.Sprintf("%s is not reserved in %s", data.Netmask.ValueString(), data.BaseCidr.ValueString()))
+		return
+	case 1:
+		data.Id = types.StringValue(owners[0])
+	default:
+		resp.Diagnostics.AddError("network_owner read error", fmt.Sprintf("%s is reserved by several network_request in %s: %s", data.Netmask.ValueString(), data.BaseCidr.ValueString(), strings.Join(owners, ", ")))
+		return
+	}
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
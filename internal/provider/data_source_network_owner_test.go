@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSubnetOwners(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{
+		"a": "10.20.3.0/24",
+		"b": "10.20.2.0/23",
+		"c": "10.20.4.0/24",
+	}}
+	for netmask, want := range map[string]string{
+		"10.20.3.0/24": "a",
+		"10.20.3.7/24": "a",
+		"10.20.2.0/23": "b",
+		"10.20.2.0/24": "",
+		"10.20.5.0/24": "",
+	} {
+		owners, err := subnetOwners(networkConfig, netmask)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(owners, ","); got != want {
+			t.Fatalf("expected owner %q for %s, got %q", want, netmask, got)
+		}
+	}
+
+	networkConfig.Subnets["d"] = "10.20.3.0/24"
+	if owners, _ := subnetOwners(networkConfig, "10.20.3.0/24"); strings.Join(owners, ",") != "a,d" {
+		t.Fatalf("expected every owner of a shared subnet, sorted, got %v", owners)
+	}
+	if _, err := subnetOwners(networkConfig, "10.20.3.0"); err == nil {
+		t.Fatal("expected an error for a netmask without prefix length")
+	}
+}
@@ -0,0 +1,23 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortedReservedCidrs(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{
+		"a": "10.0.10.0/24",
+		"b": "10.0.2.0/24",
+		"c": "10.0.2.0/23",
+		"d": "10.0.2.0/24",
+		"e": "9.255.0.0/16",
+	}}
+	got := strings.Join(sortedReservedCidrs(networkConfig), ",")
+	if got != "9.255.0.0/16,10.0.2.0/23,10.0.2.0/24,10.0.10.0/24" {
+		t.Fatalf("expected the distinct cidrs sorted by network address, got %s", got)
+	}
+	if got := sortedReservedCidrs(&NetworkConfig{}); len(got) != 0 {
+		t.Fatalf("expected no cidr without reservation, got %v", got)
+	}
+}
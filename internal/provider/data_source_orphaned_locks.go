@@ -0,0 +1,165 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+var _ datasource.DataSource = &OrphanedLocksDataSource{}
+
+const orphanedLocksDataSourceName = "orphaned_locks"
+
+func NewOrphanedLocksDataSource() datasource.DataSource {
+	return &OrphanedLocksDataSource{}
+}
+
+type OrphanedLocksDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type OrphanedLocksDataSourceModel struct {
+	OlderThanMinutes types.Int64  `tfsdk:"older_than_minutes"`
+	Bucket           types.String `tfsdk:"bucket"`
+	Locks            types.List   `tfsdk:"locks"`
+}
+
+// orphanedLockAttrTypes is the object type of a locks element.
+var orphanedLockAttrTypes = map[string]attr.Type{
+	"path":        types.StringType,
+	"owner":       types.StringType,
+	"created":     types.StringType,
+	"age_minutes": types.Int64Type,
+}
+
+func (d *OrphanedLocksDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + orphanedLocksDataSourceName
+}
+
+func (d *OrphanedLocksDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source reports the lock objects held for longer than a threshold, usually left by a crashed or killed run, " +
+			"across the whole lock bucket or under the lock_prefix when set. It only reports them: a lock must be removed manually, " +
+			"after checking that its owner is no longer running, since the resource it protects is unusable until then",
+
+		Attributes: map[string]schema.Attribute{
+			"older_than_minutes": schema.Int64Attribute{
+				MarkdownDescription: "The minimal age of the reported locks, in minutes",
+				Required:            true,
+			},
+			"bucket": schema.StringAttribute{
+				MarkdownDescription: "The bucket holding the locks, the lock_bucket when set, the referential_bucket otherwise",
+				Computed:            true,
+			},
+			"locks": schema.ListNestedAttribute{
+				MarkdownDescription: "The locks older than older_than_minutes, sorted by path",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"path": schema.StringAttribute{
+							MarkdownDescription: "The path of the lock object in the bucket",
+							Computed:            true,
+						},
+						"owner": schema.StringAttribute{
+							MarkdownDescription: "The host and process id of the run which took the lock, as host:pid, null for the locks taken by older provider versions",
+							Computed:            true,
+						},
+						"created": schema.StringAttribute{
+							MarkdownDescription: "The time the lock was taken, in RFC 3339 format",
+							Computed:            true,
+						},
+						"age_minutes": schema.Int64Attribute{
+							MarkdownDescription: "The age of the lock, in minutes",
+							Computed:            true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func (d *OrphanedLocksDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+// findOrphanedLocks returns the locks of the lock bucket of p created before now minus olderThan.
+func findOrphanedLocks(ctx context.Context, p *GCSReferentialProviderModel, olderThan time.Duration, now time.Time) ([]*storage.ObjectAttrs, error) {
+	prefix := ""
+	if lockPrefix := p.LockPrefix.ValueString(); lockPrefix != "" {
+		prefix = strings.TrimSuffix(lockPrefix, "/") + "/"
+	}
+	locks, err := connector.ListLocks(ctx, p.StorageEndpoint.ValueString(), p.lockBucketName(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	var orphaned []*storage.ObjectAttrs
+	for _, lock := range locks {
+		if now.Sub(lock.Created) >= olderThan {
+			orphaned = append(orphaned, lock)
+		}
+	}
+	return orphaned, nil
+}
+
+func (d *OrphanedLocksDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	var data OrphanedLocksDataSourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if data.OlderThanMinutes.ValueInt64() < 0 {
+		resp.Diagnostics.AddError("orphaned_locks read error", "older_than_minutes must not be negative")
+		return
+	}
+
+	now := time.Now()
+	locks, err := findOrphanedLocks(ctx, d.providerData, time.Duration(data.OlderThanMinutes.ValueInt64())*time.Minute, now)
+	if err != nil {
+		resp.Diagnostics.AddError("orphaned_locks read error", fmt.Sprintf("Cannot list the locks of bucket %s: %s", d.providerData.lockBucketName(), err.Error()))
+		return
+	}
+
+	elements := make([]attr.Value, 0, len(locks))
+	for _, lock := range locks {
+		owner := types.StringNull()
+		if value, ok := lock.Metadata[connector.LockOwnerMetadataKey]; ok {
+			owner = types.StringValue(value)
+		}
+		element, diags := types.ObjectValue(orphanedLockAttrTypes, map[string]attr.Value{
+			"path":        types.StringValue(lock.Name),
+			"owner":       owner,
+			"created":     types.StringValue(lock.Created.Format(time.RFC3339)),
+			"age_minutes": types.Int64Value(int64(now.Sub(lock.Created) / time.Minute)),
+		})
+		resp.Diagnostics.Append(diags...)
+		elements = append(elements, element)
+	}
+	locksList, diags := types.ListValue(types.ObjectType{AttrTypes: orphanedLockAttrTypes}, elements)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Locks = locksList
+	data.Bucket = types.StringValue(d.providerData.lockBucketName())
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
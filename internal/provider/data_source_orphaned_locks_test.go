@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+func TestFindOrphanedLocks(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	p := newTestProviderData()
+	p.LockBucket = types.StringValue("locks")
+	for _, poolName := range []string{"old", "recent"} {
+		gcpConnector := p.idPoolConnector(poolName)
+		if _, err := gcpConnector.Lock(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	server.SetUpdated("locks", p.idPoolPath("old")+".lock", time.Now().Add(-2*time.Hour))
+
+	locks, err := findOrphanedLocks(ctx, p, time.Hour, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Name != p.idPoolPath("old")+".lock" {
+		t.Fatalf("expected only the old lock, got %d locks", len(locks))
+	}
+	if locks[0].Metadata[connector.LockOwnerMetadataKey] == "" {
+		t.Error("expected the owner of the lock")
+	}
+	if _, ok := server.Get("locks", p.idPoolPath("old")+".lock"); !ok {
+		t.Error("the orphaned lock must not be deleted")
+	}
+}
@@ -0,0 +1,157 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/datasource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+var _ datasource.DataSource = &ProviderConfigDataSource{}
+
+const providerConfigDataSourceName = "provider_config"
+
+func NewProviderConfigDataSource() datasource.DataSource {
+	return &ProviderConfigDataSource{}
+}
+
+type ProviderConfigDataSource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type ProviderConfigDataSourceModel struct {
+	ReferentialBucket       types.String  `tfsdk:"referential_bucket"`
+	LockBucket              types.String  `tfsdk:"lock_bucket"`
+	LockPrefix              types.String  `tfsdk:"lock_prefix"`
+	Tenant                  types.String  `tfsdk:"tenant"`
+	TimeoutInMinutes        types.Int32   `tfsdk:"timeout_in_minutes"`
+	BackoffMultiplier       types.Float32 `tfsdk:"backoff_multiplier"`
+	StorageEndpoint         types.String  `tfsdk:"storage_endpoint"`
+	FailIfLocked            types.Bool    `tfsdk:"fail_if_locked"`
+	SkipLock                types.Bool    `tfsdk:"skip_lock"`
+	CleanupEmpty            types.Bool    `tfsdk:"cleanup_empty"`
+	CrashSafe               types.Bool    `tfsdk:"crash_safe"`
+	AccessTokenFallback     types.Bool    `tfsdk:"access_token_fallback"`
+	EncryptionKeyConfigured types.Bool    `tfsdk:"encryption_key_configured"`
+	CredentialsSource       types.String  `tfsdk:"credentials_source"`
+	ProviderVersion         types.String  `tfsdk:"provider_version"`
+}
+
+func (d *ProviderConfigDataSource) Metadata(ctx context.Context, req datasource.MetadataRequest, resp *datasource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + providerConfigDataSourceName
+}
+
+func (d *ProviderConfigDataSource) Schema(ctx context.Context, req datasource.SchemaRequest, resp *datasource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This data source reads the effective configuration of the provider, its defaults resolved, for example to check which bucket and credentials a provider alias actually uses. " +
+			"The secrets are never returned, only whether they are set and where the credentials come from",
+
+		Attributes: map[string]schema.Attribute{
+			"referential_bucket": schema.StringAttribute{
+				MarkdownDescription: "The bucket holding the objects of the provider",
+				Computed:            true,
+			},
+			"lock_bucket": schema.StringAttribute{
+				MarkdownDescription: "The bucket where the `.lock` objects are written, the referential_bucket unless lock_bucket is set",
+				Computed:            true,
+			},
+			"lock_prefix": schema.StringAttribute{
+				MarkdownDescription: "The prefix of the `.lock` objects, empty when they are written next to the object they protect",
+				Computed:            true,
+			},
+			"tenant": schema.StringAttribute{
+				MarkdownDescription: "The tenant namespacing the id_pool objects, empty when none is set",
+				Computed:            true,
+			},
+			"timeout_in_minutes": schema.Int32Attribute{
+				MarkdownDescription: "The timeout of the operations, in minutes",
+				Computed:            true,
+			},
+			"backoff_multiplier": schema.Float32Attribute{
+				MarkdownDescription: "The multiplier of the wait between two tries to get a lock",
+				Computed:            true,
+			},
+			"storage_endpoint": schema.StringAttribute{
+				MarkdownDescription: "The endpoint of the storage API, empty for the public googleapis one",
+				Computed:            true,
+			},
+			"fail_if_locked": schema.BoolAttribute{
+				MarkdownDescription: "Whether an operation on a locked object fails immediately",
+				Computed:            true,
+			},
+			"skip_lock": schema.BoolAttribute{
+				MarkdownDescription: "Whether the operations skip the `.lock` objects",
+				Computed:            true,
+			},
+			"cleanup_empty": schema.BoolAttribute{
+				MarkdownDescription: "Whether the network config objects are deleted with their last reservation",
+				Computed:            true,
+			},
+			"crash_safe": schema.BoolAttribute{
+				MarkdownDescription: "Whether the allocations are written in two phases with an intent object",
+				Computed:            true,
+			},
+			"access_token_fallback": schema.BoolAttribute{
+				MarkdownDescription: "Whether the storage requests switch to the application default credentials once the access token is refused as expired",
+				Computed:            true,
+			},
+			"encryption_key_configured": schema.BoolAttribute{
+				MarkdownDescription: "Whether an encryption_key is set, the key itself is never returned",
+				Computed:            true,
+			},
+			"credentials_source": schema.StringAttribute{
+				MarkdownDescription: "Where the credentials come from: `emulator` when STORAGE_EMULATOR_HOST is set and no credentials are used, `access_token` for the GOOGLE_OAUTH_ACCESS_TOKEN variable, " +
+					"`credentials_file` for the file of GOOGLE_APPLICATION_CREDENTIALS, `application_default` otherwise, for the credentials of gcloud or of the metadata server",
+				Computed: true,
+			},
+			"provider_version": schema.StringAttribute{
+				MarkdownDescription: "The version of the running provider, stamped as the `provider_version` metadata of every object it writes. Empty for a local build without version",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (d *ProviderConfigDataSource) Configure(ctx context.Context, req datasource.ConfigureRequest, resp *datasource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Data Source Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	d.providerData = providerData
+}
+
+func (d *ProviderConfigDataSource) Read(ctx context.Context, req datasource.ReadRequest, resp *datasource.ReadResponse) {
+	data := providerConfigFromModel(d.providerData)
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// providerConfigFromModel returns the effective configuration of the provider, without its secrets.
+func providerConfigFromModel(p *GCSReferentialProviderModel) ProviderConfigDataSourceModel {
+	return ProviderConfigDataSourceModel{
+		ReferentialBucket:       types.StringValue(p.ReferentialBucket.ValueString()),
+		LockBucket:              types.StringValue(p.lockBucketName()),
+		LockPrefix:              types.StringValue(p.LockPrefix.ValueString()),
+		Tenant:                  types.StringValue(p.Tenant.ValueString()),
+		TimeoutInMinutes:        types.Int32Value(p.TimeoutInMinutes.ValueInt32()),
+		BackoffMultiplier:       types.Float32Value(p.BackoffMultiplier.ValueFloat32()),
+		StorageEndpoint:         types.StringValue(p.StorageEndpoint.ValueString()),
+		FailIfLocked:            types.BoolValue(p.FailIfLocked.ValueBool()),
+		SkipLock:                types.BoolValue(p.SkipLock.ValueBool()),
+		CleanupEmpty:            types.BoolValue(p.CleanupEmpty.ValueBool()),
+		CrashSafe:               types.BoolValue(p.CrashSafe.ValueBool()),
+		AccessTokenFallback:     types.BoolValue(p.AccessTokenFallback.ValueBool()),
+		EncryptionKeyConfigured: types.BoolValue(len(p.EncryptionKeyBytes) > 0),
+		CredentialsSource:       types.StringValue(connector.CredentialsSource()),
+		ProviderVersion:         types.StringValue(p.ProviderVersion),
+	}
+}
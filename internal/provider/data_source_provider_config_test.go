@@ -0,0 +1,41 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+func TestProviderConfigFromModel(t *testing.T) {
+	t.Setenv("STORAGE_EMULATOR_HOST", "")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "secret-token")
+	p := newTestProviderData()
+	p.EncryptionKeyBytes = []byte("0123456789abcdef0123456789abcdef")
+	p.ProviderVersion = "1.4.0"
+
+	config := providerConfigFromModel(p)
+	if config.LockBucket.ValueString() != testBucket {
+		t.Fatalf("expected the locks in the referential_bucket by default, got %s", config.LockBucket)
+	}
+	if !config.EncryptionKeyConfigured.ValueBool() {
+		t.Fatal("expected the encryption key to be reported as configured")
+	}
+	if config.CredentialsSource.ValueString() != connector.CredentialsSourceAccessToken {
+		t.Fatalf("expected the access token to be reported as the credentials source, got %s", config.CredentialsSource)
+	}
+	if config.ProviderVersion.ValueString() != "1.4.0" {
+		t.Fatalf("expected the provider version, got %s", config.ProviderVersion)
+	}
+	if config.Tenant.ValueString() != "" || config.TimeoutInMinutes.ValueInt32() != 1 {
+		t.Fatalf("unexpected tenant %s or timeout %s", config.Tenant, config.TimeoutInMinutes)
+	}
+
+	p.LockBucket = types.StringValue("locks")
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "/path/to/key.json")
+	config = providerConfigFromModel(p)
+	if config.LockBucket.ValueString() != "locks" || config.CredentialsSource.ValueString() != connector.CredentialsSourceCredentialsFile {
+		t.Fatalf("expected the lock_bucket and the credentials file, got %s and %s", config.LockBucket, config.CredentialsSource)
+	}
+}
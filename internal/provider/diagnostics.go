@@ -0,0 +1,26 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+)
+
+// objectLocation locates an object of the referential bucket and its lock, implemented by the connectors.
+type objectLocation interface {
+	GetBucketName() string
+	GetFullFilePath() string
+	GetLockBucketName() string
+	GetLockPath(ctx context.Context) string
+}
+
+// objectDetail formats the detail of a diagnostic about the object of gcpConnector, followed by its bucket and path,
+// so that the failing object can be found when several providers or buckets are used.
+func objectDetail(gcpConnector objectLocation, format string, args ...any) string {
+	return fmt.Sprintf("%s (object gs://%s/%s)", fmt.Sprintf(format, args...), gcpConnector.GetBucketName(), gcpConnector.GetFullFilePath())
+}
+
+// lockDetail formats the detail of a diagnostic about the lock of the object of gcpConnector, followed by the bucket
+// and path of the lock object.
+func lockDetail(ctx context.Context, gcpConnector objectLocation, format string, args ...any) string {
+	return fmt.Sprintf("%s (lock gs://%s/%s)", fmt.Sprintf(format, args...), gcpConnector.GetLockBucketName(), gcpConnector.GetLockPath(ctx))
+}
@@ -0,0 +1,37 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+func TestObjectDetail(t *testing.T) {
+	p := newTestProviderData()
+	p.LockBucket = types.StringValue("locks")
+	gcpConnector := p.idPoolConnector("pool")
+
+	if detail := objectDetail(&gcpConnector, "Cannot read pool %s", "pool"); detail != "Cannot read pool pool (object gs://"+testBucket+"/gcsreferential/id_pool/pool)" {
+		t.Errorf("unexpected object detail: %s", detail)
+	}
+	if detail := lockDetail(context.Background(), &gcpConnector, "Cannot lock pool %s", "pool"); !strings.HasSuffix(detail, "(lock gs://locks/"+gcpConnector.GetLockPath(context.Background())+")") {
+		t.Errorf("unexpected lock detail: %s", detail)
+	}
+
+	var diags diag.Diagnostics
+	addPoolWriteError(&diags, "id_pool update error", "pool", &gcpConnector, connector.ErrGenerationConflict)
+	if !strings.Contains(diags[0].Detail(), "re-run apply") || !strings.Contains(diags[0].Detail(), "gs://"+testBucket+"/gcsreferential/id_pool/pool") {
+		t.Errorf("expected the generation conflict to name the object, got %s", diags[0].Detail())
+	}
+
+	networkConnector := p.networkConnector("10.0.0.0/16")
+	addNetworkConfigWriteError(&diags, "network_request creation error", &networkConnector, "subnet", fmt.Errorf("%w: precondition failed", connector.ErrGenerationConflict))
+	if !strings.Contains(diags[1].Detail(), "re-run apply") || !strings.Contains(diags[1].Detail(), "network_request subnet") {
+		t.Errorf("expected the generation conflict of the network config to be reported as such, got %s", diags[1].Detail())
+	}
+}
@@ -0,0 +1,153 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral/schema"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+var _ ephemeral.EphemeralResource = &LockEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithConfigure = &LockEphemeralResource{}
+var _ ephemeral.EphemeralResourceWithClose = &LockEphemeralResource{}
+
+const lockEphemeralResourceName = "lock"
+
+// lockPrivateKey is the key of the private data holding the lock taken by Open, for Close.
+const lockPrivateKey = "lock"
+
+func NewLockEphemeralResource() ephemeral.EphemeralResource {
+	return &LockEphemeralResource{}
+}
+
+type LockEphemeralResource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type LockEphemeralResourceModel struct {
+	Name   types.String `tfsdk:"name"`
+	LockId types.String `tfsdk:"lock_id"`
+}
+
+// lockPrivateData is the lock taken by Open, kept in the private data until Close releases it.
+type lockPrivateData struct {
+	Name   string `json:"name"`
+	LockId string `json:"lock_id"`
+}
+
+func (e *LockEphemeralResource) Metadata(ctx context.Context, req ephemeral.MetadataRequest, resp *ephemeral.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + lockEphemeralResourceName
+}
+
+func (e *LockEphemeralResource) Schema(ctx context.Context, req ephemeral.SchemaRequest, resp *ephemeral.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This ephemeral resource holds a named lock of the referential bucket while Terraform uses it, to serialize an operation made outside of the provider, for example the update of an external database by a provisioner, with the same locks as the pools. " +
+			"The lock is acquired when the ephemeral resource is opened, waiting for it as the resources do, and released when it is closed at the end of the plan or apply. " +
+			"It is written next to the objects of the provider, under lock_prefix and in lock_bucket when they are set. It is taken even with skip_lock, which only applies to the locks of the operations of the provider",
+
+		Attributes: map[string]schema.Attribute{
+			"name": schema.StringAttribute{
+				MarkdownDescription: "The name of the lock, shared by every configuration that must not run the operation at the same time",
+				Required:            true,
+			},
+			"lock_id": schema.StringAttribute{
+				MarkdownDescription: "The id of the lock held, written in the lock object",
+				Computed:            true,
+			},
+		},
+	}
+}
+
+func (e *LockEphemeralResource) Configure(ctx context.Context, req ephemeral.ConfigureRequest, resp *ephemeral.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Ephemeral Resource Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	e.providerData = providerData
+}
+
+func (e *LockEphemeralResource) Open(ctx context.Context, req ephemeral.OpenRequest, resp *ephemeral.OpenResponse) {
+	var data LockEphemeralResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	lockId, diags := acquireNamedLock(ctx, e.providerData, data.Name.ValueString())
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	private, err := json.Marshal(lockPrivateData{Name: data.Name.ValueString(), LockId: lockId.String()})
+	if err != nil {
+		releaseNamedLock(ctx, e.providerData, data.Name.ValueString(), lockId, &resp.Diagnostics)
+		resp.Diagnostics.AddError("lock open error", fmt.Sprintf("Cannot record lock %s: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
+	resp.Diagnostics.Append(resp.Private.SetKey(ctx, lockPrivateKey, private)...)
+	if resp.Diagnostics.HasError() {
+		releaseNamedLock(ctx, e.providerData, data.Name.ValueString(), lockId, &resp.Diagnostics)
+		return
+	}
+	data.LockId = types.StringValue(lockId.String())
+
+	resp.Diagnostics.Append(resp.Result.Set(ctx, &data)...)
+}
+
+func (e *LockEphemeralResource) Close(ctx context.Context, req ephemeral.CloseRequest, resp *ephemeral.CloseResponse) {
+	private, diags := req.Private.GetKey(ctx, lockPrivateKey)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() || private == nil {
+		return
+	}
+	var held lockPrivateData
+	if err := json.Unmarshal(private, &held); err != nil {
+		resp.Diagnostics.AddError("lock close error", fmt.Sprintf("Cannot read the lock to release: %s", err.Error()))
+		return
+	}
+	lockId, err := uuid.Parse(held.LockId)
+	if err != nil {
+		resp.Diagnostics.AddError("lock close error", fmt.Sprintf("Cannot read the id of lock %s to release: %s", held.Name, err.Error()))
+		return
+	}
+	releaseNamedLock(ctx, e.providerData, held.Name, lockId, &resp.Diagnostics)
+}
+
+// namedLockConnector returns the connector of the named lock of a lock ephemeral resource. The lock is taken even with
+// skip_lock, which only applies to the locks of the operations of the provider: the lock is asked for explicitly.
+func namedLockConnector(p *GCSReferentialProviderModel, name string) connector.GcpConnectorGeneric {
+	gcpConnector := p.genericConnector(p.namedLockPath(name))
+	gcpConnector.SkipLock = false
+	return gcpConnector
+}
+
+// acquireNamedLock waits for the named lock of a lock ephemeral resource and returns the id of the lock taken.
+func acquireNamedLock(ctx context.Context, p *GCSReferentialProviderModel, name string) (uuid.UUID, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	gcpConnector := namedLockConnector(p, name)
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError("lock open error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock %s: %s", name, err.Error()))
+		return uuid.Nil, diags
+	}
+	tflog.Debug(ctx, "Acquired named lock", map[string]interface{}{"lock": name, "lock_id": lockId.String()})
+	return lockId, diags
+}
+
+// releaseNamedLock releases the named lock of a lock ephemeral resource taken with lockId.
+func releaseNamedLock(ctx context.Context, p *GCSReferentialProviderModel, name string, lockId uuid.UUID, diags *diag.Diagnostics) {
+	gcpConnector := namedLockConnector(p, name)
+	releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("lock %s", name), diags)
+}
@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestAcquireReleaseNamedLock(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	lockPath := p.namedLockPath("db-migration") + ".lock"
+
+	lockId, diags := acquireNamedLock(ctx, p, "db-migration")
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	object, ok := server.Get(testBucket, lockPath)
+	if !ok || string(object.Data) != lockId.String() {
+		t.Fatalf("expected the lock object to hold %s, got %v", lockId, ok)
+	}
+
+	// Another configuration cannot take the lock while it is held.
+	other := newTestProviderData()
+	other.FailIfLocked = types.BoolValue(true)
+	if _, diags := acquireNamedLock(ctx, other, "db-migration"); !diags.HasError() {
+		t.Fatal("expected the held lock not to be acquired again")
+	}
+
+	var releaseDiags diag.Diagnostics
+	releaseNamedLock(ctx, p, "db-migration", lockId, &releaseDiags)
+	if releaseDiags.HasError() {
+		t.Fatal(releaseDiags)
+	}
+	if _, ok := server.Get(testBucket, lockPath); ok {
+		t.Fatal("expected the lock object to be deleted on release")
+	}
+	if _, diags := acquireNamedLock(ctx, other, "db-migration"); diags.HasError() {
+		t.Fatal(diags)
+	}
+}
+
+func TestAcquireReleaseNamedLock_skipLock(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	p.SkipLock = types.BoolValue(true)
+	ctx := context.Background()
+	lockPath := p.namedLockPath("db-migration") + ".lock"
+
+	// skip_lock only applies to the operations of the provider, the named lock is still held.
+	lockId, diags := acquireNamedLock(ctx, p, "db-migration")
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if object, ok := server.Get(testBucket, lockPath); !ok || lockId == uuid.Nil || string(object.Data) != lockId.String() {
+		t.Fatalf("expected the lock object to hold a lock id, got %s", lockId)
+	}
+	var releaseDiags diag.Diagnostics
+	releaseNamedLock(ctx, p, "db-migration", lockId, &releaseDiags)
+	if releaseDiags.HasError() {
+		t.Fatal(releaseDiags)
+	}
+	if _, ok := server.Get(testBucket, lockPath); ok {
+		t.Fatal("expected the lock object to be deleted on release")
+	}
+}
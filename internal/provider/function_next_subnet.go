@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ function.Function = &NextSubnetFunction{}
+
+const nextSubnetFunctionName = "next_subnet"
+
+func NewNextSubnetFunction() function.Function {
+	return &NextSubnetFunction{}
+}
+
+// NextSubnetFunction computes the subnet a network_request would receive from a list of reservations,
+// without reading the referential bucket.
+type NextSubnetFunction struct{}
+
+func (f *NextSubnetFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = nextSubnetFunctionName
+}
+
+func (f *NextSubnetFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Compute the next subnet available in a base_cidr",
+		MarkdownDescription: "Returns the subnet a network_request with the given prefix_length would receive in base_cidr when existing_cidrs are the reserved subnets, " +
+			"with the same allocation logic as the network_request but on the given data only, the referential_bucket is not read. " +
+			"It does not know the skip_first_subnet and alignment_prefix policies persisted for the base_cidr",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "base_cidr",
+				MarkdownDescription: "The supernet where to allocate the subnet, for example 10.0.0.0/8",
+			},
+			function.Int64Parameter{
+				Name:                "prefix_length",
+				MarkdownDescription: "The prefix of the subnet, for example 24 for a /24",
+			},
+			function.ListParameter{
+				Name:                "existing_cidrs",
+				ElementType:         types.StringType,
+				MarkdownDescription: "The subnets already reserved in base_cidr as full cidr, for example the reserved_cidrs of the network data source",
+			},
+		},
+		Return: function.StringReturn{},
+	}
+}
+
+func (f *NextSubnetFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var baseCidr string
+	var prefixLength int64
+	var existingCidrs []string
+	resp.Error = function.ConcatFuncErrors(resp.Error, req.Arguments.Get(ctx, &baseCidr, &prefixLength, &existingCidrs))
+	if resp.Error != nil {
+		return
+	}
+
+	networkConfig := NetworkConfig{Subnets: make(map[string]string, len(existingCidrs))}
+	for i, cidr := range existingCidrs {
+		networkConfig.Subnets[fmt.Sprintf("existing-%d", i)] = cidr
+	}
+	netmask, err := nextNetmask(&networkConfig, prefixLength, baseCidr)
+	if err != nil {
+		resp.Error = function.NewFuncError(fmt.Sprintf("Cannot find any available subnet in %s with prefix %d: %s", baseCidr, prefixLength, err.Error()))
+		return
+	}
+	resp.Error = function.ConcatFuncErrors(resp.Error, resp.Result.Set(ctx, netmask))
+}
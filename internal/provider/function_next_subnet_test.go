@@ -0,0 +1,52 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	cidrCalculator "github.com/public-cloud-wl/tools/cidrCalculator"
+)
+
+func TestNextSubnetFunction(t *testing.T) {
+	ctx := context.Background()
+	run := func(baseCidr string, prefixLength int64, existing ...string) *function.RunResponse {
+		elements := make([]attr.Value, 0, len(existing))
+		for _, cidr := range existing {
+			elements = append(elements, types.StringValue(cidr))
+		}
+		resp := &function.RunResponse{Result: function.NewResultData(types.StringUnknown())}
+		(&NextSubnetFunction{}).Run(ctx, function.RunRequest{
+			Arguments: function.NewArgumentsData([]attr.Value{
+				types.StringValue(baseCidr),
+				types.Int64Value(prefixLength),
+				types.ListValueMust(types.StringType, elements),
+			}),
+		}, resp)
+		return resp
+	}
+
+	existing := []string{"10.0.0.0/24", "10.0.1.0/25", "10.0.3.0/24"}
+	resp := run("10.0.0.0/16", 24, existing...)
+	if resp.Error != nil {
+		t.Fatal(resp.Error)
+	}
+	subnets := map[string]string{"a": existing[0], "b": existing[1], "c": existing[2]}
+	calculator, err := cidrCalculator.New(&subnets, 24, "10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := calculator.GetNextNetmask()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.Result.Value().(types.String).ValueString(); got != expected {
+		t.Fatalf("expected the subnet of the calculator %s, got %s", expected, got)
+	}
+
+	if resp := run("10.0.0.0/24", 24, "10.0.0.0/24"); resp.Error == nil {
+		t.Fatal("expected an error on a full base_cidr")
+	}
+}
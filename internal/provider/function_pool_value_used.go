@@ -0,0 +1,71 @@
+package provider
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var _ function.Function = &PoolValueUsedFunction{}
+
+const poolValueUsedFunctionName = "pool_value_used"
+
+func NewPoolValueUsedFunction() function.Function {
+	return &PoolValueUsedFunction{}
+}
+
+// PoolValueUsedFunction reports whether a value of an id_pool is reserved, reading the pool without lock.
+type PoolValueUsedFunction struct{}
+
+func (f *PoolValueUsedFunction) Metadata(ctx context.Context, req function.MetadataRequest, resp *function.MetadataResponse) {
+	resp.Name = poolValueUsedFunctionName
+}
+
+func (f *PoolValueUsedFunction) Definition(ctx context.Context, req function.DefinitionRequest, resp *function.DefinitionResponse) {
+	resp.Definition = function.Definition{
+		Summary: "Check whether a value of an id_pool is reserved",
+		MarkdownDescription: "Returns true when value is held by a member of the id_pool or reserved outside of terraform in its externally_managed section or released but still in its cooldown_days, false otherwise, a value out of the range of the pool included. " +
+			"The pool is read without lock, so the result can race with the allocations and releases running concurrently and is only advisory: " +
+			"it must not be relied on to reserve the value. Terraform does not give the provider configuration to the functions, " +
+			"so the referential_bucket is an argument and the pool is read at its path outside of any tenant, with the default credentials and endpoint",
+		Parameters: []function.Parameter{
+			function.StringParameter{
+				Name:                "referential_bucket",
+				MarkdownDescription: "The bucket holding the id_pool, the referential_bucket of the provider",
+			},
+			function.StringParameter{
+				Name:                "pool",
+				MarkdownDescription: "The name of the id_pool",
+			},
+			function.Int64Parameter{
+				Name:                "value",
+				MarkdownDescription: "The value to check",
+			},
+		},
+		Return: function.BoolReturn{},
+	}
+}
+
+func (f *PoolValueUsedFunction) Run(ctx context.Context, req function.RunRequest, resp *function.RunResponse) {
+	var bucket string
+	var poolName string
+	var value int64
+	resp.Error = function.ConcatFuncErrors(resp.Error, req.Arguments.Get(ctx, &bucket, &poolName, &value))
+	if resp.Error != nil {
+		return
+	}
+	if bucket == "" {
+		resp.Error = function.NewArgumentFuncError(0, "The referential_bucket must not be empty")
+		return
+	}
+
+	providerData := &GCSReferentialProviderModel{ReferentialBucket: types.StringValue(bucket)}
+	gcpConnector := providerData.idPoolConnector(poolName)
+	var pool StoredIdPool
+	if err := gcpConnector.Read(ctx, &pool); err != nil {
+		resp.Error = function.NewFuncError(objectDetail(&gcpConnector, "Cannot read id_pool %s: %s", poolName, err.Error()))
+		return
+	}
+	resp.Error = function.ConcatFuncErrors(resp.Error, resp.Result.Set(ctx, pool.valueUsed(value)))
+}
@@ -0,0 +1,45 @@
+package provider
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestPoolValueUsedFunction(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	p := newTestProviderData()
+	gcpConnector := p.idPoolConnector("pool")
+	server.Put(testBucket, gcpConnector.FullFilePath, []byte(`{"start_from":1,"end_to":10,"members":{"a":3},"externally_managed":{"legacy":5}}`))
+
+	run := func(poolName string, value int64) *function.RunResponse {
+		resp := &function.RunResponse{Result: function.NewResultData(types.BoolUnknown())}
+		(&PoolValueUsedFunction{}).Run(ctx, function.RunRequest{
+			Arguments: function.NewArgumentsData([]attr.Value{
+				types.StringValue(testBucket),
+				types.StringValue(poolName),
+				types.Int64Value(value),
+			}),
+		}, resp)
+		return resp
+	}
+
+	for value, expected := range map[int64]bool{3: true, 5: true, 4: false, 42: false, 0: false} {
+		resp := run("pool", value)
+		if resp.Error != nil {
+			t.Fatal(resp.Error)
+		}
+		if got := resp.Result.Value().(types.Bool).ValueBool(); got != expected {
+			t.Errorf("expected %t for value %d, got %t", expected, value, got)
+		}
+	}
+
+	if resp := run("missing", 3); resp.Error == nil {
+		t.Fatal("expected an error on a missing pool")
+	}
+}
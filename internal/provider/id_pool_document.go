@@ -0,0 +1,1035 @@
+package provider
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+)
+
+// StoredIdPool is the JSON document stored in the referential bucket for an id_pool.
+// It extends the IdPoolTools pool with the settings and bookkeeping owned by this provider,
+// the embedded pool fields are serialized at the same level to stay compatible with existing objects.
+type StoredIdPool struct {
+	*IdPoolTools.IDPool
+	// SchemaVersion is the version of the format of the object, absent from the objects written before it was introduced.
+	SchemaVersion int `json:"schema_version,omitempty"`
+	// NoReuse forbids a released id to be allocated again.
+	NoReuse bool `json:"no_reuse,omitempty"`
+	// ReserveSentinel keeps start_from out of the allocations, for the integrations where it means "unassigned".
+	ReserveSentinel bool `json:"reserve_sentinel,omitempty"`
+	// NextFree is the high-water mark of the allocations, it only moves forward.
+	NextFree IdPoolTools.ID `json:"next_free,omitempty"`
+	// Direction is idPoolDirectionDesc when the highest free id is allocated first, empty for the default ascending order.
+	Direction string `json:"direction,omitempty"`
+	// IdPattern is a regular expression the member names must match to be allocated an id, empty to accept any name.
+	IdPattern string `json:"id_pattern,omitempty"`
+	// LowWater is the lowest id ever allocated by a desc pool, it only moves backward.
+	LowWater IdPoolTools.ID `json:"low_water,omitempty"`
+	// ExternallyManaged holds the ids reserved by hand outside of terraform, by name. They are never allocated
+	// nor removed by the provider, the section is only edited in the object itself.
+	ExternallyManaged map[string]IdPoolTools.ID `json:"externally_managed,omitempty"`
+	// LabelTemplate is the text/template rendered into the label of each new member, empty for no label.
+	LabelTemplate string `json:"label_template,omitempty"`
+	// OutputFormat is idOutputFormatHex when the ids are rendered in hexadecimal for the id_requests, empty for decimal.
+	OutputFormat string `json:"output_format,omitempty"`
+	// OutputWidth is the number of digits the rendered ids are zero-padded to, 0 for no padding.
+	OutputWidth int64 `json:"output_width,omitempty"`
+	// BurstFrom is the first id of the burst region of the pool, only allocated to the id_requests with allow_burst,
+	// 0 when the pool has none.
+	BurstFrom IdPoolTools.ID `json:"burst_from,omitempty"`
+	// MirrorFlatOutputPath is the object of the referential bucket where the members are mirrored as a flat map of
+	// names to ids after each change, empty for no mirror.
+	MirrorFlatOutputPath string `json:"mirror_flat_output_path,omitempty"`
+	// Partitions holds the named subranges of the pool an id_request can restrict its allocation to, by name.
+	Partitions map[string]IdPartition `json:"partitions,omitempty"`
+	// MinGap is the smallest distance between a new id and the id of any member, 0 when the ids are not spaced.
+	MinGap int64 `json:"min_gap,omitempty"`
+	// CooldownDays is the number of days a released id is kept out of the allocations, 0 to make it available right away.
+	CooldownDays int64 `json:"cooldown_days,omitempty"`
+	// Quarantine holds the release time of the ids waiting for the end of their cooldown, by id.
+	Quarantine map[IdPoolTools.ID]time.Time `json:"quarantine,omitempty"`
+	// AllocationStrategy is idAllocationStrategyOldestFree when the id free the longest is allocated first, empty for
+	// the default first_free strategy.
+	AllocationStrategy string `json:"allocation_strategy,omitempty"`
+	// ReleasedAt holds the release time of the free ids that were held by a member, by id, only kept by an oldest_free pool.
+	ReleasedAt map[IdPoolTools.ID]time.Time `json:"released_at,omitempty"`
+	// LastAccessed is the last time a refresh of the id_pool recorded an access to the pool, nil when none did.
+	LastAccessed *time.Time `json:"last_accessed,omitempty"`
+	// Records holds the bookkeeping of each member, by member name.
+	Records map[string]*MemberRecord `json:"member_records,omitempty"`
+}
+
+// MemberRecord is the bookkeeping kept for a member of the pool.
+type MemberRecord struct {
+	// ReservedAt is zero for the members migrated from an object written before the records were kept for every member.
+	ReservedAt time.Time `json:"reserved_at"`
+	// TTLMinutes is the lifetime of the reservation from ReservedAt, 0 means it never expires.
+	TTLMinutes int64 `json:"ttl_minutes,omitempty"`
+	// Label is rendered from the label_template of the pool when the member is first recorded, it is kept as is afterwards.
+	Label string `json:"label,omitempty"`
+	// Metadata is the free-form metadata of the id_request of the member, absent from the objects written before it was introduced.
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// IdPartition is a named subrange of a pool, bounds included.
+type IdPartition struct {
+	From IdPoolTools.ID `json:"from"`
+	To   IdPoolTools.ID `json:"to"`
+}
+
+// expired reports whether the reservation lifetime has elapsed at the given time.
+func (r *MemberRecord) expired(now time.Time) bool {
+	return r.TTLMinutes > 0 && now.After(r.ReservedAt.Add(time.Duration(r.TTLMinutes)*time.Minute))
+}
+
+// idPoolSchemaVersion is the version of the format of the id_pool objects written by the provider.
+const idPoolSchemaVersion = 2
+
+// CurrentSchemaVersion implements connector.VersionedDocument.
+func (p *StoredIdPool) CurrentSchemaVersion() int {
+	return idPoolSchemaVersion
+}
+
+// MigrateSchema implements connector.VersionedDocument. The version 1 only added the schema_version field to version 0,
+// the version 2 keeps a record for every member where the older objects only had the flat name to id map of the members.
+// The migrated pool is only held in memory, the object is upgraded by the next write of the pool.
+func (p *StoredIdPool) MigrateSchema(fromVersion int) error {
+	p.recordMissingMembers()
+	p.SchemaVersion = idPoolSchemaVersion
+	return nil
+}
+
+// recordMissingMembers gives a record without reservation time to the members that have none.
+func (p *StoredIdPool) recordMissingMembers() {
+	if p.IDPool == nil {
+		return
+	}
+	for name := range p.Members {
+		if _, ok := p.Records[name]; ok {
+			continue
+		}
+		if p.Records == nil {
+			p.Records = make(map[string]*MemberRecord, len(p.Members))
+		}
+		p.Records[name] = &MemberRecord{}
+	}
+}
+
+// The directions of the allocations in a pool.
+const (
+	idPoolDirectionAsc  = "asc"
+	idPoolDirectionDesc = "desc"
+)
+
+// The strategies choosing the free id an allocation returns.
+const (
+	idAllocationStrategyFirstFree  = "first_free"
+	idAllocationStrategyOldestFree = "oldest_free"
+)
+
+// The output formats of the ids rendered for the id_requests.
+const (
+	idOutputFormatDec = "dec"
+	idOutputFormatHex = "hex"
+)
+
+// formatId renders id with the output format of the pool: in decimal, or in hexadecimal with a 0x prefix,
+// zero-padded to OutputWidth digits. The stored ids are numeric whatever the format.
+func (p *StoredIdPool) formatId(id IdPoolTools.ID) string {
+	if p.OutputFormat == idOutputFormatHex {
+		return fmt.Sprintf("0x%0*x", int(p.OutputWidth), uint64(id))
+	}
+	return fmt.Sprintf("%0*d", int(p.OutputWidth), uint64(id))
+}
+
+// unboundedPoolSize is the number of ids from which a pool is unbounded, such as a pool with the default end_to:
+// its free ids are never materialized in the cache of the pool, every operation derives them from the used ids.
+const unboundedPoolSize = 1 << 20
+
+// unboundedScanLimit is the number of candidate ids an allocation examines in an unbounded pool before giving up,
+// so that a filter rejecting every free id does not walk the whole range.
+const unboundedScanLimit = 1 << 20
+
+// isUnboundedRange reports whether a pool on the given range is unbounded.
+func isUnboundedRange(startFrom IdPoolTools.ID, endTo IdPoolTools.ID) bool {
+	return endTo >= startFrom && endTo-startFrom >= unboundedPoolSize
+}
+
+// newIdPoolTool returns an empty pool on the given range, whose cache holds every id unless the range is unbounded.
+func newIdPoolTool(startFrom IdPoolTools.ID, endTo IdPoolTools.ID) *IdPoolTools.IDPool {
+	if isUnboundedRange(startFrom, endTo) {
+		return &IdPoolTools.IDPool{
+			StartFrom: startFrom,
+			EndTo:     endTo,
+			Members:   make(map[string]IdPoolTools.ID),
+			IdCache:   &IdPoolTools.IdCache{Ids: make(map[IdPoolTools.ID]struct{}), Leased: make(map[IdPoolTools.ID]struct{})},
+		}
+	}
+	return IdPoolTools.NewIDPool(startFrom, endTo)
+}
+
+func newStoredIdPool(startFrom IdPoolTools.ID, endTo IdPoolTools.ID) *StoredIdPool {
+	return &StoredIdPool{IDPool: newIdPoolTool(startFrom, endTo)}
+}
+
+// isUnbounded reports whether the free ids of the pool are derived from the used ids rather than cached.
+func (p *StoredIdPool) isUnbounded() bool {
+	return isUnboundedRange(p.StartFrom, p.EndTo)
+}
+
+// isFree reports whether id can be allocated in the pool.
+func (p *StoredIdPool) isFree(id IdPoolTools.ID) bool {
+	if !p.isUnbounded() {
+		_, free := p.IdCache.Ids[id]
+		return free
+	}
+	if id < p.StartFrom || id > p.EndTo || p.valueUsed(int64(id)) || (p.ReserveSentinel && id == p.StartFrom) {
+		return false
+	}
+	// The watermarks of a no_reuse pool are applied as in freeRanges.
+	if p.NoReuse && p.isDesc() {
+		return p.LowWater == IdPoolTools.NoID || id < p.LowWater
+	}
+	return !p.NoReuse || id >= p.NextFree
+}
+
+// take makes id unavailable in the pool and reports whether it was free. The cache of an unbounded pool is empty,
+// its ids are made unavailable by the member holding them.
+func (p *StoredIdPool) take(id IdPoolTools.ID) bool {
+	if !p.isUnbounded() {
+		return p.Remove(id)
+	}
+	return p.isFree(id)
+}
+
+// rebuild returns a copy of the pool on the given range, with the same settings and members.
+// The available ids are recomputed from the members and the externally managed ids rather than trusted from the stored cache.
+func (p *StoredIdPool) rebuild(startFrom IdPoolTools.ID, endTo IdPoolTools.ID) *StoredIdPool {
+	rebuilt := *p
+	rebuilt.IDPool = newIdPoolTool(startFrom, endTo)
+	for _, allocatedID := range p.Members {
+		rebuilt.Remove(allocatedID)
+		rebuilt.bumpNextFree(allocatedID)
+	}
+	for _, externalID := range p.ExternallyManaged {
+		rebuilt.Remove(externalID)
+	}
+	for quarantinedID := range p.Quarantine {
+		rebuilt.Remove(quarantinedID)
+	}
+	if rebuilt.ReserveSentinel {
+		rebuilt.Remove(startFrom)
+	}
+	rebuilt.ReleasedAt = p.reconciledReleases(startFrom, endTo)
+	if rebuilt.isUnbounded() {
+		// Nothing is cached, the watermarks are applied when the free ids are derived.
+	} else if rebuilt.NoReuse && rebuilt.isDesc() {
+		// Everything above the low-water mark has been consumed once and can never be allocated again.
+		for id := endTo; rebuilt.LowWater != IdPoolTools.NoID && id >= rebuilt.LowWater && id >= startFrom && id != IdPoolTools.NoID; id-- {
+			rebuilt.Remove(id)
+		}
+	} else if rebuilt.NoReuse {
+		// Everything below the high-water mark has been consumed once and can never be allocated again.
+		for id := startFrom; id < rebuilt.NextFree && id <= endTo; id++ {
+			rebuilt.Remove(id)
+		}
+	}
+	rebuilt.Members = p.Members
+	return &rebuilt
+}
+
+// clone returns a deep copy of the pool, which can be modified without affecting the original.
+func (p *StoredIdPool) clone() *StoredIdPool {
+	copied := *p
+	copied.ExternallyManaged = maps.Clone(p.ExternallyManaged)
+	copied.Quarantine = maps.Clone(p.Quarantine)
+	copied.Partitions = maps.Clone(p.Partitions)
+	copied.ReleasedAt = maps.Clone(p.ReleasedAt)
+	if p.LastAccessed != nil {
+		lastAccessed := *p.LastAccessed
+		copied.LastAccessed = &lastAccessed
+	}
+	copied.Records = make(map[string]*MemberRecord, len(p.Records))
+	for name, record := range p.Records {
+		recordCopy := *record
+		recordCopy.Metadata = maps.Clone(record.Metadata)
+		copied.Records[name] = &recordCopy
+	}
+	// The embedded pool is shared by the shallow copy, its members and cache are copied as they are rather than
+	// rebuilt from the range, which would cost the whole range on every operation.
+	copied.IDPool = &IdPoolTools.IDPool{StartFrom: p.StartFrom, EndTo: p.EndTo, Members: maps.Clone(p.Members)}
+	if p.IdCache != nil {
+		copied.IdCache = &IdPoolTools.IdCache{Ids: maps.Clone(p.IdCache.Ids), Leased: maps.Clone(p.IdCache.Leased)}
+	}
+	return &copied
+}
+
+// accessDue reports whether an access to the pool at now must be recorded, at most once per interval.
+func (p *StoredIdPool) accessDue(now time.Time, interval time.Duration) bool {
+	return p.LastAccessed == nil || now.Sub(*p.LastAccessed) >= interval
+}
+
+// idFilter restricts the ids an allocation may return.
+type idFilter func(id IdPoolTools.ID) bool
+
+// seedMembers adds members to a new pool with the ids they already hold elsewhere, in ascending id order so that
+// the watermarks end up as if they were allocated by the pool. Every id must be in the range and held once.
+func (p *StoredIdPool) seedMembers(members map[string]IdPoolTools.ID, now time.Time) error {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return members[names[i]] < members[names[j]] })
+	for _, name := range names {
+		id := members[name]
+		if name == "" {
+			return fmt.Errorf("a member name is empty")
+		}
+		if err := p.checkMemberName(name); err != nil {
+			return err
+		}
+		if id < p.StartFrom || id > p.EndTo {
+			return fmt.Errorf("the id %d of member %q is out of the range %d-%d of the pool", id, name, p.StartFrom, p.EndTo)
+		}
+		if p.ReserveSentinel && id == p.StartFrom {
+			return fmt.Errorf("the id %d of member %q is the sentinel of the pool, reserved by reserve_sentinel", id, name)
+		}
+		if !p.take(id) {
+			return fmt.Errorf("the id %d of member %q is held by another member", id, name)
+		}
+		p.Members[name] = id
+		p.bumpNextFree(id)
+		p.recordReservation(name, 0, now)
+	}
+	return nil
+}
+
+// checkMemberName returns an error when name does not match the id_pattern of the pool.
+func (p *StoredIdPool) checkMemberName(name string) error {
+	if p.IdPattern == "" {
+		return nil
+	}
+	pattern, err := regexp.Compile(p.IdPattern)
+	if err != nil {
+		return fmt.Errorf("invalid id_pattern %q stored in the pool: %w", p.IdPattern, err)
+	}
+	if !pattern.MatchString(name) {
+		return fmt.Errorf("the id %q does not match the id_pattern %q of the pool", name, p.IdPattern)
+	}
+	return nil
+}
+
+// isDesc reports whether the pool allocates the highest free id first.
+func (p *StoredIdPool) isDesc() bool {
+	return p.Direction == idPoolDirectionDesc
+}
+
+// allocate reserves a free id for the given member name with the allocation strategy of the pool, it returns
+// IdPoolTools.NoID when the pool is exhausted.
+func (p *StoredIdPool) allocate(name string, filter idFilter, rng *lockedRand) IdPoolTools.ID {
+	if p.AllocationStrategy == idAllocationStrategyOldestFree {
+		return p.allocateOldestFree(name, filter)
+	}
+	return p.allocateFirstFree(name, filter, rng)
+}
+
+// allocateFirstFree reserves a free id for the given member name, it returns IdPoolTools.NoID when the pool is exhausted.
+// When filter is not nil, only an id accepted by it is allocated: the lowest one. Otherwise a free id is picked
+// with rng, or by the pool itself when rng is nil. A desc pool always allocates the highest id accepted by filter.
+// A pool with a min_gap always allocates the lowest, or highest if desc, id far enough from the members.
+func (p *StoredIdPool) allocateFirstFree(name string, filter idFilter, rng *lockedRand) IdPoolTools.ID {
+	if p.MinGap > 1 {
+		return p.allocateWithGap(name, filter)
+	}
+	if p.isUnbounded() {
+		return p.allocateUnbounded(name, filter, rng)
+	}
+	if p.isDesc() {
+		return p.allocateDesc(name, filter)
+	}
+	if p.NoReuse {
+		id := p.NextFree
+		if id < p.StartFrom {
+			id = p.StartFrom
+		}
+		for ; id <= p.EndTo; id++ {
+			if (filter == nil || filter(id)) && p.Remove(id) {
+				p.Members[name] = id
+				p.bumpNextFree(id)
+				return id
+			}
+		}
+		return IdPoolTools.NoID
+	}
+	if filter == nil && rng == nil {
+		id := p.AllocateID(name)
+		if id == IdPoolTools.NoID {
+			// AllocateID registers the member even when nothing was allocated.
+			delete(p.Members, name)
+			return IdPoolTools.NoID
+		}
+		p.bumpNextFree(id)
+		return id
+	}
+	if filter == nil {
+		// The free ids are sorted so that the same random sequence always gives the same ids.
+		free := make([]IdPoolTools.ID, 0, len(p.IdCache.Ids))
+		for candidate := range p.IdCache.Ids {
+			free = append(free, candidate)
+		}
+		if len(free) == 0 {
+			return IdPoolTools.NoID
+		}
+		sort.Slice(free, func(i, j int) bool { return free[i] < free[j] })
+		id := free[rng.Intn(len(free))]
+		p.Remove(id)
+		p.Members[name] = id
+		p.bumpNextFree(id)
+		return id
+	}
+	id := IdPoolTools.NoID
+	for candidate := range p.IdCache.Ids {
+		if filter(candidate) && (id == IdPoolTools.NoID || candidate < id) {
+			id = candidate
+		}
+	}
+	if id == IdPoolTools.NoID {
+		return IdPoolTools.NoID
+	}
+	p.Remove(id)
+	p.Members[name] = id
+	p.bumpNextFree(id)
+	return id
+}
+
+// allocateOldestFree reserves the free id accepted by filter that has been free the longest: an id never held by a
+// member first, in the order of allocateFirstFree, then the released ids from the least recently released one.
+func (p *StoredIdPool) allocateOldestFree(name string, filter idFilter) IdPoolTools.ID {
+	neverReleased := func(id IdPoolTools.ID) bool {
+		_, released := p.ReleasedAt[id]
+		return !released && (filter == nil || filter(id))
+	}
+	id := p.allocateFirstFree(name, neverReleased, nil)
+	if id == IdPoolTools.NoID {
+		for _, candidate := range p.releasedByAge() {
+			if (filter == nil || filter(candidate)) && p.isFree(candidate) && p.farFromMembers(candidate) {
+				p.take(candidate)
+				p.Members[name] = candidate
+				p.bumpNextFree(candidate)
+				id = candidate
+				break
+			}
+		}
+	}
+	if id != IdPoolTools.NoID {
+		delete(p.ReleasedAt, id)
+	}
+	return id
+}
+
+// releasedByAge returns the released ids of the pool from the least recently released one, the lowest id first on a tie.
+func (p *StoredIdPool) releasedByAge() []IdPoolTools.ID {
+	ids := make([]IdPoolTools.ID, 0, len(p.ReleasedAt))
+	for id := range p.ReleasedAt {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if !p.ReleasedAt[ids[i]].Equal(p.ReleasedAt[ids[j]]) {
+			return p.ReleasedAt[ids[i]].Before(p.ReleasedAt[ids[j]])
+		}
+		return ids[i] < ids[j]
+	})
+	return ids
+}
+
+// farFromMembers reports whether id is at least min_gap away from the id of every member.
+func (p *StoredIdPool) farFromMembers(id IdPoolTools.ID) bool {
+	if p.MinGap <= 1 {
+		return true
+	}
+	for _, memberID := range p.Members {
+		distance := id - memberID
+		if memberID > id {
+			distance = memberID - id
+		}
+		if distance < IdPoolTools.ID(p.MinGap) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordRelease stores the time id became available again, when the pool allocates the oldest free id first.
+func (p *StoredIdPool) recordRelease(id IdPoolTools.ID, releasedAt time.Time) {
+	if p.AllocationStrategy != idAllocationStrategyOldestFree {
+		return
+	}
+	if p.ReleasedAt == nil {
+		p.ReleasedAt = make(map[IdPoolTools.ID]time.Time)
+	}
+	p.ReleasedAt[id] = releasedAt.UTC()
+}
+
+// reconciledReleases returns a copy of the release times of the pool without the ids that are no longer free in the
+// range [startFrom, endTo]: held by a member, externally managed or quarantined again. It is nil when none is left.
+func (p *StoredIdPool) reconciledReleases(startFrom IdPoolTools.ID, endTo IdPoolTools.ID) map[IdPoolTools.ID]time.Time {
+	if p.AllocationStrategy != idAllocationStrategyOldestFree || len(p.ReleasedAt) == 0 {
+		return nil
+	}
+	held := make(map[IdPoolTools.ID]struct{}, len(p.Members))
+	for _, id := range p.Members {
+		held[id] = struct{}{}
+	}
+	var reconciled map[IdPoolTools.ID]time.Time
+	for id, releasedAt := range p.ReleasedAt {
+		_, isHeld := held[id]
+		_, quarantined := p.Quarantine[id]
+		if isHeld || quarantined || id < startFrom || id > endTo || p.isExternallyManaged(id) {
+			continue
+		}
+		if reconciled == nil {
+			reconciled = make(map[IdPoolTools.ID]time.Time)
+		}
+		reconciled[id] = releasedAt
+	}
+	return reconciled
+}
+
+// allocateDesc reserves the highest free id accepted by filter, below the low-water mark in no_reuse mode.
+func (p *StoredIdPool) allocateDesc(name string, filter idFilter) IdPoolTools.ID {
+	id := IdPoolTools.NoID
+	if p.NoReuse {
+		start := p.EndTo
+		if p.LowWater != IdPoolTools.NoID && p.LowWater-1 < start {
+			start = p.LowWater - 1
+		}
+		for candidate := start; candidate >= p.StartFrom && candidate != IdPoolTools.NoID; candidate-- {
+			if _, free := p.IdCache.Ids[candidate]; free && (filter == nil || filter(candidate)) {
+				id = candidate
+				break
+			}
+		}
+	} else {
+		for candidate := range p.IdCache.Ids {
+			if (filter == nil || filter(candidate)) && candidate > id {
+				id = candidate
+			}
+		}
+	}
+	if id == IdPoolTools.NoID {
+		return IdPoolTools.NoID
+	}
+	p.Remove(id)
+	p.Members[name] = id
+	p.bumpNextFree(id)
+	return id
+}
+
+// allocateUnbounded is allocate for an unbounded pool, walking the free ranges derived from the used ids instead of
+// the cache. Without filter, an ascending pool picks the id with rng at a random position among the free ids.
+func (p *StoredIdPool) allocateUnbounded(name string, filter idFilter, rng *lockedRand) IdPoolTools.ID {
+	ranges := p.freeRanges()
+	id := IdPoolTools.NoID
+	if filter == nil && rng != nil && !p.isDesc() && !p.NoReuse && len(ranges) > 0 {
+		var total uint64
+		for _, free := range ranges {
+			total += uint64(free.To-free.From) + 1
+		}
+		// The range is within [1, MaxInt64], so is the number of free ids.
+		position := IdPoolTools.ID(rng.Int63n(int64(total)))
+		for _, free := range ranges {
+			if size := free.To - free.From + 1; position >= size {
+				position -= size
+				continue
+			}
+			id = free.From + position
+			break
+		}
+	} else {
+		scanned := 0
+		accept := func(candidate IdPoolTools.ID) bool {
+			scanned++
+			return filter == nil || filter(candidate)
+		}
+		if p.isDesc() {
+			for i := len(ranges) - 1; i >= 0 && id == IdPoolTools.NoID && scanned < unboundedScanLimit; i-- {
+				for candidate := ranges[i].To; scanned < unboundedScanLimit; candidate-- {
+					if accept(candidate) {
+						id = candidate
+						break
+					}
+					if candidate == ranges[i].From {
+						break
+					}
+				}
+			}
+		} else {
+			for i := 0; i < len(ranges) && id == IdPoolTools.NoID && scanned < unboundedScanLimit; i++ {
+				for candidate := ranges[i].From; scanned < unboundedScanLimit; candidate++ {
+					if accept(candidate) {
+						id = candidate
+						break
+					}
+					if candidate == ranges[i].To {
+						break
+					}
+				}
+			}
+		}
+	}
+	if id == IdPoolTools.NoID {
+		return IdPoolTools.NoID
+	}
+	p.Members[name] = id
+	p.bumpNextFree(id)
+	return id
+}
+
+// allocateWithGap reserves the lowest free id accepted by filter that is at least min_gap away from the id of every
+// member, the highest one in a desc pool. Only the ids between the exclusion zones of the members are examined.
+func (p *StoredIdPool) allocateWithGap(name string, filter idFilter) IdPoolTools.ID {
+	ranges := p.gapRanges()
+	if p.isDesc() {
+		slices.Reverse(ranges)
+	}
+	scanned := 0
+	for _, allowed := range ranges {
+		candidate, last, step := allowed.From, allowed.To, IdPoolTools.ID(1)
+		if p.isDesc() {
+			candidate, last, step = allowed.To, allowed.From, ^IdPoolTools.ID(0)
+		}
+		for ; !p.isUnbounded() || scanned < unboundedScanLimit; candidate += step {
+			scanned++
+			if p.isFree(candidate) && (filter == nil || filter(candidate)) {
+				p.take(candidate)
+				p.Members[name] = candidate
+				p.bumpNextFree(candidate)
+				return candidate
+			}
+			if candidate == last {
+				break
+			}
+		}
+	}
+	return IdPoolTools.NoID
+}
+
+// gapRanges returns the ranges of ids of the pool at least min_gap away from the id of every member, in ascending
+// order. They are derived from the sorted ids of the members, each excluding the min_gap - 1 ids on both sides.
+func (p *StoredIdPool) gapRanges() []idRange {
+	used := make([]IdPoolTools.ID, 0, len(p.Members))
+	for _, id := range p.Members {
+		used = append(used, id)
+	}
+	slices.Sort(used)
+	margin := IdPoolTools.ID(p.MinGap - 1)
+	next := p.StartFrom
+	var ranges []idRange
+	for _, id := range used {
+		if id >= margin && id-margin > next {
+			if id-margin-1 >= p.EndTo {
+				ranges = append(ranges, idRange{From: next, To: p.EndTo})
+				return ranges
+			}
+			ranges = append(ranges, idRange{From: next, To: id - margin - 1})
+		}
+		if id > ^IdPoolTools.ID(0)-margin-1 {
+			return ranges
+		}
+		if id+margin+1 > next {
+			next = id + margin + 1
+		}
+		if next > p.EndTo {
+			return ranges
+		}
+	}
+	if next <= p.EndTo {
+		ranges = append(ranges, idRange{From: next, To: p.EndTo})
+	}
+	return ranges
+}
+
+// release frees the id of the given member at the given time. In no_reuse mode the id is not made available again,
+// with a cooldown it is quarantined until the cooldown has elapsed.
+func (p *StoredIdPool) release(name string, now time.Time) {
+	id, ok := p.Members[name]
+	if !ok {
+		return
+	}
+	delete(p.Records, name)
+	if p.NoReuse || p.isExternallyManaged(id) {
+		delete(p.Members, name)
+		return
+	}
+	if p.CooldownDays > 0 {
+		delete(p.Members, name)
+		if p.Quarantine == nil {
+			p.Quarantine = make(map[IdPoolTools.ID]time.Time)
+		}
+		p.Quarantine[id] = now.UTC()
+		return
+	}
+	p.recordRelease(id, now)
+	if p.isUnbounded() {
+		delete(p.Members, name)
+		return
+	}
+	p.Release(id)
+}
+
+// sweepQuarantine makes available again the quarantined ids whose cooldown has elapsed and returns them in ascending order.
+func (p *StoredIdPool) sweepQuarantine(now time.Time) []IdPoolTools.ID {
+	var swept []IdPoolTools.ID
+	cooldown := time.Duration(p.CooldownDays) * 24 * time.Hour
+	for id, releasedAt := range p.Quarantine {
+		if now.Before(releasedAt.Add(cooldown)) {
+			continue
+		}
+		delete(p.Quarantine, id)
+		swept = append(swept, id)
+		if id >= p.StartFrom && id <= p.EndTo && !p.isExternallyManaged(id) && !(p.ReserveSentinel && id == p.StartFrom) {
+			// The id counts as released when it left its member, not when its cooldown ended.
+			p.recordRelease(id, releasedAt)
+			if !p.isUnbounded() {
+				p.Insert(id)
+			}
+		}
+	}
+	sort.Slice(swept, func(i, j int) bool { return swept[i] < swept[j] })
+	return swept
+}
+
+// reclaim gives back to name the id it held, after its member was removed from the pool outside of Terraform.
+// It fails when the id was taken since by another member or can no longer be held in the pool.
+func (p *StoredIdPool) reclaim(name string, id IdPoolTools.ID, now time.Time) error {
+	for other, otherID := range p.Members {
+		if otherID == id {
+			return fmt.Errorf("the id %d is now held by the member %q", id, other)
+		}
+	}
+	if id < p.StartFrom || id > p.EndTo {
+		return fmt.Errorf("the id %d is out of the range %d-%d of the pool", id, p.StartFrom, p.EndTo)
+	}
+	if p.isExternallyManaged(id) {
+		return fmt.Errorf("the id %d is now reserved in the externally_managed section of the pool", id)
+	}
+	if p.ReserveSentinel && id == p.StartFrom {
+		return fmt.Errorf("the id %d is the sentinel of the pool, reserved by reserve_sentinel", id)
+	}
+	// In no_reuse mode the id was consumed by this member and is not available any more, it is held again by the same one.
+	// A quarantined id is held again by the member it was released from.
+	if _, quarantined := p.Quarantine[id]; quarantined {
+		delete(p.Quarantine, id)
+	} else if !p.take(id) && !p.NoReuse {
+		return fmt.Errorf("the id %d is not available in the pool", id)
+	}
+	delete(p.ReleasedAt, id)
+	p.Members[name] = id
+	p.bumpNextFree(id)
+	p.recordReservation(name, 0, now)
+	return nil
+}
+
+// isExternallyManaged reports whether id is reserved in the externally_managed section of the pool.
+func (p *StoredIdPool) isExternallyManaged(id IdPoolTools.ID) bool {
+	for _, externalID := range p.ExternallyManaged {
+		if externalID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// bumpNextFree moves the watermarks of the allocations past id: the high-water mark, and the low-water mark of a desc pool.
+func (p *StoredIdPool) bumpNextFree(id IdPoolTools.ID) {
+	if id+1 > p.NextFree {
+		p.NextFree = id + 1
+	}
+	if p.isDesc() && (p.LowWater == IdPoolTools.NoID || id < p.LowWater) {
+		p.LowWater = id
+	}
+}
+
+// recordReservation stores the reservation time and lifetime of a member.
+func (p *StoredIdPool) recordReservation(name string, ttlMinutes int64, now time.Time) {
+	if p.Records == nil {
+		p.Records = make(map[string]*MemberRecord)
+	}
+	record := &MemberRecord{ReservedAt: now.UTC(), TTLMinutes: ttlMinutes}
+	if previous, ok := p.Records[name]; ok {
+		record.Label = previous.Label
+		record.Metadata = previous.Metadata
+	}
+	if record.Label == "" {
+		// The template was checked when set on the pool.
+		record.Label, _ = p.renderLabel(name, p.Members[name])
+	}
+	p.Records[name] = record
+}
+
+// setMemberMetadata replaces the metadata recorded for a member, nil or empty to remove it, and reports whether it changed.
+func (p *StoredIdPool) setMemberMetadata(name string, metadata map[string]string) bool {
+	record, ok := p.Records[name]
+	if !ok {
+		if len(metadata) == 0 {
+			return false
+		}
+		p.recordReservation(name, 0, time.Time{})
+		record = p.Records[name]
+	}
+	if maps.Equal(record.Metadata, metadata) {
+		return false
+	}
+	record.Metadata = maps.Clone(metadata)
+	if len(record.Metadata) == 0 {
+		record.Metadata = nil
+	}
+	return true
+}
+
+// matchesMetadata reports whether the metadata of a member holds every entry of filter.
+// A member without metadata, such as one of a pool written before it was introduced, only matches an empty filter.
+func (p *StoredIdPool) matchesMetadata(name string, filter map[string]string) bool {
+	var metadata map[string]string
+	if record, ok := p.Records[name]; ok {
+		metadata = record.Metadata
+	}
+	for key, value := range filter {
+		if current, ok := metadata[key]; !ok || current != value {
+			return false
+		}
+	}
+	return true
+}
+
+// burstFilter returns filter restricted to the ids below the burst region of the pool, unless allowBurst is set.
+func (p *StoredIdPool) burstFilter(filter idFilter, allowBurst bool) idFilter {
+	if p.BurstFrom == 0 || allowBurst {
+		return filter
+	}
+	burstFrom := p.BurstFrom
+	return func(id IdPoolTools.ID) bool {
+		return id < burstFrom && (filter == nil || filter(id))
+	}
+}
+
+// partitionFilter returns filter restricted to the ids of the given partition of the pool, filter itself when partition
+// is empty. It fails when the pool has no such partition.
+func (p *StoredIdPool) partitionFilter(filter idFilter, partition string) (idFilter, error) {
+	if partition == "" {
+		return filter, nil
+	}
+	bounds, ok := p.Partitions[partition]
+	if !ok {
+		names := slices.Sorted(maps.Keys(p.Partitions))
+		if len(names) == 0 {
+			return nil, fmt.Errorf("the pool has no partition, cannot allocate in partition %q", partition)
+		}
+		return nil, fmt.Errorf("the pool has no partition %q, its partitions are: %s", partition, strings.Join(names, ", "))
+	}
+	return func(id IdPoolTools.ID) bool {
+		return id >= bounds.From && id <= bounds.To && (filter == nil || filter(id))
+	}, nil
+}
+
+// checkPartitions checks that the partitions are in the range of the pool, from startFrom to endTo, and do not overlap.
+func checkPartitions(partitions map[string]IdPartition, startFrom IdPoolTools.ID, endTo IdPoolTools.ID) error {
+	names := slices.Sorted(maps.Keys(partitions))
+	for i, name := range names {
+		bounds := partitions[name]
+		if bounds.From > bounds.To {
+			return fmt.Errorf("the partition %q starts at %d after its end %d", name, bounds.From, bounds.To)
+		}
+		if bounds.From < startFrom || bounds.To > endTo {
+			return fmt.Errorf("the partition %q from %d to %d is out of the range of the pool, from %d to %d", name, bounds.From, bounds.To, startFrom, endTo)
+		}
+		for _, other := range names[:i] {
+			if otherBounds := partitions[other]; bounds.From <= otherBounds.To && otherBounds.From <= bounds.To {
+				return fmt.Errorf("the partitions %q and %q overlap", other, name)
+			}
+		}
+	}
+	return nil
+}
+
+// createRetryWindow is how long after its reservation a member can be taken back by a retried creation of its id_request.
+const createRetryWindow = 24 * time.Hour
+
+// isRetriedReservation reports whether the member name looks written by an earlier attempt of the same creation, one
+// interrupted after the pool was written but before the state was saved: it was reserved within createRetryWindow with
+// the same ttl and metadata, and its id passes filter. A member migrated without reservation time never does.
+// It is no proof that the member was reserved by the same id_request, it is only checked for the ones opting in.
+func (p *StoredIdPool) isRetriedReservation(name string, filter idFilter, ttlMinutes int64, metadata map[string]string, now time.Time) bool {
+	id, ok := p.Members[name]
+	record, recorded := p.Records[name]
+	if !ok || !recorded || record.ReservedAt.IsZero() {
+		return false
+	}
+	if now.Sub(record.ReservedAt) > createRetryWindow {
+		return false
+	}
+	return record.TTLMinutes == ttlMinutes && maps.Equal(record.Metadata, metadata) && (filter == nil || filter(id))
+}
+
+// labelData is the data the label_template of a pool is rendered with.
+type labelData struct {
+	Value uint64
+	Name  string
+}
+
+// renderLabel renders the label_template of the pool for the member name holding id, empty when the pool has no template.
+func (p *StoredIdPool) renderLabel(name string, id IdPoolTools.ID) (string, error) {
+	if p.LabelTemplate == "" {
+		return "", nil
+	}
+	tmpl, err := template.New("label_template").Parse(p.LabelTemplate)
+	if err != nil {
+		return "", err
+	}
+	var label strings.Builder
+	if err := tmpl.Execute(&label, labelData{Value: uint64(id), Name: name}); err != nil {
+		return "", err
+	}
+	return label.String(), nil
+}
+
+// memberLabel returns the label recorded for the member name, empty if it has none.
+func (p *StoredIdPool) memberLabel(name string) string {
+	if record, ok := p.Records[name]; ok {
+		return record.Label
+	}
+	return ""
+}
+
+// renameMember moves a member and its record to a new name, keeping its id.
+func (p *StoredIdPool) renameMember(oldName string, newName string) {
+	if oldName == newName {
+		return
+	}
+	p.Members[newName] = p.Members[oldName]
+	delete(p.Members, oldName)
+	if record, ok := p.Records[oldName]; ok {
+		p.Records[newName] = record
+		delete(p.Records, oldName)
+	}
+}
+
+// isExpired reports whether the reservation of the given member has elapsed.
+func (p *StoredIdPool) isExpired(name string, now time.Time) bool {
+	record, ok := p.Records[name]
+	return ok && record.expired(now)
+}
+
+// sweepExpired releases every member whose reservation has elapsed and returns their names.
+func (p *StoredIdPool) sweepExpired(now time.Time) []string {
+	var swept []string
+	for name, record := range p.Records {
+		if _, ok := p.Members[name]; ok && record.expired(now) {
+			swept = append(swept, name)
+		}
+	}
+	for _, name := range swept {
+		p.release(name, now)
+	}
+	return swept
+}
+
+// valueUsed reports whether value is held by a member of the pool, externally managed or quarantined.
+func (p *StoredIdPool) valueUsed(value int64) bool {
+	if value <= 0 {
+		return false
+	}
+	id := IdPoolTools.ID(value)
+	for _, memberID := range p.Members {
+		if memberID == id {
+			return true
+		}
+	}
+	for _, externalID := range p.ExternallyManaged {
+		if externalID == id {
+			return true
+		}
+	}
+	_, quarantined := p.Quarantine[id]
+	return quarantined
+}
+
+// duplicateValues returns the ids held by more than one member, with the sorted names of those members.
+func (p *StoredIdPool) duplicateValues() map[IdPoolTools.ID][]string {
+	holders := make(map[IdPoolTools.ID][]string)
+	for name, id := range p.Members {
+		holders[id] = append(holders[id], name)
+	}
+	duplicates := make(map[IdPoolTools.ID][]string)
+	for id, names := range holders {
+		if len(names) > 1 {
+			sort.Strings(names)
+			duplicates[id] = names
+		}
+	}
+	return duplicates
+}
+
+// idRange is an inclusive range of ids.
+type idRange struct {
+	From IdPoolTools.ID
+	To   IdPoolTools.ID
+}
+
+// freeRanges returns the contiguous ranges of ids that can still be allocated, in ascending order.
+// They are derived from the sorted members so the cost does not depend on the size of the pool range.
+func (p *StoredIdPool) freeRanges() []idRange {
+	used := make([]IdPoolTools.ID, 0, len(p.Members)+len(p.ExternallyManaged))
+	for _, id := range p.Members {
+		used = append(used, id)
+	}
+	for _, id := range p.ExternallyManaged {
+		used = append(used, id)
+	}
+	for id := range p.Quarantine {
+		used = append(used, id)
+	}
+	if p.ReserveSentinel {
+		used = append(used, p.StartFrom)
+	}
+	sort.Slice(used, func(i, j int) bool { return used[i] < used[j] })
+
+	next := p.StartFrom
+	last := p.EndTo
+	if p.NoReuse && p.isDesc() && p.LowWater != IdPoolTools.NoID {
+		// Ids above the low-water mark are consumed even when released.
+		if p.LowWater <= next {
+			return nil
+		}
+		if p.LowWater-1 < last {
+			last = p.LowWater - 1
+		}
+	} else if p.NoReuse && p.NextFree > next {
+		// Ids below the high-water mark are consumed even when released.
+		next = p.NextFree
+	}
+	var ranges []idRange
+	for _, id := range used {
+		if id < next {
+			continue
+		}
+		if id > last {
+			break
+		}
+		if id > next {
+			ranges = append(ranges, idRange{From: next, To: id - 1})
+		}
+		next = id + 1
+	}
+	if next <= last {
+		ranges = append(ranges, idRange{From: next, To: last})
+	}
+	return ranges
+}
@@ -0,0 +1,743 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+)
+
+func TestStoredIdPool_NoReuse(t *testing.T) {
+	pool := newStoredIdPool(1, 3)
+	pool.NoReuse = true
+
+	first := pool.allocate("a", nil, nil)
+	if first != 1 {
+		t.Fatalf("expected first allocation to be 1, got %d", first)
+	}
+	pool.release("a", time.Now())
+	if second := pool.allocate("b", nil, nil); second != 2 {
+		t.Fatalf("expected released id to be skipped and 2 allocated, got %d", second)
+	}
+
+	// The high-water mark must survive a reconciliation of the stored pool.
+	rebuilt := pool.rebuild(pool.StartFrom, pool.EndTo)
+	if third := rebuilt.allocate("c", nil, nil); third != 3 {
+		t.Fatalf("expected 3 after rebuild, got %d", third)
+	}
+	if exhausted := rebuilt.allocate("d", nil, nil); exhausted != IdPoolTools.NoID {
+		t.Fatalf("expected pool to be exhausted, got %d", exhausted)
+	}
+	if _, ok := rebuilt.Members["d"]; ok {
+		t.Fatal("a failed allocation must not register a member")
+	}
+}
+
+func TestStoredIdPool_Reuse(t *testing.T) {
+	pool := newStoredIdPool(1, 1)
+
+	if id := pool.allocate("a", nil, nil); id != 1 {
+		t.Fatalf("expected 1, got %d", id)
+	}
+	pool.release("a", time.Now())
+	if id := pool.allocate("b", nil, nil); id != 1 {
+		t.Fatalf("expected released id 1 to be reused, got %d", id)
+	}
+}
+
+func TestStoredIdPool_Cooldown(t *testing.T) {
+	pool := newStoredIdPool(1, 1)
+	pool.CooldownDays = 30
+	releasedAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if id := pool.allocate("a", nil, nil); id != 1 {
+		t.Fatalf("expected 1, got %d", id)
+	}
+	pool.release("a", releasedAt)
+	if id := pool.allocate("b", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the released id to be quarantined, got %d", id)
+	}
+	if ranges := pool.freeRanges(); len(ranges) != 0 {
+		t.Fatalf("expected no free range during the cooldown, got %v", ranges)
+	}
+
+	// The quarantine survives a reconciliation of the stored pool.
+	rebuilt := pool.rebuild(pool.StartFrom, pool.EndTo)
+	if swept := rebuilt.sweepQuarantine(releasedAt.Add(29 * 24 * time.Hour)); len(swept) != 0 {
+		t.Fatalf("expected the id to stay quarantined before the end of the cooldown, got %v", swept)
+	}
+	if id := rebuilt.allocate("b", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the quarantined id to stay unavailable after rebuild, got %d", id)
+	}
+	if swept := rebuilt.sweepQuarantine(releasedAt.Add(30 * 24 * time.Hour)); len(swept) != 1 || swept[0] != 1 {
+		t.Fatalf("expected the id to be released at the end of the cooldown, got %v", swept)
+	}
+	if id := rebuilt.allocate("b", nil, nil); id != 1 || len(rebuilt.Quarantine) != 0 {
+		t.Fatalf("expected the id to be available again, got %d with quarantine %v", id, rebuilt.Quarantine)
+	}
+}
+
+func TestStoredIdPool_OldestFree(t *testing.T) {
+	pool := newStoredIdPool(1, 4)
+	pool.AllocationStrategy = idAllocationStrategyOldestFree
+	releasedAt := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"a", "b", "c"} {
+		pool.allocate(name, nil, nil)
+	}
+	pool.release("b", releasedAt)
+	pool.release("a", releasedAt.Add(time.Hour))
+
+	// The id never held is allocated before the released ones, then the least recently released.
+	if id := pool.allocate("d", nil, nil); id != 4 {
+		t.Fatalf("expected the never held id 4, got %d", id)
+	}
+	// The release times survive a reconciliation of the stored pool, and through JSON.
+	encoded, err := json.Marshal(pool.rebuild(pool.StartFrom, pool.EndTo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored StoredIdPool
+	if err := json.Unmarshal(encoded, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if id := stored.allocate("e", nil, nil); id != 2 {
+		t.Fatalf("expected the least recently released id 2, got %d", id)
+	}
+	if _, ok := stored.ReleasedAt[2]; ok || len(stored.ReleasedAt) != 1 {
+		t.Fatalf("expected the release time of 2 to be dropped once allocated, got %v", stored.ReleasedAt)
+	}
+	if id := stored.allocate("f", func(id IdPoolTools.ID) bool { return id != 1 }, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the filter to apply to the released ids, got %d", id)
+	}
+	if id := stored.allocate("f", nil, nil); id != 1 {
+		t.Fatalf("expected the last released id 1, got %d", id)
+	}
+
+	// A quarantined id counts as released when it left its member.
+	pool = newStoredIdPool(1, 3)
+	pool.AllocationStrategy = idAllocationStrategyOldestFree
+	pool.CooldownDays = 1
+	for _, name := range []string{"a", "b", "c"} {
+		pool.allocate(name, nil, nil)
+	}
+	pool.release("c", releasedAt)
+	pool.CooldownDays = 0
+	pool.release("a", releasedAt.Add(time.Hour))
+	if swept := pool.sweepQuarantine(releasedAt.Add(2 * time.Hour)); len(swept) != 1 || swept[0] != 3 {
+		t.Fatalf("expected 3 to leave the quarantine, got %v", swept)
+	}
+	if !pool.ReleasedAt[3].Equal(releasedAt) {
+		t.Fatalf("expected 3 to be released at %s, got %v", releasedAt, pool.ReleasedAt)
+	}
+	if id := pool.allocate("d", nil, nil); id != 3 {
+		t.Fatalf("expected the id released first 3, got %d", id)
+	}
+
+	// The release times are dropped when the pool returns to first_free, and for an id held again.
+	if diags := setPoolAllocationStrategy(pool, types.StringValue(idAllocationStrategyFirstFree)); diags.HasError() || pool.ReleasedAt != nil {
+		t.Fatalf("expected no release time with first_free, got %v: %v", pool.ReleasedAt, diags)
+	}
+	pool.AllocationStrategy = idAllocationStrategyOldestFree
+	pool.release("b", releasedAt)
+	if err := pool.reclaim("b", 2, releasedAt); err != nil || len(pool.ReleasedAt) != 0 {
+		t.Fatalf("expected the release time of the reclaimed id to be dropped, got %v: %v", pool.ReleasedAt, err)
+	}
+}
+
+func TestStoredIdPool_clone(t *testing.T) {
+	pool := newStoredIdPool(1, 10)
+	now := time.Now()
+	// a gets the lowest id, so that it is not the quarantined one.
+	pool.allocate("a", func(id IdPoolTools.ID) bool { return true }, nil)
+	pool.recordReservation("a", 0, now)
+	pool.setMemberMetadata("a", map[string]string{"team": "payments"})
+	pool.Quarantine = map[IdPoolTools.ID]time.Time{9: now}
+	pool.Remove(9)
+
+	copied := pool.clone()
+	if !reflect.DeepEqual(copied.IdCache.Ids, pool.IdCache.Ids) || !reflect.DeepEqual(copied.Members, pool.Members) {
+		t.Fatal("expected the copy to hold the free ids and the members of the pool")
+	}
+	copied.allocate("b", nil, nil)
+	copied.release("a", now)
+	copied.Quarantine[8] = now
+	copied.Records["b"] = &MemberRecord{}
+	if len(pool.Members) != 1 || len(pool.IdCache.Ids) != 8 || len(pool.Quarantine) != 1 || len(pool.Records) != 1 {
+		t.Fatalf("expected the pool to be left unchanged by its copy, got members %v and %d free ids", pool.Members, len(pool.IdCache.Ids))
+	}
+	copied = pool.clone()
+	copied.Records["a"].Metadata["team"] = "other"
+	if pool.Records["a"].Metadata["team"] != "payments" {
+		t.Fatal("expected the metadata of the records to be copied")
+	}
+}
+
+func TestStoredIdPool_SweepExpired(t *testing.T) {
+	now := time.Now()
+	pool := newStoredIdPool(1, 2)
+	pool.allocate("expiring", nil, nil)
+	pool.recordReservation("expiring", 10, now.Add(-11*time.Minute))
+	pool.allocate("permanent", nil, nil)
+	pool.recordReservation("permanent", 0, now.Add(-24*time.Hour))
+
+	if !pool.isExpired("expiring", now) || pool.isExpired("permanent", now) {
+		t.Fatal("only the reservation with an elapsed ttl must be expired")
+	}
+	swept := pool.sweepExpired(now)
+	if len(swept) != 1 || swept[0] != "expiring" {
+		t.Fatalf("expected only 'expiring' to be swept, got %v", swept)
+	}
+	if _, ok := pool.Members["expiring"]; ok {
+		t.Fatal("swept member must be removed from the pool")
+	}
+	if id := pool.allocate("new", nil, nil); id == IdPoolTools.NoID {
+		t.Fatal("the id of the swept member must be available again")
+	}
+}
+
+func TestStoredIdPool_AllocateWithFilter(t *testing.T) {
+	even := func(id IdPoolTools.ID) bool { return id%2 == 0 }
+
+	pool := newStoredIdPool(1, 6)
+	for _, expected := range []IdPoolTools.ID{2, 4, 6} {
+		if id := pool.allocate(fmt.Sprintf("even-%d", expected), even, nil); id != expected {
+			t.Fatalf("expected %d, got %d", expected, id)
+		}
+	}
+	if id := pool.allocate("even-none", even, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected no even id left, got %d", id)
+	}
+	if _, ok := pool.Members["even-none"]; ok {
+		t.Fatal("a failed allocation must not register a member")
+	}
+
+	noReuse := newStoredIdPool(1, 6)
+	noReuse.NoReuse = true
+	if id := noReuse.allocate("a", even, nil); id != 2 {
+		t.Fatalf("expected 2, got %d", id)
+	}
+}
+
+func TestStoredIdPool_AllocateWithMinGap(t *testing.T) {
+	pool := newStoredIdPool(1, 20)
+	pool.MinGap = 4
+	for _, expected := range []IdPoolTools.ID{1, 5, 9, 13, 17} {
+		if id := pool.allocate(fmt.Sprintf("m-%d", expected), nil, nil); id != expected {
+			t.Fatalf("expected %d, got %d", expected, id)
+		}
+	}
+	if id := pool.allocate("none", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected no id 4 away from every member, got %d", id)
+	}
+	// A release opens its zone again, the lowest id far enough from the remaining members is allocated.
+	pool.release("m-5", time.Now())
+	if id := pool.allocate("again", nil, nil); id != 5 {
+		t.Fatalf("expected 5, got %d", id)
+	}
+
+	even := func(id IdPoolTools.ID) bool { return id%2 == 0 }
+	filtered := newStoredIdPool(1, 20)
+	filtered.MinGap = 3
+	filtered.Members["held"] = 7
+	filtered.Remove(7)
+	if id := filtered.allocate("even", even, nil); id != 2 {
+		t.Fatalf("expected 2, got %d", id)
+	}
+	if id := filtered.allocate("next", even, nil); id != 10 {
+		t.Fatalf("expected 10, got %d", id)
+	}
+
+	desc := newStoredIdPool(1, 20)
+	desc.MinGap = 5
+	desc.Direction = idPoolDirectionDesc
+	for _, expected := range []IdPoolTools.ID{20, 15, 10} {
+		if id := desc.allocate(fmt.Sprintf("d-%d", expected), nil, nil); id != expected {
+			t.Fatalf("expected %d, got %d", expected, id)
+		}
+	}
+
+	unbounded := &StoredIdPool{IDPool: &IdPoolTools.IDPool{StartFrom: 1, EndTo: 9223372036854775807, Members: map[string]IdPoolTools.ID{"a": 3}}, MinGap: 10}
+	if id := unbounded.allocate("b", nil, nil); id != 13 {
+		t.Fatalf("expected 13, got %d", id)
+	}
+}
+
+func TestStoredIdPool_gapRanges(t *testing.T) {
+	pool := &StoredIdPool{IDPool: &IdPoolTools.IDPool{StartFrom: 1, EndTo: 30, Members: map[string]IdPoolTools.ID{"a": 2, "b": 10, "c": 12, "d": 29}}, MinGap: 3}
+	expected := []idRange{{5, 7}, {15, 26}}
+	if got := pool.gapRanges(); !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	pool.Members = map[string]IdPoolTools.ID{}
+	if got := pool.gapRanges(); !reflect.DeepEqual(got, []idRange{{1, 30}}) {
+		t.Fatalf("expected the whole range without member, got %v", got)
+	}
+}
+
+func TestStoredIdPool_FreeRanges(t *testing.T) {
+	// The available set is not materialized, the ranges must be derived from the members only.
+	pool := &StoredIdPool{IDPool: &IdPoolTools.IDPool{
+		StartFrom: 1,
+		EndTo:     9223372036854775807,
+		Members:   map[string]IdPoolTools.ID{"a": 1, "b": 5, "c": 6, "d": 10},
+	}}
+
+	expected := []idRange{{2, 4}, {7, 9}, {11, 9223372036854775807}}
+	got := pool.freeRanges()
+	if len(got) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+
+	pool.NoReuse = true
+	pool.NextFree = 8
+	if got := pool.freeRanges(); len(got) != 2 || got[0] != (idRange{8, 9}) {
+		t.Fatalf("ids below next_free must not be free, got %v", got)
+	}
+}
+
+func TestStoredIdPool_DuplicateValues(t *testing.T) {
+	pool := &StoredIdPool{IDPool: &IdPoolTools.IDPool{StartFrom: 1, EndTo: 10, Members: map[string]IdPoolTools.ID{
+		"a": 1, "b": 2, "c": 1, "d": 3, "e": 3, "f": 1,
+	}}}
+	duplicates := pool.duplicateValues()
+	if len(duplicates) != 2 {
+		t.Fatalf("expected 2 duplicated ids, got %v", duplicates)
+	}
+	if got := strings.Join(duplicates[1], ","); got != "a,c,f" {
+		t.Errorf("unexpected holders of id 1: %s", got)
+	}
+	if got := strings.Join(duplicates[3], ","); got != "d,e" {
+		t.Errorf("unexpected holders of id 3: %s", got)
+	}
+
+	delete(pool.Members, "c")
+	delete(pool.Members, "f")
+	delete(pool.Members, "e")
+	if duplicates := pool.duplicateValues(); len(duplicates) != 0 {
+		t.Fatalf("expected no duplicate, got %v", duplicates)
+	}
+}
+
+func TestStoredIdPool_AllocateWithSeed(t *testing.T) {
+	allocateAll := func(seed int64) []IdPoolTools.ID {
+		pool := newStoredIdPool(1, 50)
+		rng := newLockedRand(seed)
+		var ids []IdPoolTools.ID
+		for i := 0; i < 10; i++ {
+			ids = append(ids, pool.allocate(fmt.Sprintf("member-%d", i), nil, rng))
+		}
+		return ids
+	}
+	first := allocateAll(42)
+	second := allocateAll(42)
+	for i := range first {
+		if first[i] == IdPoolTools.NoID || first[i] != second[i] {
+			t.Fatalf("expected the same ids with the same seed, got %v and %v", first, second)
+		}
+	}
+
+	pool := newStoredIdPool(1, 2)
+	rng := newLockedRand(42)
+	pool.allocate("a", nil, rng)
+	pool.allocate("b", nil, rng)
+	if id := pool.allocate("c", nil, rng); id != IdPoolTools.NoID {
+		t.Fatalf("expected an exhausted pool, got %d", id)
+	}
+	if _, ok := pool.Members["c"]; ok {
+		t.Fatal("an exhausted pool must not keep the member")
+	}
+}
+
+func TestStoredIdPool_Desc(t *testing.T) {
+	pool := newStoredIdPool(1, 5)
+	pool.Direction = idPoolDirectionDesc
+
+	if first := pool.allocate("a", nil, newLockedRand(1)); first != 5 {
+		t.Fatalf("expected the first allocation of an empty desc pool to be end_to, got %d", first)
+	}
+	if second := pool.allocate("b", nil, nil); second != 4 {
+		t.Fatalf("expected 4, got %d", second)
+	}
+	pool.release("a", time.Now())
+	if reused := pool.allocate("c", nil, nil); reused != 5 {
+		t.Fatalf("expected the released highest id to be allocated again, got %d", reused)
+	}
+	odd := func(id IdPoolTools.ID) bool { return id%2 == 1 }
+	if filtered := pool.allocate("d", odd, nil); filtered != 3 {
+		t.Fatalf("expected the highest odd free id 3, got %d", filtered)
+	}
+	if ranges := pool.freeRanges(); len(ranges) != 1 || ranges[0] != (idRange{From: 1, To: 2}) {
+		t.Fatalf("unexpected free ranges %v", ranges)
+	}
+}
+
+func TestStoredIdPool_DescNoReuse(t *testing.T) {
+	pool := newStoredIdPool(3, 5)
+	pool.Direction = idPoolDirectionDesc
+	pool.NoReuse = true
+
+	if first := pool.allocate("a", nil, nil); first != 5 {
+		t.Fatalf("expected end_to first, got %d", first)
+	}
+	pool.release("a", time.Now())
+	if second := pool.allocate("b", nil, nil); second != 4 {
+		t.Fatalf("expected the released id to be skipped and 4 allocated, got %d", second)
+	}
+
+	// The low-water mark must survive a reconciliation, and the pool is extended downward.
+	rebuilt := pool.rebuild(1, pool.EndTo)
+	if ranges := rebuilt.freeRanges(); len(ranges) != 1 || ranges[0] != (idRange{From: 1, To: 3}) {
+		t.Fatalf("expected only the ids below the low-water mark to be free, got %v", ranges)
+	}
+	for _, expected := range []IdPoolTools.ID{3, 2, 1} {
+		if id := rebuilt.allocate(fmt.Sprintf("m%d", expected), nil, nil); id != expected {
+			t.Fatalf("expected %d, got %d", expected, id)
+		}
+	}
+	if exhausted := rebuilt.allocate("z", nil, nil); exhausted != IdPoolTools.NoID {
+		t.Fatalf("expected the pool to be exhausted, got %d", exhausted)
+	}
+	if ranges := rebuilt.freeRanges(); len(ranges) != 0 {
+		t.Fatalf("expected no free range, got %v", ranges)
+	}
+}
+
+func TestStoredIdPool_SeedMembers(t *testing.T) {
+	now := time.Now()
+	pool := newStoredIdPool(1, 10)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 3, "b": 7}, now); err != nil {
+		t.Fatal(err)
+	}
+	if pool.Members["a"] != 3 || pool.Members["b"] != 7 || pool.NextFree != 8 {
+		t.Fatalf("unexpected members %v with next free %d", pool.Members, pool.NextFree)
+	}
+	if ranges := pool.freeRanges(); len(ranges) != 3 {
+		t.Fatalf("expected the seeded ids to split the free range, got %v", ranges)
+	}
+	for i := 0; i < 8; i++ {
+		if id := pool.allocate(fmt.Sprintf("m%d", i), nil, nil); id == 3 || id == 7 || id == IdPoolTools.NoID {
+			t.Fatalf("expected a free id other than the seeded ones, got %d", id)
+		}
+	}
+
+	for name, members := range map[string]map[string]IdPoolTools.ID{
+		"out of range": {"a": 11},
+		"duplicate":    {"a": 2, "b": 2},
+		"empty name":   {"": 2},
+	} {
+		if err := newStoredIdPool(1, 10).seedMembers(members, now); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	pool = newStoredIdPool(1, 10)
+	pool.IdPattern = "^svc-"
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"other": 2}, now); err == nil || !strings.Contains(err.Error(), "id_pattern") {
+		t.Errorf("expected the names to be checked against id_pattern, got %v", err)
+	}
+}
+
+func TestStoredIdPool_ExternallyManaged(t *testing.T) {
+	stored := newStoredIdPool(1, 5)
+	stored.Members["a"] = 2
+	stored.ExternallyManaged = map[string]IdPoolTools.ID{"emergency-fw": 2, "legacy": 4}
+	pool := stored.rebuild(1, 5)
+	if ranges := pool.freeRanges(); len(ranges) != 3 || ranges[1] != (idRange{From: 3, To: 3}) {
+		t.Fatalf("expected the externally managed ids to be excluded from the free ranges, got %v", ranges)
+	}
+	for i := 0; i < 3; i++ {
+		if id := pool.allocate(fmt.Sprintf("m%d", i), nil, nil); id == 2 || id == 4 || id == IdPoolTools.NoID {
+			t.Fatalf("expected a free id other than the externally managed ones, got %d", id)
+		}
+	}
+	if id := pool.allocate("full", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the pool to be exhausted, got %d", id)
+	}
+
+	// A member holding an externally managed id by mistake does not free it when released.
+	pool.release("a", time.Now())
+	if id := pool.allocate("b", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the externally managed id to stay reserved, got %d", id)
+	}
+	if len(pool.ExternallyManaged) != 2 {
+		t.Fatalf("expected the externally managed ids to be kept, got %v", pool.ExternallyManaged)
+	}
+}
+
+func TestStoredIdPool_ReserveSentinel(t *testing.T) {
+	stored := newStoredIdPool(1, 3)
+	stored.ReserveSentinel = true
+	pool := stored.rebuild(1, 3)
+	if ranges := pool.freeRanges(); len(ranges) != 1 || ranges[0] != (idRange{From: 2, To: 3}) {
+		t.Fatalf("expected the sentinel to be excluded from the free ranges, got %v", ranges)
+	}
+	for i := 0; i < 2; i++ {
+		if id := pool.allocate(fmt.Sprintf("m%d", i), nil, nil); id == 1 || id == IdPoolTools.NoID {
+			t.Fatalf("expected a free id other than the sentinel, got %d", id)
+		}
+	}
+	if id := pool.allocate("full", nil, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected the pool to be exhausted, got %d", id)
+	}
+
+	// The sentinel follows start_from when the range changes.
+	for name, id := range pool.Members {
+		if id == 2 {
+			pool.release(name, time.Now())
+		}
+	}
+	pool = pool.rebuild(2, 3)
+	if ranges := pool.freeRanges(); len(ranges) != 0 {
+		t.Fatalf("expected the new start_from to be the sentinel, got %v", ranges)
+	}
+}
+
+func TestStoredIdPool_LabelTemplate(t *testing.T) {
+	pool := newStoredIdPool(1, 10)
+	pool.LabelTemplate = `node-{{printf "%03d" .Value}}`
+	id := pool.allocate("a", nil, nil)
+	pool.recordReservation("a", 0, time.Now())
+	if label := pool.memberLabel("a"); label != fmt.Sprintf("node-%03d", id) {
+		t.Fatalf("unexpected label: %q", label)
+	}
+
+	// The label is kept when the template changes.
+	pool.LabelTemplate = "host-{{.Name}}-{{.Value}}"
+	pool.recordReservation("a", 5, time.Now())
+	if label := pool.memberLabel("a"); label != fmt.Sprintf("node-%03d", id) {
+		t.Fatalf("expected the recorded label to be kept, got %q", label)
+	}
+	id = pool.allocate("b", nil, nil)
+	pool.recordReservation("b", 0, time.Now())
+	if label := pool.memberLabel("b"); label != fmt.Sprintf("host-b-%d", id) {
+		t.Fatalf("unexpected label of a new member: %q", label)
+	}
+
+	if _, err := (&StoredIdPool{LabelTemplate: "{{.Missing}}"}).renderLabel("a", 1); err == nil {
+		t.Fatal("expected an error on a template using an unknown field")
+	}
+}
+
+func TestStoredIdPool_Unbounded(t *testing.T) {
+	endTo := IdPoolTools.ID(math.MaxInt64)
+	pool := newStoredIdPool(1, endTo)
+	pool.ReserveSentinel = true
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 2, "b": 3, "c": 1000}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if id := pool.allocate("d", nil, nil); id != 4 {
+		t.Fatalf("expected the lowest free id 4, got %d", id)
+	}
+	if id := pool.allocate("e", func(id IdPoolTools.ID) bool { return id%100 == 0 }, nil); id != 100 {
+		t.Fatalf("expected the lowest id accepted by the filter, got %d", id)
+	}
+	if id := pool.allocate("f", func(id IdPoolTools.ID) bool { return false }, nil); id != IdPoolTools.NoID {
+		t.Fatalf("expected no id accepted by the filter, got %d", id)
+	}
+	rng := newLockedRand(42)
+	random := pool.allocate("g", nil, rng)
+	if random == IdPoolTools.NoID || pool.Members["g"] != random || pool.isFree(random) {
+		t.Fatalf("unexpected random id %d", random)
+	}
+	pool.release("b", time.Now())
+	if !pool.isFree(3) || pool.isFree(2) || pool.isFree(1) {
+		t.Fatal("expected only the released id to be free again")
+	}
+	if err := pool.reclaim("b", 3, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if ranges := pool.freeRanges(); len(ranges) == 0 || ranges[0] != (idRange{From: 5, To: 99}) {
+		t.Fatalf("unexpected free ranges %v", ranges)
+	}
+
+	desc := newStoredIdPool(1, endTo)
+	desc.Direction = idPoolDirectionDesc
+	desc.NoReuse = true
+	if id := desc.allocate("a", nil, nil); id != endTo {
+		t.Fatalf("expected the highest id, got %d", id)
+	}
+	desc.release("a", time.Now())
+	if id := desc.allocate("b", nil, nil); id != endTo-1 {
+		t.Fatalf("expected the consumed id to be skipped, got %d", id)
+	}
+
+	// Nothing proportional to the range is ever built, the object only holds the members.
+	allocations := testing.AllocsPerRun(10, func() {
+		rebuilt := pool.clone().rebuild(pool.StartFrom, pool.EndTo)
+		rebuilt.allocate("h", nil, rng)
+		rebuilt.release("h", time.Now())
+		rebuilt.freeRanges()
+	})
+	if allocations > 200 {
+		t.Errorf("expected a handful of allocations, got %.0f", allocations)
+	}
+	for _, p := range []*StoredIdPool{pool, desc, pool.rebuild(pool.StartFrom, pool.EndTo)} {
+		if len(p.IdCache.Ids) != 0 {
+			t.Fatalf("expected no cached id, got %d", len(p.IdCache.Ids))
+		}
+	}
+	content, err := json.Marshal(pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(content) > 2048 {
+		t.Errorf("expected a small object, got %d bytes", len(content))
+	}
+}
+
+func TestStoredIdPool_Metadata(t *testing.T) {
+	pool := newStoredIdPool(1, 10)
+	pool.allocate("a", nil, nil)
+	pool.recordReservation("a", 0, time.Now())
+	pool.allocate("b", nil, nil)
+	pool.recordReservation("b", 0, time.Now())
+	if !pool.setMemberMetadata("a", map[string]string{"team": "payments", "env": "prod"}) {
+		t.Fatal("expected the metadata to change")
+	}
+	if pool.setMemberMetadata("a", map[string]string{"env": "prod", "team": "payments"}) {
+		t.Fatal("expected the same metadata not to be a change")
+	}
+	// A renewal of the reservation keeps the metadata.
+	pool.recordReservation("a", 60, time.Now())
+
+	if !pool.matchesMetadata("a", map[string]string{"team": "payments"}) || !pool.matchesMetadata("a", nil) {
+		t.Fatal("expected a to match")
+	}
+	if pool.matchesMetadata("a", map[string]string{"team": "search"}) || pool.matchesMetadata("b", map[string]string{"team": "payments"}) {
+		t.Fatal("expected a different value or a member without metadata not to match")
+	}
+
+	// The members of a pool written before the metadata were introduced have none.
+	var legacy StoredIdPool
+	if err := json.Unmarshal([]byte(`{"start_from":1,"end_to":10,"members":{"a":1}}`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if legacy.matchesMetadata("a", map[string]string{"team": "payments"}) || !legacy.matchesMetadata("a", map[string]string{}) {
+		t.Fatal("expected a legacy member to only match an empty filter")
+	}
+
+	if !pool.setMemberMetadata("a", nil) || pool.Records["a"].Metadata != nil {
+		t.Fatal("expected the metadata to be removed")
+	}
+}
+
+func TestStoredIdPool_isRetriedReservation(t *testing.T) {
+	pool := &StoredIdPool{IDPool: &IdPoolTools.IDPool{Members: map[string]IdPoolTools.ID{"a": 3, "legacy": 4}}, Records: map[string]*MemberRecord{}}
+	now := time.Now()
+	pool.recordReservation("a", 60, now.Add(-time.Hour))
+	pool.setMemberMetadata("a", map[string]string{"team": "payments"})
+
+	metadata := map[string]string{"team": "payments"}
+	if !pool.isRetriedReservation("a", nil, 60, metadata, now) {
+		t.Fatal("expected a recent member with the same ttl and metadata to be a retried reservation")
+	}
+	if pool.isRetriedReservation("a", nil, 0, metadata, now) {
+		t.Fatal("a member with another ttl must not be taken back")
+	}
+	if pool.isRetriedReservation("a", nil, 60, nil, now) {
+		t.Fatal("a member with other metadata must not be taken back")
+	}
+	if pool.isRetriedReservation("a", func(id IdPoolTools.ID) bool { return id > 3 }, 60, metadata, now) {
+		t.Fatal("a member whose id does not pass the filter must not be taken back")
+	}
+	if pool.isRetriedReservation("a", nil, 60, metadata, now.Add(createRetryWindow)) {
+		t.Fatal("a member reserved before the retry window must not be taken back")
+	}
+	if pool.isRetriedReservation("legacy", nil, 0, nil, now) || pool.isRetriedReservation("missing", nil, 0, nil, now) {
+		t.Fatal("a member without reservation time or absent must not be taken back")
+	}
+}
+
+func TestStoredIdPool_formatId(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		width  int64
+		id     IdPoolTools.ID
+		want   string
+	}{
+		{"", 0, 163, "163"},
+		{"", 6, 163, "000163"},
+		{idOutputFormatHex, 0, 163, "0xa3"},
+		{idOutputFormatHex, 4, 163, "0x00a3"},
+		{idOutputFormatHex, 2, 4095, "0xfff"},
+	} {
+		pool := &StoredIdPool{OutputFormat: tc.format, OutputWidth: tc.width}
+		if got := pool.formatId(tc.id); got != tc.want {
+			t.Fatalf("expected %d formatted as %s with width %d to be %s, got %s", tc.id, tc.format, tc.width, tc.want, got)
+		}
+	}
+}
+
+func TestStoredIdPool_burstFilter(t *testing.T) {
+	for _, noReuse := range []bool{false, true} {
+		pool := newStoredIdPool(1, 10)
+		pool.NoReuse = noReuse
+		pool.BurstFrom = 8
+		for i := 1; i <= 7; i++ {
+			if id := pool.allocate(fmt.Sprintf("m%d", i), pool.burstFilter(nil, false), nil); id != IdPoolTools.ID(i) {
+				t.Fatalf("expected id %d below the burst region, got %d", i, id)
+			}
+		}
+		if id := pool.allocate("normal", pool.burstFilter(nil, false), nil); id != IdPoolTools.NoID {
+			t.Fatalf("expected a request without allow_burst not to use the burst region, got %d", id)
+		}
+		// Without filter, the id is picked by the pool among the free ones.
+		burst := pool.allocate("burst", pool.burstFilter(nil, true), nil)
+		if burst < 8 || burst > 10 {
+			t.Fatalf("expected a request with allow_burst to get an id of the burst region, got %d", burst)
+		}
+		even := func(id IdPoolTools.ID) bool { return id%2 == 0 }
+		if id := pool.allocate("even", pool.burstFilter(even, true), nil); id == IdPoolTools.NoID || id < 8 || id%2 != 0 {
+			t.Fatalf("expected the value_filter to still apply in the burst region, got %d", id)
+		}
+	}
+	pool := newStoredIdPool(1, 10)
+	if filter := pool.burstFilter(nil, false); filter != nil {
+		t.Fatal("expected no filter on a pool without burst region")
+	}
+}
+
+func TestCheckPartitions(t *testing.T) {
+	valid := map[string]IdPartition{"reserved": {From: 1, To: 9}, "dynamic": {From: 10, To: 100}}
+	if err := checkPartitions(valid, 1, 100); err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []map[string]IdPartition{
+		{"reversed": {From: 9, To: 1}},
+		{"out": {From: 90, To: 101}},
+		{"a": {From: 1, To: 10}, "b": {From: 10, To: 20}},
+	} {
+		if err := checkPartitions(invalid, 1, 100); err == nil {
+			t.Fatalf("expected %v to be rejected", invalid)
+		}
+	}
+}
+
+func TestStoredIdPool_partitionFilter(t *testing.T) {
+	pool := newStoredIdPool(1, 20)
+	pool.Partitions = map[string]IdPartition{"reserved": {From: 5, To: 8}}
+	even := func(id IdPoolTools.ID) bool { return id%2 == 0 }
+	filter, err := pool.partitionFilter(even, "reserved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := pool.allocate("a", filter, nil); id != 6 {
+		t.Fatalf("expected the lowest even id of the partition, got %d", id)
+	}
+	if filter, err := pool.partitionFilter(even, ""); err != nil || filter == nil || !filter(2) {
+		t.Fatalf("expected the filter to be kept without partition, got %v", err)
+	}
+	if _, err := pool.partitionFilter(nil, "dynamic"); err == nil || !strings.Contains(err.Error(), "reserved") {
+		t.Fatalf("expected an unknown partition to be rejected with the known ones, got %v", err)
+	}
+}
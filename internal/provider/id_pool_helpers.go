@@ -3,22 +3,35 @@ package provider
 import (
 	"context"
 	"errors"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
-	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
 	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
 )
 
+// idPoolObject is the storage of an id pool document, implemented by *connector.GcpConnectorGeneric.
+type idPoolObject interface {
+	GetAttrs(ctx context.Context) (*storage.ObjectAttrs, error)
+	Read(ctx context.Context, data interface{}) error
+	Write(ctx context.Context, data interface{}) error
+	GetFullFilePath() string
+	GetGeneration() int64
+	GetContentHash() string
+	SetGeneration(generation int64)
+}
+
+var _ idPoolObject = &connector.GcpConnectorGeneric{}
+
 // getAndCacheIdPool retrieves an ID pool, utilizing a cache to minimize GCS reads.
 // It checks the remote object's generation against the cached version. If they differ,
 // it fetches the latest version from GCS and updates the cache.
 // This function assumes that a higher-level lock (e.g., a GCS lock file) is already held
 // to prevent race conditions between different Terraform processes.
-func getAndCacheIdPool(ctx context.Context, p *GCSReferentialProviderModel, poolName string, gcpConnector *connector.GcpConnectorGeneric) (*CachedIdPool, error) {
-	p.CacheMutex.Lock()
-	defer p.CacheMutex.Unlock()
-
+// The cache mutex is never held during the GCS calls: lookups only take its read lock, so the operations
+// on unrelated pools do not wait for each other, and the write lock is only taken to update an entry.
+func getAndCacheIdPool(ctx context.Context, p *GCSReferentialProviderModel, poolName string, gcpConnector idPoolObject) (*CachedIdPool, error) {
 	// Get remote object attributes to check generation.
 	attrs, err := gcpConnector.GetAttrs(ctx)
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
@@ -31,41 +44,110 @@ func getAndCacheIdPool(ctx context.Context, p *GCSReferentialProviderModel, pool
 	}
 
 	// Always update the connector's generation to what was just observed from the remote state.
-	gcpConnector.Generation = remoteGeneration
+	gcpConnector.SetGeneration(remoteGeneration)
 
 	// Check if a valid, up-to-date pool is already in the cache.
-	if cachedPool, ok := p.IdPoolsCache[poolName]; ok && cachedPool.Generation == remoteGeneration {
+	// The cache is keyed by object path since it is shared by every provider on the bucket.
+	cacheKey := gcpConnector.GetFullFilePath()
+	p.CacheMutex.RLock()
+	cachedPool, ok := p.IdPoolsCache[cacheKey]
+	p.CacheMutex.RUnlock()
+	if ok && cachedPool.Generation == remoteGeneration {
 		tflog.Debug(ctx, "Cache hit for pool", map[string]interface{}{"pool": poolName, "generation": remoteGeneration})
 		return cachedPool, nil
 	}
 
 	// Cache miss or stale data: read from GCS.
 	tflog.Debug(ctx, "Cache miss for pool", map[string]interface{}{"pool": poolName})
-	var pool IdPoolTools.IDPool
+	var pool StoredIdPool
 	err = gcpConnector.Read(ctx, &pool)
 	if err != nil {
 		// If the object doesn't exist, remove it from cache in case it's a stale entry.
 		if errors.Is(err, storage.ErrObjectNotExist) {
-			delete(p.IdPoolsCache, poolName)
+			p.CacheMutex.Lock()
+			delete(p.IdPoolsCache, cacheKey)
+			p.CacheMutex.Unlock()
 		}
 		return nil, err
 	}
 
 	// Reconcile the pool's internal state after reading from JSON.
-	members := pool.Members
-	reconciledPoolPtr := IdPoolTools.NewIDPool(pool.StartFrom, pool.EndTo)
-	for _, allocatedID := range members {
-		reconciledPoolPtr.Remove(allocatedID)
-	}
-	reconciledPoolPtr.Members = members
+	reconciledPoolPtr := pool.rebuild(pool.StartFrom, pool.EndTo)
+	// The pool is not shared yet, the ids whose cooldown has elapsed are made available in memory until the next write.
+	sweepQuarantinedIds(ctx, poolName, reconciledPoolPtr)
 
 	// Store the newly read and reconciled pool in the cache.
 	newCachedPool := &CachedIdPool{
-		Pool:       reconciledPoolPtr,
-		Generation: gcpConnector.Generation, // Read() updates the connector's generation.
+		Pool:        reconciledPoolPtr,
+		Generation:  gcpConnector.GetGeneration(), // Read() updates the connector's generation and hash.
+		ContentHash: gcpConnector.GetContentHash(),
+	}
+	p.CacheMutex.Lock()
+	// A concurrent read or write may have cached a more recent version meanwhile, keep it.
+	if current, ok := p.IdPoolsCache[cacheKey]; !ok || current.Generation < newCachedPool.Generation {
+		p.IdPoolsCache[cacheKey] = newCachedPool
 	}
-	p.IdPoolsCache[poolName] = newCachedPool
+	p.CacheMutex.Unlock()
 	tflog.Debug(ctx, "Cached new pool version", map[string]interface{}{"pool": poolName, "generation": newCachedPool.Generation})
 
 	return newCachedPool, nil
 }
+
+// getIdPoolForUpdate returns a private copy of the pool given by getAndCacheIdPool, for the operations that modify it
+// under the pool lock. The cached pool is shared with the concurrent operations of every provider on the bucket, that
+// read it without lock, so it must never be modified in place: the copy replaces it with storeCachedIdPool once written.
+func getIdPoolForUpdate(ctx context.Context, p *GCSReferentialProviderModel, poolName string, gcpConnector idPoolObject) (*CachedIdPool, error) {
+	cachedPool, err := getAndCacheIdPool(ctx, p, poolName, gcpConnector)
+	if err != nil {
+		return nil, err
+	}
+	return &CachedIdPool{Pool: cachedPool.Pool.clone(), Generation: cachedPool.Generation, ContentHash: cachedPool.ContentHash}, nil
+}
+
+// listIdPools returns the sorted names of the id_pools stored in the referential bucket, for the tenant of the provider.
+// The locks and the subfolders, such as those of other tenants, are not pools and are left out.
+func listIdPools(ctx context.Context, p *GCSReferentialProviderModel) ([]string, error) {
+	return connector.ListChildren(ctx, p.StorageEndpoint.ValueString(), p.ReferentialBucket.ValueString(), p.idPoolDir())
+}
+
+// addPoolWriteError adds the diagnostic of a failed write of the pool object of gcpConnector. A generation conflict
+// gets its own message since the pool was changed by someone else and running the apply again is safe.
+func addPoolWriteError(diags *diag.Diagnostics, summary string, poolName string, gcpConnector objectLocation, err error) {
+	if errors.Is(err, connector.ErrGenerationConflict) {
+		diags.AddError(summary, objectDetail(gcpConnector, "pool '%s' was modified concurrently (generation conflict); re-run apply", poolName))
+		return
+	}
+	diags.AddError(summary, objectDetail(gcpConnector, "Cannot write pool '%s' on the referential_bucket: %s", poolName, err.Error()))
+}
+
+// invalidateCachedIdPool removes a pool from the cache, forcing a re-read on the next operation.
+func invalidateCachedIdPool(p *GCSReferentialProviderModel, poolName string) {
+	p.CacheMutex.Lock()
+	delete(p.IdPoolsCache, p.idPoolPath(poolName))
+	p.CacheMutex.Unlock()
+}
+
+// storeCachedIdPool caches a pool just written under lock with the generation and hash of the write,
+// so the following operations of the run on this pool hit the cache instead of reading it again.
+func storeCachedIdPool(p *GCSReferentialProviderModel, poolName string, pool *StoredIdPool, written idPoolObject) {
+	p.CacheMutex.Lock()
+	p.IdPoolsCache[p.idPoolPath(poolName)] = &CachedIdPool{Pool: pool, Generation: written.GetGeneration(), ContentHash: written.GetContentHash()}
+	p.CacheMutex.Unlock()
+}
+
+// sweepQuarantinedIds makes available again the quarantined ids of a pool whose cooldown has elapsed.
+// The caller must own the pool, it is responsible for writing it.
+func sweepQuarantinedIds(ctx context.Context, poolName string, pool *StoredIdPool) {
+	for _, id := range pool.sweepQuarantine(time.Now()) {
+		tflog.Debug(ctx, "Released quarantined id", map[string]interface{}{"pool": poolName, "id": uint64(id)})
+	}
+}
+
+// sweepExpiredMembers releases the members of a pool whose reservation ttl has elapsed. Only the allocations call it,
+// the other operations keep the expired members, such as an update renewing its own reservation.
+// It must be called while holding the pool lock, the caller is responsible for writing the pool.
+func sweepExpiredMembers(ctx context.Context, poolName string, pool *StoredIdPool) {
+	for _, name := range pool.sweepExpired(time.Now()) {
+		tflog.Info(ctx, "Released expired reservation", map[string]interface{}{"pool": poolName, "member": name})
+	}
+}
@@ -0,0 +1,398 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+const testBucket = "test-bucket"
+
+// newTestProviderData returns a configured provider model with its own cache.
+func newTestProviderData() *GCSReferentialProviderModel {
+	return &GCSReferentialProviderModel{
+		ReferentialBucket: types.StringValue(testBucket),
+		TimeoutInMinutes:  types.Int32Value(1),
+		BackoffMultiplier: types.Float32Value(0.5),
+		IdPoolsCache:      make(map[string]*CachedIdPool),
+		CacheMutex:        &sync.RWMutex{},
+		Batchers:          newPoolBatchers(),
+	}
+}
+
+// createTestIdPool writes a new pool on the fake server.
+func createTestIdPool(tb testing.TB, p *GCSReferentialProviderModel, poolName string, startFrom int, endTo int) {
+	gcpConnector := p.idPoolConnector(poolName)
+	if err := gcpConnector.Write(context.Background(), newStoredIdPool(idOf(startFrom), idOf(endTo))); err != nil {
+		tb.Fatalf("cannot create pool %s: %s", poolName, err)
+	}
+}
+
+// idOf returns the id of the given bound of a test pool.
+func idOf(i int) IdPoolTools.ID {
+	return IdPoolTools.ID(i)
+}
+
+// allocateTestMember allocates an id to member on the given pool as the creation of an id_request does.
+func allocateTestMember(tb testing.TB, p *GCSReferentialProviderModel, poolName string, member string) {
+	result := allocateInBatch(context.Background(), p, poolName, &allocationRequest{member: member})
+	if result.diags.HasError() {
+		tb.Fatalf("cannot allocate %s on pool %s: %v", member, poolName, result.diags)
+	}
+}
+
+func TestGetAndCacheIdPool_warmAfterWrite(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 10)
+
+	allocateTestMember(t, p, "pool", "a")
+	allocateTestMember(t, p, "pool", "b")
+
+	gcpConnector := p.idPoolConnector("pool")
+	cachedPool, err := getAndCacheIdPool(context.Background(), p, "pool", &gcpConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cachedPool.Pool.Members) != 2 {
+		t.Fatalf("expected 2 members, got %v", cachedPool.Pool.Members)
+	}
+	stored, _ := server.Get(testBucket, gcpConnector.FullFilePath)
+	if cachedPool.Generation != stored.Generation {
+		t.Fatalf("cached generation %d does not match stored generation %d", cachedPool.Generation, stored.Generation)
+	}
+}
+
+// BenchmarkIdRequestCreateRPCs compares the number of GCS requests of an apply creating 100 id_requests on
+// the same pool, when the pool cache is invalidated after each allocation versus kept warm with the written pool.
+func BenchmarkIdRequestCreateRPCs(b *testing.B) {
+	for _, warm := range []bool{false, true} {
+		b.Run(fmt.Sprintf("warm=%t", warm), func(b *testing.B) {
+			server := gcstest.NewServer(b)
+			for i := 0; i < b.N; i++ {
+				p := newTestProviderData()
+				poolName := fmt.Sprintf("pool-%d", i)
+				createTestIdPool(b, p, poolName, 1, 1000)
+				server.ResetRequests()
+				for j := 0; j < 100; j++ {
+					allocateTestMember(b, p, poolName, fmt.Sprintf("req-%d", j))
+					if !warm {
+						invalidateCachedIdPool(p, poolName)
+					}
+				}
+				b.ReportMetric(float64(server.Requests())/100, "rpc/request")
+			}
+		})
+	}
+}
+
+func TestGetAndCacheIdPool_corruptedMember(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	gcpConnector := p.idPoolConnector("pool")
+	server.Put(testBucket, gcpConnector.FullFilePath, []byte(`{"start_from":1,"end_to":10,"members":{"a":"3"}}`))
+
+	_, err := getAndCacheIdPool(context.Background(), p, "pool", &gcpConnector)
+	if !errors.Is(err, connector.ErrCorruptedObject) {
+		t.Fatalf("expected a corrupted object error, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), gcpConnector.FullFilePath) {
+		t.Errorf("expected the object path in the error, got: %s", err)
+	}
+}
+
+func TestGetAndCacheIdPool_migrateMembers(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	reservedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	objects := map[string]string{
+		// Written before the member records, with only the flat map of the members.
+		"legacy": `{"start_from":1,"end_to":10,"members":{"a":3}}`,
+		"v1":     `{"schema_version":1,"start_from":1,"end_to":10,"members":{"a":3,"b":4},"member_records":{"b":{"reserved_at":"2026-01-02T03:04:05Z","ttl_minutes":60}}}`,
+		"v2":     `{"schema_version":2,"start_from":1,"end_to":10,"members":{"a":3},"member_records":{"a":{"reserved_at":"2026-01-02T03:04:05Z"}}}`,
+	}
+	for poolName, content := range objects {
+		gcpConnector := p.idPoolConnector(poolName)
+		server.Put(testBucket, gcpConnector.FullFilePath, []byte(content))
+
+		cachedPool, err := getAndCacheIdPool(ctx, p, poolName, &gcpConnector)
+		if err != nil {
+			t.Fatalf("%s: %s", poolName, err)
+		}
+		pool := cachedPool.Pool
+		if pool.Members["a"] != 3 || pool.Records["a"] == nil || pool.SchemaVersion != idPoolSchemaVersion {
+			t.Fatalf("%s: expected a migrated pool with a record for a, got members %v records %v", poolName, pool.Members, pool.Records)
+		}
+		if record := pool.Records["b"]; poolName == "v1" && (record == nil || !record.ReservedAt.Equal(reservedAt) || record.TTLMinutes != 60) {
+			t.Fatalf("%s: expected the existing record of b to be kept, got %v", poolName, record)
+		}
+		// The migration is not written back on read.
+		if stored, _ := server.Get(testBucket, gcpConnector.FullFilePath); string(stored.Data) != content {
+			t.Fatalf("%s: expected the object to be left unchanged on read, got %s", poolName, stored.Data)
+		}
+
+		updated, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		updated.Pool.allocate("c", nil, nil)
+		updated.Pool.recordReservation("c", 0, reservedAt)
+		if err := gcpConnector.Write(ctx, updated.Pool); err != nil {
+			t.Fatal(err)
+		}
+		stored, _ := server.Get(testBucket, gcpConnector.FullFilePath)
+		var written struct {
+			SchemaVersion int                      `json:"schema_version"`
+			Records       map[string]*MemberRecord `json:"member_records"`
+		}
+		if err := json.Unmarshal(stored.Data, &written); err != nil {
+			t.Fatal(err)
+		}
+		if written.SchemaVersion != idPoolSchemaVersion || written.Records["a"] == nil || written.Records["c"] == nil {
+			t.Fatalf("%s: expected the upgraded format to be written, got %s", poolName, stored.Data)
+		}
+	}
+}
+
+func TestListIdPools(t *testing.T) {
+	for _, hierarchical := range []bool{false, true} {
+		server := gcstest.NewServer(t)
+		p := newTestProviderData()
+		createTestIdPool(t, p, "pool-a", 1, 10)
+		createTestIdPool(t, p, "pool-b", 1, 10)
+		tenant := newTestProviderData()
+		tenant.Tenant = types.StringValue("team")
+		createTestIdPool(t, tenant, "pool-c", 1, 10)
+		server.Put(testBucket, p.idPoolPath("pool-a")+".lock", []byte("lock"))
+		if hierarchical {
+			server.PutFolder(testBucket, p.idPoolDir())
+			server.PutFolder(testBucket, tenant.idPoolDir())
+		} else {
+			server.Put(testBucket, p.idPoolDir()+"/", nil)
+		}
+
+		names, err := listIdPools(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(names, ",") != "pool-a,pool-b" {
+			t.Errorf("hierarchical=%t: expected pool-a and pool-b, got %v", hierarchical, names)
+		}
+		names, err = listIdPools(context.Background(), tenant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(names, ",") != "pool-c" {
+			t.Errorf("hierarchical=%t: expected pool-c for the tenant, got %v", hierarchical, names)
+		}
+	}
+}
+
+// fakeIdPoolObject is an in-memory idPoolObject counting the reads, to test the cache without GCS.
+type fakeIdPoolObject struct {
+	path       string
+	data       []byte
+	remote     int64
+	generation int64
+	reads      int
+	// latency simulates the round trip of a GCS call in GetAttrs.
+	latency time.Duration
+}
+
+// put replaces the stored pool as another client would, with a new generation.
+func (f *fakeIdPoolObject) put(tb testing.TB, pool *StoredIdPool) {
+	data, err := json.Marshal(pool)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	f.data = data
+	f.remote++
+}
+
+func (f *fakeIdPoolObject) GetAttrs(ctx context.Context) (*storage.ObjectAttrs, error) {
+	time.Sleep(f.latency)
+	if f.data == nil {
+		return nil, storage.ErrObjectNotExist
+	}
+	return &storage.ObjectAttrs{Name: f.path, Generation: f.remote}, nil
+}
+
+func (f *fakeIdPoolObject) Read(ctx context.Context, data interface{}) error {
+	f.reads++
+	if f.data == nil {
+		return storage.ErrObjectNotExist
+	}
+	f.generation = f.remote
+	return json.Unmarshal(f.data, data)
+}
+
+func (f *fakeIdPoolObject) Write(ctx context.Context, data interface{}) error {
+	if f.generation != f.remote {
+		return connector.ErrGenerationConflict
+	}
+	content, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	f.data = content
+	f.remote++
+	f.generation = f.remote
+	return nil
+}
+
+func (f *fakeIdPoolObject) GetFullFilePath() string        { return f.path }
+func (f *fakeIdPoolObject) GetGeneration() int64           { return f.generation }
+func (f *fakeIdPoolObject) GetContentHash() string         { return "" }
+func (f *fakeIdPoolObject) SetGeneration(generation int64) { f.generation = generation }
+
+func TestGetAndCacheIdPool_cache(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProviderData()
+	object := &fakeIdPoolObject{path: p.idPoolPath("pool")}
+	object.put(t, newStoredIdPool(1, 10))
+
+	// Miss: the pool is read and cached.
+	first, err := getAndCacheIdPool(ctx, p, "pool", object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.reads != 1 || first.Generation != object.remote {
+		t.Fatalf("expected one read at generation %d, got %d reads at generation %d", object.remote, object.reads, first.Generation)
+	}
+
+	// Hit: the generation did not change.
+	second, err := getAndCacheIdPool(ctx, p, "pool", object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.reads != 1 || second != first {
+		t.Fatalf("expected a cache hit, got %d reads", object.reads)
+	}
+
+	// Stale: another client wrote the pool.
+	changed := newStoredIdPool(1, 10)
+	changed.allocate("member", nil, nil)
+	object.put(t, changed)
+	third, err := getAndCacheIdPool(ctx, p, "pool", object)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if object.reads != 2 || third.Generation != object.remote || object.GetGeneration() != object.remote {
+		t.Fatalf("expected the stale pool to be read again, got %d reads at generation %d", object.reads, third.Generation)
+	}
+	if _, ok := third.Pool.Members["member"]; !ok {
+		t.Fatal("expected the pool written by the other client")
+	}
+
+	// Deleted: the cache entry is dropped.
+	object.data = nil
+	if _, err := getAndCacheIdPool(ctx, p, "pool", object); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Fatalf("expected ErrObjectNotExist, got %v", err)
+	}
+	if _, ok := p.IdPoolsCache[object.path]; ok || object.GetGeneration() != -1 {
+		t.Fatalf("expected the deleted pool out of the cache, with generation -1, got %d", object.GetGeneration())
+	}
+}
+
+func TestGetAndCacheIdPool_contentHash(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+	created, err := getAndCacheIdPool(ctx, p, "pool", &gcpConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.ContentHash == "" || created.ContentHash != gcpConnector.ContentHash {
+		t.Fatalf("expected the hash read to be cached, got %q", created.ContentHash)
+	}
+
+	// A write cached warm keeps the hash of the written content.
+	allocateTestMember(t, p, "pool", "member")
+	gcpConnector = p.idPoolConnector("pool")
+	allocated, err := getAndCacheIdPool(ctx, p, "pool", &gcpConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allocated.ContentHash == created.ContentHash {
+		t.Fatal("expected the allocation to change the hash")
+	}
+	var pool StoredIdPool
+	if err := gcpConnector.Read(ctx, &pool); err != nil {
+		t.Fatal(err)
+	}
+	if allocated.ContentHash != gcpConnector.ContentHash {
+		t.Errorf("expected the cached hash %s to match the stored object %s", allocated.ContentHash, gcpConnector.ContentHash)
+	}
+}
+
+// BenchmarkGetAndCacheIdPool_parallelPools looks up many warm pools in parallel with a simulated GCS latency:
+// since the cache mutex is not held during the GCS calls, the lookups of different pools overlap.
+func BenchmarkGetAndCacheIdPool_parallelPools(b *testing.B) {
+	const pools = 64
+	ctx := context.Background()
+	p := newTestProviderData()
+	prototypes := make([]*fakeIdPoolObject, pools)
+	for i := range prototypes {
+		prototypes[i] = &fakeIdPoolObject{path: p.idPoolPath(fmt.Sprintf("pool-%d", i)), latency: 50 * time.Microsecond}
+		prototypes[i].put(b, newStoredIdPool(1, 10))
+		if _, err := getAndCacheIdPool(ctx, p, fmt.Sprintf("pool-%d", i), prototypes[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.SetParallelism(16)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// Each goroutine has its own connectors, as each operation does.
+		objects := make([]*fakeIdPoolObject, pools)
+		for i, prototype := range prototypes {
+			object := *prototype
+			objects[i] = &object
+		}
+		i := 0
+		for pb.Next() {
+			if _, err := getAndCacheIdPool(ctx, p, "pool", objects[i%pools]); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+// BenchmarkGetIdPoolForUpdate measures the private copy of a warm pool made by every operation modifying it, on a
+// large range mostly free: the copy must not cost a rebuild of the whole range.
+func BenchmarkGetIdPoolForUpdate(b *testing.B) {
+	ctx := context.Background()
+	p := newTestProviderData()
+	pool := newStoredIdPool(1, 1000000)
+	for i := 0; i < 1000; i++ {
+		pool.allocate(fmt.Sprintf("member-%d", i), nil, nil)
+	}
+	object := &fakeIdPoolObject{path: p.idPoolPath("pool")}
+	object.put(b, pool)
+	if _, err := getAndCacheIdPool(ctx, p, "pool", object); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := getIdPoolForUpdate(ctx, p, "pool", object); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+// idPoolIntentDirName is the folder of the intent objects of the id_pools, out of the folder of the pools so that
+// they are never listed as pools.
+const idPoolIntentDirName = "id_pool_intent"
+
+// allocationIntent is the write-ahead record of a batch of allocations, written before the pool when crash_safe is set.
+type allocationIntent struct {
+	Pool string `json:"pool"`
+	// BaseGeneration is the generation of the pool the allocations were made on. While the pool still has it,
+	// the write of the allocations never happened.
+	BaseGeneration int64                   `json:"base_generation"`
+	CreatedAt      time.Time               `json:"created_at"`
+	Members        map[string]intentMember `json:"members"`
+}
+
+// intentMember is an allocation recorded in an allocationIntent.
+type intentMember struct {
+	Id         IdPoolTools.ID    `json:"id"`
+	TTLMinutes int64             `json:"ttl_minutes,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// idPoolIntentPath returns the path of the intent object of the given id_pool, namespaced by the tenant if any.
+func (p *GCSReferentialProviderModel) idPoolIntentPath(poolName string) string {
+	return fmt.Sprintf("%s/%s", p.resourceDir(idPoolIntentDirName), poolName)
+}
+
+// poolIntent is the intent object of a pool. It is only used under the lock of the pool, it has no lock of its own.
+type poolIntent struct {
+	poolName     string
+	gcpConnector connector.GcpConnectorGeneric
+	// pending is true while the intent object exists.
+	pending bool
+	// replayed holds the members recovered from the pending intent, kept in the next intent until the pool is written.
+	replayed map[string]intentMember
+}
+
+func newPoolIntent(p *GCSReferentialProviderModel, poolName string) *poolIntent {
+	return &poolIntent{poolName: poolName, gcpConnector: p.genericConnector(p.idPoolIntentPath(poolName))}
+}
+
+// recover reads the intent left by a run interrupted before its write of the pool and replays its allocations in pool,
+// read with the given generation. The allocations are only replayed while the pool still has the generation they were
+// made on: otherwise the pool was written since, with them or after them. It returns the number of members added.
+// The intent stays pending until clear is called once the pool is written.
+func (i *poolIntent) recover(ctx context.Context, pool *StoredIdPool, generation int64) (int, error) {
+	var intent allocationIntent
+	if err := i.gcpConnector.Read(ctx, &intent); err != nil {
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	i.pending = true
+	if intent.BaseGeneration != generation {
+		tflog.Debug(ctx, fmt.Sprintf("The intent of pool %s is already resolved, the pool was written since", i.poolName))
+		return 0, nil
+	}
+	i.replayed = pool.replayIntent(ctx, &intent)
+	return len(i.replayed), nil
+}
+
+// write records the allocations about to be written in the pool, with the members replayed from the pending intent.
+func (i *poolIntent) write(ctx context.Context, intent *allocationIntent) error {
+	for name, member := range i.replayed {
+		intent.Members[name] = member
+	}
+	if err := i.gcpConnector.Write(ctx, intent); err != nil {
+		return err
+	}
+	i.pending = true
+	return nil
+}
+
+// clear deletes the pending intent, once the pool holding its allocations is written or when it was already resolved.
+// A failure is only logged: the next recovery finds the pool written since and discards the intent. When the write of
+// the pool fails, the intent is left pending and its allocations are replayed by the next allocation.
+func (i *poolIntent) clear(ctx context.Context) {
+	if !i.pending {
+		return
+	}
+	if err := i.gcpConnector.Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		tflog.Warn(ctx, fmt.Sprintf("Cannot delete the intent of pool %s, it will be discarded by the next allocation: %s", i.poolName, err.Error()))
+		return
+	}
+	i.pending = false
+}
+
+// replayIntent adds the members of intent to the pool with the ids they were allocated, reserved at the time of the
+// intent. A member already in the pool, or whose id was taken since, is skipped. It returns the members added.
+func (p *StoredIdPool) replayIntent(ctx context.Context, intent *allocationIntent) map[string]intentMember {
+	names := make([]string, 0, len(intent.Members))
+	for name := range intent.Members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	added := make(map[string]intentMember)
+	for _, name := range names {
+		member := intent.Members[name]
+		if _, ok := p.Members[name]; ok {
+			continue
+		}
+		if err := p.reclaim(name, member.Id, intent.CreatedAt); err != nil {
+			tflog.Warn(ctx, fmt.Sprintf("Cannot recover the allocation of id %d to %s in pool %s from its intent: %s", member.Id, name, intent.Pool, err.Error()))
+			continue
+		}
+		p.recordReservation(name, member.TTLMinutes, intent.CreatedAt)
+		p.setMemberMetadata(name, member.Metadata)
+		tflog.Warn(ctx, fmt.Sprintf("Recovered the allocation of id %d to %s in pool %s, interrupted before the pool was written", member.Id, name, intent.Pool))
+		added[name] = member
+	}
+	return added
+}
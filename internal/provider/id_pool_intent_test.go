@@ -0,0 +1,91 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestAllocateInBatch_crashSafe(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	p.CrashSafe = types.BoolValue(true)
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+	attrs, err := gcpConnector.GetAttrs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A run died after writing its intent, before writing the pool.
+	intentConnector := p.genericConnector(p.idPoolIntentPath("pool"))
+	if err := intentConnector.Write(ctx, &allocationIntent{
+		Pool:           "pool",
+		BaseGeneration: attrs.Generation,
+		CreatedAt:      time.Now().UTC(),
+		Members:        map[string]intentMember{"ghost": {Id: 5, TTLMinutes: 30, Metadata: map[string]string{"team": "a"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "next"})
+	if result.diags.HasError() || result.id == IdPoolTools.NoID || result.id == 5 {
+		t.Fatalf("expected an id other than the replayed one, got %d: %v", result.id, result.diags)
+	}
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Members["ghost"] != 5 || stored.Records["ghost"].TTLMinutes != 30 || stored.Records["ghost"].Metadata["team"] != "a" {
+		t.Fatalf("expected the allocation of the intent to be replayed with its reservation, got %v %+v", stored.Members, stored.Records["ghost"])
+	}
+	if _, ok := server.Get(testBucket, p.idPoolIntentPath("pool")); ok {
+		t.Fatal("expected the intent to be deleted once the pool is written")
+	}
+
+	// The intent of a run that died after writing the pool is discarded: a member released since is not replayed.
+	intentConnector.SetGeneration(-1)
+	if err := intentConnector.Write(ctx, &allocationIntent{
+		Pool:           "pool",
+		BaseGeneration: attrs.Generation,
+		CreatedAt:      time.Now().UTC(),
+		Members:        map[string]intentMember{"released": {Id: 7}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	result = allocateInBatch(ctx, p, "pool", &allocationRequest{member: "other"})
+	if result.diags.HasError() {
+		t.Fatal(result.diags)
+	}
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Members["released"]; ok {
+		t.Fatal("expected an intent made on an older generation of the pool not to be replayed")
+	}
+	if _, ok := server.Get(testBucket, p.idPoolIntentPath("pool")); ok {
+		t.Fatal("expected the resolved intent to be deleted")
+	}
+}
+
+func TestStoredIdPool_replayIntent(t *testing.T) {
+	pool := newStoredIdPool(1, 10)
+	pool.allocate("held", func(id IdPoolTools.ID) bool { return id == 3 }, nil)
+	intent := &allocationIntent{Pool: "pool", CreatedAt: time.Now(), Members: map[string]intentMember{
+		"held":  {Id: 3},
+		"taken": {Id: 3},
+		"new":   {Id: 4},
+	}}
+	replayed := pool.replayIntent(context.Background(), intent)
+	if len(replayed) != 1 || pool.Members["new"] != 4 {
+		t.Fatalf("expected only the member whose id is still free to be replayed, got %v", replayed)
+	}
+	if _, ok := pool.Members["taken"]; ok {
+		t.Fatal("a member whose id is held by another member must not be replayed")
+	}
+}
@@ -0,0 +1,83 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+// flatMirror returns the members of the pool as a flat map of member names to ids, the format of the mirror object
+// read by the consumers outside of Terraform. It holds none of the settings nor the bookkeeping of the pool.
+func (p *StoredIdPool) flatMirror() map[string]IdPoolTools.ID {
+	mirror := make(map[string]IdPoolTools.ID, len(p.Members))
+	for name, id := range p.Members {
+		mirror[name] = id
+	}
+	return mirror
+}
+
+// setPoolMirrorPath checks the mirror_flat_output_path attribute and applies it to pool. The mirror must stay out of
+// the objects of the provider, where it would be taken for a pool or a lock.
+func setPoolMirrorPath(pool *StoredIdPool, value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	mirrorPath := value.ValueString()
+	if mirrorPath != "" && (strings.HasPrefix(mirrorPath, "/") || strings.HasSuffix(mirrorPath, "/") || strings.HasPrefix(mirrorPath, ProviderName+"/")) {
+		diags.AddAttributeError(path.Root("mirror_flat_output_path"), "Invalid mirror_flat_output_path",
+			fmt.Sprintf("mirror_flat_output_path must be an object path of the referential_bucket without leading nor trailing slash, out of the %s/ folder, got: %q", ProviderName, mirrorPath))
+		return diags
+	}
+	pool.MirrorFlatOutputPath = mirrorPath
+	return diags
+}
+
+// mirrorConnector returns a connector on the mirror object at mirrorPath. The mirror is a plain JSON object for the
+// consumers outside of Terraform, it is not stamped with the minimum provider version.
+func (p *GCSReferentialProviderModel) mirrorConnector(mirrorPath string) connector.GcpConnectorGeneric {
+	gcpConnector := p.genericConnector(mirrorPath)
+	gcpConnector.MinProviderVersion = ""
+	return gcpConnector
+}
+
+// writePoolMirror writes the flat mirror of a pool just written, when it has a mirror_flat_output_path. It must be
+// called under the lock of the pool, which protects its mirror too. The pool object is the reference: a failure only
+// adds a warning, the mirror is written again by the next change of the pool.
+func writePoolMirror(ctx context.Context, p *GCSReferentialProviderModel, poolName string, pool *StoredIdPool, diags *diag.Diagnostics) {
+	if pool.MirrorFlatOutputPath == "" {
+		return
+	}
+	gcpConnector := p.mirrorConnector(pool.MirrorFlatOutputPath)
+	attrs, err := gcpConnector.GetAttrs(ctx)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		diags.AddWarning("id_pool mirror warning", objectDetail(&gcpConnector, "Cannot read the mirror of pool %s, it is out of date until the next change of the pool: %s", poolName, err.Error()))
+		return
+	}
+	if err == nil {
+		gcpConnector.SetGeneration(attrs.Generation)
+	}
+	if err := gcpConnector.Write(ctx, pool.flatMirror()); err != nil {
+		diags.AddWarning("id_pool mirror warning", objectDetail(&gcpConnector, "Cannot write the mirror of pool %s, it is out of date until the next change of the pool: %s", poolName, err.Error()))
+		return
+	}
+	tflog.Debug(ctx, "Wrote pool mirror", map[string]interface{}{"pool": poolName, "path": pool.MirrorFlatOutputPath})
+}
+
+// deletePoolMirror deletes the mirror object at mirrorPath, once its pool is deleted or mirrored elsewhere.
+// A failure only adds a warning, the mirror is left behind.
+func deletePoolMirror(ctx context.Context, p *GCSReferentialProviderModel, poolName string, mirrorPath string, diags *diag.Diagnostics) {
+	if mirrorPath == "" {
+		return
+	}
+	gcpConnector := p.mirrorConnector(mirrorPath)
+	if err := gcpConnector.Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		diags.AddWarning("id_pool mirror warning", objectDetail(&gcpConnector, "Cannot delete the former mirror of pool %s, it must be deleted by hand: %s", poolName, err.Error()))
+	}
+}
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestAllocateInBatch_mirrorFlatOutput(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 10)
+	pool.MirrorFlatOutputPath = "exports/pool.json"
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	readMirror := func() map[string]IdPoolTools.ID {
+		object, ok := server.Get(testBucket, "exports/pool.json")
+		if !ok {
+			t.Fatal("expected the mirror to be written")
+		}
+		var mirror map[string]IdPoolTools.ID
+		if err := json.Unmarshal(object.Data, &mirror); err != nil {
+			t.Fatalf("expected the mirror to be a flat map of names to ids, got %s: %s", object.Data, err)
+		}
+		return mirror
+	}
+
+	first := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a", metadata: map[string]string{"team": "x"}})
+	second := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "b"})
+	if first.diags.HasError() || second.diags.HasError() {
+		t.Fatal(first.diags, second.diags)
+	}
+	if mirror := readMirror(); !reflect.DeepEqual(mirror, map[string]IdPoolTools.ID{"a": first.id, "b": second.id}) {
+		t.Fatalf("expected the mirror to hold both members and nothing else, got %v", mirror)
+	}
+
+	// A release rewrites the mirror over the previous one.
+	cachedPool, err := getIdPoolForUpdate(ctx, p, "pool", &gcpConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cachedPool.Pool.release("a", cachedPool.Pool.Records["a"].ReservedAt)
+	if err := gcpConnector.Write(ctx, cachedPool.Pool); err != nil {
+		t.Fatal(err)
+	}
+	var diags diag.Diagnostics
+	writePoolMirror(ctx, p, "pool", cachedPool.Pool, &diags)
+	if diags.HasError() || diags.WarningsCount() != 0 {
+		t.Fatal(diags)
+	}
+	if mirror := readMirror(); !reflect.DeepEqual(mirror, map[string]IdPoolTools.ID{"b": second.id}) {
+		t.Fatalf("expected the mirror to drop the released member, got %v", mirror)
+	}
+}
+
+func TestSetPoolMirrorPath(t *testing.T) {
+	for _, value := range []string{"exports/pool.json", ""} {
+		pool := newStoredIdPool(1, 10)
+		if diags := setPoolMirrorPath(pool, types.StringValue(value)); diags.HasError() || pool.MirrorFlatOutputPath != value {
+			t.Fatalf("expected %q to be accepted, got %q: %v", value, pool.MirrorFlatOutputPath, diags)
+		}
+	}
+	for _, value := range []string{"/exports/pool.json", "exports/", ProviderName + "/id_pool/other"} {
+		if diags := setPoolMirrorPath(newStoredIdPool(1, 10), types.StringValue(value)); !diags.HasError() {
+			t.Fatalf("expected %q to be rejected", value)
+		}
+	}
+}
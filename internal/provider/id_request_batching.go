@@ -0,0 +1,368 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+)
+
+// allocationRequest is an id_request creation waiting for its pool batch.
+type allocationRequest struct {
+	member     string
+	filter     idFilter
+	ttlMinutes int64
+	// adoptExisting returns the id already held by member instead of failing.
+	adoptExisting bool
+	// recoverInterrupted returns the id held by member when it looks reserved by an interrupted attempt of the
+	// same creation, see isRetriedReservation.
+	recoverInterrupted bool
+	// metadata is recorded with the reservation of member.
+	metadata map[string]string
+	// allowBurst lets the allocation use the burst region of the pool.
+	allowBurst bool
+	// partition restricts the allocation to a partition of the pool, empty for the whole pool.
+	partition string
+	result    chan allocationResult
+	// ctx is the context of the creation waiting for the allocation.
+	ctx context.Context
+}
+
+// allocationResult is the outcome of an allocationRequest, id is IdPoolTools.NoID when the pool is exhausted
+// or diags has an error. label is the one recorded for the member, empty when the pool has no label_template,
+// formatted is id rendered with the output format of the pool, generation the one of the pool holding it.
+type allocationResult struct {
+	id         IdPoolTools.ID
+	label      string
+	formatted  string
+	generation int64
+	diags      diag.Diagnostics
+}
+
+// poolBatcher coalesces the concurrent allocations made on a pool by one provider: the first request starts serve,
+// which runs every request queued meanwhile in a single lock, read and write cycle, until the queue is empty.
+type poolBatcher struct {
+	mutex   sync.Mutex
+	pending []*allocationRequest
+	running bool
+	// created holds the members allocated by the provider on the pool, to tell the id_requests of one configuration
+	// declaring the same id from an existing member. It is only used by serve.
+	created map[string]bool
+}
+
+// poolBatchers holds the batcher of each pool of a provider, keyed by object path.
+type poolBatchers struct {
+	mutex    sync.Mutex
+	batchers map[string]*poolBatcher
+}
+
+func newPoolBatchers() *poolBatchers {
+	return &poolBatchers{batchers: make(map[string]*poolBatcher)}
+}
+
+// get returns the batcher of the given pool object, creating it on first use.
+func (b *poolBatchers) get(objectPath string) *poolBatcher {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	batcher, ok := b.batchers[objectPath]
+	if !ok {
+		batcher = &poolBatcher{created: make(map[string]bool)}
+		b.batchers[objectPath] = batcher
+	}
+	return batcher
+}
+
+// allocateInBatch queues an allocation on the given pool and waits for its result. A request whose ctx is done while
+// it is still queued is withdrawn, and each batch runs with a context done only once those of all its requests are.
+func allocateInBatch(ctx context.Context, p *GCSReferentialProviderModel, poolName string, request *allocationRequest) allocationResult {
+	request.result = make(chan allocationResult, 1)
+	request.ctx = ctx
+	batcher := p.Batchers.get(p.idPoolPath(poolName))
+
+	batcher.mutex.Lock()
+	batcher.pending = append(batcher.pending, request)
+	if !batcher.running {
+		batcher.running = true
+		go batcher.serve(context.WithoutCancel(ctx), p, poolName)
+	}
+	batcher.mutex.Unlock()
+
+	select {
+	case result := <-request.result:
+		return result
+	case <-ctx.Done():
+		if batcher.withdraw(request) {
+			return cancelledAllocation(poolName, ctx.Err())
+		}
+		// The batch holding the request is running, and cancelled once every request of it is done.
+		return <-request.result
+	}
+}
+
+// serve runs the batches of the queued requests until the queue is empty, ctx only gives the values of their contexts.
+func (b *poolBatcher) serve(ctx context.Context, p *GCSReferentialProviderModel, poolName string) {
+	for {
+		b.mutex.Lock()
+		batch := b.pending
+		b.pending = nil
+		if len(batch) == 0 {
+			b.running = false
+			b.mutex.Unlock()
+			return
+		}
+		b.mutex.Unlock()
+		if batch = withdrawDone(poolName, batch); len(batch) > 0 {
+			batchCtx, cancel := batchContext(ctx, batch)
+			runAllocationBatch(batchCtx, p, poolName, b.created, batch)
+			cancel()
+		}
+	}
+}
+
+// withdraw removes request from the queue of the batcher, it returns false when a batch already took it.
+func (b *poolBatcher) withdraw(request *allocationRequest) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for i, pending := range b.pending {
+		if pending == request {
+			b.pending = append(b.pending[:i], b.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// withdrawDone answers the requests of batch whose context is done without allocating them, and returns the others.
+func withdrawDone(poolName string, batch []*allocationRequest) []*allocationRequest {
+	kept := batch[:0]
+	for _, request := range batch {
+		if err := request.ctx.Err(); err != nil {
+			request.result <- cancelledAllocation(poolName, err)
+			continue
+		}
+		kept = append(kept, request)
+	}
+	return kept
+}
+
+// cancelledAllocation is the result of a request withdrawn from the queue of the pool because its context is done.
+func cancelledAllocation(poolName string, err error) allocationResult {
+	result := allocationResult{id: IdPoolTools.NoID}
+	result.diags.AddError("id_request creation error", fmt.Sprintf("The id_request was cancelled while waiting for the allocations queued on pool %s: %s", poolName, err.Error()))
+	return result
+}
+
+// batchContext returns the context of a batch, with the values of ctx: it is done once the contexts of all the requests
+// of batch are, and has the latest of their deadlines when they all have one, so that no request is cut short by the
+// timeout or the cancellation of another.
+func batchContext(ctx context.Context, batch []*allocationRequest) (context.Context, context.CancelFunc) {
+	batchCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	var latest time.Time
+	for _, request := range batch {
+		deadline, ok := request.ctx.Deadline()
+		if !ok {
+			latest = time.Time{}
+			break
+		}
+		if deadline.After(latest) {
+			latest = deadline
+		}
+	}
+	cancelDeadline := context.CancelFunc(func() {})
+	if !latest.IsZero() {
+		batchCtx, cancelDeadline = context.WithDeadline(batchCtx, latest)
+	}
+	var mutex sync.Mutex
+	remaining := len(batch)
+	stops := make([]func() bool, 0, len(batch))
+	for _, request := range batch {
+		stops = append(stops, context.AfterFunc(request.ctx, func() {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if remaining--; remaining == 0 {
+				cancel()
+			}
+		}))
+	}
+	return batchCtx, func() {
+		for _, stop := range stops {
+			stop()
+		}
+		cancelDeadline()
+		cancel()
+	}
+}
+
+// runAllocationBatch serves a batch of allocations on a pool under a single lock and write, created holds the members
+// allocated by the previous batches. The errors that concern the whole batch are reported to every request of it.
+func runAllocationBatch(ctx context.Context, p *GCSReferentialProviderModel, poolName string, created map[string]bool, batch []*allocationRequest) {
+	var batchDiags diag.Diagnostics
+	results := make([]allocationResult, len(batch))
+	for i := range results {
+		results[i].id = IdPoolTools.NoID
+	}
+	defer func() {
+		for i, request := range batch {
+			results[i].diags.Append(batchDiags...)
+			request.result <- results[i]
+		}
+	}()
+
+	gcpConnector := p.idPoolConnector(poolName)
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		batchDiags.AddError("id_request creation error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", poolName, err.Error()))
+		return
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), &batchDiags)
+
+	cachedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+	if err != nil {
+		batchDiags.AddError("id_request creation error", objectDetail(&gcpConnector, "Cannot find pool '%s' to make the id_request on: %s", poolName, err.Error()))
+		return
+	}
+
+	changed := 0
+	var intent *poolIntent
+	if p.CrashSafe.ValueBool() {
+		intent = newPoolIntent(p, poolName)
+		recovered, err := intent.recover(ctx, cachedPool.Pool, cachedPool.Generation)
+		if err != nil {
+			batchDiags.AddError("id_request creation error", objectDetail(&intent.gcpConnector, "Cannot read the intent of pool %s: %s", poolName, err.Error()))
+			return
+		}
+		changed += recovered
+	}
+
+	// Expired reservations are released before allocating, their ids become available again,
+	// as well as the quarantined ids of the cached pool whose cooldown elapsed since it was read.
+	sweepExpiredMembers(ctx, poolName, cachedPool.Pool)
+	sweepQuarantinedIds(ctx, poolName, cachedPool.Pool)
+
+	now := time.Now()
+	allocated := make(map[string]bool)
+	for i, request := range batch {
+		filter, err := cachedPool.Pool.partitionFilter(cachedPool.Pool.burstFilter(request.filter, request.allowBurst), request.partition)
+		if err != nil {
+			results[i].diags.AddAttributeError(path.Root("partition"), "id_request creation error", objectDetail(&gcpConnector, "Cannot make the id_request on pool %s: %s", poolName, err.Error()))
+			continue
+		}
+		if _, ok := cachedPool.Pool.Members[request.member]; ok && (created[request.member] || allocated[request.member]) {
+			results[i].diags.AddAttributeError(path.Root("id"), "id_request creation error", objectDetail(&gcpConnector, "The id %s is declared by several id_requests on the pool %s: another id_request of this configuration already holds it. "+
+				"The id of every id_request made on a pool must be unique, rename one of them", request.member, poolName))
+			continue
+		}
+		if existing, ok := cachedPool.Pool.Members[request.member]; ok && request.adoptExisting {
+			tflog.Info(ctx, fmt.Sprintf("Adopting the id %d already held by %s in pool %s", existing, request.member, poolName))
+			results[i].id = existing
+			results[i].label = cachedPool.Pool.memberLabel(request.member)
+			if cachedPool.Pool.setMemberMetadata(request.member, request.metadata) {
+				changed++
+			}
+			continue
+		} else if ok && request.recoverInterrupted && cachedPool.Pool.isRetriedReservation(request.member, filter, request.ttlMinutes, request.metadata, now) {
+			// An earlier attempt of this creation wrote the pool but did not save the state, take its id back.
+			tflog.Info(ctx, fmt.Sprintf("Recovering the id %d reserved for %s in pool %s by an interrupted creation", existing, request.member, poolName))
+			results[i].id = existing
+			results[i].label = cachedPool.Pool.memberLabel(request.member)
+			continue
+		} else if ok {
+			results[i].diags.AddError("id_request creation error", objectDetail(&gcpConnector, "The id %s of your id_request is already present in the pool %s, be sure you did not make any mistake, or consider to import", request.member, poolName))
+			continue
+		}
+		if err := cachedPool.Pool.checkMemberName(request.member); err != nil {
+			results[i].diags.AddAttributeError(path.Root("id"), "id_request creation error", fmt.Sprintf("Cannot make the id_request on pool %s: %s", poolName, err.Error()))
+			continue
+		}
+		var beforeAllocation *StoredIdPool
+		if !p.AllocationWebhookUrl.IsNull() {
+			beforeAllocation = cachedPool.Pool.clone()
+		}
+		id := cachedPool.Pool.allocate(request.member, filter, p.Rand)
+		if id == IdPoolTools.NoID {
+			continue
+		}
+		if err := p.approveAllocation(ctx, p.idProposal(poolName, request.member, int64(id))); err != nil {
+			// The refused id is given back, the other allocations of the batch go on.
+			cachedPool.Pool = beforeAllocation
+			results[i].diags.AddError("id_request creation error", fmt.Sprintf("Cannot allocate the id %d of pool %s to the id_request %s: %s", id, poolName, request.member, err.Error()))
+			continue
+		}
+		cachedPool.Pool.recordReservation(request.member, request.ttlMinutes, now)
+		cachedPool.Pool.setMemberMetadata(request.member, request.metadata)
+		results[i].id = id
+		results[i].label = cachedPool.Pool.memberLabel(request.member)
+		allocated[request.member] = true
+		changed++
+	}
+	for i := range results {
+		if results[i].id != IdPoolTools.NoID {
+			results[i].formatted = cachedPool.Pool.formatId(results[i].id)
+		}
+	}
+	if changed == 0 {
+		// Nothing to write.
+		if intent != nil {
+			intent.clear(ctx)
+		}
+		setResultGenerations(results, cachedPool.Generation)
+		return
+	}
+
+	if intent != nil && len(allocated) > 0 {
+		// The allocations are recorded before the pool is written, to be replayed if the run dies in between.
+		if err := intent.write(ctx, newAllocationIntent(poolName, cachedPool.Generation, batch, results, allocated)); err != nil {
+			for i := range results {
+				results[i].id = IdPoolTools.NoID
+			}
+			batchDiags.AddError("id_request creation error", objectDetail(&intent.gcpConnector, "Cannot write the intent of the allocations on pool %s: %s", poolName, err.Error()))
+			return
+		}
+	}
+	err = gcpConnector.Write(ctx, cachedPool.Pool)
+	if err != nil {
+		// None of the allocations of the batch were saved, the adopted ids are reported with the batch error anyway.
+		for i := range results {
+			results[i].id = IdPoolTools.NoID
+		}
+		addPoolWriteError(&batchDiags, "id_request creation error", poolName, &gcpConnector, err)
+		return
+	}
+	// Keep the written pool in cache, Write updated the connector's generation.
+	storeCachedIdPool(p, poolName, cachedPool.Pool, &gcpConnector)
+	writePoolMirror(ctx, p, poolName, cachedPool.Pool, &batchDiags)
+	recordAllocations(ctx, p, allocationKindId, int64(len(allocated)), &batchDiags)
+	if intent != nil {
+		intent.clear(ctx)
+	}
+	setResultGenerations(results, gcpConnector.GetGeneration())
+	for member := range allocated {
+		created[member] = true
+	}
+}
+
+// setResultGenerations records the generation of the pool in the results holding an id.
+func setResultGenerations(results []allocationResult, generation int64) {
+	for i := range results {
+		if results[i].id != IdPoolTools.NoID {
+			results[i].generation = generation
+		}
+	}
+}
+
+// newAllocationIntent returns the intent of the allocations of a batch, made on the given generation of the pool.
+// Only the members in allocated are recorded, the adopted ones are already in the pool.
+func newAllocationIntent(poolName string, generation int64, batch []*allocationRequest, results []allocationResult, allocated map[string]bool) *allocationIntent {
+	intent := &allocationIntent{Pool: poolName, BaseGeneration: generation, CreatedAt: time.Now().UTC(), Members: make(map[string]intentMember)}
+	for i, request := range batch {
+		if allocated[request.member] {
+			intent.Members[request.member] = intentMember{Id: results[i].id, TTLMinutes: request.ttlMinutes, Metadata: request.metadata}
+		}
+	}
+	return intent
+}
@@ -0,0 +1,311 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+// allocateConcurrently runs count concurrent allocations on the pool and returns the allocated ids by member.
+func allocateConcurrently(t *testing.T, p *GCSReferentialProviderModel, poolName string, count int) map[string]IdPoolTools.ID {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	ids := make(map[string]IdPoolTools.ID)
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func(member string) {
+			defer wg.Done()
+			result := allocateInBatch(context.Background(), p, poolName, &allocationRequest{member: member})
+			if result.diags.HasError() {
+				t.Errorf("allocation of %s failed: %v", member, result.diags)
+				return
+			}
+			mutex.Lock()
+			ids[member] = result.id
+			mutex.Unlock()
+		}(fmt.Sprintf("member-%d", i))
+	}
+	wg.Wait()
+	return ids
+}
+
+func TestAllocateInBatch_concurrentUniqueness(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 100)
+	gcpConnector := p.idPoolConnector("pool")
+
+	ids := allocateConcurrently(t, p, "pool", 50)
+
+	seen := make(map[IdPoolTools.ID]string)
+	for member, id := range ids {
+		if id == IdPoolTools.NoID {
+			t.Fatalf("%s got no id", member)
+		}
+		if other, ok := seen[id]; ok {
+			t.Fatalf("id %d allocated to both %s and %s", id, member, other)
+		}
+		seen[id] = member
+	}
+
+	var stored StoredIdPool
+	if err := gcpConnector.Read(context.Background(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Members) != 50 {
+		t.Fatalf("expected 50 members in the stored pool, got %d", len(stored.Members))
+	}
+	for member, id := range ids {
+		if stored.Members[member] != id {
+			t.Errorf("stored id of %s is %d, allocated %d", member, stored.Members[member], id)
+		}
+	}
+	// The fake server keeps every version of the pool, one per write after its creation.
+	versions, err := gcpConnector.ListVersions(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes := len(versions) - 1; writes >= 50 {
+		t.Errorf("expected the allocations to be batched, got %d writes for 50 requests", writes)
+	}
+}
+
+func TestAllocateInBatch_exhausted(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 5)
+
+	ids := allocateConcurrently(t, p, "pool", 8)
+
+	allocated := 0
+	for _, id := range ids {
+		if id != IdPoolTools.NoID {
+			allocated++
+		}
+	}
+	if allocated != 5 {
+		t.Fatalf("expected the 5 ids of the pool to be allocated, got %d: %v", allocated, ids)
+	}
+}
+
+// createIdRequestsConcurrently runs count concurrent id_request creations on the pool, spread over the given providers,
+// while reading the pool meanwhile as the refreshes of the other resources do. It returns the created ids by member.
+func createIdRequestsConcurrently(t *testing.T, providers []*GCSReferentialProviderModel, poolName string, count int) map[string]IdPoolTools.ID {
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	ids := make(map[string]IdPoolTools.ID)
+	for i := 0; i < count; i++ {
+		p := providers[i%len(providers)]
+		r := &IdRequestResource{providerData: p}
+		member := fmt.Sprintf("member-%d", i)
+		plan := newPlan(t, r, newIdRequestModel(member, poolName))
+		state := newState(t, r, nil)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			resp := &fwresource.CreateResponse{State: state}
+			r.Create(ctx, fwresource.CreateRequest{Plan: plan}, resp)
+			if resp.Diagnostics.HasError() {
+				t.Errorf("creation of %s failed: %v", member, resp.Diagnostics)
+				return
+			}
+			var created IdRequestResourceModel
+			resp.State.Get(ctx, &created)
+			mutex.Lock()
+			ids[member] = IdPoolTools.ID(created.RequestedId.ValueInt64())
+			mutex.Unlock()
+		}()
+		go func() {
+			defer wg.Done()
+			gcpConnector := p.idPoolConnector(poolName)
+			for j := 0; j < 10; j++ {
+				cachedPool, err := getAndCacheIdPool(ctx, p, poolName, &gcpConnector)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for range cachedPool.Pool.Members {
+				}
+				cachedPool.Pool.freeRanges()
+			}
+		}()
+	}
+	wg.Wait()
+	return ids
+}
+
+func TestIdRequestCreate_concurrent(t *testing.T) {
+	gcstest.NewServer(t)
+	// Two provider aliases on the same bucket share the pool cache, but each batches its own creations.
+	first := newTestProviderData()
+	second := newTestProviderData()
+	second.IdPoolsCache = first.IdPoolsCache
+	second.CacheMutex = first.CacheMutex
+	createTestIdPool(t, first, "pool", 1, 1000)
+
+	const count = 40
+	ids := createIdRequestsConcurrently(t, []*GCSReferentialProviderModel{first, second}, "pool", count)
+
+	seen := make(map[IdPoolTools.ID]string)
+	for member, id := range ids {
+		if id == IdPoolTools.NoID {
+			t.Fatalf("%s got no id", member)
+		}
+		if other, ok := seen[id]; ok {
+			t.Fatalf("id %d allocated to both %s and %s", id, member, other)
+		}
+		seen[id] = member
+	}
+	var stored StoredIdPool
+	gcpConnector := first.idPoolConnector("pool")
+	if err := gcpConnector.Read(context.Background(), &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != count || len(stored.Members) != count {
+		t.Fatalf("expected %d created id_requests and members in the stored pool, got %d and %d", count, len(ids), len(stored.Members))
+	}
+	for member, id := range ids {
+		if stored.Members[member] != id {
+			t.Errorf("stored id of %s is %d, created with %d", member, stored.Members[member], id)
+		}
+	}
+}
+
+func TestAllocateInBatch_duplicateDeclaration(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 100)
+
+	// Two id_requests with the same id created concurrently, they may share a batch or not.
+	var wg sync.WaitGroup
+	results := make([]allocationResult, 2)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = allocateInBatch(context.Background(), p, "pool", &allocationRequest{member: "dup"})
+		}(i)
+	}
+	wg.Wait()
+	failed := 0
+	for _, result := range results {
+		if result.diags.HasError() {
+			failed++
+			if detail := result.diags.Errors()[0].Detail(); !strings.Contains(detail, "declared by several id_requests") {
+				t.Fatalf("expected the error to point at a duplicate declaration, got: %s", detail)
+			}
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected exactly one of the two id_requests to fail, got %d failures", failed)
+	}
+
+	// Adopting an id allocated by another id_request of the same run is a duplicate declaration too.
+	if result := allocateInBatch(context.Background(), p, "pool", &allocationRequest{member: "dup", adoptExisting: true}); !result.diags.HasError() {
+		t.Fatalf("expected the adoption of an id created in the same run to fail, got %d", result.id)
+	}
+}
+
+func TestAllocateInBatch_partition(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 20)
+	pool.Partitions = map[string]IdPartition{"reserved": {From: 1, To: 2}, "dynamic": {From: 11, To: 20}}
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, member := range []string{"core-a", "core-b"} {
+		result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: member, partition: "reserved"})
+		if result.diags.HasError() || result.id < 1 || result.id > 2 {
+			t.Fatalf("expected %s to get an id of the reserved partition, got %d: %v", member, result.id, result.diags)
+		}
+	}
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "core-c", partition: "reserved"}); result.diags.HasError() || result.id != IdPoolTools.NoID {
+		t.Fatalf("expected the reserved partition to be exhausted, got %d: %v", result.id, result.diags)
+	}
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "app", partition: "dynamic"}); result.diags.HasError() || result.id < 11 {
+		t.Fatalf("expected an id of the dynamic partition, got %d: %v", result.id, result.diags)
+	}
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "other", partition: "unknown"}); !result.diags.HasError() {
+		t.Fatal("expected an unknown partition to be rejected")
+	}
+}
+
+func TestAllocateInBatch_requestContexts(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	// Another run holds the lock of the pool, the first batch waits for it.
+	holder := p.idPoolConnector("pool")
+	holderLock, err := holder.Lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batcher := p.Batchers.get(p.idPoolPath("pool"))
+	waitQueued := func(count int) {
+		for {
+			batcher.mutex.Lock()
+			running, queued := batcher.running, len(batcher.pending)
+			batcher.mutex.Unlock()
+			if running && queued == count {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	allocate := func(ctx context.Context, member string) chan allocationResult {
+		result := make(chan allocationResult, 1)
+		go func() {
+			result <- allocateInBatch(ctx, p, "pool", &allocationRequest{member: member})
+		}()
+		return result
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(ctx)
+	leader := allocate(leaderCtx, "leader")
+	waitQueued(0)
+	queued := allocate(ctx, "queued")
+	waitQueued(1)
+	withdrawnCtx, cancelWithdrawn := context.WithCancel(ctx)
+	withdrawn := allocate(withdrawnCtx, "withdrawn")
+	waitQueued(2)
+
+	// A queued request gives up on its own cancellation, without waiting for the batch before it.
+	cancelWithdrawn()
+	if result := <-withdrawn; !result.diags.HasError() || !strings.Contains(result.diags.Errors()[0].Detail(), "cancelled while waiting") {
+		t.Fatalf("expected the withdrawn request to be cancelled, got %v", result.diags)
+	}
+	// The cancellation of the leader ends its batch, not the one of the request queued after it.
+	cancelLeader()
+	if result := <-leader; !result.diags.HasError() {
+		t.Fatal("expected the batch of the cancelled leader to fail")
+	}
+	if err := holder.Unlock(ctx, holderLock); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-queued; result.diags.HasError() || result.id == IdPoolTools.NoID {
+		t.Fatalf("expected the queued request to be allocated, got %d: %v", result.id, result.diags)
+	}
+
+	gcpConnector := p.idPoolConnector("pool")
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Members["queued"]; !ok || len(stored.Members) != 1 {
+		t.Fatalf("expected only the queued request to be allocated, got %v", stored.Members)
+	}
+}
@@ -0,0 +1,61 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+// lockReleaseTimeout bounds the release of a lock, run once the operation is done, whatever its deadline.
+const lockReleaseTimeout = 30 * time.Second
+
+// releaseLock releases a lock acquired with WaitForlock. It does not use the deadline nor the cancellation of ctx, the
+// lock being left in place otherwise when the operation ran out of time: it gets its own lockReleaseTimeout. Failures are only logged, except when the lock
+// object is retained by the bucket: in that case every later operation would wait for it, so a warning is raised.
+// It is not an error: releaseLock runs deferred once the operation, and the state, were saved.
+// A lock that expired and was broken by another run is reported as a warning.
+func releaseLock(ctx context.Context, gcpConnector *connector.GcpConnectorGeneric, lockId uuid.UUID, subject string, diags *diag.Diagnostics) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), lockReleaseTimeout)
+	defer cancel()
+	err := gcpConnector.Unlock(ctx, lockId)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, connector.ErrLockRetention) {
+		diags.AddWarning(
+			"Lock object retained",
+			lockDetail(ctx, gcpConnector, "The lock object of %s cannot be deleted because it is subject to a retention policy, every following operation on it will wait until the retention expires. "+
+				"Exclude the lock objects from retention, or set lock_prefix on the provider to a prefix that is not retained, then delete the lock object once its retention expires: %s",
+				subject, err.Error()),
+		)
+		return
+	}
+	if errors.Is(err, connector.ErrLockLost) {
+		message := lockDetail(ctx, gcpConnector, "The lock of %s was held longer than its ttl and was taken over by another run, the lock of the other run is left in place. "+
+			"The changes written meanwhile are still protected by the generation preconditions of the objects: %s", subject, err.Error())
+		tflog.Warn(ctx, message)
+		diags.AddWarning("Lock lost", message)
+		return
+	}
+	tflog.Warn(ctx, lockDetail(ctx, gcpConnector, "Failed to unlock %s, manual intervention may be required to remove lock file: %s", subject, err.Error()))
+}
+
+// cleanupStaleLock deletes the lock object of gcpConnector left by another run once the object itself is deleted, when
+// it was written before the given time. It is best-effort: a failure is logged and reported as a warning.
+func cleanupStaleLock(ctx context.Context, gcpConnector *connector.GcpConnectorGeneric, subject string, before time.Time, diags *diag.Diagnostics) {
+	deleted, err := gcpConnector.DeleteLockCreatedBefore(ctx, before)
+	if err != nil {
+		message := lockDetail(ctx, gcpConnector, "Cannot delete the lock object left for %s, delete it by hand before creating an object of the same name: %s", subject, err.Error())
+		tflog.Warn(ctx, message)
+		diags.AddWarning("Lock object not cleaned up", message)
+		return
+	}
+	if deleted {
+		tflog.Info(ctx, lockDetail(ctx, gcpConnector, "Deleted the lock object left for %s", subject))
+	}
+}
@@ -0,0 +1,92 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestReleaseLock_retention(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+	server.SetRetained(testBucket, gcpConnector.GetLockPath(ctx))
+
+	// The allocation was written before the lock is released, a retained lock must not fail it.
+	result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a"})
+	if result.diags.HasError() || result.diags.WarningsCount() != 1 || result.diags.Warnings()[0].Summary() != "Lock object retained" {
+		t.Fatalf("expected a single retained lock warning, got %v", result.diags)
+	}
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if result.id == IdPoolTools.NoID || stored.Members["a"] != result.id {
+		t.Fatalf("expected the allocation of a to be kept, got %d in %v", result.id, stored.Members)
+	}
+	if _, ok := server.Get(testBucket, gcpConnector.GetLockPath(ctx)); !ok {
+		t.Fatal("expected the retained lock object to be left in place")
+	}
+}
+
+func TestReleaseLock_deadline(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The operation runs out of time while holding the lock, which must be released all the same.
+	<-ctx.Done()
+	var diags diag.Diagnostics
+	releaseLock(ctx, &gcpConnector, lockId, "pool", &diags)
+	if diags.HasError() || diags.WarningsCount() != 0 {
+		t.Fatalf("unexpected diagnostics: %v", diags)
+	}
+	if _, ok := server.Get(testBucket, gcpConnector.GetLockPath(ctx)); ok {
+		t.Fatal("expected the lock object to be deleted after the deadline of the operation")
+	}
+}
+
+func TestAllocateInBatch_lockTtl(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	p.FailIfLocked = types.BoolValue(true)
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+	lockPath := gcpConnector.GetLockPath(ctx)
+	server.Put(testBucket, lockPath, []byte(uuid.New().String()))
+	server.SetUpdated(testBucket, lockPath, time.Now().Add(-10*time.Minute))
+
+	// Without lock_ttl_minutes the lock of the other run is never broken.
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a"}); !result.diags.HasError() {
+		t.Fatal("expected the allocation to fail on the lock of another run")
+	}
+	p.LockTtlMinutes = types.Int64Value(15)
+	if result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a"}); !result.diags.HasError() {
+		t.Fatal("expected a lock younger than lock_ttl_minutes to be kept")
+	}
+
+	p.LockTtlMinutes = types.Int64Value(5)
+	result := allocateInBatch(ctx, p, "pool", &allocationRequest{member: "a"})
+	if result.diags.HasError() || result.diags.WarningsCount() != 0 || result.id == IdPoolTools.NoID {
+		t.Fatalf("expected the expired lock to be broken, got %d: %v", result.id, result.diags)
+	}
+	if _, ok := server.Get(testBucket, lockPath); ok {
+		t.Fatal("expected the lock to be released")
+	}
+}
@@ -0,0 +1,420 @@
+package provider
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"net"
+	"sort"
+	"strings"
+
+	cidrCalculator "github.com/public-cloud-wl/tools/cidrCalculator"
+)
+
+// skippedSubnetKey is the pseudo reservation under which the skipped subnet is handed to the calculator.
+const skippedSubnetKey = "__skipped_first_subnet__"
+
+// firstSubnet returns the first subnet of the given prefix length in baseCidr.
+func firstSubnet(baseCidr string, prefixLength int64) (string, error) {
+	_, baseNet, err := net.ParseCIDR(baseCidr)
+	if err != nil {
+		return "", err
+	}
+	basePrefix, bits := baseNet.Mask.Size()
+	if prefixLength < int64(basePrefix) || prefixLength > int64(bits) {
+		return "", fmt.Errorf("prefix_length %d must be between %d and %d for %s", prefixLength, basePrefix, bits, baseCidr)
+	}
+	first := net.IPNet{IP: baseNet.IP, Mask: net.CIDRMask(int(prefixLength), bits)}
+	return first.String(), nil
+}
+
+// nextNetmask returns the next subnet available in networkConfig for the given prefix length.
+// Subnets kept out of allocation by the network config policies are excluded without being reservations,
+// and the aligned reservations hold their whole alignment block.
+func nextNetmask(networkConfig *NetworkConfig, prefixLength int64, baseCidr string) (string, error) {
+	subnets := make(map[string]string, len(networkConfig.Subnets)+1)
+	for id := range networkConfig.Subnets {
+		subnets[id] = networkConfig.reservedArea(id)
+	}
+	if networkConfig.SkippedSubnet != "" {
+		subnets[skippedSubnetKey] = networkConfig.SkippedSubnet
+	}
+	subnets = distinctAddressAreas(subnets)
+	cidrCalc, err := cidrCalculator.New(&subnets, int8(prefixLength), baseCidr)
+	if err != nil {
+		return "", err
+	}
+	return cidrCalc.GetNextNetmask()
+}
+
+// hashedNetmask returns the subnet of the given prefix length of baseCidr whose position is derived from the hash of
+// id, so that the same id is given the same subnet whenever it is free. When it overlaps a reservation or the skipped
+// subnet, the next available subnet is returned as by nextNetmask.
+func hashedNetmask(networkConfig *NetworkConfig, id string, prefixLength int64, baseCidr string) (string, error) {
+	_, baseNet, err := net.ParseCIDR(baseCidr)
+	if err != nil {
+		return "", err
+	}
+	basePrefix, addressBits := baseNet.Mask.Size()
+	if prefixLength < int64(basePrefix) || prefixLength > int64(addressBits) {
+		return "", fmt.Errorf("prefix_length %d must be between %d and %d for %s", prefixLength, basePrefix, addressBits, baseCidr)
+	}
+	ip := baseNet.IP
+	if addressBits == 32 {
+		ip = ip.To4()
+	}
+	sum := sha256.Sum256([]byte(id))
+	count := new(big.Int).Lsh(big.NewInt(1), uint(prefixLength)-uint(basePrefix))
+	index := new(big.Int).Mod(new(big.Int).SetBytes(sum[:]), count)
+	address := new(big.Int).Lsh(index, uint(addressBits)-uint(prefixLength))
+	address.Add(address, new(big.Int).SetBytes(ip))
+	candidateIP := make(net.IP, len(ip))
+	address.FillBytes(candidateIP)
+	candidate := (&net.IPNet{IP: candidateIP, Mask: net.CIDRMask(int(prefixLength), addressBits)}).String()
+
+	taken := networkConfig.SkippedSubnet != "" && subnetsOverlap(candidate, networkConfig.SkippedSubnet)
+	for reserved := range networkConfig.Subnets {
+		if taken {
+			break
+		}
+		taken = subnetsOverlap(candidate, networkConfig.reservedArea(reserved))
+	}
+	if taken {
+		return nextNetmask(networkConfig, prefixLength, baseCidr)
+	}
+	return candidate, nil
+}
+
+// freeSubnets returns up to limit subnets of the given prefix length free in networkConfig, in the order the next
+// network_requests would receive them. Each subnet found is reserved in a copy of networkConfig before looking for
+// the next one, so the policies of the base_cidr apply as they do to the allocations.
+func freeSubnets(networkConfig *NetworkConfig, prefixLength int64, baseCidr string, limit int64) ([]string, error) {
+	probe := NetworkConfig{
+		Subnets:       make(map[string]string, len(networkConfig.Subnets)),
+		SkippedSubnet: networkConfig.SkippedSubnet,
+		AlignedBlocks: networkConfig.AlignedBlocks,
+	}
+	for id, netmask := range networkConfig.Subnets {
+		probe.Subnets[id] = netmask
+	}
+	free := []string{}
+	for int64(len(free)) < limit {
+		netmask, err := nextNetmask(&probe, prefixLength, baseCidr)
+		if isExhaustedError(err) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		probe.Subnets[fmt.Sprintf("__free_subnet_%d__", len(free))] = netmask
+		free = append(free, netmask)
+	}
+	return free, nil
+}
+
+// distinctAddressAreas returns the reserved areas of subnets without the ones sharing their network address with a
+// larger or equal one, which already covers them. The calculator sorts the areas by address only from a map: areas at
+// the same address would be sorted in the random order of the map, leaving the allocation to depend on it.
+// Of two equal areas, the one of the smallest key is kept. The areas that cannot be parsed are left to the calculator.
+func distinctAddressAreas(subnets map[string]string) map[string]string {
+	type area struct {
+		key    string
+		prefix int
+	}
+	largest := make(map[string]area, len(subnets))
+	distinct := make(map[string]string, len(subnets))
+	for key, cidr := range subnets {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			distinct[key] = cidr
+			continue
+		}
+		prefix, _ := ipNet.Mask.Size()
+		address := ipNet.IP.String()
+		if kept, ok := largest[address]; !ok || prefix < kept.prefix || (prefix == kept.prefix && key < kept.key) {
+			largest[address] = area{key: key, prefix: prefix}
+		}
+	}
+	for _, kept := range largest {
+		distinct[kept.key] = subnets[kept.key]
+	}
+	return distinct
+}
+
+// isExhaustedError reports whether err is the calculator failing because the base_cidr has no room left,
+// the calculator only returning plain errors.
+func isExhaustedError(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "exhausted")
+}
+
+// reservedArea returns the cidr held by the reservation of id: its alignment block when it has one, its subnet otherwise.
+func (networkConfig *NetworkConfig) reservedArea(id string) string {
+	if block, ok := networkConfig.AlignedBlocks[id]; ok {
+		return block
+	}
+	return networkConfig.Subnets[id]
+}
+
+// allocateSubnet reserves the next available subnet of the given prefix length for id in networkConfig and returns it.
+// With an alignmentPrefix, a whole free block of that prefix length is held by id and the subnet is its first one,
+// so that no other reservation shares the block. When deterministic, the subnet, or the block, is the one placed by
+// the hash of id if it is free, see hashedNetmask.
+func allocateSubnet(networkConfig *NetworkConfig, id string, prefixLength int64, alignmentPrefix int64, baseCidr string, deterministic bool) (string, error) {
+	next := nextNetmask
+	if deterministic {
+		next = func(networkConfig *NetworkConfig, prefixLength int64, baseCidr string) (string, error) {
+			return hashedNetmask(networkConfig, id, prefixLength, baseCidr)
+		}
+	}
+	if alignmentPrefix == 0 {
+		netmask, err := next(networkConfig, prefixLength, baseCidr)
+		if err != nil {
+			return "", err
+		}
+		networkConfig.Subnets[id] = netmask
+		return netmask, nil
+	}
+	if alignmentPrefix > prefixLength {
+		return "", fmt.Errorf("alignment_prefix %d must not be longer than prefix_length %d", alignmentPrefix, prefixLength)
+	}
+	if _, err := firstSubnet(baseCidr, alignmentPrefix); err != nil {
+		return "", err
+	}
+	block, err := next(networkConfig, alignmentPrefix, baseCidr)
+	if err != nil {
+		return "", err
+	}
+	netmask, err := firstSubnet(block, prefixLength)
+	if err != nil {
+		return "", err
+	}
+	if networkConfig.AlignedBlocks == nil {
+		networkConfig.AlignedBlocks = make(map[string]string)
+	}
+	networkConfig.Subnets[id] = netmask
+	networkConfig.AlignedBlocks[id] = block
+	return netmask, nil
+}
+
+// releaseSubnet removes the reservation of id from networkConfig, its alignment block included.
+func releaseSubnet(networkConfig *NetworkConfig, id string) {
+	delete(networkConfig.Subnets, id)
+	delete(networkConfig.AlignedBlocks, id)
+	delete(networkConfig.SubnetCounts, id)
+	delete(networkConfig.Exclusions, id)
+}
+
+// reservationSubnets returns the subnets reserved for id in networkConfig in address order: its subnet, or the
+// subnets of its aligned block when it reserves several.
+func (networkConfig *NetworkConfig) reservationSubnets(id string) ([]string, error) {
+	netmask := networkConfig.Subnets[id]
+	block, aligned := networkConfig.AlignedBlocks[id]
+	if count := networkConfig.SubnetCounts[id]; count <= 1 || !aligned {
+		return []string{netmask}, nil
+	}
+	_, subnet, err := net.ParseCIDR(netmask)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse the subnet %s reserved for %s: %w", netmask, id, err)
+	}
+	prefixLength, _ := subnet.Mask.Size()
+	subnets, err := blockSubnets(block, int64(prefixLength))
+	if err != nil {
+		return nil, fmt.Errorf("cannot list the subnets of the block %s reserved for %s: %w", block, id, err)
+	}
+	return subnets, nil
+}
+
+// subnetExclusions returns the addresses of subnet a DHCP server must not lease: its network address, its first
+// host address as the gateway when gateway is true, and its broadcast address. A /31 or /32 has none, all its
+// addresses are hosts (RFC 3021), as a /127 or /128 (RFC 6164). An IPv6 subnet has no broadcast address.
+func subnetExclusions(subnet string, gateway bool) ([]string, error) {
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		return nil, err
+	}
+	prefixLength, addressBits := ipNet.Mask.Size()
+	if addressBits-prefixLength <= 1 {
+		return []string{}, nil
+	}
+	ip := ipNet.IP
+	if addressBits == 32 {
+		ip = ip.To4()
+	}
+	network := new(big.Int).SetBytes(ip)
+	address := func(offset *big.Int) string {
+		excluded := make(net.IP, len(ip))
+		new(big.Int).Add(network, offset).FillBytes(excluded)
+		return excluded.String()
+	}
+	exclusions := []string{address(big.NewInt(0))}
+	if gateway {
+		exclusions = append(exclusions, address(big.NewInt(1)))
+	}
+	if addressBits == 32 {
+		size := new(big.Int).Lsh(big.NewInt(1), uint(addressBits-prefixLength))
+		exclusions = append(exclusions, address(size.Sub(size, big.NewInt(1))))
+	}
+	return exclusions, nil
+}
+
+// reservationExclusions returns the addresses to exclude of every subnet reserved for id, in address order.
+func (networkConfig *NetworkConfig) reservationExclusions(id string, gateway bool) ([]string, error) {
+	subnets, err := networkConfig.reservationSubnets(id)
+	if err != nil {
+		return nil, err
+	}
+	exclusions := make([]string, 0, 3*len(subnets))
+	for _, subnet := range subnets {
+		excluded, err := subnetExclusions(subnet, gateway)
+		if err != nil {
+			return nil, err
+		}
+		exclusions = append(exclusions, excluded...)
+	}
+	return exclusions, nil
+}
+
+// persistExclusions stores the addresses to exclude of the reservation of id in the exclusions of networkConfig when
+// persist is true, and removes them otherwise.
+func (networkConfig *NetworkConfig) persistExclusions(id string, persist bool, gateway bool) error {
+	if !persist {
+		delete(networkConfig.Exclusions, id)
+		return nil
+	}
+	exclusions, err := networkConfig.reservationExclusions(id, gateway)
+	if err != nil {
+		return err
+	}
+	if networkConfig.Exclusions == nil {
+		networkConfig.Exclusions = make(map[string][]string)
+	}
+	networkConfig.Exclusions[id] = exclusions
+	return nil
+}
+
+// summaryPrefix returns the prefix length of the aligned supernet made of count subnets of the given prefix length.
+// count must be a power of two, any other number of subnets cannot be summarized by a single cidr.
+func summaryPrefix(prefixLength int64, count int64) (int64, error) {
+	if count < 1 || count&(count-1) != 0 {
+		return 0, fmt.Errorf("subnet_count %d must be a power of two to form a single aligned supernet", count)
+	}
+	summary := prefixLength - int64(bits.TrailingZeros64(uint64(count)))
+	if summary < 0 {
+		return 0, fmt.Errorf("%d subnets with prefix_length %d do not fit in a single supernet", count, prefixLength)
+	}
+	return summary, nil
+}
+
+// blockSubnets returns, in address order, the subnets of the given prefix length that make up block.
+func blockSubnets(block string, prefixLength int64) ([]string, error) {
+	_, blockNet, err := net.ParseCIDR(block)
+	if err != nil {
+		return nil, err
+	}
+	blockPrefix, addressBits := blockNet.Mask.Size()
+	if prefixLength < int64(blockPrefix) || prefixLength > int64(addressBits) {
+		return nil, fmt.Errorf("prefix_length %d must be between %d and %d for %s", prefixLength, blockPrefix, addressBits, block)
+	}
+	ip := blockNet.IP
+	if addressBits == 32 {
+		ip = ip.To4()
+	}
+	start := new(big.Int).SetBytes(ip)
+	step := new(big.Int).Lsh(big.NewInt(1), uint(int64(addressBits)-prefixLength))
+	count := 1 << (prefixLength - int64(blockPrefix))
+	subnets := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		address := make(net.IP, len(ip))
+		start.FillBytes(address)
+		subnet := net.IPNet{IP: address, Mask: net.CIDRMask(int(prefixLength), addressBits)}
+		subnets = append(subnets, subnet.String())
+		start.Add(start, step)
+	}
+	return subnets, nil
+}
+
+// subnetsOverlap reports whether two cidrs share at least one address.
+func subnetsOverlap(a string, b string) bool {
+	_, netA, errA := net.ParseCIDR(a)
+	_, netB, errB := net.ParseCIDR(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	return netA.Contains(netB.IP) || netB.Contains(netA.IP)
+}
+
+// collidingReservations returns the other ids of networkConfig whose reserved area overlaps the one of id,
+// the skipped subnet included under skippedSubnetKey.
+func collidingReservations(networkConfig *NetworkConfig, id string) []string {
+	if _, ok := networkConfig.Subnets[id]; !ok {
+		return nil
+	}
+	reserved := networkConfig.reservedArea(id)
+	var colliding []string
+	for otherId := range networkConfig.Subnets {
+		if otherId != id && subnetsOverlap(reserved, networkConfig.reservedArea(otherId)) {
+			colliding = append(colliding, otherId)
+		}
+	}
+	if networkConfig.SkippedSubnet != "" && subnetsOverlap(reserved, networkConfig.SkippedSubnet) {
+		colliding = append(colliding, skippedSubnetKey)
+	}
+	sort.Strings(colliding)
+	return colliding
+}
+
+// sortedReservedCidrs returns the distinct subnets reserved in networkConfig, sorted by network address then prefix length.
+func sortedReservedCidrs(networkConfig *NetworkConfig) []string {
+	type reservedCidr struct {
+		cidr   string
+		ip     net.IP
+		prefix int
+	}
+	seen := make(map[string]bool, len(networkConfig.Subnets))
+	reserved := make([]reservedCidr, 0, len(networkConfig.Subnets))
+	for _, netmask := range networkConfig.Subnets {
+		if seen[netmask] {
+			continue
+		}
+		seen[netmask] = true
+		entry := reservedCidr{cidr: netmask}
+		if _, subnet, err := net.ParseCIDR(netmask); err == nil {
+			entry.ip = subnet.IP.To16()
+			entry.prefix, _ = subnet.Mask.Size()
+		}
+		reserved = append(reserved, entry)
+	}
+	sort.Slice(reserved, func(i, j int) bool {
+		if c := bytes.Compare(reserved[i].ip, reserved[j].ip); c != 0 {
+			return c < 0
+		}
+		if reserved[i].prefix != reserved[j].prefix {
+			return reserved[i].prefix < reserved[j].prefix
+		}
+		return reserved[i].cidr < reserved[j].cidr
+	})
+	cidrs := make([]string, 0, len(reserved))
+	for _, entry := range reserved {
+		cidrs = append(cidrs, entry.cidr)
+	}
+	return cidrs
+}
+
+// subnetOwners returns the ids whose reserved subnet in networkConfig is netmask, sorted. The comparison is made on the
+// parsed networks so that a netmask written with host bits, such as 10.0.3.1/24, still finds 10.0.3.0/24.
+func subnetOwners(networkConfig *NetworkConfig, netmask string) ([]string, error) {
+	_, wanted, err := net.ParseCIDR(netmask)
+	if err != nil {
+		return nil, fmt.Errorf("invalid netmask %s: %w", netmask, err)
+	}
+	var owners []string
+	for id, reserved := range networkConfig.Subnets {
+		if _, subnet, err := net.ParseCIDR(reserved); err == nil && subnet.String() == wanted.String() {
+			owners = append(owners, id)
+		}
+	}
+	sort.Strings(owners)
+	return owners, nil
+}
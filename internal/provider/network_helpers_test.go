@@ -0,0 +1,626 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestNextNetmask_skippedSubnet(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{}}
+	skipped, err := firstSubnet("10.0.0.0/16", 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if skipped != "10.0.0.0/24" {
+		t.Fatalf("unexpected first subnet: %s", skipped)
+	}
+	networkConfig.SkippedSubnet = skipped
+
+	netmask, err := nextNetmask(networkConfig, 24, "10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netmask != "10.0.1.0/24" {
+		t.Fatalf("expected the first subnet to be skipped, got %s", netmask)
+	}
+
+	// Releasing the reservations must not make the skipped subnet available.
+	networkConfig.Subnets["a"] = netmask
+	delete(networkConfig.Subnets, "a")
+	netmask, err = nextNetmask(networkConfig, 26, "10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netmask != "10.0.1.0/26" {
+		t.Fatalf("expected the skipped subnet to stay excluded, got %s", netmask)
+	}
+}
+
+func TestNextNetmask_deterministic(t *testing.T) {
+	// Overlapping areas at the same address, as left by a concurrent writer or an aligned block, are sorted by
+	// address only in the calculator.
+	cidrs := []string{"10.0.48.0/20", "10.0.44.0/26", "10.0.16.0/22", "10.0.8.0/24", "10.0.16.0/26", "10.0.8.0/21", "10.0.32.0/23", "10.0.8.0/24"}
+	rnd := rand.New(rand.NewSource(42))
+	for _, prefixLength := range []int64{20, 22, 24, 26} {
+		var want string
+		for attempt := 0; attempt < 50; attempt++ {
+			networkConfig := &NetworkConfig{Subnets: make(map[string]string, len(cidrs))}
+			for i, j := range rnd.Perm(len(cidrs)) {
+				networkConfig.Subnets[fmt.Sprintf("request-%d", i)] = cidrs[j]
+			}
+			netmask, err := nextNetmask(networkConfig, prefixLength, "10.0.0.0/16")
+			if err != nil {
+				t.Fatalf("expected a subnet with prefix %d, got: %v", prefixLength, err)
+			}
+			for _, cidr := range cidrs {
+				if subnetsOverlap(cidr, netmask) {
+					t.Fatalf("the subnet %s overlaps the reservation %s", netmask, cidr)
+				}
+			}
+			if attempt == 0 {
+				want = netmask
+			} else if netmask != want {
+				t.Fatalf("expected the same subnet %s with prefix %d whatever the order of the reservations, got %s", want, prefixLength, netmask)
+			}
+		}
+	}
+}
+
+func TestDistinctAddressAreas(t *testing.T) {
+	distinct := distinctAddressAreas(map[string]string{"a": "10.0.0.0/24", "b": "10.0.0.0/22", "c": "10.0.8.0/24", "d": "10.0.8.0/24", "e": "invalid"})
+	want := map[string]string{"b": "10.0.0.0/22", "c": "10.0.8.0/24", "e": "invalid"}
+	if !reflect.DeepEqual(distinct, want) {
+		t.Fatalf("expected %v, got %v", want, distinct)
+	}
+}
+
+func TestAllocateSubnet_alignment(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{"other": "10.0.0.0/24"}}
+	netmask, err := allocateSubnet(networkConfig, "zone-a", 24, 20, "10.0.0.0/16", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netmask != "10.0.16.0/24" || networkConfig.AlignedBlocks["zone-a"] != "10.0.16.0/20" {
+		t.Fatalf("expected the first subnet of a free /20, got %s in %s", netmask, networkConfig.AlignedBlocks["zone-a"])
+	}
+
+	// The rest of the block stays out of the other allocations.
+	netmask, err = allocateSubnet(networkConfig, "b", 24, 0, "10.0.0.0/16", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netmask != "10.0.1.0/24" {
+		t.Fatalf("unexpected subnet of b: %s", netmask)
+	}
+	for i := 0; i < 15; i++ {
+		if _, err := allocateSubnet(networkConfig, fmt.Sprintf("c%d", i), 24, 0, "10.0.0.0/16", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if netmask := networkConfig.Subnets["c14"]; netmask != "10.0.32.0/24" {
+		t.Fatalf("expected the allocations to go past the aligned block, got %s", netmask)
+	}
+
+	releaseSubnet(networkConfig, "zone-a")
+	if _, ok := networkConfig.AlignedBlocks["zone-a"]; ok {
+		t.Fatal("expected the aligned block to be released")
+	}
+
+	if _, err := allocateSubnet(networkConfig, "d", 20, 24, "10.0.0.0/16", false); err == nil {
+		t.Fatal("expected an alignment_prefix longer than prefix_length to be refused")
+	}
+	if _, err := allocateSubnet(networkConfig, "e", 24, 8, "10.0.0.0/16", false); err == nil {
+		t.Fatal("expected an alignment_prefix shorter than the base_cidr to be refused")
+	}
+}
+
+func TestAllocateSubnet_deterministic(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{}}
+	placed, err := allocateSubnet(networkConfig, "app", 24, 0, "10.0.0.0/16", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placed == "10.0.0.0/24" {
+		t.Fatalf("expected the subnet to be placed by the hash of the id, got the first one %s", placed)
+	}
+
+	// Recreated among other reservations, the id is given the same subnet while it is free.
+	releaseSubnet(networkConfig, "app")
+	for i := 0; i < 10; i++ {
+		if _, err := allocateSubnet(networkConfig, fmt.Sprintf("other-%d", i), 24, 0, "10.0.0.0/16", false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if netmask, err := allocateSubnet(networkConfig, "app", 24, 0, "10.0.0.0/16", true); err != nil || netmask != placed {
+		t.Fatalf("expected app to be given %s again, got %s: %v", placed, netmask, err)
+	}
+
+	// Taken by another id, the subnet falls back to the next free one.
+	releaseSubnet(networkConfig, "app")
+	networkConfig.Subnets["squatter"] = placed
+	next, err := nextNetmask(networkConfig, 24, "10.0.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if netmask, err := allocateSubnet(networkConfig, "app", 24, 0, "10.0.0.0/16", true); err != nil || netmask != next {
+		t.Fatalf("expected app to fall back to the next free subnet %s, got %s: %v", next, netmask, err)
+	}
+
+	// The aligned block is placed by the hash, the subnet is its first one.
+	networkConfig = &NetworkConfig{Subnets: map[string]string{}}
+	netmask, err := allocateSubnet(networkConfig, "zone", 24, 20, "10.0.0.0/16", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := networkConfig.AlignedBlocks["zone"]
+	if first, _ := firstSubnet(block, 24); netmask != first || !strings.HasSuffix(block, "/20") {
+		t.Fatalf("expected the first subnet of a hashed /20, got %s in %s", netmask, block)
+	}
+	if netmask, err := hashedNetmask(networkConfig, "zone", 20, "10.0.0.0/16"); err != nil || netmask == block {
+		t.Fatalf("expected the held block to be skipped, got %s: %v", netmask, err)
+	}
+}
+
+func TestHashedNetmask(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{}}
+	for _, test := range []struct {
+		baseCidr     string
+		prefixLength int64
+	}{
+		{"10.0.0.0/16", 24},
+		{"10.0.0.0/16", 16},
+		{"10.0.0.0/8", 32},
+		{"2001:db8::/32", 64},
+	} {
+		netmask, err := hashedNetmask(networkConfig, "app", test.prefixLength, test.baseCidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, subnet, err := net.ParseCIDR(netmask)
+		if err != nil || subnet.String() != netmask {
+			t.Fatalf("expected a subnet in canonical form, got %s: %v", netmask, err)
+		}
+		if prefix, _ := subnet.Mask.Size(); int64(prefix) != test.prefixLength || !subnetsOverlap(netmask, test.baseCidr) {
+			t.Fatalf("expected a /%d of %s, got %s", test.prefixLength, test.baseCidr, netmask)
+		}
+		if again, _ := hashedNetmask(networkConfig, "app", test.prefixLength, test.baseCidr); again != netmask {
+			t.Fatalf("expected a stable placement in %s, got %s then %s", test.baseCidr, netmask, again)
+		}
+	}
+	if _, err := hashedNetmask(networkConfig, "app", 8, "10.0.0.0/16"); err == nil {
+		t.Fatal("expected a prefix length shorter than the base_cidr to be refused")
+	}
+}
+
+func TestCollidingReservations(t *testing.T) {
+	networkConfig := &NetworkConfig{
+		Subnets: map[string]string{
+			"a": "10.0.1.0/24",
+			"b": "10.0.1.0/24",
+			"c": "10.0.1.128/25",
+			"d": "10.0.2.0/24",
+		},
+		SkippedSubnet: "10.0.0.0/23",
+	}
+	if got := collidingReservations(networkConfig, "a"); strings.Join(got, ",") != skippedSubnetKey+",b,c" {
+		t.Errorf("unexpected collisions of a: %v", got)
+	}
+	if got := collidingReservations(networkConfig, "d"); len(got) != 0 {
+		t.Errorf("unexpected collisions of d: %v", got)
+	}
+}
+
+func TestNetworkRequestVerifyAllocation(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	r := &networkRequestResource{providerData: p}
+	gcpConnector := p.networkConnector("10.0.0.0/16")
+	ctx := context.Background()
+	// Another process reserved the same subnet with a stale view of the config.
+	if err := gcpConnector.Write(ctx, &NetworkConfig{Subnets: map[string]string{"a": "10.0.0.0/24", "b": "10.0.0.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := r.verifyAllocation(ctx, p, &gcpConnector, networkRequestResourceModel{Id: types.StringValue("b"), PrefixLength: types.Int64Value(24)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	netmask := verified.Subnets["b"]
+	if netmask != "10.0.1.0/24" {
+		t.Fatalf("expected b to be allocated another subnet, got %s", netmask)
+	}
+	var stored NetworkConfig
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Subnets["a"] != "10.0.0.0/24" || stored.Subnets["b"] != netmask {
+		t.Fatalf("unexpected stored subnets: %v", stored.Subnets)
+	}
+}
+
+func TestNetworkRequestVerifyRelease(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	r := &networkRequestResource{providerData: p}
+	gcpConnector := p.networkConnector("10.0.0.0/16")
+	ctx := context.Background()
+	data := networkRequestResourceModel{Id: types.StringValue("a"), PrefixLength: types.Int64Value(24)}
+	if err := gcpConnector.Write(ctx, &NetworkConfig{Subnets: map[string]string{"b": "10.0.1.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyRelease(ctx, &gcpConnector, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process wrote the reservation back with a stale view of the config.
+	if err := gcpConnector.Write(ctx, &NetworkConfig{Subnets: map[string]string{"a": "10.0.0.0/24", "b": "10.0.1.0/24"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.verifyRelease(ctx, &gcpConnector, data); err == nil || !strings.Contains(err.Error(), "10.0.0.0/24") {
+		t.Fatalf("expected the reappeared reservation to be reported, got: %v", err)
+	}
+}
+
+func TestAllocateRequest_summarizable(t *testing.T) {
+	ctx := context.Background()
+	networkConfig := &NetworkConfig{Subnets: map[string]string{"other": "10.0.0.0/24"}}
+	data := networkRequestResourceModel{
+		Id:              types.StringValue("team"),
+		PrefixLength:    types.Int64Value(24),
+		AlignmentPrefix: types.Int64Null(),
+		SubnetCount:     types.Int64Value(4),
+		Summarizable:    types.BoolValue(true),
+	}
+	if _, err := allocateRequest(networkConfig, &data, "10.0.0.0/16"); err != nil {
+		t.Fatal(err)
+	}
+	if diags := data.setReservation(ctx, networkConfig); diags.HasError() {
+		t.Fatal(diags)
+	}
+	var netmasks []string
+	data.Netmasks.ElementsAs(ctx, &netmasks, false)
+	if data.SummaryCidr.ValueString() != "10.0.4.0/22" || strings.Join(netmasks, ",") != "10.0.4.0/24,10.0.5.0/24,10.0.6.0/24,10.0.7.0/24" {
+		t.Fatalf("expected the subnets of a free /22, got %v summarized by %s", netmasks, data.SummaryCidr.ValueString())
+	}
+	if data.Netmask.ValueString() != "10.0.4.0/24" || !data.AlignmentPrefix.IsNull() {
+		t.Fatalf("unexpected netmask %s and alignment_prefix %s", data.Netmask.ValueString(), data.AlignmentPrefix.String())
+	}
+
+	// No aligned /22 is left in a /22 holding another subnet.
+	full := &NetworkConfig{Subnets: map[string]string{"other": "10.1.0.0/24"}}
+	if _, err := allocateRequest(full, &data, "10.1.0.0/22"); err == nil {
+		t.Fatalf("expected the allocation to fail without a free aligned block, got %v", full.Subnets)
+	}
+
+	data.SubnetCount = types.Int64Value(3)
+	if _, err := allocateRequest(networkConfig, &data, "10.0.0.0/16"); err == nil {
+		t.Fatal("expected a count that is not a power of two to be refused")
+	}
+	data.SubnetCount = types.Int64Value(2)
+	data.Summarizable = types.BoolValue(false)
+	if _, err := allocateRequest(networkConfig, &data, "10.0.0.0/16"); err == nil {
+		t.Fatal("expected a count greater than 1 without summarizable to be refused")
+	}
+
+	releaseSubnet(networkConfig, "team")
+	if _, ok := networkConfig.SubnetCounts["team"]; ok {
+		t.Fatal("expected the subnet count to be released")
+	}
+}
+
+// newNetworkRequestModel returns the plan of a new network_request of a single subnet of the given prefix length in
+// baseCidr, with the default settings and its computed attributes unknown.
+func newNetworkRequestModel(id string, prefixLength int64, baseCidr string) *networkRequestResourceModel {
+	return &networkRequestResourceModel{
+		Id:                types.StringValue(id),
+		PrefixLength:      types.Int64Value(prefixLength),
+		BaseCidr:          types.StringValue(baseCidr),
+		BaseCidrs:         types.ListNull(types.StringType),
+		Netmask:           types.StringUnknown(),
+		SkipFirstSubnet:   types.BoolValue(false),
+		AlignmentPrefix:   types.Int64Null(),
+		SubnetCount:       types.Int64Value(1),
+		Summarizable:      types.BoolValue(false),
+		Netmasks:          types.ListUnknown(types.StringType),
+		SummaryCidr:       types.StringUnknown(),
+		VerifyAllocation:  types.BoolValue(false),
+		VerifyRelease:     types.BoolValue(false),
+		ContentHash:       types.StringUnknown(),
+		Timeouts:          nullTimeouts,
+		ExcludedAddresses: types.ListUnknown(types.StringType),
+	}
+}
+
+func TestNetworkRequestResource_baseCidrsFillThenSpill(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	r := &networkRequestResource{providerData: p}
+	baseCidrs, _ := types.ListValueFrom(ctx, types.StringType, []string{"10.0.0.0/23", "10.1.0.0/24"})
+	create := func(id string) *fwresource.CreateResponse {
+		model := newNetworkRequestModel(id, 24, "")
+		model.BaseCidr = types.StringUnknown()
+		model.BaseCidrs = baseCidrs
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, resp)
+		return resp
+	}
+
+	// The first base_cidr is filled before any subnet is reserved in the second one.
+	expected := []struct{ baseCidr, netmask string }{
+		{"10.0.0.0/23", "10.0.0.0/24"},
+		{"10.0.0.0/23", "10.0.1.0/24"},
+		{"10.1.0.0/24", "10.1.0.0/24"},
+	}
+	states := make([]tfsdk.State, len(expected))
+	for i, want := range expected {
+		resp := create(fmt.Sprintf("net%d", i))
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		var data networkRequestResourceModel
+		resp.State.Get(ctx, &data)
+		if data.BaseCidr.ValueString() != want.baseCidr || data.Netmask.ValueString() != want.netmask {
+			t.Fatalf("expected net%d to get %s in %s, got %s in %s", i, want.netmask, want.baseCidr, data.Netmask.ValueString(), data.BaseCidr.ValueString())
+		}
+		states[i] = resp.State
+	}
+	if resp := create("net3"); !resp.Diagnostics.HasError() {
+		t.Fatal("expected the creation to fail once every base_cidr is full")
+	}
+
+	// The deletion releases the subnet in the base_cidr it was reserved in.
+	deleteResp := &fwresource.DeleteResponse{State: states[2]}
+	r.Delete(ctx, fwresource.DeleteRequest{State: states[2]}, deleteResp)
+	if deleteResp.Diagnostics.HasError() {
+		t.Fatal(deleteResp.Diagnostics)
+	}
+	gcpConnector := p.networkConnector("10.1.0.0/24")
+	var stored NetworkConfig
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := stored.Subnets["net2"]; ok {
+		t.Fatalf("expected net2 to be released from 10.1.0.0/24, got %v", stored.Subnets)
+	}
+	if resp := create("net3"); resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+}
+
+func TestCandidateBaseCidrs(t *testing.T) {
+	ctx := context.Background()
+	overlapping, _ := types.ListValueFrom(ctx, types.StringType, []string{"10.0.0.0/16", "10.0.128.0/17"})
+	for _, data := range []networkRequestResourceModel{
+		{BaseCidr: types.StringNull(), BaseCidrs: types.ListNull(types.StringType)},
+		{BaseCidr: types.StringValue("10.0.0.0/16"), BaseCidrs: overlapping},
+		{BaseCidr: types.StringUnknown(), BaseCidrs: overlapping},
+	} {
+		if _, diags := candidateBaseCidrs(ctx, data); !diags.HasError() {
+			t.Errorf("expected base_cidr %s with base_cidrs %s to be refused", data.BaseCidr, data.BaseCidrs)
+		}
+	}
+}
+
+func TestNetworkRequestResourceDelete_cleanupEmpty(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	p.CleanupEmpty = types.BoolValue(true)
+	ctx := context.Background()
+	r := &networkRequestResource{providerData: p}
+	create := func(id string) tfsdk.State {
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newNetworkRequestModel(id, 25, "10.2.0.0/24"))}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		return resp.State
+	}
+	remove := func(state tfsdk.State) {
+		resp := &fwresource.DeleteResponse{State: state}
+		r.Delete(ctx, fwresource.DeleteRequest{State: state}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+	}
+	objectPath := p.networkConnector("10.2.0.0/24").FullFilePath
+
+	first := create("net0")
+	second := create("net1")
+	remove(first)
+	if _, ok := server.Get(testBucket, objectPath); !ok {
+		t.Fatal("expected the network config to be kept while it holds a reservation")
+	}
+	remove(second)
+	if _, ok := server.Get(testBucket, objectPath); ok {
+		t.Fatal("expected the network config to be deleted with its last reservation")
+	}
+
+	// The next request creates the object again.
+	create("net2")
+	if _, ok := server.Get(testBucket, objectPath); !ok {
+		t.Fatal("expected the network config to be created again")
+	}
+}
+
+func TestNetworkRequestResourceModifyPlan_prefixLength(t *testing.T) {
+	ctx := context.Background()
+	r := &networkRequestResource{}
+	modifyPlanRange := func(prefixLength types.Int64, minPrefixLength types.Int64, maxPrefixLength types.Int64, baseCidr types.String, baseCidrs types.List) diag.Diagnostics {
+		model := newNetworkRequestModel("a", 0, "")
+		model.PrefixLength = prefixLength
+		model.MinPrefixLength = minPrefixLength
+		model.MaxPrefixLength = maxPrefixLength
+		model.BaseCidr = baseCidr
+		model.BaseCidrs = baseCidrs
+		plan := newPlan(t, r, model)
+		resp := &fwresource.ModifyPlanResponse{Plan: plan}
+		r.ModifyPlan(ctx, fwresource.ModifyPlanRequest{
+			Config: tfsdk.Config{Schema: plan.Schema, Raw: plan.Raw},
+			Plan:   plan,
+			State:  newState(t, r, nil),
+		}, resp)
+		return resp.Diagnostics
+	}
+	modifyPlan := func(prefixLength int64, baseCidr types.String, baseCidrs types.List) diag.Diagnostics {
+		return modifyPlanRange(types.Int64Value(prefixLength), types.Int64Null(), types.Int64Null(), baseCidr, baseCidrs)
+	}
+
+	if diags := modifyPlan(24, types.StringValue("10.0.0.0/16"), types.ListNull(types.StringType)); diags.HasError() {
+		t.Fatal(diags)
+	}
+	diags := modifyPlan(16, types.StringValue("10.0.0.0/24"), types.ListNull(types.StringType))
+	if !diags.HasError() || !strings.Contains(diags[0].Detail(), "prefix_length (16) must be >= base_cidr prefix (24)") {
+		t.Fatalf("expected prefix_length shorter than base_cidr to be rejected, got %v", diags)
+	}
+	baseCidrs := types.ListValueMust(types.StringType, []attr.Value{types.StringValue("10.0.0.0/16"), types.StringValue("10.1.0.0/24")})
+	if diags := modifyPlan(20, types.StringUnknown(), baseCidrs); !diags.HasError() {
+		t.Fatal("expected prefix_length shorter than one of base_cidrs to be rejected")
+	}
+
+	// A range is checked on its shortest prefix length, and excludes prefix_length.
+	if diags := modifyPlanRange(types.Int64Null(), types.Int64Value(20), types.Int64Value(24), types.StringValue("10.0.0.0/16"), types.ListNull(types.StringType)); diags.HasError() {
+		t.Fatal(diags)
+	}
+	for _, invalid := range [][3]types.Int64{
+		{types.Int64Null(), types.Int64Value(12), types.Int64Value(24)},
+		{types.Int64Null(), types.Int64Value(24), types.Int64Value(20)},
+		{types.Int64Null(), types.Int64Value(20), types.Int64Null()},
+		{types.Int64Value(24), types.Int64Value(20), types.Int64Value(24)},
+		{types.Int64Null(), types.Int64Null(), types.Int64Null()},
+	} {
+		if diags := modifyPlanRange(invalid[0], invalid[1], invalid[2], types.StringValue("10.0.0.0/16"), types.ListNull(types.StringType)); !diags.HasError() {
+			t.Fatalf("expected prefix_length %s with range %s to %s to be rejected", invalid[0], invalid[1], invalid[2])
+		}
+	}
+}
+
+func TestAllocateLargestRequest(t *testing.T) {
+	data := networkRequestResourceModel{Id: types.StringValue("c"), PrefixLength: types.Int64Unknown(), MinPrefixLength: types.Int64Value(22), MaxPrefixLength: types.Int64Value(24)}
+	// The /22 and the second /23 of 10.0.0.0/21 are taken, the first free block of the range is the first /23.
+	networkConfig := &NetworkConfig{Subnets: map[string]string{"a": "10.0.4.0/22", "b": "10.0.2.0/23"}}
+	netmask, err := allocateLargestRequest(networkConfig, &data, "10.0.0.0/21")
+	if err != nil || netmask != "10.0.0.0/23" || data.PrefixLength.ValueInt64() != 23 {
+		t.Fatalf("expected the largest free block 10.0.0.0/23, got %s with prefix_length %s: %v", netmask, data.PrefixLength, err)
+	}
+
+	full := networkRequestResourceModel{Id: types.StringValue("d"), PrefixLength: types.Int64Unknown(), MinPrefixLength: types.Int64Value(22), MaxPrefixLength: types.Int64Value(23)}
+	if _, err := allocateLargestRequest(networkConfig, &full, "10.0.0.0/21"); !isExhaustedError(err) || !full.PrefixLength.IsUnknown() {
+		t.Fatalf("expected the range to be exhausted without choosing a prefix_length, got %s: %v", full.PrefixLength, err)
+	}
+	if _, ok := networkConfig.Subnets["d"]; ok {
+		t.Fatal("an exhausted range must not reserve anything")
+	}
+}
+
+func TestFreeSubnets(t *testing.T) {
+	networkConfig := &NetworkConfig{Subnets: map[string]string{"a": "10.0.1.0/24"}, SkippedSubnet: "10.0.0.0/24"}
+	free, err := freeSubnets(networkConfig, 24, "10.0.0.0/22", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(free, ",") != "10.0.2.0/24,10.0.3.0/24" {
+		t.Fatalf("expected the two subnets left after the reservation and the skipped subnet, got %v", free)
+	}
+	if len(networkConfig.Subnets) != 1 {
+		t.Fatalf("expected the network config to be left untouched, got %v", networkConfig.Subnets)
+	}
+	if free, err := freeSubnets(networkConfig, 24, "10.0.0.0/22", 1); err != nil || strings.Join(free, ",") != "10.0.2.0/24" {
+		t.Fatalf("expected the limit to stop at the first free subnet, got %v: %v", free, err)
+	}
+	networkConfig.Subnets["b"] = "10.0.2.0/23"
+	if free, err := freeSubnets(networkConfig, 24, "10.0.0.0/22", 10); err != nil || len(free) != 0 {
+		t.Fatalf("expected no free subnet in an exhausted base_cidr, got %v: %v", free, err)
+	}
+}
+
+func TestSubnetExclusions(t *testing.T) {
+	tests := []struct {
+		subnet   string
+		gateway  bool
+		expected []string
+	}{
+		{"10.12.13.0/24", false, []string{"10.12.13.0", "10.12.13.255"}},
+		{"10.12.13.0/24", true, []string{"10.12.13.0", "10.12.13.1", "10.12.13.255"}},
+		{"10.12.13.4/30", true, []string{"10.12.13.4", "10.12.13.5", "10.12.13.7"}},
+		{"10.12.13.4/31", true, []string{}},
+		{"10.12.13.4/32", false, []string{}},
+		{"2001:db8::/64", true, []string{"2001:db8::", "2001:db8::1"}},
+		{"2001:db8::/127", true, []string{}},
+	}
+	for _, test := range tests {
+		exclusions, err := subnetExclusions(test.subnet, test.gateway)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(exclusions, test.expected) {
+			t.Errorf("expected %v excluded from %s with gateway %t, got %v", test.expected, test.subnet, test.gateway, exclusions)
+		}
+	}
+	if _, err := subnetExclusions("10.12.13.0", false); err == nil {
+		t.Fatal("expected an error for an address without prefix length")
+	}
+}
+
+func TestNetworkRequestResource_persistExclusions(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	r := &networkRequestResource{providerData: p}
+	model := newNetworkRequestModel("net", 25, "10.3.0.0/24")
+	model.ExcludeGateway = types.BoolValue(true)
+	model.PersistExclusions = types.BoolValue(true)
+	stored := func() NetworkConfig {
+		gcpConnector := p.networkConnector("10.3.0.0/24")
+		var networkConfig NetworkConfig
+		if err := gcpConnector.Read(ctx, &networkConfig); err != nil {
+			t.Fatal(err)
+		}
+		return networkConfig
+	}
+
+	resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+	r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var data networkRequestResourceModel
+	resp.State.Get(ctx, &data)
+	var excluded []string
+	data.ExcludedAddresses.ElementsAs(ctx, &excluded, false)
+	if expected := []string{"10.3.0.0", "10.3.0.1", "10.3.0.127"}; !reflect.DeepEqual(excluded, expected) {
+		t.Fatalf("expected %v excluded, got %v", expected, excluded)
+	}
+	if exclusions := stored().Exclusions["net"]; !reflect.DeepEqual(exclusions, excluded) {
+		t.Fatalf("expected the exclusions to be persisted, got %v", exclusions)
+	}
+
+	// Turning the persistence off removes the exclusions, the excluded_addresses are still computed.
+	model.PersistExclusions = types.BoolValue(false)
+	model.ExcludeGateway = types.BoolValue(false)
+	updateResp := &fwresource.UpdateResponse{State: resp.State}
+	r.Update(ctx, fwresource.UpdateRequest{Plan: newPlan(t, r, model), State: resp.State}, updateResp)
+	if updateResp.Diagnostics.HasError() {
+		t.Fatal(updateResp.Diagnostics)
+	}
+	updateResp.State.Get(ctx, &data)
+	data.ExcludedAddresses.ElementsAs(ctx, &excluded, false)
+	if expected := []string{"10.3.0.0", "10.3.0.127"}; !reflect.DeepEqual(excluded, expected) {
+		t.Fatalf("expected %v excluded, got %v", expected, excluded)
+	}
+	if networkConfig := stored(); networkConfig.Exclusions != nil || networkConfig.Subnets["net"] != "10.3.0.0/25" {
+		t.Fatalf("expected the exclusions to be removed and the reservation kept, got %+v", networkConfig)
+	}
+}
@@ -2,22 +2,40 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/hashicorp/terraform-plugin-framework/datasource"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/ephemeral"
+	"github.com/hashicorp/terraform-plugin-framework/function"
+	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/provider"
 	"github.com/hashicorp/terraform-plugin-framework/provider/schema"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/types"
-	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
 )
 
 var _ provider.Provider = &GCSReferentialProvider{}
-
-/*var _ provider.ProviderWithFunctions = &GCSReferentialProvider{} */
+var _ provider.ProviderWithFunctions = &GCSReferentialProvider{}
+var _ provider.ProviderWithEphemeralResources = &GCSReferentialProvider{}
 
 const ProviderName = "gcsreferential"
 
+// objectFormatMinProviderVersion is the first release of the provider able to round-trip the objects in the format it
+// writes, stamped as their min_provider_version so that an older release refuses them instead of dropping the fields it
+// does not know. It must be raised to the upcoming release whenever a format change cannot be written back by the older ones.
+const objectFormatMinProviderVersion = "1.1.0"
+
 type GCSReferentialProvider struct {
 	version string
 }
@@ -32,16 +50,98 @@ func New(version string) func() provider.Provider {
 }
 
 type CachedIdPool struct {
-	Pool       *IdPoolTools.IDPool
-	Generation int64
+	Pool        *StoredIdPool
+	Generation  int64
+	ContentHash string
+}
+
+// sharedIdPoolsCache is an id pool cache with its mutex.
+type sharedIdPoolsCache struct {
+	pools map[string]*CachedIdPool
+	mutex *sync.RWMutex
+}
+
+// idPoolsCacheKey identifies the pools a cache can be shared for: those read from the same bucket of the same storage
+// endpoint with the same encryption key, given by its hash.
+type idPoolsCacheKey struct {
+	bucket        string
+	endpoint      string
+	encryptionKey string
+}
+
+// idPoolsCaches holds one cache, keyed by object path, per referential bucket for the whole process: provider aliases configured on the
+// same bucket share it, so the writes of one alias are immediately visible to the others. The generation check
+// done on every access still protects against writes made by other processes. Aliases with another storage_endpoint or
+// encryption_key get their own cache, so that they never get pools they could not have read themselves.
+var (
+	idPoolsCaches      = map[idPoolsCacheKey]*sharedIdPoolsCache{}
+	idPoolsCachesMutex sync.Mutex
+)
+
+// getSharedIdPoolsCache returns the cache of the referential bucket of p, creating it on first use.
+func getSharedIdPoolsCache(p *GCSReferentialProviderModel) *sharedIdPoolsCache {
+	cacheKey := idPoolsCacheKey{bucket: p.ReferentialBucket.ValueString(), endpoint: p.StorageEndpoint.ValueString()}
+	if len(p.EncryptionKeyBytes) > 0 {
+		keyHash := sha256.Sum256(p.EncryptionKeyBytes)
+		cacheKey.encryptionKey = hex.EncodeToString(keyHash[:])
+	}
+	idPoolsCachesMutex.Lock()
+	defer idPoolsCachesMutex.Unlock()
+	cache, ok := idPoolsCaches[cacheKey]
+	if !ok {
+		cache = &sharedIdPoolsCache{pools: make(map[string]*CachedIdPool), mutex: &sync.RWMutex{}}
+		idPoolsCaches[cacheKey] = cache
+	}
+	return cache
 }
 
 type GCSReferentialProviderModel struct {
-	ReferentialBucket types.String             `tfsdk:"referential_bucket"`
-	TimeoutInMinutes  types.Int32              `tfsdk:"timeout_in_minutes"`
-	BackoffMultiplier types.Float32            `tfsdk:"backoff_multiplier"`
-	IdPoolsCache      map[string]*CachedIdPool `tfsdk:"-"`
-	CacheMutex        *sync.Mutex              `tfsdk:"-"`
+	ReferentialBucket   types.String             `tfsdk:"referential_bucket"`
+	TimeoutInMinutes    types.Int32              `tfsdk:"timeout_in_minutes"`
+	BackoffMultiplier   types.Float32            `tfsdk:"backoff_multiplier"`
+	LockPrefix          types.String             `tfsdk:"lock_prefix"`
+	LockBucket          types.String             `tfsdk:"lock_bucket"`
+	EncryptionKey       types.String             `tfsdk:"encryption_key"`
+	RandomSeed          types.Int64              `tfsdk:"random_seed"`
+	Tenant              types.String             `tfsdk:"tenant"`
+	ObjectMetadata      types.Map                `tfsdk:"object_metadata"`
+	SkipPermissionCheck types.Bool               `tfsdk:"skip_permission_check"`
+	FailIfLocked        types.Bool               `tfsdk:"fail_if_locked"`
+	StorageEndpoint     types.String             `tfsdk:"storage_endpoint"`
+	SkipLock            types.Bool               `tfsdk:"skip_lock"`
+	CleanupEmpty        types.Bool               `tfsdk:"cleanup_empty"`
+	CrashSafe           types.Bool               `tfsdk:"crash_safe"`
+	AccessTokenFallback types.Bool               `tfsdk:"access_token_fallback"`
+	Referentials        types.Map                `tfsdk:"referentials"`
+	IdPoolsCache        map[string]*CachedIdPool `tfsdk:"-"`
+	CacheMutex          *sync.RWMutex            `tfsdk:"-"`
+	// AllocationWebhookUrl is called to approve each allocation before it is written.
+	AllocationWebhookUrl            types.String `tfsdk:"allocation_webhook_url"`
+	AllocationWebhookTimeoutSeconds types.Int64  `tfsdk:"allocation_webhook_timeout_seconds"`
+	// MaxStateReservations caps the size of the reservations map recorded in the state of an id_pool.
+	MaxStateReservations types.Int64 `tfsdk:"max_state_reservations"`
+	// AllocationMetrics counts the allocations in the metrics object of the referential.
+	AllocationMetrics types.Bool `tfsdk:"allocation_metrics"`
+	// EncryptionKeyBytes is the decoded encryption_key.
+	EncryptionKeyBytes []byte `tfsdk:"-"`
+	// ObjectMetadataValues is the decoded object_metadata, nil when unset.
+	ObjectMetadataValues map[string]string `tfsdk:"-"`
+	// Rand picks the allocated ids, seeded by random_seed.
+	Rand *lockedRand `tfsdk:"-"`
+	// Batchers coalesce the concurrent id_request creations made on each pool.
+	Batchers *poolBatchers `tfsdk:"-"`
+	// ProviderVersion is the version of the running provider, checked against the min_provider_version of the objects.
+	ProviderVersion string `tfsdk:"-"`
+	// NamedReferentials holds the provider data of each entry of referentials, nil when unset.
+	NamedReferentials map[string]*GCSReferentialProviderModel `tfsdk:"-"`
+	// LockTtlMinutes is the age after which a lock held by another run is broken.
+	LockTtlMinutes types.Int64 `tfsdk:"lock_ttl_minutes"`
+}
+
+// namedReferentialModel is an entry of the referentials attribute of the provider.
+type namedReferentialModel struct {
+	Bucket types.String `tfsdk:"bucket"`
+	Tenant types.String `tfsdk:"tenant"`
 }
 
 func (p *GCSReferentialProvider) Metadata(ctx context.Context, req provider.MetadataRequest, resp *provider.MetadataResponse) {
@@ -62,7 +162,110 @@ func (p *GCSReferentialProvider) Schema(ctx context.Context, req provider.Schema
 				Optional:            true,
 			},
 			"backoff_multiplier": schema.Float32Attribute{
-				MarkdownDescription: "The GCS bucket name where the information from this provider will be stocked",
+				MarkdownDescription: "The factor applied to the wait between two tries to get a lock held by another run: the wait starts at 1 second and is multiplied by backoff_multiplier at each try, up to 10 seconds, with a jitter. A value up to 1 keeps waiting 1 second between tries. Default to 2",
+				Optional:            true,
+			},
+			"tenant": schema.StringAttribute{
+				MarkdownDescription: "An optional tenant namespacing the id_pool objects, they are stored under `gcsreferential/<tenant>/id_pool/<name>` so the same pool name can exist for each tenant of a shared bucket",
+				Optional:            true,
+			},
+			"referentials": schema.MapNestedAttribute{
+				MarkdownDescription: "Optional named referentials, each in its own bucket, selected by the `referential` attribute of the id_pool, id_request and network_request resources instead of configuring a provider alias per referential. " +
+					"They share every other setting of the provider and each has its own cache of the pools. The resources without referential use referential_bucket and tenant. " +
+					"With lock_bucket, whose lock paths do not name the bucket, a referential can only be on the referential_bucket, for example with another tenant",
+				Optional: true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"bucket": schema.StringAttribute{
+							MarkdownDescription: "The GCS bucket of the referential",
+							Required:            true,
+						},
+						"tenant": schema.StringAttribute{
+							MarkdownDescription: "An optional tenant namespacing the id_pool objects of the referential in its bucket, as the tenant of the provider",
+							Optional:            true,
+						},
+					},
+				},
+			},
+			"object_metadata": schema.MapAttribute{
+				MarkdownDescription: "Optional custom metadata set on every id_pool and network config object written by the provider, for example `managed-by = \"terraform\"`, so that bucket inventory tools can attribute the objects without reading them. It is applied on the next write of each object. The `provider_version` key is reserved: every write stamps it with the version of the provider, to audit which release last modified an object",
+				ElementType:         types.StringType,
+				Optional:            true,
+			},
+			"lock_prefix": schema.StringAttribute{
+				MarkdownDescription: "An optional prefix under which the `.lock` objects are written, for example to keep them out of a prefix subject to object retention. By default locks are written next to the object they protect",
+				Optional:            true,
+			},
+			"lock_bucket": schema.StringAttribute{
+				MarkdownDescription: "An optional GCS bucket where the `.lock` objects are written instead of the referential_bucket, for example to isolate them from the lifecycle rules of the data. The lock object keeps the path derived from the object it protects, so the referentials can then only be on the referential_bucket. By default locks are written in the referential_bucket",
+				Optional:            true,
+			},
+			"lock_ttl_minutes": schema.Int64Attribute{
+				MarkdownDescription: "If set, a `.lock` object older than this number of minutes is considered left by a run that died and is deleted by the next operation waiting for it, instead of waiting for it up to the timeout. " +
+					"It must be longer than the longest operation, since the lock of a run still holding it is broken as well: that run then gets a `Lock lost` warning when it releases it. Default to null, the locks never expire",
+				Optional: true,
+			},
+			"fail_if_locked": schema.BoolAttribute{
+				MarkdownDescription: "If true, an operation on an object locked by another run fails immediately with a `resource is locked by another run` error naming the lock holder, instead of waiting for the lock up to the timeout, for example for fail-fast pipelines. Default to false",
+				Optional:            true,
+			},
+			"storage_endpoint": schema.StringAttribute{
+				MarkdownDescription: "An optional endpoint of the storage API used for every object of the provider, locks included, instead of the public googleapis one, for example a private service connect endpoint such as `https://storage-myendpoint.p.googleapis.com/storage/v1/` under VPC Service Controls",
+				Optional:            true,
+			},
+			"allocation_webhook_url": schema.StringAttribute{
+				MarkdownDescription: "An optional http or https URL called before writing each new allocation, an id of an id_request or a subnet of a network_request, to gate them through an external approval system. " +
+					"The proposed allocation is POSTed as JSON with its `kind` (`id` or `subnet`), `bucket`, `tenant`, `request_id`, and `pool` and `id`, or `base_cidr`, `netmask` and `aligned_block`. It is written only when the webhook answers 200, otherwise the operation fails with the body of the answer. " +
+					"The call is made under the lock of the pool or network config. Default to no webhook",
+				Optional: true,
+			},
+			"allocation_webhook_timeout_seconds": schema.Int64Attribute{
+				MarkdownDescription: "The time given to the allocation_webhook_url to answer, the allocation fails past it. Default to 10",
+				Optional:            true,
+			},
+			"allocation_metrics": schema.BoolAttribute{
+				MarkdownDescription: "If true, every id allocated to an id_request and every subnet reserved by a network_request is counted in a metrics object of the referential, under the tenant when one is set, read with the allocation_metrics data source, for example for billing. " +
+					"The object is updated under its own lock once the allocations are written, a failure to count them only adds a warning. Default to false",
+				Optional: true,
+			},
+			"max_state_reservations": schema.Int64Attribute{
+				MarkdownDescription: "If set, the reservations map of an id_pool holding more members than this number is left null in its state, only its used_count is recorded, to keep the state files and the plans of huge pools small. " +
+					"The members of such a pool are then not visible in its state: read them with the id_pool_members data source, or in the pool object. 0 omits the reservations of every pool with members. Default to null, the reservations are always recorded",
+				Optional: true,
+			},
+			"skip_lock": schema.BoolAttribute{
+				MarkdownDescription: "**Unsafe under concurrent writers.** If true, the operations do not take the `.lock` objects, saving their round-trips, for setups where the referential_bucket is not written concurrently at apply time, for example when the allocations are made by a single controlled job. " +
+					"A concurrent write is then only caught by the generation precondition of the objects, which fails the operation instead of overwriting them. Default to false",
+				Optional: true,
+			},
+			"cleanup_empty": schema.BoolAttribute{
+				MarkdownDescription: "If true, deleting the last network_request of a base_cidr deletes its network config object, under its lock, instead of leaving an empty object behind. The next network_request on the base_cidr creates it again. " +
+					"An object keeping the first subnet skipped by a past request is kept. Default to false",
+				Optional: true,
+			},
+			"crash_safe": schema.BoolAttribute{
+				MarkdownDescription: "If true, the allocations of the id_requests are written in two phases: an intent object recording them is written under `id_pool_intent/` before the pool, and deleted once the pool is written. " +
+					"An intent left by a run that died in between, or whose write of the pool failed, is replayed in the pool by the next allocation on it, when the pool was not written since. " +
+					"The replayed ids are taken back by the id_requests created again with recover_interrupted_create, as after an interrupted apply. It costs two more writes per allocation. Default to false",
+				Optional: true,
+			},
+			"access_token_fallback": schema.BoolAttribute{
+				MarkdownDescription: "If true, once the GOOGLE_OAUTH_ACCESS_TOKEN access token is refused as expired (HTTP 401) during the run, the storage requests switch to the application default credentials, or those of GOOGLE_APPLICATION_CREDENTIALS, for the rest of the run, and the refused request is sent again when possible. " +
+					"It applies to every provider of the process, which share the access token. Default to false, the operations then fail with an access token expired error",
+				Optional: true,
+			},
+			"encryption_key": schema.StringAttribute{
+				MarkdownDescription: "An optional customer-supplied AES-256 encryption key (CSEK), base64 encoded, used to write and read every object of the provider, locks included. Objects written with another key or without key cannot be read with it",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"skip_permission_check": schema.BoolAttribute{
+				MarkdownDescription: "If true, the provider does not check at configuration that it can write, lock and delete objects in the referential_bucket and the lock_bucket. " +
+					"The check writes and deletes a temporary object under `gcsreferential/healthcheck/`, set it for least-privilege setups where the credentials cannot write there or for plans that must not write at all. Default to false",
+				Optional: true,
+			},
+			"random_seed": schema.Int64Attribute{
+				MarkdownDescription: "An optional seed of the random choice of the ids allocated by id_request, so that the same sequence of allocations on the same pools gives the same ids, for example in tests or to reproduce an allocation. By default the seed is based on the time",
 				Optional:            true,
 			},
 		},
@@ -79,31 +282,286 @@ func (p *GCSReferentialProvider) Configure(ctx context.Context, req provider.Con
 	if data.ReferentialBucket.ValueString() == "" {
 		resp.Diagnostics.AddError("The provide must be set with referential_bucket argument", "")
 	}
+	if !data.LockBucket.IsNull() && data.LockBucket.ValueString() == "" {
+		resp.Diagnostics.AddError("Invalid lock_bucket", "The lock_bucket must be a non empty bucket name when set")
+	}
+	if !data.StorageEndpoint.IsNull() {
+		if endpoint, err := url.Parse(data.StorageEndpoint.ValueString()); err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Host == "" {
+			resp.Diagnostics.AddError("Invalid storage_endpoint", fmt.Sprintf("The storage_endpoint must be an http or https URL, got: %q", data.StorageEndpoint.ValueString()))
+		}
+	}
+	if !data.AllocationWebhookUrl.IsNull() {
+		if webhookUrl, err := url.Parse(data.AllocationWebhookUrl.ValueString()); err != nil || (webhookUrl.Scheme != "https" && webhookUrl.Scheme != "http") || webhookUrl.Host == "" {
+			resp.Diagnostics.AddError("Invalid allocation_webhook_url", fmt.Sprintf("The allocation_webhook_url must be an http or https URL, got: %q", data.AllocationWebhookUrl.ValueString()))
+		}
+	}
+	if !data.AllocationWebhookTimeoutSeconds.IsNull() && data.AllocationWebhookTimeoutSeconds.ValueInt64() < 1 {
+		resp.Diagnostics.AddError("Invalid allocation_webhook_timeout_seconds", fmt.Sprintf("The allocation_webhook_timeout_seconds must be at least 1, got: %d", data.AllocationWebhookTimeoutSeconds.ValueInt64()))
+	}
+	if !data.LockTtlMinutes.IsNull() && data.LockTtlMinutes.ValueInt64() < 1 {
+		resp.Diagnostics.AddError("Invalid lock_ttl_minutes", fmt.Sprintf("The lock_ttl_minutes must be at least 1, got: %d", data.LockTtlMinutes.ValueInt64()))
+	}
+	if !data.MaxStateReservations.IsNull() && data.MaxStateReservations.ValueInt64() < 0 {
+		resp.Diagnostics.AddError("Invalid max_state_reservations", fmt.Sprintf("The max_state_reservations must be at least 0, got: %d", data.MaxStateReservations.ValueInt64()))
+	}
+	if data.SkipLock.ValueBool() {
+		resp.Diagnostics.AddWarning("Locking is disabled", "skip_lock is set: the objects of the referential_bucket are not locked by the operations of this provider, which is unsafe if another run or job writes them concurrently")
+	}
+	if data.AccessTokenFallback.ValueBool() {
+		connector.EnableAccessTokenFallback()
+	}
+	if !data.EncryptionKey.IsNull() {
+		key, err := base64.StdEncoding.DecodeString(data.EncryptionKey.ValueString())
+		if err != nil || len(key) != 32 {
+			resp.Diagnostics.AddError("Invalid encryption_key", "The encryption_key must be a base64 encoded 32 bytes AES-256 key")
+		}
+		data.EncryptionKeyBytes = key
+	}
+	if !data.ObjectMetadata.IsNull() && !data.ObjectMetadata.IsUnknown() {
+		resp.Diagnostics.Append(data.ObjectMetadata.ElementsAs(ctx, &data.ObjectMetadataValues, false)...)
+		for key := range data.ObjectMetadataValues {
+			if key == "" {
+				resp.Diagnostics.AddError("Invalid object_metadata", "The keys of object_metadata must not be empty")
+			}
+			if key == connector.ProviderVersionMetadataKey {
+				resp.Diagnostics.AddError("Invalid object_metadata", fmt.Sprintf("The key %s of object_metadata is reserved, it is stamped with the version of the provider on every write", connector.ProviderVersionMetadataKey))
+			}
+		}
+	}
+	if strings.Contains(data.Tenant.ValueString(), "/") || (!data.Tenant.IsNull() && data.Tenant.ValueString() == "") {
+		resp.Diagnostics.AddError("Invalid tenant", fmt.Sprintf("The tenant must be a non empty name without '/', got: %q", data.Tenant.ValueString()))
+	}
 	if data.TimeoutInMinutes.IsNull() {
 		data.TimeoutInMinutes = types.Int32Value(5)
 	}
 	if data.BackoffMultiplier.IsNull() {
-		data.BackoffMultiplier = types.Float32Value(0.5)
+		data.BackoffMultiplier = types.Float32Value(2)
+	}
+
+	if data.RandomSeed.IsNull() {
+		data.Rand = newLockedRand(time.Now().UnixNano())
+	} else {
+		data.Rand = newLockedRand(data.RandomSeed.ValueInt64())
+	}
+
+	data.Batchers = newPoolBatchers()
+	data.ProviderVersion = p.version
+
+	if !data.SkipPermissionCheck.ValueBool() && !resp.Diagnostics.HasError() {
+		resp.Diagnostics.Append(checkBucketPermissions(ctx, data)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
 	}
 
-	data.IdPoolsCache = make(map[string]*CachedIdPool)
-	data.CacheMutex = &sync.Mutex{}
+	cache := getSharedIdPoolsCache(data)
+	data.IdPoolsCache = cache.pools
+	data.CacheMutex = cache.mutex
+
+	if !data.Referentials.IsNull() && !data.Referentials.IsUnknown() {
+		resp.Diagnostics.Append(configureNamedReferentials(ctx, data)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
 
 	resp.DataSourceData = data
 	resp.ResourceData = data
+	resp.EphemeralResourceData = data
+}
+
+// configureNamedReferentials builds the provider data of each entry of the referentials attribute of p: a copy of p on
+// the bucket and tenant of the entry, with its own cache and batchers since the same pool name may exist in each.
+func configureNamedReferentials(ctx context.Context, p *GCSReferentialProviderModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var referentials map[string]namedReferentialModel
+	diags.Append(p.Referentials.ElementsAs(ctx, &referentials, false)...)
+	if diags.HasError() {
+		return diags
+	}
+	p.NamedReferentials = make(map[string]*GCSReferentialProviderModel, len(referentials))
+	for name, referential := range referentials {
+		if name == "" || referential.Bucket.ValueString() == "" {
+			diags.AddAttributeError(path.Root("referentials"), "Invalid referentials", fmt.Sprintf("Each referential must have a non empty name and bucket, got %q with bucket %q", name, referential.Bucket.ValueString()))
+			continue
+		}
+		if strings.Contains(referential.Tenant.ValueString(), "/") || (!referential.Tenant.IsNull() && referential.Tenant.ValueString() == "") {
+			diags.AddAttributeError(path.Root("referentials").AtMapKey(name), "Invalid referentials", fmt.Sprintf("The tenant of referential %s must be a non empty name without '/', got: %q", name, referential.Tenant.ValueString()))
+			continue
+		}
+		if p.LockBucket.ValueString() != "" && referential.Bucket.ValueString() != p.ReferentialBucket.ValueString() {
+			// The lock path is derived from the object path only, the objects of the same name in two buckets would share their lock.
+			diags.AddAttributeError(path.Root("referentials").AtMapKey(name), "Invalid referentials",
+				fmt.Sprintf("The referential %s cannot use bucket %s with lock_bucket set: the locks of its objects would be shared with the objects of the same name in bucket %s, configure a provider alias per bucket instead", name, referential.Bucket.ValueString(), p.ReferentialBucket.ValueString()))
+			continue
+		}
+		named := *p
+		named.ReferentialBucket = referential.Bucket
+		named.Tenant = referential.Tenant
+		named.Referentials = types.MapNull(p.Referentials.ElementType(ctx))
+		named.NamedReferentials = nil
+		named.Batchers = newPoolBatchers()
+		cache := getSharedIdPoolsCache(&named)
+		named.IdPoolsCache = cache.pools
+		named.CacheMutex = cache.mutex
+		if !p.SkipPermissionCheck.ValueBool() {
+			diags.Append(checkBucketPermissions(ctx, &named)...)
+		}
+		p.NamedReferentials[name] = &named
+	}
+	return diags
+}
+
+// referential returns the provider data of the referential selected by the referential attribute of a resource,
+// p itself when it is not set.
+func (p *GCSReferentialProviderModel) referential(name types.String) (*GCSReferentialProviderModel, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if name.IsNull() || name.IsUnknown() {
+		return p, diags
+	}
+	named, ok := p.NamedReferentials[name.ValueString()]
+	if !ok {
+		diags.AddAttributeError(path.Root("referential"), "Unknown referential", fmt.Sprintf("The referential %q is not declared in the referentials of the provider", name.ValueString()))
+		return nil, diags
+	}
+	return named, diags
+}
+
+// checkBucketPermissions runs the health check on a temporary object, so that missing permissions on the buckets are
+// reported once at configuration instead of by the first write of an apply.
+func checkBucketPermissions(ctx context.Context, p *GCSReferentialProviderModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	gcpConnector := p.genericConnector(fmt.Sprintf("%s/healthcheck/.probe-%s.json", ProviderName, uuid.New().String()))
+	details, err := runHealthCheck(ctx, &gcpConnector)
+	if err == nil {
+		return diags
+	}
+	if errors.Is(err, connector.ErrAccessTokenExpired) {
+		diags.AddError("Access token expired",
+			fmt.Sprintf("The GOOGLE_OAUTH_ACCESS_TOKEN access token was refused while checking bucket %s: renew it, or set access_token_fallback to switch to the application default credentials.\n%s", p.ReferentialBucket.ValueString(), details))
+		return diags
+	}
+	if connector.IsPermissionDenied(err) {
+		diags.AddError("Missing permissions on the referential_bucket",
+			fmt.Sprintf("The credentials of the provider cannot write, lock and delete objects in bucket %s (locks in bucket %s): grant them storage.objects.create, storage.objects.get and storage.objects.delete, for example with roles/storage.objectUser, "+
+				"check that referential_bucket names the right bucket, or set skip_permission_check to disable this check.\n%s", p.ReferentialBucket.ValueString(), p.lockBucketName(), details))
+		return diags
+	}
+	diags.AddError("Cannot use the referential_bucket",
+		fmt.Sprintf("The provider failed to write, lock and delete a temporary object in bucket %s (locks in bucket %s), set skip_permission_check to disable this check.\n%s", p.ReferentialBucket.ValueString(), p.lockBucketName(), details))
+	return diags
+}
+
+// idPoolPath returns the path of the object backing the given id_pool, namespaced by the tenant if any.
+func (p *GCSReferentialProviderModel) idPoolPath(poolName string) string {
+	return fmt.Sprintf("%s/%s", p.idPoolDir(), poolName)
+}
+
+// idPoolDir returns the folder holding the objects of the id_pools, under the tenant when one is set.
+func (p *GCSReferentialProviderModel) idPoolDir() string {
+	return p.resourceDir(idPoolResourceName)
+}
+
+// sequencePath returns the path of the object backing the given sequence, namespaced by the tenant if any.
+func (p *GCSReferentialProviderModel) sequencePath(sequenceName string) string {
+	return fmt.Sprintf("%s/%s", p.resourceDir(sequenceResourceName), sequenceName)
+}
+
+// namedLockPath returns the path of the object whose lock is the given lock ephemeral resource, namespaced by the
+// tenant if any. The object itself is never written, only its lock.
+func (p *GCSReferentialProviderModel) namedLockPath(lockName string) string {
+	return fmt.Sprintf("%s/%s", p.resourceDir(lockEphemeralResourceName), lockName)
+}
+
+// resourceDir returns the folder holding the objects of the given resource type, under the tenant when one is set.
+func (p *GCSReferentialProviderModel) resourceDir(resourceName string) string {
+	if tenant := p.Tenant.ValueString(); tenant != "" {
+		return fmt.Sprintf("%s/%s/%s", ProviderName, tenant, resourceName)
+	}
+	return fmt.Sprintf("%s/%s", ProviderName, resourceName)
+}
+
+// genericConnector returns a connector on the given object of the referential bucket, with the settings of the provider.
+func (p *GCSReferentialProviderModel) genericConnector(fullFilePath string) connector.GcpConnectorGeneric {
+	gcpConnector := connector.NewGeneric(p.ReferentialBucket.ValueString(), fullFilePath)
+	p.setConnectorSettings(&gcpConnector)
+	return gcpConnector
+}
+
+// idPoolConnector returns a connector on the object backing the given id_pool.
+func (p *GCSReferentialProviderModel) idPoolConnector(poolName string) connector.GcpConnectorGeneric {
+	return p.genericConnector(p.idPoolPath(poolName))
+}
+
+// networkConnector returns a connector on the object backing the given base_cidr.
+func (p *GCSReferentialProviderModel) networkConnector(baseCidr string) connector.GcpConnectorNetwork {
+	gcpConnector := connector.NewNetwork(p.ReferentialBucket.ValueString(), baseCidr)
+	p.setConnectorSettings(&gcpConnector.GcpConnectorGeneric)
+	return gcpConnector
+}
+
+// lockBucketName returns the bucket holding the locks, the lock_bucket when set, the referential_bucket otherwise.
+func (p *GCSReferentialProviderModel) lockBucketName() string {
+	if lockBucket := p.LockBucket.ValueString(); lockBucket != "" {
+		return lockBucket
+	}
+	return p.ReferentialBucket.ValueString()
+}
+
+// setConnectorSettings applies the lock_prefix, lock_bucket, lock_ttl_minutes, fail_if_locked, skip_lock, storage_endpoint, encryption_key
+// and object_metadata of the provider to gcpConnector, with the provider version the objects are checked against.
+func (p *GCSReferentialProviderModel) setConnectorSettings(gcpConnector *connector.GcpConnectorGeneric) {
+	gcpConnector.ProviderVersion = p.ProviderVersion
+	gcpConnector.MinProviderVersion = objectFormatMinProviderVersion
+	gcpConnector.LockPrefix = p.LockPrefix.ValueString()
+	gcpConnector.LockBucket = p.LockBucket.ValueString()
+	gcpConnector.LockTTL = time.Duration(p.LockTtlMinutes.ValueInt64()) * time.Minute
+	gcpConnector.FailIfLocked = p.FailIfLocked.ValueBool()
+	gcpConnector.SkipLock = p.SkipLock.ValueBool()
+	gcpConnector.StorageEndpoint = p.StorageEndpoint.ValueString()
+	gcpConnector.EncryptionKey = p.EncryptionKeyBytes
+	gcpConnector.ObjectMetadata = p.ObjectMetadataValues
 }
 
 func (p *GCSReferentialProvider) Resources(ctx context.Context) []func() resource.Resource {
 	return []func() resource.Resource{
 		NewIdPoolResource,
 		NewIdRequestResource,
+		NewIdTransferResource,
+		NewIdPoolReservationsResource,
 		NewNetworkRequestResource,
+		NewSequenceResource,
 	}
 
 }
 
 // DataSources implements provider.Provider.
 func (p *GCSReferentialProvider) DataSources(context.Context) []func() datasource.DataSource {
-	/*return []func() datasource.DataSource{}*/
-	return nil
+	return []func() datasource.DataSource{
+		NewHealthCheckDataSource,
+		NewIdPoolVersionsDataSource,
+		NewIdPoolMembersDataSource,
+		NewOrphanedLocksDataSource,
+		NewExportDataSource,
+		NewNetworkDataSource,
+		NewNetworkOwnerDataSource,
+		NewNetworkFreeSubnetsDataSource,
+		NewAllocationMetricsDataSource,
+		NewProviderConfigDataSource,
+	}
+}
+
+// EphemeralResources implements provider.ProviderWithEphemeralResources.
+func (p *GCSReferentialProvider) EphemeralResources(context.Context) []func() ephemeral.EphemeralResource {
+	return []func() ephemeral.EphemeralResource{
+		NewLockEphemeralResource,
+	}
+}
+
+// Functions implements provider.ProviderWithFunctions.
+func (p *GCSReferentialProvider) Functions(context.Context) []func() function.Function {
+	return []func() function.Function{
+		NewNextSubnetFunction,
+		NewPoolValueUsedFunction,
+	}
 }
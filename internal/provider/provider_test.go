@@ -4,7 +4,20 @@
 package provider
 
 import (
+	"context"
+	"errors"
 	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
 )
 
 // providerFactories are used to instantiate a provider during acceptance testing.
@@ -17,3 +30,205 @@ func TestProvider(t *testing.T) {
 		t.Fatal("Failed to instantiate provider")
 	}
 }
+
+func TestSharedIdPoolsCache(t *testing.T) {
+	provider := func(bucket string) *GCSReferentialProviderModel {
+		return &GCSReferentialProviderModel{ReferentialBucket: types.StringValue(bucket), StorageEndpoint: types.StringNull()}
+	}
+	first := getSharedIdPoolsCache(provider("test-shared-cache-bucket"))
+	second := getSharedIdPoolsCache(provider("test-shared-cache-bucket"))
+	otherEndpoint := provider("test-shared-cache-bucket")
+	otherEndpoint.StorageEndpoint = types.StringValue("https://storage.example.com")
+	encrypted := provider("test-shared-cache-bucket")
+	encrypted.EncryptionKeyBytes = []byte("0123456789abcdef0123456789abcdef")
+
+	if first.mutex != second.mutex {
+		t.Fatal("providers on the same bucket must share the cache mutex")
+	}
+	first.pools["pool"] = &CachedIdPool{Generation: 42}
+	if cached, ok := second.pools["pool"]; !ok || cached.Generation != 42 {
+		t.Fatal("providers on the same bucket must share the cached pools")
+	}
+	for name, p := range map[string]*GCSReferentialProviderModel{"bucket": provider("test-shared-cache-other-bucket"), "storage_endpoint": otherEndpoint, "encryption_key": encrypted} {
+		if _, ok := getSharedIdPoolsCache(p).pools["pool"]; ok {
+			t.Errorf("providers with another %s must not share the cached pools", name)
+		}
+	}
+}
+
+func TestSharedIdPoolsCache_encryptionKey(t *testing.T) {
+	gcstest.NewServer(t)
+	ctx := context.Background()
+	alias := func(key string) *GCSReferentialProviderModel {
+		p := newTestProviderData()
+		if key != "" {
+			p.EncryptionKeyBytes = []byte(key)
+		}
+		cache := getSharedIdPoolsCache(p)
+		p.IdPoolsCache = cache.pools
+		p.CacheMutex = cache.mutex
+		return p
+	}
+	keyed := alias("0123456789abcdef0123456789abcdef")
+	createTestIdPool(t, keyed, "shared-cache-encrypted", 1, 10)
+	gcpConnector := keyed.idPoolConnector("shared-cache-encrypted")
+	if _, err := getAndCacheIdPool(ctx, keyed, "shared-cache-encrypted", &gcpConnector); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool decrypted by the first alias is cached, the others must still be refused it.
+	for name, p := range map[string]*GCSReferentialProviderModel{"another key": alias("fedcba9876543210fedcba9876543210"), "no key": alias("")} {
+		gcpConnector := p.idPoolConnector("shared-cache-encrypted")
+		if _, err := getAndCacheIdPool(ctx, p, "shared-cache-encrypted", &gcpConnector); !errors.Is(err, connector.ErrEncryptionKeyMismatch) {
+			t.Errorf("%s: expected an encryption key mismatch, got %v", name, err)
+		}
+	}
+}
+
+func TestIdPoolPath(t *testing.T) {
+	p := &GCSReferentialProviderModel{}
+	if got := p.idPoolPath("pool"); got != "gcsreferential/id_pool/pool" {
+		t.Fatalf("unexpected path without tenant: %s", got)
+	}
+	p.Tenant = types.StringValue("team-a")
+	if got := p.idPoolPath("pool"); got != "gcsreferential/team-a/id_pool/pool" {
+		t.Fatalf("unexpected path with tenant: %s", got)
+	}
+}
+
+func TestCheckBucketPermissions(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	if diags := checkBucketPermissions(ctx, p); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if leftovers, err := connector.ListChildren(ctx, "", testBucket, ProviderName+"/healthcheck"); err != nil || len(leftovers) != 0 {
+		t.Fatalf("expected the probe object to be deleted, got %v: %v", leftovers, err)
+	}
+
+	server.SetReadOnly(testBucket)
+	diags := checkBucketPermissions(ctx, p)
+	if !diags.HasError() || diags[0].Summary() != "Missing permissions on the referential_bucket" {
+		t.Fatalf("expected a missing permissions error, got %v", diags)
+	}
+}
+
+func TestConfigureNamedReferentials(t *testing.T) {
+	server := gcstest.NewServer(t)
+	ctx := context.Background()
+	p := newTestProviderData()
+	p.SkipPermissionCheck = types.BoolValue(true)
+	referentialType := types.ObjectType{AttrTypes: map[string]attr.Type{"bucket": types.StringType, "tenant": types.StringType}}
+	referentials, diags := types.MapValueFrom(ctx, referentialType, map[string]namedReferentialModel{
+		"eu": {Bucket: types.StringValue("eu-bucket"), Tenant: types.StringNull()},
+		"us": {Bucket: types.StringValue("us-bucket"), Tenant: types.StringValue("team")},
+	})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	p.Referentials = referentials
+	if diags := configureNamedReferentials(ctx, p); diags.HasError() {
+		t.Fatal(diags)
+	}
+
+	if defaultReferential, diags := p.referential(types.StringNull()); diags.HasError() || defaultReferential != p {
+		t.Fatalf("expected the provider itself without referential, got %v", diags)
+	}
+	if _, diags := p.referential(types.StringValue("asia")); !diags.HasError() {
+		t.Fatal("expected an error for an undeclared referential")
+	}
+	us, diags := p.referential(types.StringValue("us"))
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if us.ReferentialBucket.ValueString() != "us-bucket" || us.idPoolPath("pool") != "gcsreferential/team/id_pool/pool" {
+		t.Fatalf("unexpected referential us: bucket %s, pool path %s", us.ReferentialBucket.ValueString(), us.idPoolPath("pool"))
+	}
+
+	// The same pool name lives independently in each referential.
+	eu, _ := p.referential(types.StringValue("eu"))
+	if eu.Batchers == p.Batchers || eu.CacheMutex == p.CacheMutex {
+		t.Fatal("expected each referential to have its own batchers and cache")
+	}
+	createTestIdPool(t, p, "pool", 1, 10)
+	createTestIdPool(t, eu, "pool", 1, 10)
+	if result := allocateInBatch(ctx, eu, "pool", &allocationRequest{member: "a"}); result.diags.HasError() {
+		t.Fatal(result.diags)
+	}
+	var euPool, defaultPool StoredIdPool
+	euConnector, defaultConnector := eu.idPoolConnector("pool"), p.idPoolConnector("pool")
+	if err := euConnector.Read(ctx, &euPool); err != nil || len(euPool.Members) != 1 {
+		t.Fatalf("expected the member in the pool of referential eu, got %v: %v", euPool.Members, err)
+	}
+	if err := defaultConnector.Read(ctx, &defaultPool); err != nil || len(defaultPool.Members) != 0 {
+		t.Fatalf("expected the pool of referential_bucket to be left untouched, got %v: %v", defaultPool.Members, err)
+	}
+	if _, ok := server.Get("eu-bucket", "gcsreferential/id_pool/pool"); !ok {
+		t.Fatal("expected the pool of referential eu in its bucket")
+	}
+}
+
+func TestConfigureNamedReferentials_lockBucket(t *testing.T) {
+	ctx := context.Background()
+	referentialType := types.ObjectType{AttrTypes: map[string]attr.Type{"bucket": types.StringType, "tenant": types.StringType}}
+	configure := func(referentials map[string]namedReferentialModel) diag.Diagnostics {
+		p := newTestProviderData()
+		p.SkipPermissionCheck = types.BoolValue(true)
+		p.LockBucket = types.StringValue("lock-bucket")
+		value, diags := types.MapValueFrom(ctx, referentialType, referentials)
+		if diags.HasError() {
+			t.Fatal(diags)
+		}
+		p.Referentials = value
+		return configureNamedReferentials(ctx, p)
+	}
+
+	// The objects of the same name in two buckets would share their lock object.
+	if diags := configure(map[string]namedReferentialModel{"eu": {Bucket: types.StringValue("eu-bucket"), Tenant: types.StringNull()}}); !diags.HasError() {
+		t.Fatal("expected a referential on another bucket to be rejected with lock_bucket")
+	}
+	if diags := configure(map[string]namedReferentialModel{"team": {Bucket: types.StringValue(testBucket), Tenant: types.StringValue("team")}}); diags.HasError() {
+		t.Fatal(diags)
+	}
+}
+
+// nullTimeouts is the timeouts block of the fixtures, unset.
+var nullTimeouts = timeouts.Value{Object: types.ObjectNull(map[string]attr.Type{
+	"create": types.StringType,
+	"read":   types.StringType,
+	"update": types.StringType,
+	"delete": types.StringType,
+})}
+
+// newPlan returns a plan on the schema of r, set to model, or null when model is nil.
+func newPlan(tb testing.TB, r fwresource.Resource, model any) tfsdk.Plan {
+	schema, raw := nullResourceValue(r)
+	plan := tfsdk.Plan{Schema: schema, Raw: raw}
+	if model != nil {
+		if diags := plan.Set(context.Background(), model); diags.HasError() {
+			tb.Fatal(diags)
+		}
+	}
+	return plan
+}
+
+// newState returns a state on the schema of r, set to model, or null when model is nil.
+func newState(tb testing.TB, r fwresource.Resource, model any) tfsdk.State {
+	schema, raw := nullResourceValue(r)
+	state := tfsdk.State{Schema: schema, Raw: raw}
+	if model != nil {
+		if diags := state.Set(context.Background(), model); diags.HasError() {
+			tb.Fatal(diags)
+		}
+	}
+	return state
+}
+
+// nullResourceValue returns the schema of r with a null value of its type.
+func nullResourceValue(r fwresource.Resource) (schema.Schema, tftypes.Value) {
+	ctx := context.Background()
+	schemaResp := &fwresource.SchemaResponse{}
+	r.Schema(ctx, fwresource.SchemaRequest{}, schemaResp)
+	return schemaResp.Schema, tftypes.NewValue(schemaResp.Schema.Type().TerraformType(ctx), nil)
+}
@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// lockedRand is a random source safe for concurrent use by the resources of a provider.
+type lockedRand struct {
+	mutex sync.Mutex
+	rand  *rand.Rand
+}
+
+func newLockedRand(seed int64) *lockedRand {
+	return &lockedRand{rand: rand.New(rand.NewSource(seed))}
+}
+
+// Intn returns a random number in [0, n).
+func (r *lockedRand) Intn(n int) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.Intn(n)
+}
+
+// Int63n returns a random number in [0, n).
+func (r *lockedRand) Int63n(n int64) int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.rand.Int63n(n)
+}
@@ -2,19 +2,28 @@ package provider
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
+	"strings"
 	"time"
 
 	"cloud.google.com/go/storage"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
 	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
@@ -24,6 +33,7 @@ import (
 // Ensure provider defined types fully satisfy framework interfaces.
 var _ resource.Resource = &IdPoolResource{}
 var _ resource.ResourceWithImportState = &IdPoolResource{}
+var _ resource.ResourceWithModifyPlan = &IdPoolResource{}
 
 const idPoolResourceName = "id_pool"
 
@@ -36,11 +46,46 @@ type IdPoolResource struct {
 }
 
 type IdPoolResourceModel struct {
-	Id           types.String `tfsdk:"id"`
-	Name         types.String `tfsdk:"name"`
-	StartFrom    types.Int64  `tfsdk:"start_from"`
-	EndTo        types.Int64  `tfsdk:"end_to"`
-	Reservations types.Map    `tfsdk:"reservations"`
+	Id                types.String `tfsdk:"id"`
+	Name              types.String `tfsdk:"name"`
+	Referential       types.String `tfsdk:"referential"`
+	StartFrom         types.Int64  `tfsdk:"start_from"`
+	EndTo             types.Int64  `tfsdk:"end_to"`
+	Reservations      types.Map    `tfsdk:"reservations"`
+	UsedCount         types.Int64  `tfsdk:"used_count"`
+	ExternallyManaged types.Map    `tfsdk:"externally_managed"`
+	NoReuse           types.Bool   `tfsdk:"no_reuse"`
+	ReserveSentinel   types.Bool   `tfsdk:"reserve_sentinel"`
+	Direction         types.String `tfsdk:"direction"`
+	IdPattern         types.String `tfsdk:"id_pattern"`
+	LabelTemplate     types.String `tfsdk:"label_template"`
+	OutputFormat      types.String `tfsdk:"output_format"`
+	OutputWidth       types.Int64  `tfsdk:"output_width"`
+	BurstFrom         types.Int64  `tfsdk:"burst_from"`
+	MirrorPath        types.String `tfsdk:"mirror_flat_output_path"`
+	Partitions        types.Map    `tfsdk:"partitions"`
+	MinGap            types.Int64  `tfsdk:"min_gap"`
+	CooldownDays      types.Int64  `tfsdk:"cooldown_days"`
+	// AllocationStrategy is stored in the pool object with the release times it needs.
+	AllocationStrategy types.String `tfsdk:"allocation_strategy"`
+	// AccessTrackingIntervalMinutes only drives the refreshes, it is not stored in the pool object.
+	AccessTrackingIntervalMinutes types.Int64  `tfsdk:"access_tracking_interval_minutes"`
+	LastAccessed                  types.String `tfsdk:"last_accessed"`
+	// PreventRename only guards the updates of the resource, it is not stored in the pool object.
+	PreventRename types.Bool `tfsdk:"prevent_rename"`
+	// CleanupLockOnDelete only drives the deletion, it is not stored in the pool object.
+	CleanupLockOnDelete types.Bool `tfsdk:"cleanup_lock_on_delete"`
+	// ImportMembersJson only seeds the members at creation.
+	ImportMembersJson types.String   `tfsdk:"import_members_json"`
+	ContentHash       types.String   `tfsdk:"content_hash"`
+	FreeRanges        types.List     `tfsdk:"free_ranges"`
+	Timeouts          timeouts.Value `tfsdk:"timeouts"`
+}
+
+// idRangeAttrTypes is the object type of a free_ranges element.
+var idRangeAttrTypes = map[string]attr.Type{
+	"from": types.Int64Type,
+	"to":   types.Int64Type,
 }
 
 func (r *IdPoolResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -60,10 +105,17 @@ func (r *IdPoolResource) Schema(ctx context.Context, req resource.SchemaRequest,
 				},
 			},
 			"name": schema.StringAttribute{
-				MarkdownDescription: "The name of the pool, it must be unique for the provider. If you change it, the pool will be destroyed and recreate",
+				MarkdownDescription: "The name of the pool, it must be unique for the provider. If you change it, the pool object is renamed in place with all its members and settings, the new name must not be used by another pool. The id_request whose pool references this name follow the rename and keep their id",
 				Optional:            false,
 				Required:            true,
 			},
+			"referential": schema.StringAttribute{
+				MarkdownDescription: "The name of one of the referentials of the provider to store the pool in, instead of referential_bucket. If you change it, the id_pool will be destroyed and recreate. Default to referential_bucket",
+				Optional:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
 			"start_from": schema.Int64Attribute{
 				MarkdownDescription: "The first id of the created pool, if you not set it it will be set to 1",
 				Optional:            true,
@@ -71,17 +123,171 @@ func (r *IdPoolResource) Schema(ctx context.Context, req resource.SchemaRequest,
 				Computed:            true,
 			},
 			"end_to": schema.Int64Attribute{
-				MarkdownDescription: "The last id of the created pool, if you not set it it will be set to 9223372036854775807",
+				MarkdownDescription: "The last id of the created pool, if you not set it it will be set to 9223372036854775807. A pool of more than 1048576 ids, such as the default one, only stores its members: its free ids are derived from them rather than kept in the pool object, and an allocation with a value_filter examines at most 1048576 free ids. Shrinking the range of an existing pool warns at plan time about the members holding an id outside of the new range, the update fails unless they are released first",
 				Optional:            true,
 				Default:             int64default.StaticInt64(9223372036854775807),
 				Computed:            true,
 			},
+			"no_reuse": schema.BoolAttribute{
+				MarkdownDescription: "If true, an id released by an id_request is never allocated again, the allocations only move forward in the pool. Be aware that this permanently consumes the capacity of the pool. Default to false",
+				Optional:            true,
+				Default:             booldefault.StaticBool(false),
+				Computed:            true,
+			},
+			"reserve_sentinel": schema.BoolAttribute{
+				MarkdownDescription: "If true, start_from is never allocated, for the integrations where the first id, such as 0, means unassigned. It still counts in the range of the pool: a pool from 0 to 9 keeps 9 ids to allocate. " +
+					"The sentinel follows start_from when it changes, the previous one becomes a regular id. It cannot be set while a member holds start_from. Default to false",
+				Optional: true,
+				Default:  booldefault.StaticBool(false),
+				Computed: true,
+			},
+			"direction": schema.StringAttribute{
+				MarkdownDescription: "The order of the allocations in the pool: `asc` (default) or `desc`, where the highest free id is allocated first and the allocations proceed downward from end_to, for referentials numbered from a ceiling. " +
+					"With no_reuse, a desc pool never allocates again an id above the lowest one ever allocated, so it must be extended by lowering start_from. " +
+					"The direction of a no_reuse pool cannot be changed once an id was allocated",
+				Optional: true,
+				Default:  stringdefault.StaticString(idPoolDirectionAsc),
+				Computed: true,
+			},
+			"id_pattern": schema.StringAttribute{
+				MarkdownDescription: "An optional regular expression, in Go RE2 syntax, the id of every id_request made on the pool must match, for example `^svc-[a-z0-9]+$`. It is checked when an id_request is created or renamed, the existing reservations are kept when it changes",
+				Optional:            true,
+			},
+			"label_template": schema.StringAttribute{
+				MarkdownDescription: "An optional Go text/template rendered into the label of each id_request made on the pool, from the allocated `.Value` and the id of the id_request as `.Name`, for example `node-{{.Value}}` or `host-{{printf \"%04d\" .Value}}`. " +
+					"The label is stored with the reservation and kept as is when the template changes, the id_requests made before the template was set get a label on their next update",
+				Optional: true,
+			},
+			"output_format": schema.StringAttribute{
+				MarkdownDescription: "The format of the requested_id_formatted of the id_requests made on the pool: `dec` (default) or `hex`, rendered with a `0x` prefix, for example for device names. The ids are stored as numbers whatever the format",
+				Optional:            true,
+				Default:             stringdefault.StaticString(idOutputFormatDec),
+				Computed:            true,
+			},
+			"output_width": schema.Int64Attribute{
+				MarkdownDescription: "The number of digits the requested_id_formatted of the id_requests made on the pool is zero-padded to, the `0x` prefix excluded, for example 4 to render the id 163 as `0x00a3` in hex. It must be wide enough for end_to. Default to 0, no padding",
+				Optional:            true,
+				Default:             int64default.StaticInt64(0),
+				Computed:            true,
+			},
+			"burst_from": schema.Int64Attribute{
+				MarkdownDescription: "The first id of the burst region of the pool, from burst_from to end_to, kept as emergency capacity: only the id_requests with allow_burst are allocated an id in it, the others are allocated below burst_from and fail once it is exhausted. " +
+					"It must be in the range of the pool, above start_from. A change keeps the existing reservations. Default to no burst region",
+				Optional: true,
+			},
+			"mirror_flat_output_path": schema.StringAttribute{
+				MarkdownDescription: "The path of an object of the referential_bucket where the members of the pool are mirrored as a flat JSON object of member names to ids, for example `exports/vlans.json`, for the consumers reading the ids outside of Terraform without depending on the format of the pool object. " +
+					"The mirror is written under the lock of the pool after each allocation, update and release. A failed write of the mirror is a warning, it is written again by the next change of the pool. " +
+					"It is deleted with the pool, or when the path changes. It must be out of the `gcsreferential/` folder. Default to no mirror",
+				Optional: true,
+			},
+			"partitions": schema.MapNestedAttribute{
+				MarkdownDescription: "Named subranges of the pool, for example `reserved` and `dynamic`, an id_request with partition only being allocated an id of its partition, under the lock of the single pool. " +
+					"The partitions must be in the range of the pool and must not overlap, the ids out of any partition stay allocated to the id_requests without partition. " +
+					"A change keeps the existing reservations, even out of their partition. Default to no partition",
+				Optional: true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"from": schema.Int64Attribute{
+							MarkdownDescription: "The first id of the partition",
+							Required:            true,
+						},
+						"to": schema.Int64Attribute{
+							MarkdownDescription: "The last id of the partition",
+							Required:            true,
+						},
+					},
+				},
+			},
+			"min_gap": schema.Int64Attribute{
+				MarkdownDescription: "The smallest distance between a newly allocated id and the id of any member of the pool, for example 8 to keep the ids of a hashed system at least 8 apart. " +
+					"The pool then allocates the lowest free id far enough from every member, the highest one with direction `desc`, and the id_request fails when none is left. " +
+					"A change applies to the next allocations, the existing reservations are kept. Default to 0, the ids are not spaced",
+				Optional: true,
+				Default:  int64default.StaticInt64(0),
+				Computed: true,
+			},
+			"cooldown_days": schema.Int64Attribute{
+				MarkdownDescription: "The number of days an id released by an id_request is kept out of the allocations, for example to avoid collisions in caches or DNS records still holding the previous owner. " +
+					"The released ids are quarantined in the pool object with their release time and become available again on the first read or allocation after the cooldown has elapsed. " +
+					"A change applies to the ids already quarantined, 0 releases them. It has no effect with no_reuse. Default to 0, the released ids are available right away",
+				Optional: true,
+				Default:  int64default.StaticInt64(0),
+				Computed: true,
+			},
+			"allocation_strategy": schema.StringAttribute{
+				MarkdownDescription: "The strategy choosing the free id allocated to an id_request: `first_free` (default), the first free id in the order of direction, or `oldest_free`, the id free the longest, to avoid collisions with caches or external TTLs still holding a recently released id. " +
+					"An oldest_free pool keeps the release time of every released id in the pool object: the ids never held by a member are allocated first, in the order of direction, then the released ids from the least recently released one. " +
+					"A quarantined id counts as released when it left its member. The release times are recorded from the switch to oldest_free, the ids released before count as never held, and they are dropped when the pool returns to first_free. " +
+					"It has no effect with no_reuse and takes precedence over the random allocation of the provider",
+				Optional: true,
+				Default:  stringdefault.StaticString(idAllocationStrategyFirstFree),
+				Computed: true,
+			},
+			"import_members_json": schema.StringAttribute{
+				MarkdownDescription: "An optional JSON object of member names to ids, for example `jsonencode({ \"svc-a\" = 12 })`, used to seed the reservations of the pool when it is created, in the same write, to migrate an existing referential. " +
+					"Every id must be in the range of the pool and held by a single member, and the names must match id_pattern. The seeded members can then be adopted by importing id_request resources. It is ignored after the creation",
+				Optional: true,
+			},
+			"free_ranges": schema.ListNestedAttribute{
+				MarkdownDescription: "The ids still available in the pool, summarized as contiguous ranges, it is a readonly field",
+				Computed:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"from": schema.Int64Attribute{
+							MarkdownDescription: "The first free id of the range",
+							Computed:            true,
+						},
+						"to": schema.Int64Attribute{
+							MarkdownDescription: "The last free id of the range",
+							Computed:            true,
+						},
+					},
+				},
+			},
+			"access_tracking_interval_minutes": schema.Int64Attribute{
+				MarkdownDescription: "If set, each refresh of the id_pool records the time of the access in the pool object as last_accessed, at most once per this number of minutes, to identify the pools no longer used. " +
+					"It turns some refreshes into a write of the pool object under its lock. Default to null, the accesses are not recorded",
+				Optional: true,
+			},
+			"prevent_rename": schema.BoolAttribute{
+				MarkdownDescription: "If true, a change of name is rejected instead of renaming the pool object, to protect a shared pool from an accidental rename. " +
+					"It is checked against both the previous and the new configuration: set it to false in an apply of its own before renaming on purpose, or create a new pool and migrate its members explicitly. Default to false",
+				Optional: true,
+			},
+			"cleanup_lock_on_delete": schema.BoolAttribute{
+				MarkdownDescription: "If true, the deletion of the pool also deletes the lock object of the pool when one is left after it, usually by a crashed run or because skip_lock is set, so that a future pool of the same name does not inherit it. " +
+					"Only the lock object of this pool is deleted, and only when it was written before the deletion started: a lock taken since then by another run is kept. The cleanup is best-effort, a failure is reported as a warning. Default to false",
+				Optional: true,
+			},
+			"last_accessed": schema.StringAttribute{
+				MarkdownDescription: "The last time, as a RFC3339 timestamp, a refresh of an id_pool with access_tracking_interval_minutes recorded an access to the pool, it is a readonly field. Null when no access was ever recorded",
+				Computed:            true,
+			},
+			"content_hash": schema.StringAttribute{
+				MarkdownDescription: "The SHA-256 of the canonical JSON of the pool object, it is a readonly field. It changes on refresh whenever the object was modified outside of this resource, by an id_request or by hand, a single value to watch for drift",
+				Computed:            true,
+			},
+			"externally_managed": schema.MapAttribute{
+				MarkdownDescription: "The ids reserved by hand outside of terraform, by name, it is a readonly field. They are read from the `externally_managed` JSON object of the pool object, for example `\"externally_managed\": {\"emergency-fw\": 42}`, which is only edited in the object itself while no apply is running on the pool. " +
+					"The provider never allocates these ids to an id_request, even once released by a member, and never removes them, they are kept through every update of the pool",
+				ElementType: types.Int64Type,
+				Computed:    true,
+			},
 			"reservations": schema.MapAttribute{
-				MarkdownDescription: "The existing reservation made on this pool, it is a readonly field",
-				ElementType:         types.Int64Type,
+				MarkdownDescription: "The existing reservation made on this pool, it is a readonly field. It is read from the referential_bucket on refresh: the id_request created or destroyed in an apply show up on the next plan. " +
+					"Null when the pool holds more members than the max_state_reservations of the provider, only used_count is then recorded",
+				ElementType: types.Int64Type,
+				Computed:    true,
+			},
+			"used_count": schema.Int64Attribute{
+				MarkdownDescription: "The number of members of the pool, it is a readonly field. It is recorded even when the reservations are left out of the state by max_state_reservations",
 				Computed:            true,
 			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
 	}
 }
 
@@ -105,57 +311,103 @@ func (r *IdPoolResource) Create(ctx context.Context, req resource.CreateRequest,
 		return
 	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Name.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := p.idPoolConnector(data.Name.ValueString())
 
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool create error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool create error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Name.ValueString(), err.Error()))
-		}
-	}()
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", data.Name.ValueString()), &resp.Diagnostics)
 
 	// Use the caching helper to check for existence.
-	_, err = getAndCacheIdPool(ctx, r.providerData, data.Name.ValueString(), &gcpConnector)
+	_, err = getAndCacheIdPool(ctx, p, data.Name.ValueString(), &gcpConnector)
 	if err == nil {
 		resp.Diagnostics.AddError(
 			"id_pool create error",
-			fmt.Sprintf("Pool '%s' already exists. To manage this existing pool, please import it.", data.Name.ValueString()),
+			objectDetail(&gcpConnector, "Pool '%s' already exists. To manage this existing pool, please import it.", data.Name.ValueString()),
 		)
 		return
 	}
 	if !errors.Is(err, storage.ErrObjectNotExist) {
-		resp.Diagnostics.AddError("id_pool create error", fmt.Sprintf("Failed to check for existing pool '%s': %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool create error", objectDetail(&gcpConnector, "Failed to check for existing pool '%s': %s", data.Name.ValueString(), err.Error()))
 		return
 	}
 
-	pool := *IdPoolTools.NewIDPool(IdPoolTools.ID(data.StartFrom.ValueInt64()), IdPoolTools.ID(data.EndTo.ValueInt64()))
+	pool := newStoredIdPool(IdPoolTools.ID(data.StartFrom.ValueInt64()), IdPoolTools.ID(data.EndTo.ValueInt64()))
+	pool.NoReuse = data.NoReuse.ValueBool()
+	pool.ReserveSentinel = data.ReserveSentinel.ValueBool()
+	if pool.ReserveSentinel {
+		pool.Remove(pool.StartFrom)
+	}
+	resp.Diagnostics.Append(setPoolDirection(pool, data.Direction)...)
+	resp.Diagnostics.Append(setPoolIdPattern(pool, data.IdPattern)...)
+	resp.Diagnostics.Append(setPoolLabelTemplate(pool, data.LabelTemplate)...)
+	resp.Diagnostics.Append(setPoolOutputFormat(pool, data.OutputFormat, data.OutputWidth, pool.EndTo)...)
+	resp.Diagnostics.Append(setPoolBurstFrom(pool, data.BurstFrom, pool.StartFrom, pool.EndTo)...)
+	resp.Diagnostics.Append(setPoolPartitions(ctx, pool, data.Partitions, pool.StartFrom, pool.EndTo)...)
+	resp.Diagnostics.Append(setPoolMirrorPath(pool, data.MirrorPath)...)
+	resp.Diagnostics.Append(setPoolMinGap(pool, data.MinGap)...)
+	resp.Diagnostics.Append(setPoolCooldownDays(pool, data.CooldownDays)...)
+	resp.Diagnostics.Append(setPoolAllocationStrategy(pool, data.AllocationStrategy)...)
+	resp.Diagnostics.Append(checkAccessTrackingInterval(data.AccessTrackingIntervalMinutes)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if !data.AccessTrackingIntervalMinutes.IsNull() {
+		now := time.Now().UTC()
+		pool.LastAccessed = &now
+	}
 	if !pool.IsValid() {
 		resp.Diagnostics.AddError("id_pool create error", "Invalid pool, please check start_from and end_to")
 		return
 	}
+	if !data.ImportMembersJson.IsNull() {
+		var members map[string]IdPoolTools.ID
+		if err := json.Unmarshal([]byte(data.ImportMembersJson.ValueString()), &members); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("import_members_json"), "id_pool create error", fmt.Sprintf("import_members_json must be a JSON object of member names to ids: %s", err.Error()))
+			return
+		}
+		if err := pool.seedMembers(members, time.Now()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("import_members_json"), "id_pool create error", fmt.Sprintf("Cannot seed pool %s from import_members_json: %s", data.Name.ValueString(), err.Error()))
+			return
+		}
+	}
 
 	// The connector's generation is -1 because Read failed. This will cause Write to use DoesNotExist condition.
-	err = gcpConnector.Write(ctx, &pool)
+	err = gcpConnector.Write(ctx, pool)
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool create error", fmt.Sprintf("Cannot save id_pool on referential_bucket: %s", err.Error()))
+		addPoolWriteError(&resp.Diagnostics, "id_pool create error", data.Name.ValueString(), &gcpConnector, err)
 		return
 	}
+	writePoolMirror(ctx, p, data.Name.ValueString(), pool, &resp.Diagnostics)
 
 	// After a successful write, the pool is created. We can warm up the cache.
 	// The lock is still held, so this is safe.
-	if _, err := getAndCacheIdPool(ctx, r.providerData, data.Name.ValueString(), &gcpConnector); err != nil {
+	if _, err := getAndCacheIdPool(ctx, p, data.Name.ValueString(), &gcpConnector); err != nil {
 		tflog.Warn(ctx, fmt.Sprintf("Failed to warm cache for pool %s after creation: %s", data.Name.ValueString(), err.Error()))
 		resp.Diagnostics.AddWarning("id_pool create warning", fmt.Sprintf("Failed to warm cache for pool %s after creation: %s", data.Name.ValueString(), err.Error()))
 	}
 
 	data.Id = data.Name
-	emptyGoMap := map[string]attr.Value{}
-	data.Reservations, _ = types.MapValue(types.Int64Type, emptyGoMap)
+	data.ContentHash = types.StringValue(gcpConnector.ContentHash)
+	err = idPoolFromToolToModel(&data, pool, p)
+	if err != nil {
+		resp.Diagnostics.AddError("id_pool create error", fmt.Sprintf("Failed to process pool data for %s: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -168,19 +420,46 @@ func (r *IdPoolResource) Read(ctx context.Context, req resource.ReadRequest, res
 		return
 	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Name.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Read)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := p.idPoolConnector(data.Name.ValueString())
 
-	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.Name.ValueString(), &gcpConnector)
+	cachedPool, err := getAndCacheIdPool(ctx, p, data.Name.ValueString(), &gcpConnector)
+	if errors.Is(err, connector.ErrEncryptionKeyMismatch) {
+		// The pool exists, it must not be forgotten because of a wrong key.
+		resp.Diagnostics.AddError("id_pool read error", objectDetail(&gcpConnector, "Cannot read pool %s, check the encryption_key of the provider: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
+	if errors.Is(err, connector.ErrCorruptedObject) || errors.Is(err, connector.ErrUnsupportedSchemaVersion) {
+		resp.Diagnostics.AddError("id_pool read error", objectDetail(&gcpConnector, "Cannot read pool %s: %s", data.Name.ValueString(), err.Error()))
+		return
+	}
 	if err != nil {
 		tflog.Warn(ctx, fmt.Sprintf("Pool %s not found, removing from state.", data.Name.ValueString()))
 		resp.State.RemoveResource(ctx)
 		return
 	}
+	if !data.AccessTrackingIntervalMinutes.IsNull() {
+		interval := time.Duration(data.AccessTrackingIntervalMinutes.ValueInt64()) * time.Minute
+		if cachedPool.Pool.accessDue(time.Now(), interval) {
+			cachedPool = r.recordAccess(ctx, p, data.Name.ValueString(), interval, cachedPool, &resp.Diagnostics)
+		}
+	}
 
-	err = idPoolFromToolToModel(&data, cachedPool.Pool, r.providerData)
+	data.ContentHash = types.StringValue(cachedPool.ContentHash)
+	err = idPoolFromToolToModel(&data, cachedPool.Pool, p)
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool read error", fmt.Sprintf("Failed to process pool data for %s: %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool read error", objectDetail(&gcpConnector, "Failed to process pool data for %s: %s", data.Name.ValueString(), err.Error()))
 		return
 	}
 
@@ -198,33 +477,47 @@ func (r *IdPoolResource) Update(ctx context.Context, req resource.UpdateRequest,
 		return
 	}
 
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, newData.Timeouts.Update)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(newData.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	// Determine if the pool is being renamed.
 	nameChanged := !data.Name.Equal(newData.Name)
 
+	if nameChanged {
+		resp.Diagnostics.Append(checkPoolRename(data, newData)...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
 	// Set up connector for the *old* pool name to acquire the lock.
-	oldFullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Name.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), oldFullPath)
+	gcpConnector := p.idPoolConnector(data.Name.ValueString())
 
 	// Acquire lock on the old pool name to prevent concurrent modifications.
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool update error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Name.ValueString(), err.Error()))
-		}
-	}()
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", data.Name.ValueString()), &resp.Diagnostics)
 
 	// Since this is an update, we must read the current state directly from GCS, bypassing the cache.
-	var currentPool IdPoolTools.IDPool
+	var currentPool StoredIdPool
 	err = gcpConnector.Read(ctx, &currentPool)
 	if err != nil {
 		if errors.Is(err, storage.ErrObjectNotExist) {
-			resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Cannot update pool '%s' because it was deleted outside of Terraform.", data.Name.ValueString()))
+			resp.Diagnostics.AddError("id_pool update error", objectDetail(&gcpConnector, "Cannot update pool '%s' because it was deleted outside of Terraform.", data.Name.ValueString()))
 		} else {
-			resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Cannot read id_pool '%s' for update: %s", data.Name.ValueString(), err.Error()))
+			resp.Diagnostics.AddError("id_pool update error", objectDetail(&gcpConnector, "Cannot read id_pool '%s' for update: %s", data.Name.ValueString(), err.Error()))
 		}
 		return
 	}
@@ -237,18 +530,63 @@ func (r *IdPoolResource) Update(ctx context.Context, req resource.UpdateRequest,
 		}
 	}
 
+	// A rename copies the members wholesale, never carry a corrupted pool forward.
+	if nameChanged {
+		if duplicates := currentPool.duplicateValues(); len(duplicates) > 0 {
+			ids := make([]IdPoolTools.ID, 0, len(duplicates))
+			for id := range duplicates {
+				ids = append(ids, id)
+			}
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+			details := make([]string, 0, len(ids))
+			for _, id := range ids {
+				details = append(details, fmt.Sprintf("%d: %s", id, strings.Join(duplicates[id], ", ")))
+			}
+			resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Cannot rename pool '%s' to '%s', the same id is held by several members, fix the pool object before renaming it:\n%s", data.Name.ValueString(), newData.Name.ValueString(), strings.Join(details, "\n")))
+			return
+		}
+	}
+
+	// The watermarks of a no_reuse pool only make sense in the direction they were recorded in.
+	if currentPool.NoReuse && currentPool.NextFree != IdPoolTools.NoID && !newData.Direction.Equal(data.Direction) {
+		resp.Diagnostics.AddAttributeError(path.Root("direction"), "id_pool update error", fmt.Sprintf("Cannot change the direction of pool '%s', it is a no_reuse pool where ids were already allocated", data.Name.ValueString()))
+		return
+	}
+
+	if newData.ReserveSentinel.ValueBool() {
+		sentinel := IdPoolTools.ID(newData.StartFrom.ValueInt64())
+		for k, v := range currentPool.Members {
+			if v == sentinel {
+				resp.Diagnostics.AddAttributeError(path.Root("reserve_sentinel"), "id_pool update error", fmt.Sprintf("Cannot reserve the sentinel %d of pool '%s', it is held by the member %s", sentinel, newData.Name.ValueString(), k))
+				return
+			}
+		}
+	}
+
 	// Rebuild the pool from scratch with the new range and existing members. This is the safest way to handle range changes.
-	rebuiltPool := IdPoolTools.NewIDPool(IdPoolTools.ID(newData.StartFrom.ValueInt64()), IdPoolTools.ID(newData.EndTo.ValueInt64()))
-	for _, allocatedID := range currentPool.Members {
-		rebuiltPool.Remove(allocatedID)
+	currentPool.NoReuse = newData.NoReuse.ValueBool()
+	currentPool.ReserveSentinel = newData.ReserveSentinel.ValueBool()
+	resp.Diagnostics.Append(setPoolDirection(&currentPool, newData.Direction)...)
+	resp.Diagnostics.Append(setPoolIdPattern(&currentPool, newData.IdPattern)...)
+	resp.Diagnostics.Append(setPoolLabelTemplate(&currentPool, newData.LabelTemplate)...)
+	resp.Diagnostics.Append(setPoolOutputFormat(&currentPool, newData.OutputFormat, newData.OutputWidth, IdPoolTools.ID(newData.EndTo.ValueInt64()))...)
+	resp.Diagnostics.Append(setPoolBurstFrom(&currentPool, newData.BurstFrom, IdPoolTools.ID(newData.StartFrom.ValueInt64()), IdPoolTools.ID(newData.EndTo.ValueInt64()))...)
+	resp.Diagnostics.Append(setPoolPartitions(ctx, &currentPool, newData.Partitions, IdPoolTools.ID(newData.StartFrom.ValueInt64()), IdPoolTools.ID(newData.EndTo.ValueInt64()))...)
+	previousMirrorPath := currentPool.MirrorFlatOutputPath
+	resp.Diagnostics.Append(setPoolMirrorPath(&currentPool, newData.MirrorPath)...)
+	resp.Diagnostics.Append(setPoolMinGap(&currentPool, newData.MinGap)...)
+	resp.Diagnostics.Append(setPoolCooldownDays(&currentPool, newData.CooldownDays)...)
+	resp.Diagnostics.Append(setPoolAllocationStrategy(&currentPool, newData.AllocationStrategy)...)
+	resp.Diagnostics.Append(checkAccessTrackingInterval(newData.AccessTrackingIntervalMinutes)...)
+	if resp.Diagnostics.HasError() {
+		return
 	}
-	rebuiltPool.Members = currentPool.Members
+	rebuiltPool := currentPool.rebuild(IdPoolTools.ID(newData.StartFrom.ValueInt64()), IdPoolTools.ID(newData.EndTo.ValueInt64()))
 
 	// Determine which connector to use for writing.
 	writeConnector := gcpConnector
 	if nameChanged {
-		newFullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, newData.Name.ValueString())
-		writeConnector = connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), newFullPath)
+		writeConnector = p.idPoolConnector(newData.Name.ValueString())
 		// When renaming, the new file must not exist.
 		writeConnector.Generation = -1
 	}
@@ -256,18 +594,20 @@ func (r *IdPoolResource) Update(ctx context.Context, req resource.UpdateRequest,
 	// Write the updated pool state.
 	err = writeConnector.Write(ctx, rebuiltPool)
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Cannot write updated id_pool '%s': %s", newData.Name.ValueString(), err.Error()))
+		addPoolWriteError(&resp.Diagnostics, "id_pool update error", newData.Name.ValueString(), &writeConnector, err)
 		return
 	}
+	writePoolMirror(ctx, p, newData.Name.ValueString(), rebuiltPool, &resp.Diagnostics)
+	if previousMirrorPath != rebuiltPool.MirrorFlatOutputPath {
+		deletePoolMirror(ctx, p, data.Name.ValueString(), previousMirrorPath, &resp.Diagnostics)
+	}
 
 	// Invalidate the cache for this pool. This is safer than trying to update it
 	// in-place and ensures the next operation reads the fresh state from GCS.
-	r.providerData.CacheMutex.Lock()
-	delete(r.providerData.IdPoolsCache, data.Name.ValueString())
+	invalidateCachedIdPool(p, data.Name.ValueString())
 	if nameChanged {
-		delete(r.providerData.IdPoolsCache, newData.Name.ValueString())
+		invalidateCachedIdPool(p, newData.Name.ValueString())
 	}
-	r.providerData.CacheMutex.Unlock()
 
 	// If the name changed, delete the old pool file.
 	if nameChanged {
@@ -275,14 +615,15 @@ func (r *IdPoolResource) Update(ctx context.Context, req resource.UpdateRequest,
 		err = gcpConnector.Delete(ctx)
 		if err != nil {
 			// This is not a fatal error, but we should warn the user. The old file is orphaned.
-			resp.Diagnostics.AddWarning("Orphaned pool file", fmt.Sprintf("Successfully renamed pool to '%s', but failed to delete the old file at '%s'. Manual cleanup may be required. Error: %s", newData.Name.ValueString(), oldFullPath, err.Error()))
+			resp.Diagnostics.AddWarning("Orphaned pool file", fmt.Sprintf("Successfully renamed pool to '%s', but failed to delete the old file at '%s'. Manual cleanup may be required. Error: %s", newData.Name.ValueString(), gcpConnector.FullFilePath, err.Error()))
 		}
 	}
 
 	// Now, correctly populate the `newData` model to be saved into state.
 	// This is the fix for the "refresh plan was not empty" error.
 	newData.Id = data.Id // The ID must remain constant through updates.
-	err = idPoolFromToolToModel(&newData, rebuiltPool, r.providerData)
+	newData.ContentHash = types.StringValue(writeConnector.ContentHash)
+	err = idPoolFromToolToModel(&newData, rebuiltPool, p)
 	if err != nil {
 		resp.Diagnostics.AddError("id_pool update error", fmt.Sprintf("Failed to process updated pool data for %s: %s", newData.Name.ValueString(), err.Error()))
 		return
@@ -299,29 +640,85 @@ func (r *IdPoolResource) Delete(ctx context.Context, req resource.DeleteRequest,
 		return
 	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Name.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Delete)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := p.idPoolConnector(data.Name.ValueString())
 
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+	startTime := time.Now()
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("id_pool delete error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool delete error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", data.Name.ValueString(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Name.ValueString(), err.Error()))
-		}
-	}()
+	poolDeleted := false
+	if data.CleanupLockOnDelete.ValueBool() {
+		// Registered before the release of the lock so that it runs after it.
+		defer func() {
+			if poolDeleted {
+				cleanupStaleLock(ctx, &gcpConnector, fmt.Sprintf("pool %s", data.Name.ValueString()), startTime, &resp.Diagnostics)
+			}
+		}()
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", data.Name.ValueString()), &resp.Diagnostics)
 
 	err = gcpConnector.Delete(ctx)
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
-		resp.Diagnostics.AddError("id_pool delete error", fmt.Sprintf("Cannot delete id_pool %s: %s", data.Name.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_pool delete error", objectDetail(&gcpConnector, "Cannot delete id_pool %s: %s", data.Name.ValueString(), err.Error()))
+	} else {
+		poolDeleted = true
+		deletePoolMirror(ctx, p, data.Name.ValueString(), data.MirrorPath.ValueString(), &resp.Diagnostics)
 	}
 
 	// Invalidate cache
-	r.providerData.CacheMutex.Lock()
-	delete(r.providerData.IdPoolsCache, data.Name.ValueString())
-	r.providerData.CacheMutex.Unlock()
+	invalidateCachedIdPool(p, data.Name.ValueString())
+}
+
+// ModifyPlan plans the computed fields of a new pool, which has no reservation yet and a single free range,
+// instead of leaving them unknown. They are not predicted on update: the reservations may change concurrently
+// until the pool is locked by the apply. An update of the range is previewed with warnStrandedMembers instead.
+func (r *IdPoolResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	if !req.State.Raw.IsNull() {
+		r.warnStrandedMembers(ctx, req.State, req.Plan, &resp.Diagnostics)
+		return
+	}
+	var data IdPoolResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() || data.StartFrom.IsUnknown() || data.EndTo.IsUnknown() {
+		return
+	}
+	if !data.ImportMembersJson.IsNull() {
+		// The seeded reservations are only known once validated against the pool at creation.
+		return
+	}
+	data.Reservations = types.MapValueMust(types.Int64Type, map[string]attr.Value{})
+	data.UsedCount = types.Int64Value(0)
+	data.ExternallyManaged = types.MapValueMust(types.Int64Type, map[string]attr.Value{})
+	freeRanges := []attr.Value{}
+	from := data.StartFrom.ValueInt64()
+	if data.ReserveSentinel.ValueBool() {
+		from++
+	}
+	if from <= data.EndTo.ValueInt64() {
+		freeRanges = append(freeRanges, types.ObjectValueMust(idRangeAttrTypes, map[string]attr.Value{
+			"from": types.Int64Value(from),
+			"to":   data.EndTo,
+		}))
+	}
+	data.FreeRanges = types.ListValueMust(types.ObjectType{AttrTypes: idRangeAttrTypes}, freeRanges)
+	resp.Diagnostics.Append(resp.Plan.Set(ctx, &data)...)
 }
 
 func (r *IdPoolResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
@@ -329,16 +726,360 @@ func (r *IdPoolResource) ImportState(ctx context.Context, req resource.ImportSta
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
 }
 
-func idPoolFromToolToModel(data *IdPoolResourceModel, pool *IdPoolTools.IDPool, p *GCSReferentialProviderModel) error {
+// setPoolDirection validates the direction attribute and applies it to pool, the default asc is stored as empty.
+func setPoolDirection(pool *StoredIdPool, value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	switch value.ValueString() {
+	case "", idPoolDirectionAsc:
+		pool.Direction = ""
+	case idPoolDirectionDesc:
+		pool.Direction = idPoolDirectionDesc
+	default:
+		diags.AddAttributeError(path.Root("direction"), "Invalid direction", fmt.Sprintf("direction must be %q or %q, got: %q", idPoolDirectionAsc, idPoolDirectionDesc, value.ValueString()))
+	}
+	return diags
+}
+
+// setPoolIdPattern checks that the id_pattern attribute compiles and applies it to pool.
+func setPoolIdPattern(pool *StoredIdPool, value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if !value.IsNull() && value.ValueString() == "" {
+		diags.AddAttributeError(path.Root("id_pattern"), "Invalid id_pattern", "id_pattern must not be empty, remove it to accept any id")
+		return diags
+	}
+	if _, err := regexp.Compile(value.ValueString()); err != nil {
+		diags.AddAttributeError(path.Root("id_pattern"), "Invalid id_pattern", fmt.Sprintf("id_pattern must be a valid regular expression: %s", err.Error()))
+		return diags
+	}
+	pool.IdPattern = value.ValueString()
+	return diags
+}
+
+// setPoolLabelTemplate checks that the label_template attribute renders and applies it to pool.
+func setPoolLabelTemplate(pool *StoredIdPool, value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if !value.IsNull() && value.ValueString() == "" {
+		diags.AddAttributeError(path.Root("label_template"), "Invalid label_template", "label_template must not be empty, remove it to have no label")
+		return diags
+	}
+	checked := StoredIdPool{LabelTemplate: value.ValueString()}
+	if _, err := checked.renderLabel("member", pool.StartFrom); err != nil {
+		diags.AddAttributeError(path.Root("label_template"), "Invalid label_template", fmt.Sprintf("label_template must be a valid template rendered from .Value and .Name: %s", err.Error()))
+		return diags
+	}
+	pool.LabelTemplate = value.ValueString()
+	return diags
+}
+
+// maxStrandedMembersListed is the number of members listed by the plan-time warning of a shrink, the others are counted.
+const maxStrandedMembersListed = 50
+
+// warnStrandedMembers warns at plan time about the members of the pool holding an id outside of the planned range,
+// which make the update fail at apply unless they are released first, for example by destroying their id_request
+// in the same apply. The pool is read as a refresh does, without lock: a failure to read it only skips the preview.
+func (r *IdPoolResource) warnStrandedMembers(ctx context.Context, stateData tfsdk.State, planData tfsdk.Plan, diags *diag.Diagnostics) {
+	var state, plan IdPoolResourceModel
+	if d := stateData.Get(ctx, &state); d.HasError() {
+		return
+	}
+	if d := planData.Get(ctx, &plan); d.HasError() {
+		return
+	}
+	if plan.StartFrom.IsUnknown() || plan.EndTo.IsUnknown() || r.providerData == nil {
+		return
+	}
+	if plan.StartFrom.ValueInt64() <= state.StartFrom.ValueInt64() && plan.EndTo.ValueInt64() >= state.EndTo.ValueInt64() {
+		// The range does not shrink.
+		return
+	}
+	p, referentialDiags := r.providerData.referential(state.Referential)
+	if referentialDiags.HasError() {
+		return
+	}
+	gcpConnector := p.idPoolConnector(state.Name.ValueString())
+	cachedPool, err := getAndCacheIdPool(ctx, p, state.Name.ValueString(), &gcpConnector)
+	if err != nil {
+		tflog.Debug(ctx, fmt.Sprintf("Cannot read pool %s to preview its resize: %s", state.Name.ValueString(), err.Error()))
+		return
+	}
+	stranded := strandedMembers(cachedPool.Pool, IdPoolTools.ID(plan.StartFrom.ValueInt64()), IdPoolTools.ID(plan.EndTo.ValueInt64()))
+	if len(stranded) == 0 {
+		return
+	}
+	listed := stranded
+	if len(listed) > maxStrandedMembersListed {
+		listed = append(listed[:maxStrandedMembersListed:maxStrandedMembersListed], fmt.Sprintf("and %d more", len(stranded)-maxStrandedMembersListed))
+	}
+	diags.AddAttributeWarning(path.Root("end_to"), "id_pool resize warning", objectDetail(&gcpConnector, "%d members of pool %s hold an id outside of the planned range [%d, %d], the update will fail at apply unless they are released first:\n%s",
+		len(stranded), state.Name.ValueString(), plan.StartFrom.ValueInt64(), plan.EndTo.ValueInt64(), strings.Join(listed, "\n")))
+}
+
+// strandedMembers returns the members of pool whose id is outside of [startFrom, endTo], as `name: id` ordered by id.
+func strandedMembers(pool *StoredIdPool, startFrom IdPoolTools.ID, endTo IdPoolTools.ID) []string {
+	names := make([]string, 0)
+	for name, id := range pool.Members {
+		if id < startFrom || id > endTo {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if pool.Members[names[i]] != pool.Members[names[j]] {
+			return pool.Members[names[i]] < pool.Members[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	stranded := make([]string, len(names))
+	for i, name := range names {
+		stranded[i] = fmt.Sprintf("%s: %d", name, pool.Members[name])
+	}
+	return stranded
+}
+
+// checkPoolRename rejects the rename of a pool from state to plan when prevent_rename is set on either side.
+func checkPoolRename(state IdPoolResourceModel, plan IdPoolResourceModel) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if state.PreventRename.ValueBool() || plan.PreventRename.ValueBool() {
+		diags.AddAttributeError(path.Root("name"), "id_pool update error",
+			fmt.Sprintf("Cannot rename pool '%s' to '%s', prevent_rename is set: create a new pool and migrate its members explicitly, or set prevent_rename to false in an apply of its own before renaming it", state.Name.ValueString(), plan.Name.ValueString()))
+	}
+	return diags
+}
+
+// checkAccessTrackingInterval checks the access_tracking_interval_minutes attribute.
+func checkAccessTrackingInterval(value types.Int64) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if !value.IsNull() && value.ValueInt64() < 1 {
+		diags.AddAttributeError(path.Root("access_tracking_interval_minutes"), "Invalid access_tracking_interval_minutes", fmt.Sprintf("access_tracking_interval_minutes must be at least 1 minute, got: %d", value.ValueInt64()))
+	}
+	return diags
+}
+
+// recordAccess writes the time of the access in the pool object under its lock, unless another refresh recorded one
+// less than interval ago meanwhile, and returns the pool as written. The refresh must not fail because of it: when the
+// access cannot be recorded, a warning is added and cachedPool is returned unchanged.
+func (r *IdPoolResource) recordAccess(ctx context.Context, p *GCSReferentialProviderModel, poolName string, interval time.Duration, cachedPool *CachedIdPool, diags *diag.Diagnostics) *CachedIdPool {
+	gcpConnector := p.idPoolConnector(poolName)
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddWarning("id_pool read warning", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s to record its last access: %s", poolName, err.Error()))
+		return cachedPool
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), diags)
+
+	updatedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+	if err != nil {
+		diags.AddWarning("id_pool read warning", objectDetail(&gcpConnector, "Cannot read pool %s to record its last access: %s", poolName, err.Error()))
+		return cachedPool
+	}
+	now := time.Now().UTC()
+	if !updatedPool.Pool.accessDue(now, interval) {
+		return updatedPool
+	}
+	updatedPool.Pool.LastAccessed = &now
+	if err := gcpConnector.Write(ctx, updatedPool.Pool); err != nil {
+		diags.AddWarning("id_pool read warning", objectDetail(&gcpConnector, "Cannot record the last access of pool %s: %s", poolName, err.Error()))
+		return cachedPool
+	}
+	storeCachedIdPool(p, poolName, updatedPool.Pool, &gcpConnector)
+	tflog.Debug(ctx, "Recorded pool access", map[string]interface{}{"pool": poolName})
+	return &CachedIdPool{Pool: updatedPool.Pool, Generation: gcpConnector.GetGeneration(), ContentHash: gcpConnector.GetContentHash()}
+}
+
+// setPoolOutputFormat checks the output_format and output_width attributes and applies them to pool.
+// The width must hold endTo, the largest id of the pool.
+func setPoolOutputFormat(pool *StoredIdPool, format types.String, width types.Int64, endTo IdPoolTools.ID) diag.Diagnostics {
+	var diags diag.Diagnostics
+	checked := StoredIdPool{}
+	switch format.ValueString() {
+	case "", idOutputFormatDec:
+	case idOutputFormatHex:
+		checked.OutputFormat = idOutputFormatHex
+	default:
+		diags.AddAttributeError(path.Root("output_format"), "Invalid output_format", fmt.Sprintf("output_format must be %q or %q, got: %q", idOutputFormatDec, idOutputFormatHex, format.ValueString()))
+		return diags
+	}
+	if width.ValueInt64() < 0 {
+		diags.AddAttributeError(path.Root("output_width"), "Invalid output_width", fmt.Sprintf("output_width must be a positive number of digits, got: %d", width.ValueInt64()))
+		return diags
+	}
+	if digits := len(strings.TrimPrefix(checked.formatId(endTo), "0x")); width.ValueInt64() > 0 && int64(digits) > width.ValueInt64() {
+		diags.AddAttributeError(path.Root("output_width"), "Invalid output_width", fmt.Sprintf("output_width %d cannot hold end_to %d, which takes %d digits in %s", width.ValueInt64(), endTo, digits, format.ValueString()))
+		return diags
+	}
+	pool.OutputFormat = checked.OutputFormat
+	pool.OutputWidth = width.ValueInt64()
+	return diags
+}
+
+// setPoolBurstFrom checks the burst_from attribute against the range of the pool and applies it to pool.
+func setPoolBurstFrom(pool *StoredIdPool, value types.Int64, startFrom IdPoolTools.ID, endTo IdPoolTools.ID) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.IsNull() {
+		pool.BurstFrom = 0
+		return diags
+	}
+	if value.ValueInt64() <= int64(startFrom) || value.ValueInt64() > int64(endTo) {
+		diags.AddAttributeError(path.Root("burst_from"), "Invalid burst_from", fmt.Sprintf("burst_from must be above start_from %d and up to end_to %d, got: %d", startFrom, endTo, value.ValueInt64()))
+		return diags
+	}
+	pool.BurstFrom = IdPoolTools.ID(value.ValueInt64())
+	return diags
+}
+
+// setPoolPartitions checks the partitions attribute against the range of the pool and applies it to pool.
+func setPoolPartitions(ctx context.Context, pool *StoredIdPool, value types.Map, startFrom IdPoolTools.ID, endTo IdPoolTools.ID) diag.Diagnostics {
+	var diags diag.Diagnostics
+	var ranges map[string]struct {
+		From types.Int64 `tfsdk:"from"`
+		To   types.Int64 `tfsdk:"to"`
+	}
+	if !value.IsNull() && !value.IsUnknown() {
+		diags.Append(value.ElementsAs(ctx, &ranges, false)...)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	var partitions map[string]IdPartition
+	for name, bounds := range ranges {
+		if bounds.From.ValueInt64() < 0 || bounds.To.ValueInt64() < 0 {
+			diags.AddAttributeError(path.Root("partitions"), "Invalid partitions", fmt.Sprintf("The bounds of the partition %q must be positive ids, got %d and %d", name, bounds.From.ValueInt64(), bounds.To.ValueInt64()))
+			return diags
+		}
+		if partitions == nil {
+			partitions = make(map[string]IdPartition, len(ranges))
+		}
+		partitions[name] = IdPartition{From: IdPoolTools.ID(bounds.From.ValueInt64()), To: IdPoolTools.ID(bounds.To.ValueInt64())}
+	}
+	if err := checkPartitions(partitions, startFrom, endTo); err != nil {
+		diags.AddAttributeError(path.Root("partitions"), "Invalid partitions", err.Error())
+		return diags
+	}
+	pool.Partitions = partitions
+	return diags
+}
+
+// setPoolMinGap checks the min_gap attribute and applies it to pool.
+func setPoolMinGap(pool *StoredIdPool, value types.Int64) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.ValueInt64() < 0 {
+		diags.AddAttributeError(path.Root("min_gap"), "Invalid min_gap", fmt.Sprintf("min_gap must be a positive distance between ids, got: %d", value.ValueInt64()))
+		return diags
+	}
+	pool.MinGap = value.ValueInt64()
+	return diags
+}
+
+// setPoolCooldownDays checks the cooldown_days attribute and applies it to pool.
+func setPoolCooldownDays(pool *StoredIdPool, value types.Int64) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.ValueInt64() < 0 {
+		diags.AddAttributeError(path.Root("cooldown_days"), "Invalid cooldown_days", fmt.Sprintf("cooldown_days must be a positive number of days, got: %d", value.ValueInt64()))
+		return diags
+	}
+	pool.CooldownDays = value.ValueInt64()
+	return diags
+}
+
+// setPoolAllocationStrategy validates the allocation_strategy attribute and applies it to pool, the default first_free
+// is stored as empty. The release times are only kept by an oldest_free pool.
+func setPoolAllocationStrategy(pool *StoredIdPool, value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	switch value.ValueString() {
+	case "", idAllocationStrategyFirstFree:
+		pool.AllocationStrategy = ""
+		pool.ReleasedAt = nil
+	case idAllocationStrategyOldestFree:
+		pool.AllocationStrategy = idAllocationStrategyOldestFree
+	default:
+		diags.AddAttributeError(path.Root("allocation_strategy"), "Invalid allocation_strategy", fmt.Sprintf("allocation_strategy must be %q or %q, got: %q", idAllocationStrategyFirstFree, idAllocationStrategyOldestFree, value.ValueString()))
+	}
+	return diags
+}
+
+func idPoolFromToolToModel(data *IdPoolResourceModel, pool *StoredIdPool, p *GCSReferentialProviderModel) error {
 	if !pool.IsValid() {
 		return fmt.Errorf("Something append with the %s from the %s bucket that invalidate it", data.Name, p.ReferentialBucket)
 	}
 	data.StartFrom = types.Int64Value(int64(pool.StartFrom))
 	data.EndTo = types.Int64Value(int64(pool.EndTo))
-	reservations := make(map[string]attr.Value)
-	for k, m := range pool.Members {
-		reservations[k] = types.Int64Value(int64(m))
+	data.NoReuse = types.BoolValue(pool.NoReuse)
+	data.ReserveSentinel = types.BoolValue(pool.ReserveSentinel)
+	data.CooldownDays = types.Int64Value(pool.CooldownDays)
+	data.AllocationStrategy = types.StringValue(idAllocationStrategyFirstFree)
+	if pool.AllocationStrategy == idAllocationStrategyOldestFree {
+		data.AllocationStrategy = types.StringValue(idAllocationStrategyOldestFree)
+	}
+	data.MinGap = types.Int64Value(pool.MinGap)
+	if len(pool.Partitions) == 0 {
+		// A pool without partitions keeps the planned or prior value when it has none either, null or an empty map,
+		// so that a configuration with partitions = {} is applied as is.
+		if data.Partitions.IsUnknown() || len(data.Partitions.Elements()) > 0 {
+			data.Partitions = types.MapNull(types.ObjectType{AttrTypes: idRangeAttrTypes})
+		}
+	} else {
+		partitions := make(map[string]attr.Value, len(pool.Partitions))
+		for name, bounds := range pool.Partitions {
+			partitions[name], _ = types.ObjectValue(idRangeAttrTypes, map[string]attr.Value{
+				"from": types.Int64Value(int64(bounds.From)),
+				"to":   types.Int64Value(int64(bounds.To)),
+			})
+		}
+		data.Partitions, _ = types.MapValue(types.ObjectType{AttrTypes: idRangeAttrTypes}, partitions)
+	}
+	data.BurstFrom = types.Int64Null()
+	if pool.BurstFrom != 0 {
+		data.BurstFrom = types.Int64Value(int64(pool.BurstFrom))
+	}
+	data.MirrorPath = types.StringNull()
+	if pool.MirrorFlatOutputPath != "" {
+		data.MirrorPath = types.StringValue(pool.MirrorFlatOutputPath)
+	}
+	data.OutputFormat = types.StringValue(idOutputFormatDec)
+	if pool.OutputFormat == idOutputFormatHex {
+		data.OutputFormat = types.StringValue(idOutputFormatHex)
+	}
+	data.OutputWidth = types.Int64Value(pool.OutputWidth)
+	data.LastAccessed = types.StringNull()
+	if pool.LastAccessed != nil {
+		data.LastAccessed = types.StringValue(pool.LastAccessed.Format(time.RFC3339))
+	}
+	data.IdPattern = types.StringNull()
+	if pool.IdPattern != "" {
+		data.IdPattern = types.StringValue(pool.IdPattern)
+	}
+	data.LabelTemplate = types.StringNull()
+	if pool.LabelTemplate != "" {
+		data.LabelTemplate = types.StringValue(pool.LabelTemplate)
+	}
+	data.Direction = types.StringValue(idPoolDirectionAsc)
+	if pool.isDesc() {
+		data.Direction = types.StringValue(idPoolDirectionDesc)
+	}
+	// The maps are always known, empty rather than null for a pool without members, so that a refresh never shows a diff.
+	// Only the reservations of a pool larger than max_state_reservations are left null, to keep its state small.
+	data.UsedCount = types.Int64Value(int64(len(pool.Members)))
+	if !p.MaxStateReservations.IsNull() && int64(len(pool.Members)) > p.MaxStateReservations.ValueInt64() {
+		data.Reservations = types.MapNull(types.Int64Type)
+	} else {
+		reservations := make(map[string]attr.Value)
+		for k, m := range pool.Members {
+			reservations[k] = types.Int64Value(int64(m))
+		}
+		data.Reservations, _ = types.MapValue(types.Int64Type, reservations)
+	}
+	externallyManaged := make(map[string]attr.Value)
+	for k, m := range pool.ExternallyManaged {
+		externallyManaged[k] = types.Int64Value(int64(m))
+	}
+	data.ExternallyManaged, _ = types.MapValue(types.Int64Type, externallyManaged)
+	freeRanges := []attr.Value{}
+	for _, free := range pool.freeRanges() {
+		freeRange, _ := types.ObjectValue(idRangeAttrTypes, map[string]attr.Value{
+			"from": types.Int64Value(int64(free.From)),
+			"to":   types.Int64Value(int64(free.To)),
+		})
+		freeRanges = append(freeRanges, freeRange)
 	}
-	data.Reservations, _ = types.MapValue(types.Int64Type, reservations)
+	data.FreeRanges, _ = types.ListValue(types.ObjectType{AttrTypes: idRangeAttrTypes}, freeRanges)
 	return nil
 }
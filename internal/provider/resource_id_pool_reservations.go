@@ -0,0 +1,420 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ resource.Resource = &IdPoolReservationsResource{}
+var _ resource.ResourceWithImportState = &IdPoolReservationsResource{}
+
+func NewIdPoolReservationsResource() resource.Resource {
+	return &IdPoolReservationsResource{}
+}
+
+type IdPoolReservationsResource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type IdPoolReservationsResourceModel struct {
+	Id           types.String   `tfsdk:"id"`
+	Pool         types.String   `tfsdk:"pool"`
+	Referential  types.String   `tfsdk:"referential"`
+	Members      types.Map      `tfsdk:"members"`
+	Reservations types.Map      `tfsdk:"reservations"`
+	Timeouts     timeouts.Value `tfsdk:"timeouts"`
+}
+
+// IdPoolReservationsMemberModel is a member declared in the members of an id_pool_reservations.
+type IdPoolReservationsMemberModel struct {
+	PreferredValue types.Int64 `tfsdk:"preferred_value"`
+}
+
+var poolReservationsMemberAttrTypes = map[string]attr.Type{
+	"preferred_value": types.Int64Type,
+}
+
+func (r *IdPoolReservationsResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_id_pool_reservations"
+}
+
+func (r *IdPoolReservationsResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This resource declares the complete set of members of an existing id_pool, as a declarative alternative to an id_request per member, for example to manage the reservations of a pool from a single GitOps file. " +
+			"Each apply syncs the pool to exactly the declared members under the pool lock, in a single write: the missing members are allocated an id and the other members of the pool are released, including those of id_requests, so it must be the only manager of the pool. " +
+			"A member removed from the pool outside of Terraform, or added to it, shows as a change of members to apply. Destroying the id_pool_reservations releases its members. It can be imported with the name of the pool, adopting all its members",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The name of the pool, it is a readonly field",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"pool": schema.StringAttribute{
+				MarkdownDescription: "The name of the id_pool to sync, it must exist. If you change it, the id_pool_reservations will be destroyed and recreate",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"referential": schema.StringAttribute{
+				MarkdownDescription: "The name of one of the referentials of the provider to find the pool in, instead of referential_bucket. If you change it, the id_pool_reservations will be destroyed and recreate. Default to referential_bucket",
+				Optional:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"members": schema.MapNestedAttribute{
+				MarkdownDescription: "The members the pool must hold, by member name, such as the id of the id_request they replace. The names must match the id_pattern of the pool",
+				Required:            true,
+				NestedObject: schema.NestedAttributeObject{
+					Attributes: map[string]schema.Attribute{
+						"preferred_value": schema.Int64Attribute{
+							MarkdownDescription: "The id to allocate to the member when it is added, if it is free, otherwise it is allocated as any other member. A member already in the pool keeps its id",
+							Optional:            true,
+						},
+					},
+				},
+			},
+			"reservations": schema.MapAttribute{
+				MarkdownDescription: "The id held by each member in the pool, it is a readonly field",
+				ElementType:         types.Int64Type,
+				Computed:            true,
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
+	}
+}
+
+func (r *IdPoolReservationsResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	r.providerData = providerData
+}
+
+func (r *IdPoolReservationsResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data IdPoolReservationsResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.sync(ctx, &data, "id_pool_reservations creation error", &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *IdPoolReservationsResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data IdPoolReservationsResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Read)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := p.idPoolConnector(data.Pool.ValueString())
+	cachedPool, err := getAndCacheIdPool(ctx, p, data.Pool.ValueString(), &gcpConnector)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		tflog.Warn(ctx, fmt.Sprintf("Pool %s not found, removing id_pool_reservations from state", data.Pool.ValueString()))
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("id_pool_reservations read error", objectDetail(&gcpConnector, "Cannot read pool %s: %s", data.Pool.ValueString(), err.Error()))
+		return
+	}
+
+	// The members follow the pool so that a drift shows as a change to apply, the preferred values are kept from the state.
+	declared, diags := poolReservationsMembers(ctx, data.Members)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	members := make(map[string]attr.Value, len(cachedPool.Pool.Members))
+	for name := range cachedPool.Pool.Members {
+		preferred := types.Int64Null()
+		if member, ok := declared[name]; ok {
+			preferred = member.PreferredValue
+		}
+		members[name] = types.ObjectValueMust(poolReservationsMemberAttrTypes, map[string]attr.Value{"preferred_value": preferred})
+	}
+	data.Members = types.MapValueMust(types.ObjectType{AttrTypes: poolReservationsMemberAttrTypes}, members)
+	data.Id = data.Pool
+	data.Reservations = poolReservationsFromPool(cachedPool.Pool)
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+func (r *IdPoolReservationsResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var data IdPoolReservationsResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Update)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	r.sync(ctx, &data, "id_pool_reservations update error", &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Save updated data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// Delete releases the members of the state still held in the pool, the other members are left as they are.
+func (r *IdPoolReservationsResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+	var data IdPoolReservationsResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Delete)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	poolName := data.Pool.ValueString()
+	gcpConnector := p.idPoolConnector(poolName)
+
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		resp.Diagnostics.AddError("id_pool_reservations delete error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", poolName, err.Error()))
+		return
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), &resp.Diagnostics)
+
+	cachedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+	if err != nil {
+		// If the pool doesn't exist, its members are already gone. Not an error.
+		tflog.Warn(ctx, fmt.Sprintf("Pool %s not found during id_pool_reservations delete. Assuming its members are already gone.", poolName))
+		return
+	}
+	declared, diags := poolReservationsMembers(ctx, data.Members)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	now := time.Now()
+	released := 0
+	for name := range declared {
+		if _, ok := cachedPool.Pool.Members[name]; ok {
+			cachedPool.Pool.release(name, now)
+			released++
+		}
+	}
+	if released == 0 {
+		return
+	}
+
+	if err := gcpConnector.Write(ctx, cachedPool.Pool); err != nil {
+		addPoolWriteError(&resp.Diagnostics, "id_pool_reservations delete error", poolName, &gcpConnector, err)
+		return
+	}
+	// Keep the written pool in cache, Write updated the connector's generation.
+	storeCachedIdPool(p, poolName, cachedPool.Pool, &gcpConnector)
+	writePoolMirror(ctx, p, poolName, cachedPool.Pool, &resp.Diagnostics)
+}
+
+// ImportState takes the name of the pool, the Read adopts all its members without preferred value.
+func (r *IdPoolReservationsResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	if req.ID == "" {
+		resp.Diagnostics.AddError("Unexpected Import Identifier", "Expected the name of the pool as import identifier, got an empty one")
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("pool"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("members"), types.MapValueMust(types.ObjectType{AttrTypes: poolReservationsMemberAttrTypes}, map[string]attr.Value{}))...)
+}
+
+// sync makes the pool of data hold exactly its members under the pool lock and records the resulting reservations in data.
+// Nothing is written when a member cannot be allocated.
+func (r *IdPoolReservationsResource) sync(ctx context.Context, data *IdPoolReservationsResourceModel, summary string, diags *diag.Diagnostics) {
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	diags.Append(referentialDiags...)
+	if diags.HasError() {
+		return
+	}
+	declared, memberDiags := poolReservationsMembers(ctx, data.Members)
+	diags.Append(memberDiags...)
+	if diags.HasError() {
+		return
+	}
+	poolName := data.Pool.ValueString()
+	gcpConnector := p.idPoolConnector(poolName)
+
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError(summary, lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s: %s", poolName, err.Error()))
+		return
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), diags)
+
+	cachedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+	if err != nil {
+		diags.AddError(summary, objectDetail(&gcpConnector, "Cannot find pool '%s' to sync the members of: %s", poolName, err.Error()))
+		return
+	}
+	// The quarantined ids whose cooldown elapsed since the pool was read are available to the new members.
+	sweepQuarantinedIds(ctx, poolName, cachedPool.Pool)
+
+	added, removed, err := syncPoolMembers(ctx, p, poolName, cachedPool.Pool, declared, time.Now())
+	if err != nil {
+		diags.AddError(summary, objectDetail(&gcpConnector, "Cannot sync the members of pool %s, nothing was changed: %s", poolName, err.Error()))
+		return
+	}
+	if len(added)+len(removed) > 0 {
+		if err := gcpConnector.Write(ctx, cachedPool.Pool); err != nil {
+			addPoolWriteError(diags, summary, poolName, &gcpConnector, err)
+			return
+		}
+		tflog.Info(ctx, "Synced pool members", map[string]interface{}{"pool": poolName, "added": added, "removed": removed})
+		// Keep the written pool in cache, Write updated the connector's generation.
+		storeCachedIdPool(p, poolName, cachedPool.Pool, &gcpConnector)
+		writePoolMirror(ctx, p, poolName, cachedPool.Pool, diags)
+		recordAllocations(ctx, p, allocationKindId, int64(len(added)), diags)
+	}
+	data.Id = data.Pool
+	data.Reservations = poolReservationsFromPool(cachedPool.Pool)
+}
+
+// syncPoolMembers releases the members of pool that are not declared and allocates an id to the declared members
+// it does not hold, the ones with a free preferred value first, and returns the sorted names of both. The caller
+// must own the pool and write it, pool is left partially synced when an error is returned.
+func syncPoolMembers(ctx context.Context, p *GCSReferentialProviderModel, poolName string, pool *StoredIdPool, declared map[string]IdPoolReservationsMemberModel, now time.Time) ([]string, []string, error) {
+	removed := make([]string, 0)
+	for name := range pool.Members {
+		if _, ok := declared[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		pool.release(name, now)
+	}
+
+	missing := make([]string, 0)
+	for name := range declared {
+		if _, ok := pool.Members[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	// The preferred values are taken before any other allocation can take them.
+	sort.Slice(missing, func(i, j int) bool {
+		iPreferred, jPreferred := !declared[missing[i]].PreferredValue.IsNull(), !declared[missing[j]].PreferredValue.IsNull()
+		if iPreferred != jPreferred {
+			return iPreferred
+		}
+		return missing[i] < missing[j]
+	})
+	for _, name := range missing {
+		if name == "" {
+			return nil, nil, fmt.Errorf("a member name is empty")
+		}
+		if err := pool.checkMemberName(name); err != nil {
+			return nil, nil, err
+		}
+		id := IdPoolTools.NoID
+		if preferred := declared[name].PreferredValue; !preferred.IsNull() {
+			id = pool.allocate(name, func(candidate IdPoolTools.ID) bool { return int64(candidate) == preferred.ValueInt64() }, nil)
+			if id == IdPoolTools.NoID {
+				tflog.Info(ctx, fmt.Sprintf("The preferred value %d of %s is not free in pool %s, allocating another id", preferred.ValueInt64(), name, poolName))
+			}
+		}
+		if id == IdPoolTools.NoID {
+			id = pool.allocate(name, nil, p.Rand)
+		}
+		if id == IdPoolTools.NoID {
+			return nil, nil, fmt.Errorf("the pool is exhausted, no id is left for the member %q", name)
+		}
+		if err := p.approveAllocation(ctx, p.idProposal(poolName, name, int64(id))); err != nil {
+			return nil, nil, fmt.Errorf("the id %d of the member %q was refused: %w", id, name, err)
+		}
+		pool.recordReservation(name, 0, now)
+	}
+	sort.Strings(missing)
+	return missing, removed, nil
+}
+
+// poolReservationsMembers returns the members of an id_pool_reservations by name.
+func poolReservationsMembers(ctx context.Context, value types.Map) (map[string]IdPoolReservationsMemberModel, diag.Diagnostics) {
+	members := make(map[string]IdPoolReservationsMemberModel)
+	if value.IsNull() || value.IsUnknown() {
+		return members, nil
+	}
+	diags := value.ElementsAs(ctx, &members, false)
+	return members, diags
+}
+
+// poolReservationsFromPool returns the reservations attribute of an id_pool_reservations holding the members of pool.
+func poolReservationsFromPool(pool *StoredIdPool) types.Map {
+	reservations := make(map[string]attr.Value, len(pool.Members))
+	for name, id := range pool.Members {
+		reservations[name] = types.Int64Value(int64(id))
+	}
+	return types.MapValueMust(types.Int64Type, reservations)
+}
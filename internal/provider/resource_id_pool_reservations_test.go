@@ -0,0 +1,118 @@
+package provider
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+// newIdPoolReservationsModel returns the plan of a new id_pool_reservations declaring the given members on the pool,
+// with their preferred value, and its computed attributes unknown.
+func newIdPoolReservationsModel(pool string, declared map[string]types.Int64) *IdPoolReservationsResourceModel {
+	values := make(map[string]attr.Value, len(declared))
+	for name, preferred := range declared {
+		values[name] = types.ObjectValueMust(poolReservationsMemberAttrTypes, map[string]attr.Value{"preferred_value": preferred})
+	}
+	return &IdPoolReservationsResourceModel{
+		Id:           types.StringUnknown(),
+		Pool:         types.StringValue(pool),
+		Members:      types.MapValueMust(types.ObjectType{AttrTypes: poolReservationsMemberAttrTypes}, values),
+		Reservations: types.MapUnknown(types.Int64Type),
+		Timeouts:     nullTimeouts,
+	}
+}
+
+func TestIdPoolReservationsResource(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 5)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"kept": 1, "extra": 2}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	members := func() map[string]IdPoolTools.ID {
+		var stored StoredIdPool
+		if err := gcpConnector.Read(ctx, &stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored.Members
+	}
+
+	r := &IdPoolReservationsResource{providerData: p}
+	create := func(declared map[string]types.Int64) *fwresource.CreateResponse {
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newIdPoolReservationsModel("pool", declared))}, resp)
+		return resp
+	}
+
+	// The preferred value is taken when free, the undeclared member is released.
+	resp := create(map[string]types.Int64{"kept": types.Int64Null(), "new": types.Int64Null(), "preferred": types.Int64Value(4)})
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	got := members()
+	if id, ok := got["new"]; !ok || id == 1 || id == 4 || len(got) != 3 {
+		t.Fatalf("expected new to be allocated a free id, got %v", got)
+	}
+	delete(got, "new")
+	if expected := map[string]IdPoolTools.ID{"kept": 1, "preferred": 4}; !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected the pool to hold %v, got %v", expected, got)
+	}
+	var data IdPoolReservationsResourceModel
+	resp.State.Get(ctx, &data)
+	if data.Id.ValueString() != "pool" || len(data.Reservations.Elements()) != 3 || !data.Reservations.Elements()["preferred"].Equal(types.Int64Value(4)) {
+		t.Fatalf("expected the reservations of the pool, got %s", data.Reservations)
+	}
+
+	// A drift of the pool shows in the members read.
+	invalidateCachedIdPool(p, "pool")
+	pool = newStoredIdPool(1, 5)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"kept": 1, "preferred": 4, "added": 5}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+	readResp := &fwresource.ReadResponse{State: resp.State}
+	r.Read(ctx, fwresource.ReadRequest{State: resp.State}, readResp)
+	if readResp.Diagnostics.HasError() {
+		t.Fatal(readResp.Diagnostics)
+	}
+	readResp.State.Get(ctx, &data)
+	read, _ := poolReservationsMembers(ctx, data.Members)
+	if _, ok := read["added"]; !ok || len(read) != 3 || read["preferred"].PreferredValue.ValueInt64() != 4 {
+		t.Fatalf("expected the members of the pool with the preferred values of the state, got %v", read)
+	}
+
+	// Nothing is written when a member cannot be allocated.
+	invalidateCachedIdPool(p, "pool")
+	resp = create(map[string]types.Int64{"a": types.Int64Null(), "b": types.Int64Null(), "c": types.Int64Null(), "d": types.Int64Null(), "e": types.Int64Null(), "f": types.Int64Null()})
+	if !resp.Diagnostics.HasError() || !strings.Contains(resp.Diagnostics.Errors()[0].Detail(), "exhausted") {
+		t.Fatalf("expected an exhausted pool error, got %v", resp.Diagnostics)
+	}
+	if got := members(); len(got) != 3 || got["added"] != 5 {
+		t.Fatalf("expected the pool to be left unchanged, got %v", got)
+	}
+
+	// Destroying releases the members of the state.
+	deleteResp := &fwresource.DeleteResponse{State: readResp.State}
+	r.Delete(ctx, fwresource.DeleteRequest{State: readResp.State}, deleteResp)
+	if deleteResp.Diagnostics.HasError() {
+		t.Fatal(deleteResp.Diagnostics)
+	}
+	if got := members(); len(got) != 0 {
+		t.Fatalf("expected the members to be released, got %v", got)
+	}
+}
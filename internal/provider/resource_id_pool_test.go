@@ -1,14 +1,31 @@
 package provider
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/providerserver"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-go/tfprotov6"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	"github.com/hashicorp/terraform-plugin-testing/knownvalue"
+	"github.com/hashicorp/terraform-plugin-testing/plancheck"
+	"github.com/hashicorp/terraform-plugin-testing/tfjsonpath"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
 )
 
 // testAccProtoV6ProviderFactories are used to instantiate a provider during
@@ -68,6 +85,95 @@ func TestAccIdPoolResource(t *testing.T) {
 	})
 }
 
+func TestAccIdPoolResource_deleteThenCreate(t *testing.T) {
+	bucketName := os.Getenv("GCS_REFERENTIAL_BUCKET")
+	if bucketName == "" {
+		t.Skip("GCS_REFERENTIAL_BUCKET environment variable not set, skipping acceptance test")
+	}
+
+	poolName := "test-pool-delete-then-create"
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// 1. Create the pool with a reservation
+			{
+				Config: testAccIdPoolResourceConfig(poolName, 1, 10) + testAccIdPoolResourceConfig_request("req-1"),
+				Check:  resource.TestCheckResourceAttrSet("gcsreferential_id_request.test", "requested_id"),
+			},
+			// 2. Delete it
+			{
+				Config: testAccIdPoolResourceConfig_providerOnly(),
+			},
+			// 3. Recreate it with the same name, the previous version must not be seen anymore
+			{
+				Config: testAccIdPoolResourceConfig(poolName, 20, 30) + testAccIdPoolResourceConfig_request("req-2"),
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("gcsreferential_id_pool.test", "start_from", "20"),
+					resource.TestCheckResourceAttr("gcsreferential_id_pool.test", "end_to", "30"),
+					resource.TestCheckResourceAttrSet("gcsreferential_id_request.test", "requested_id"),
+				),
+			},
+			{
+				RefreshState: true,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("gcsreferential_id_pool.test", "reservations.%", "1"),
+					resource.TestCheckNoResourceAttr("gcsreferential_id_pool.test", "reservations.req-1"),
+				),
+			},
+		},
+	})
+}
+
+func TestAccIdPoolResource_planReservations(t *testing.T) {
+	bucketName := os.Getenv("GCS_REFERENTIAL_BUCKET")
+	if bucketName == "" {
+		t.Skip("GCS_REFERENTIAL_BUCKET environment variable not set, skipping acceptance test")
+	}
+
+	poolName := "test-pool-plan-reservations"
+
+	resource.Test(t, resource.TestCase{
+		ProtoV6ProviderFactories: testAccProtoV6ProviderFactories,
+		Steps: []resource.TestStep{
+			// 1. A new pool is planned without reservation and with its whole range free
+			{
+				Config: testAccIdPoolResourceConfig(poolName, 1, 10),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectKnownValue("gcsreferential_id_pool.test", tfjsonpath.New("reservations"), knownvalue.MapExact(map[string]knownvalue.Check{})),
+						plancheck.ExpectKnownValue("gcsreferential_id_pool.test", tfjsonpath.New("free_ranges"), knownvalue.ListExact([]knownvalue.Check{
+							knownvalue.ObjectExact(map[string]knownvalue.Check{
+								"from": knownvalue.Int64Exact(1),
+								"to":   knownvalue.Int64Exact(10),
+							}),
+						})),
+					},
+				},
+			},
+			// 2. Adding a request only plans the request, the pool shows the reservation on the next plan
+			{
+				Config: testAccIdPoolResourceConfig(poolName, 1, 10) + testAccIdPoolResourceConfig_request("req-1"),
+				ConfigPlanChecks: resource.ConfigPlanChecks{
+					PreApply: []plancheck.PlanCheck{
+						plancheck.ExpectResourceAction("gcsreferential_id_pool.test", plancheck.ResourceActionNoop),
+						plancheck.ExpectResourceAction("gcsreferential_id_request.test", plancheck.ResourceActionCreate),
+						plancheck.ExpectUnknownValue("gcsreferential_id_request.test", tfjsonpath.New("requested_id")),
+					},
+				},
+			},
+			// 3. After refresh the reservation is known
+			{
+				RefreshState: true,
+				Check: resource.ComposeAggregateTestCheckFunc(
+					resource.TestCheckResourceAttr("gcsreferential_id_pool.test", "reservations.%", "1"),
+					resource.TestCheckResourceAttrPair("gcsreferential_id_pool.test", "reservations.req-1", "gcsreferential_id_request.test", "requested_id"),
+				),
+			},
+		},
+	})
+}
+
 func testAccIdPoolResourceConfig(poolName string, start int, end int) string {
 	bucketName := os.Getenv("GCS_REFERENTIAL_BUCKET")
 	return fmt.Sprintf(`
@@ -94,3 +200,492 @@ func testAccIdPoolResourceConfig_duplicate_pool(poolName string, start int, end
 
 	return returned
 }
+
+func testAccIdPoolResourceConfig_providerOnly() string {
+	return fmt.Sprintf(`
+provider "gcsreferential" {
+  referential_bucket = "%s"
+}
+`, os.Getenv("GCS_REFERENTIAL_BUCKET"))
+}
+
+func testAccIdPoolResourceConfig_request(requestId string) string {
+	return fmt.Sprintf(`
+resource "gcsreferential_id_request" "test" {
+  pool = gcsreferential_id_pool.test.name
+  id   = "%s"
+}
+`, requestId)
+}
+
+// newIdPoolModel returns the plan of a new id_pool on the given range, with the default settings and its computed
+// attributes unknown.
+func newIdPoolModel(name string, startFrom int64, endTo int64) *IdPoolResourceModel {
+	return &IdPoolResourceModel{
+		Id:                types.StringUnknown(),
+		Name:              types.StringValue(name),
+		StartFrom:         types.Int64Value(startFrom),
+		EndTo:             types.Int64Value(endTo),
+		NoReuse:           types.BoolValue(false),
+		ReserveSentinel:   types.BoolValue(false),
+		Direction:         types.StringValue(idPoolDirectionAsc),
+		CooldownDays:      types.Int64Value(0),
+		ImportMembersJson: types.StringNull(),
+		ContentHash:       types.StringUnknown(),
+		LastAccessed:      types.StringUnknown(),
+		Reservations:      types.MapUnknown(types.Int64Type),
+		ExternallyManaged: types.MapUnknown(types.Int64Type),
+		FreeRanges:        types.ListUnknown(types.ObjectType{AttrTypes: idRangeAttrTypes}),
+		Partitions:        types.MapNull(types.ObjectType{AttrTypes: idRangeAttrTypes}),
+		Timeouts:          nullTimeouts,
+	}
+}
+
+func TestIdPoolResourceModifyPlan_create(t *testing.T) {
+	ctx := context.Background()
+	r := &IdPoolResource{}
+	plan := newPlan(t, r, newIdPoolModel("pool", 5, 9))
+	resp := &fwresource.ModifyPlanResponse{Plan: plan}
+	r.ModifyPlan(ctx, fwresource.ModifyPlanRequest{Plan: plan, State: newState(t, r, nil)}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+
+	var planned IdPoolResourceModel
+	resp.Plan.Get(ctx, &planned)
+	if planned.Reservations.IsUnknown() || len(planned.Reservations.Elements()) != 0 {
+		t.Errorf("expected no planned reservation, got %s", planned.Reservations)
+	}
+	expected := types.ListValueMust(types.ObjectType{AttrTypes: idRangeAttrTypes}, []attr.Value{
+		types.ObjectValueMust(idRangeAttrTypes, map[string]attr.Value{"from": types.Int64Value(5), "to": types.Int64Value(9)}),
+	})
+	if !planned.FreeRanges.Equal(expected) {
+		t.Errorf("expected the whole range to be planned free, got %s", planned.FreeRanges)
+	}
+}
+
+func TestIdPoolResourceModifyPlan_resize(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 100)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 3, "b": 95, "c": 42, "d": 2}, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &IdPoolResource{providerData: p}
+	modifyPlan := func(startFrom int64, endTo int64) diag.Diagnostics {
+		model := func(startFrom int64, endTo int64) *IdPoolResourceModel {
+			model := newIdPoolModel("pool", startFrom, endTo)
+			model.Id = types.StringValue("pool")
+			return model
+		}
+		state := newState(t, r, model(1, 100))
+		plan := newPlan(t, r, model(startFrom, endTo))
+		resp := &fwresource.ModifyPlanResponse{Plan: plan}
+		r.ModifyPlan(ctx, fwresource.ModifyPlanRequest{Plan: plan, State: state}, resp)
+		return resp.Diagnostics
+	}
+
+	// Growing the range or keeping the members inside it previews nothing.
+	if diags := modifyPlan(1, 200); len(diags) != 0 {
+		t.Fatalf("expected no diagnostic when growing the range, got %v", diags)
+	}
+	if diags := modifyPlan(2, 95); len(diags) != 0 {
+		t.Fatalf("expected no diagnostic when all members fit, got %v", diags)
+	}
+
+	diags := modifyPlan(3, 50)
+	if diags.HasError() || diags.WarningsCount() != 1 {
+		t.Fatalf("expected a single warning, got %v", diags)
+	}
+	detail := diags.Warnings()[0].Detail()
+	if !strings.Contains(detail, "2 members") || !strings.Contains(detail, "d: 2\nb: 95") || strings.Contains(detail, "a: 3") || strings.Contains(detail, "c: 42") {
+		t.Errorf("expected the members d and b to be listed in id order, got %s", detail)
+	}
+}
+
+func TestIdPoolResourceUpdate_renameKeepsMembers(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 100)
+	pool.NoReuse = true
+	pool.IdPattern = "^svc-"
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"svc-a": 3, "svc-b": 42, "svc-c": 7}, time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	pool.recordReservation("svc-b", 60, time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC))
+	oldConnector := p.idPoolConnector("old")
+	if err := oldConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &IdPoolResource{providerData: p}
+	model := func(name string) *IdPoolResourceModel {
+		model := newIdPoolModel(name, 1, 100)
+		model.Id = types.StringValue("old")
+		model.NoReuse = types.BoolValue(true)
+		model.IdPattern = types.StringValue("^svc-")
+		return model
+	}
+	state := newState(t, r, model("old"))
+	plan := newPlan(t, r, model("new"))
+	resp := &fwresource.UpdateResponse{State: state}
+	r.Update(ctx, fwresource.UpdateRequest{Plan: plan, State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+
+	var renamed StoredIdPool
+	newConnector := p.idPoolConnector("new")
+	if err := newConnector.Read(ctx, &renamed); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(renamed.Members, pool.Members) || !reflect.DeepEqual(renamed.Records, pool.Records) {
+		t.Errorf("expected the members to be kept exactly, got %v and %v", renamed.Members, renamed.Records)
+	}
+	if renamed.NextFree != pool.NextFree || renamed.IdPattern != pool.IdPattern || !renamed.NoReuse {
+		t.Errorf("expected the settings to be kept, got %+v", renamed)
+	}
+	var old StoredIdPool
+	if err := oldConnector.Read(ctx, &old); !errors.Is(err, storage.ErrObjectNotExist) {
+		t.Errorf("expected the old pool object to be deleted, got %v", err)
+	}
+}
+
+func TestIdPoolResourceDelete_cleanupLock(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	// The lock of a crashed run does not block the deletion without locking.
+	p.SkipLock = types.BoolValue(true)
+	ctx := context.Background()
+
+	r := &IdPoolResource{providerData: p}
+	for _, cleanup := range []bool{false, true} {
+		createTestIdPool(t, p, "pool", 1, 10)
+		gcpConnector := p.idPoolConnector("pool")
+		server.Put(testBucket, gcpConnector.GetLockPath(ctx), []byte("00000000-0000-0000-0000-000000000001"))
+		server.SetUpdated(testBucket, gcpConnector.GetLockPath(ctx), time.Now().Add(-time.Hour))
+		otherConnector := p.idPoolConnector("pool-other")
+		server.Put(testBucket, otherConnector.GetLockPath(ctx), []byte("00000000-0000-0000-0000-000000000002"))
+		server.SetUpdated(testBucket, otherConnector.GetLockPath(ctx), time.Now().Add(-time.Hour))
+
+		model := newIdPoolModel("pool", 1, 10)
+		model.Id = types.StringValue("pool")
+		model.CleanupLockOnDelete = types.BoolValue(cleanup)
+		state := newState(t, r, model)
+		resp := &fwresource.DeleteResponse{State: state}
+		r.Delete(ctx, fwresource.DeleteRequest{State: state}, resp)
+		if resp.Diagnostics.HasError() || resp.Diagnostics.WarningsCount() != 0 {
+			t.Fatal(resp.Diagnostics)
+		}
+
+		if _, ok := server.Get(testBucket, gcpConnector.GetFullFilePath()); ok {
+			t.Fatal("expected the pool object to be deleted")
+		}
+		if _, ok := server.Get(testBucket, gcpConnector.GetLockPath(ctx)); ok == cleanup {
+			t.Fatalf("expected the stale lock to be deleted only with cleanup_lock_on_delete, got kept %v with %v", ok, cleanup)
+		}
+		if _, ok := server.Get(testBucket, otherConnector.GetLockPath(ctx)); !ok {
+			t.Fatal("expected the lock of another pool to be kept")
+		}
+	}
+}
+
+func TestIdPoolResourceCreateUpdate_resize(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	r := &IdPoolResource{providerData: p}
+	freeRanges := func(state tfsdk.State) string {
+		var data IdPoolResourceModel
+		state.Get(ctx, &data)
+		var ranges []string
+		for _, element := range data.FreeRanges.Elements() {
+			attrs := element.(types.Object).Attributes()
+			ranges = append(ranges, fmt.Sprintf("%s-%s", attrs["from"], attrs["to"]))
+		}
+		return fmt.Sprint(ranges)
+	}
+
+	createResp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+	r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newIdPoolModel("pool", 1, 10))}, createResp)
+	if createResp.Diagnostics.HasError() {
+		t.Fatal(createResp.Diagnostics)
+	}
+	if got := freeRanges(createResp.State); got != "[1-10]" {
+		t.Fatalf("unexpected free ranges of the new pool: %s", got)
+	}
+
+	gcpConnector := p.idPoolConnector("pool")
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if err := stored.seedMembers(map[string]IdPoolTools.ID{"a": 3, "b": 9}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := gcpConnector.Write(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	state := createResp.State
+	update := func(startFrom int64, endTo int64) *fwresource.UpdateResponse {
+		resp := &fwresource.UpdateResponse{State: state}
+		r.Update(ctx, fwresource.UpdateRequest{Plan: newPlan(t, r, newIdPoolModel("pool", startFrom, endTo)), State: state}, resp)
+		return resp
+	}
+
+	resp := update(1, 20)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	if got := freeRanges(resp.State); got != "[1-2 4-8 10-20]" {
+		t.Fatalf("unexpected free ranges after growing the pool: %s", got)
+	}
+	state = resp.State
+
+	if resp := update(1, 5); !resp.Diagnostics.HasError() {
+		t.Fatal("expected the pool not to shrink below a member")
+	}
+
+	resp = update(3, 9)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	if got := freeRanges(resp.State); got != "[4-8]" {
+		t.Fatalf("unexpected free ranges after shrinking the pool: %s", got)
+	}
+	var resized StoredIdPool
+	if err := gcpConnector.Read(ctx, &resized); err != nil {
+		t.Fatal(err)
+	}
+	if resized.StartFrom != 3 || resized.EndTo != 9 || len(resized.Members) != 2 || resized.Members["a"] != 3 || resized.Members["b"] != 9 {
+		t.Fatalf("expected the stored pool to be resized with its members, got %d-%d %v", resized.StartFrom, resized.EndTo, resized.Members)
+	}
+}
+
+func TestIdPoolResourceRead_recordsAccess(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	r := &IdPoolResource{providerData: p}
+	read := func(interval types.Int64) IdPoolResourceModel {
+		model := newIdPoolModel("pool", 1, 10)
+		model.Id = types.StringValue("pool")
+		model.AccessTrackingIntervalMinutes = interval
+		state := newState(t, r, model)
+		resp := &fwresource.ReadResponse{State: state}
+		r.Read(ctx, fwresource.ReadRequest{State: state}, resp)
+		if resp.Diagnostics.HasError() || resp.Diagnostics.WarningsCount() > 0 {
+			t.Fatal(resp.Diagnostics)
+		}
+		var data IdPoolResourceModel
+		resp.State.Get(ctx, &data)
+		return data
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	stored := func() StoredIdPool {
+		var pool StoredIdPool
+		if err := gcpConnector.Read(ctx, &pool); err != nil {
+			t.Fatal(err)
+		}
+		return pool
+	}
+
+	if data := read(types.Int64Null()); !data.LastAccessed.IsNull() || stored().LastAccessed != nil {
+		t.Fatalf("expected no access to be recorded without access_tracking_interval_minutes, got %s", data.LastAccessed)
+	}
+
+	data := read(types.Int64Value(60))
+	pool := stored()
+	if pool.LastAccessed == nil || data.LastAccessed.ValueString() != pool.LastAccessed.Format(time.RFC3339) {
+		t.Fatalf("expected the access to be recorded, got %s and %v", data.LastAccessed, pool.LastAccessed)
+	}
+	generation := gcpConnector.Generation
+	read(types.Int64Value(60))
+	stored()
+	if gcpConnector.Generation != generation {
+		t.Fatal("expected no write within the interval")
+	}
+
+	// Once the interval has elapsed, the next refresh records the access again.
+	lastAccessed := pool.LastAccessed.Add(-2 * time.Hour)
+	pool.LastAccessed = &lastAccessed
+	if err := gcpConnector.Write(ctx, &pool); err != nil {
+		t.Fatal(err)
+	}
+	read(types.Int64Value(60))
+	if pool := stored(); !pool.LastAccessed.After(lastAccessed.Add(time.Hour)) {
+		t.Fatalf("expected the access to be recorded again, got %v", pool.LastAccessed)
+	}
+}
+
+func TestIdPoolResourceCreateRead_emptyReservations(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	r := &IdPoolResource{providerData: p}
+	createResp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+	r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newIdPoolModel("pool", 1, 10))}, createResp)
+	if createResp.Diagnostics.HasError() {
+		t.Fatal(createResp.Diagnostics)
+	}
+	var created IdPoolResourceModel
+	createResp.State.Get(ctx, &created)
+	if created.Reservations.IsNull() || created.Reservations.IsUnknown() || len(created.Reservations.Elements()) != 0 {
+		t.Fatalf("expected an empty known reservations map after create, got %s", created.Reservations)
+	}
+
+	// A refresh of the unchanged pool, from the cache then from the bucket, gives the same reservations.
+	for _, cached := range []bool{true, false} {
+		if !cached {
+			invalidateCachedIdPool(p, "pool")
+		}
+		readResp := &fwresource.ReadResponse{State: createResp.State}
+		r.Read(ctx, fwresource.ReadRequest{State: createResp.State}, readResp)
+		if readResp.Diagnostics.HasError() {
+			t.Fatal(readResp.Diagnostics)
+		}
+		var read IdPoolResourceModel
+		readResp.State.Get(ctx, &read)
+		if !read.Reservations.Equal(created.Reservations) || !read.ExternallyManaged.Equal(created.ExternallyManaged) {
+			t.Fatalf("expected no diff on refresh (cached: %t), got %s then %s", cached, created.Reservations, read.Reservations)
+		}
+	}
+}
+
+func TestIdPoolResourceCreateRead_emptyPartitions(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	r := &IdPoolResource{providerData: p}
+	emptyPartitions := types.MapValueMust(types.ObjectType{AttrTypes: idRangeAttrTypes}, map[string]attr.Value{})
+	model := newIdPoolModel("pool", 1, 10)
+	model.Partitions = emptyPartitions
+	createResp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+	r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, createResp)
+	if createResp.Diagnostics.HasError() {
+		t.Fatal(createResp.Diagnostics)
+	}
+	var created IdPoolResourceModel
+	createResp.State.Get(ctx, &created)
+	if !created.Partitions.Equal(emptyPartitions) {
+		t.Fatalf("expected the planned empty partitions to be kept after create, got %s", created.Partitions)
+	}
+
+	invalidateCachedIdPool(p, "pool")
+	readResp := &fwresource.ReadResponse{State: createResp.State}
+	r.Read(ctx, fwresource.ReadRequest{State: createResp.State}, readResp)
+	if readResp.Diagnostics.HasError() {
+		t.Fatal(readResp.Diagnostics)
+	}
+	var read IdPoolResourceModel
+	readResp.State.Get(ctx, &read)
+	if !read.Partitions.Equal(emptyPartitions) {
+		t.Fatalf("expected no diff on refresh, got %s", read.Partitions)
+	}
+
+	// Partitions removed from the object are shown as a drift.
+	read.Partitions = types.MapValueMust(types.ObjectType{AttrTypes: idRangeAttrTypes}, map[string]attr.Value{
+		"low": types.ObjectValueMust(idRangeAttrTypes, map[string]attr.Value{"from": types.Int64Value(1), "to": types.Int64Value(5)}),
+	})
+	if err := idPoolFromToolToModel(&read, newStoredIdPool(1, 10), p); err != nil || !read.Partitions.IsNull() {
+		t.Fatalf("expected the partitions gone from the pool to be null, got %s: %v", read.Partitions, err)
+	}
+}
+
+func TestSetPoolOutputFormat(t *testing.T) {
+	pool := &StoredIdPool{}
+	if diags := setPoolOutputFormat(pool, types.StringValue(idOutputFormatHex), types.Int64Value(4), 0xffff); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if pool.OutputFormat != idOutputFormatHex || pool.OutputWidth != 4 {
+		t.Fatalf("expected the hex format with width 4 to be stored, got %q %d", pool.OutputFormat, pool.OutputWidth)
+	}
+	if diags := setPoolOutputFormat(pool, types.StringValue(idOutputFormatHex), types.Int64Value(4), 0x10000); !diags.HasError() {
+		t.Fatal("expected an error when end_to needs more digits than output_width")
+	}
+	if diags := setPoolOutputFormat(pool, types.StringValue(idOutputFormatDec), types.Int64Value(5), 99999); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if pool.OutputFormat != "" || pool.OutputWidth != 5 {
+		t.Fatalf("expected the dec format to be stored as the default, got %q %d", pool.OutputFormat, pool.OutputWidth)
+	}
+	if diags := setPoolOutputFormat(pool, types.StringValue("oct"), types.Int64Value(0), 10); !diags.HasError() {
+		t.Fatal("expected an error for an unknown output_format")
+	}
+	if diags := setPoolOutputFormat(pool, types.StringValue(idOutputFormatDec), types.Int64Value(-1), 10); !diags.HasError() {
+		t.Fatal("expected an error for a negative output_width")
+	}
+}
+
+func TestSetPoolBurstFrom(t *testing.T) {
+	pool := &StoredIdPool{BurstFrom: 5}
+	if diags := setPoolBurstFrom(pool, types.Int64Value(8), 1, 10); diags.HasError() || pool.BurstFrom != 8 {
+		t.Fatalf("expected burst_from 8 to be stored, got %d: %v", pool.BurstFrom, diags)
+	}
+	for _, value := range []int64{1, 11} {
+		if diags := setPoolBurstFrom(pool, types.Int64Value(value), 1, 10); !diags.HasError() {
+			t.Fatalf("expected an error for burst_from %d out of the range 1-10", value)
+		}
+	}
+	if diags := setPoolBurstFrom(pool, types.Int64Null(), 1, 10); diags.HasError() || pool.BurstFrom != 0 {
+		t.Fatalf("expected the burst region to be removed, got %d: %v", pool.BurstFrom, diags)
+	}
+}
+
+func TestCheckPoolRename(t *testing.T) {
+	model := func(name string, preventRename types.Bool) IdPoolResourceModel {
+		return IdPoolResourceModel{Name: types.StringValue(name), PreventRename: preventRename}
+	}
+	if diags := checkPoolRename(model("old", types.BoolNull()), model("new", types.BoolValue(false))); diags.HasError() {
+		t.Fatal(diags)
+	}
+	for _, pair := range [][2]types.Bool{{types.BoolValue(true), types.BoolValue(true)}, {types.BoolValue(true), types.BoolNull()}, {types.BoolNull(), types.BoolValue(true)}} {
+		if diags := checkPoolRename(model("old", pair[0]), model("new", pair[1])); !diags.HasError() {
+			t.Fatalf("expected the rename to be rejected with prevent_rename %v then %v", pair[0], pair[1])
+		}
+	}
+}
+
+func TestIdPoolFromToolToModel_maxStateReservations(t *testing.T) {
+	pool := newStoredIdPool(1, 10)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 1, "b": 2, "c": 3}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		max      types.Int64
+		pool     *StoredIdPool
+		expected int
+	}{
+		{types.Int64Null(), pool, 3},
+		{types.Int64Value(3), pool, 3},
+		{types.Int64Value(2), pool, -1},
+		{types.Int64Value(0), pool, -1},
+		{types.Int64Value(0), newStoredIdPool(1, 10), 0},
+	} {
+		p := newTestProviderData()
+		p.MaxStateReservations = tc.max
+		var data IdPoolResourceModel
+		if err := idPoolFromToolToModel(&data, tc.pool, p); err != nil {
+			t.Fatal(err)
+		}
+		if data.UsedCount.ValueInt64() != int64(len(tc.pool.Members)) {
+			t.Fatalf("expected used_count %d with max_state_reservations %s, got %s", len(tc.pool.Members), tc.max, data.UsedCount)
+		}
+		if tc.expected < 0 {
+			if !data.Reservations.IsNull() {
+				t.Fatalf("expected the reservations to be left null with max_state_reservations %s, got %s", tc.max, data.Reservations)
+			}
+		} else if data.Reservations.IsNull() || len(data.Reservations.Elements()) != tc.expected {
+			t.Fatalf("expected %d reservations with max_state_reservations %s, got %s", tc.expected, tc.max, data.Reservations)
+		}
+	}
+}
@@ -2,20 +2,30 @@ package provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/listplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/objectplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringdefault"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-framework/types/basetypes"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
 	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
-	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+
+	"cloud.google.com/go/storage"
 )
 
 // Ensure provider defined types fully satisfy framework interfaces.
@@ -24,6 +34,15 @@ var _ resource.ResourceWithImportState = &IdRequestResource{}
 
 const IdRequestResourceName = "id_request"
 
+// The values of on_exhaustion: fail the creation of the id_request when its pools are full, or create it without id.
+const (
+	onExhaustionError = "error"
+	onExhaustionSkip  = "skip"
+)
+
+// namespaceSeparator joins the namespace and the id of an id_request in the name of its member.
+const namespaceSeparator = ":"
+
 func NewIdRequestResource() resource.Resource {
 	return &IdRequestResource{}
 }
@@ -34,8 +53,32 @@ type IdRequestResource struct {
 
 type IdRequestResourceModel struct {
 	Id          types.String `tfsdk:"id"`
+	Namespace   types.String `tfsdk:"namespace"`
 	Pool        types.String `tfsdk:"pool"`
+	Pools       types.List   `tfsdk:"pools"`
+	Referential types.String `tfsdk:"referential"`
 	RequestedId types.Int64  `tfsdk:"requested_id"`
+	// RequestedIdFormatted is derived from RequestedId with the output format of the pool, it is not stored.
+	RequestedIdFormatted types.String   `tfsdk:"requested_id_formatted"`
+	Label                types.String   `tfsdk:"label"`
+	TTLMinutes           types.Int64    `tfsdk:"ttl_minutes"`
+	OnExhaustion         types.String   `tfsdk:"on_exhaustion"`
+	ReclaimDrift         types.Bool     `tfsdk:"reclaim_on_drift"`
+	AdoptExisting        types.Bool     `tfsdk:"adopt_existing"`
+	RecoverInterrupted   types.Bool     `tfsdk:"recover_interrupted_create"`
+	AllowBurst           types.Bool     `tfsdk:"allow_burst"`
+	Partition            types.String   `tfsdk:"partition"`
+	Metadata             types.Map      `tfsdk:"metadata"`
+	PoolGeneration       types.Int64    `tfsdk:"pool_generation"`
+	WarnOnPoolChange     types.Bool     `tfsdk:"warn_on_pool_change"`
+	StrictRange          types.Bool     `tfsdk:"strict_range"`
+	ValueFilter          types.Object   `tfsdk:"value_filter"`
+	Timeouts             timeouts.Value `tfsdk:"timeouts"`
+}
+
+type IdRequestValueFilterModel struct {
+	Mod       types.Int64 `tfsdk:"mod"`
+	Remainder types.Int64 `tfsdk:"remainder"`
 }
 
 func (r *IdRequestResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
@@ -51,21 +94,149 @@ func (r *IdRequestResource) Schema(ctx context.Context, req resource.SchemaReque
 				Optional:            false,
 				Required:            true,
 			},
+			"namespace": schema.StringAttribute{
+				MarkdownDescription: "An optional namespace combined with id to form the name of the member in the pool, `namespace:id`, for example `prod` so that the id_requests `frontend` of several environments get independent ids from one pool. " +
+					"It must not contain `:`, and the id_pattern of the pool applies to the combined name. It can be changed without replacing the id_request, the member is renamed as for a change of id. Default to the id alone",
+				Optional: true,
+			},
 			"pool": schema.StringAttribute{
-				MarkdownDescription: "The name of the pool, to make the id_request on. If you change it, the id_request will be destroyed and recreate",
-				Optional:            false,
-				Required:            true,
+				MarkdownDescription: "The name of the pool, to make the id_request on. If you change it, the id_request will be destroyed and recreate, unless the new pool already holds the id_request with the same id or does not exist yet: " +
+					"the change then follows a rename of the id_pool, planned in the same apply when pool references the name of the id_pool, and the id is kept. When pools is set instead, it is the pool the id was allocated from",
+				Optional: true,
+				Computed: true,
 				PlanModifiers: []planmodifier.String{
-					stringplanmodifier.RequiresReplace(),
+					stringplanmodifier.UseStateForUnknown(),
+					poolRenamePlanModifier{resource: r},
+				},
+			},
+			"pools": schema.ListAttribute{
+				MarkdownDescription: "An ordered list of pools to make the id_request on, instead of pool: the id is allocated from the first pool that still has a free id, for example a primary pool then an overflow pool. If you change it so that it no longer contains the pool the id was allocated from, the id_request will be destroyed and recreate",
+				ElementType:         types.StringType,
+				Optional:            true,
+				PlanModifiers: []planmodifier.List{
+					listplanmodifier.RequiresReplaceIf(requiresReplaceIfAllocatedPoolRemoved, "The pool the id was allocated from is no longer in pools", "The pool the id was allocated from is no longer in `pools`"),
 				},
 			},
 			"requested_id": schema.Int64Attribute{
-				MarkdownDescription: "The requested id from the pool, a free one that will be reserved for this resource",
+				MarkdownDescription: "The requested id from the pool, a free one that will be reserved for this resource. Null when the creation was skipped because of on_exhaustion",
 				Computed:            true,
 				PlanModifiers: []planmodifier.Int64{
 					int64planmodifier.UseStateForUnknown(),
 				},
 			},
+			"requested_id_formatted": schema.StringAttribute{
+				MarkdownDescription: "The requested id rendered with the output_format and output_width of the pool, for example `0x00a3`, to embed it in names. It follows the format of the pool on refresh. Null when the creation was skipped because of on_exhaustion",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"label": schema.StringAttribute{
+				MarkdownDescription: "The label rendered from the label_template of the pool with the requested id, stored with the reservation so that it stays the same across reads. Null when the pool has no label_template",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"value_filter": schema.SingleNestedAttribute{
+				MarkdownDescription: "An optional filter on the allocated id: only an id where `id % mod == remainder` is allocated, the lowest free one, or the highest in a desc pool. If you change it, the id_request will be destroyed and recreate",
+				Optional:            true,
+				Attributes: map[string]schema.Attribute{
+					"mod": schema.Int64Attribute{
+						MarkdownDescription: "The modulus applied to the candidate ids, must be greater than 0",
+						Required:            true,
+					},
+					"remainder": schema.Int64Attribute{
+						MarkdownDescription: "The expected remainder, between 0 and mod - 1",
+						Required:            true,
+					},
+				},
+				PlanModifiers: []planmodifier.Object{
+					objectplanmodifier.RequiresReplace(),
+				},
+			},
+			"on_exhaustion": schema.StringAttribute{
+				MarkdownDescription: "What to do when no pool has a free id left at creation: `error` (default) fails the apply, `skip` only emits a warning and creates the id_request with a null requested_id, so that the resources depending on it can be conditioned on it. " +
+					"A skipped id_request stays in the state without id and is not retried on the next applies, even once ids are freed: replace it, for example with `terraform apply -replace`, to allocate an id, or set on_exhaustion back to `error` so that it is created again after the next refresh. Destroying a skipped id_request does not touch any pool",
+				Optional: true,
+				Computed: true,
+				Default:  stringdefault.StaticString(onExhaustionError),
+			},
+			"pool_generation": schema.Int64Attribute{
+				MarkdownDescription: "The generation of the pool object when the id_request was last created, updated or refreshed, to tell whether the pool changed since. Null when the creation was skipped because of on_exhaustion",
+				Computed:            true,
+			},
+			"warn_on_pool_change": schema.BoolAttribute{
+				MarkdownDescription: "If true, updating or deleting the id_request warns when its pool was modified since the id_request was last refreshed, typically since the plan, by another process or by the other resources of the apply, to give insight into the interference between runs on a shared pool. " +
+					"The apply goes on. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"strict_range": schema.BoolAttribute{
+				MarkdownDescription: "If true, a refresh fails when the id of the id_request is out of the current range of its pool, [start_from, end_to], for example after the pool object was edited by hand to shrink it, to detect the stranded id_requests of a strict referential. " +
+					"Otherwise the refresh only warns. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"reclaim_on_drift": schema.BoolAttribute{
+				MarkdownDescription: "If true, an id_request whose member was removed from its pool outside of Terraform is added back with its requested_id on refresh, instead of being removed from the state and created again with another id. " +
+					"The refresh fails if the id was taken since by another member. It does not apply to an id_request with ttl_minutes, whose member is expected to go away once expired. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"allow_burst": schema.BoolAttribute{
+				MarkdownDescription: "If true, the id may be allocated in the burst region of the pool, from its burst_from, once the ids below are exhausted, or first in a desc pool. " +
+					"It only applies to the allocation, a change does not move the id. Default to false, the burst region is never used",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"referential": schema.StringAttribute{
+				MarkdownDescription: "The name of one of the referentials of the provider to find the pool in, instead of referential_bucket. If you change it, the id_request will be destroyed and recreate. Default to referential_bucket",
+				Optional:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"partition": schema.StringAttribute{
+				MarkdownDescription: "The name of a partition of the pool to allocate the id in, only the ids of its subrange are then allocated, for example `reserved` for the ids kept for the core services. " +
+					"The creation fails when the pool has no such partition, with pools every pool must define it. If you change it, the id_request will be destroyed and recreate. Default to the whole pool",
+				Optional: true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"adopt_existing": schema.BoolAttribute{
+				MarkdownDescription: "If true, creating an id_request whose id is already a member of the pool adopts the id it holds instead of failing, as an import would, so that declaring the same id again is idempotent. " +
+					"The id is adopted as is: neither value_filter nor ttl_minutes are applied to it. With pools, the id is adopted from the first pool holding it or with a free id left. Default to false, the creation fails and asks to import. Two id_requests of one configuration declaring the same id on a pool are reported as such rather than adopted, one of them fails",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"recover_interrupted_create": schema.BoolAttribute{
+				MarkdownDescription: "If true, a creation retried after an interrupted apply, which wrote the pool but not the state, takes back the id it reserved instead of failing: the member is recognized when it was reserved less than 24 hours ago with the same ttl_minutes and metadata and an id passing value_filter. " +
+					"Nothing proves that the member was reserved by this id_request rather than by another configuration declaring the same id on the pool with the same settings, which would then share the id: only set it when the ids of the pool are not declared by several configurations. " +
+					"Default to false, the creation fails as the id is already present, import it to recover it",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"metadata": schema.MapAttribute{
+				MarkdownDescription: "Optional free-form metadata recorded with the reservation in the pool object, for example `team = \"payments\"`, to slice a shared pool by ownership with the id_pool_members data source. It can be changed without replacing the id_request. " +
+					"With adopt_existing, it replaces the metadata of the adopted member",
+				ElementType: types.StringType,
+				Optional:    true,
+			},
+			"ttl_minutes": schema.Int64Attribute{
+				MarkdownDescription: "An optional lifetime of the reservation in minutes. Once elapsed the id is released by the next id_request created on the pool, the other operations leave it in the pool object, and the id_request is removed from the state on next refresh, so it will be created again. Any update of the id_request renews the reservation",
+				Optional:            true,
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
 		},
 	}
 }
@@ -91,52 +262,250 @@ func (r *IdRequestResource) Create(ctx context.Context, req resource.CreateReque
 		return
 	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Pool.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
-
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
-	if err != nil {
-		resp.Diagnostics.AddError("id_request creation error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Pool.ValueString(), err.Error()))
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if data.TTLMinutes.ValueInt64() < 0 {
+		resp.Diagnostics.AddError("id_request creation error", "ttl_minutes must be a positive number of minutes")
+		return
+	}
+	resp.Diagnostics.Append(validateOnExhaustion(data.OnExhaustion)...)
+	resp.Diagnostics.Append(validateNamespace(data.Namespace)...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Pool.ValueString(), err.Error()))
-		}
-	}()
 
-	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.Pool.ValueString(), &gcpConnector)
-	if err != nil {
-		resp.Diagnostics.AddError("id_request creation error", fmt.Sprintf("Cannot find pool '%s' to make the id_request on: %s", data.Pool.ValueString(), err.Error()))
+	filter, diags := valueFilterFromModel(ctx, data.ValueFilter)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	_, ok := cachedPool.Pool.Members[data.Id.ValueString()]
-	if ok {
-		resp.Diagnostics.AddError("id_request creation error", "The id of your id_request is already present in the pool, be sure you did not make any mistake, or consider to import")
+	poolNames, diags := candidatePools(ctx, data)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
 		return
 	}
-	generatedId := cachedPool.Pool.AllocateID(data.Id.ValueString())
-	if generatedId == IdPoolTools.NoID {
-		resp.Diagnostics.AddError("id_request creation error", "There is no more id available in the pool")
+
+	// Each pool is tried under its own lock, the next one only when it is exhausted.
+	for _, poolName := range poolNames {
+		generatedId := r.allocateFromPool(ctx, p, poolName, &data, filter, &resp.Diagnostics)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		if generatedId == IdPoolTools.NoID {
+			tflog.Info(ctx, fmt.Sprintf("Pool %s is exhausted for id_request %s", poolName, data.member()))
+			continue
+		}
+		data.Pool = types.StringValue(poolName)
+		data.RequestedId = types.Int64Value(int64(generatedId))
+
+		// Save data into Terraform state
+		resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 		return
 	}
-	data.RequestedId = types.Int64Value(int64(generatedId))
 
-	err = gcpConnector.Write(ctx, cachedPool.Pool)
-	if err != nil {
-		resp.Diagnostics.AddError("id_request creation error", fmt.Sprintf("Cannot update pool on the referential_bucket: %s", err.Error()))
+	exhaustedMessage := fmt.Sprintf("There is no more id available in pool %s", strings.Join(poolNames, ", "))
+	if filter != nil {
+		exhaustedMessage = fmt.Sprintf("There is no more id matching value_filter available in pool %s", strings.Join(poolNames, ", "))
+	}
+	if data.OnExhaustion.ValueString() != onExhaustionSkip {
+		resp.Diagnostics.AddError("id_request creation error", exhaustedMessage)
 		return
 	}
-	// Invalidate the cache for this pool to force a re-read on the next operation.
-	r.providerData.CacheMutex.Lock()
-	delete(r.providerData.IdPoolsCache, data.Pool.ValueString())
-	r.providerData.CacheMutex.Unlock()
+	resp.Diagnostics.AddWarning("id_request skipped", fmt.Sprintf("%s, id_request %s is created without requested_id since on_exhaustion is skip. Replace it to allocate an id once ids are freed", exhaustedMessage, data.member()))
+	if data.Pool.IsUnknown() {
+		data.Pool = types.StringNull()
+	}
+	data.RequestedId = types.Int64Null()
+	data.RequestedIdFormatted = types.StringNull()
+	data.PoolGeneration = types.Int64Null()
+	data.Label = types.StringNull()
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
+// validateOnExhaustion checks the value of on_exhaustion.
+func validateOnExhaustion(value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.IsNull() || value.IsUnknown() {
+		return diags
+	}
+	if v := value.ValueString(); v != onExhaustionError && v != onExhaustionSkip {
+		diags.AddAttributeError(path.Root("on_exhaustion"), "Invalid on_exhaustion", fmt.Sprintf("on_exhaustion must be %q or %q, got: %q", onExhaustionError, onExhaustionSkip, v))
+	}
+	return diags
+}
+
+// isSkipped reports whether the id_request was created without id because its pools were full.
+// An imported id_request has no requested_id either until read, but no on_exhaustion.
+func (data IdRequestResourceModel) isSkipped() bool {
+	return data.RequestedId.IsNull() && data.OnExhaustion.ValueString() == onExhaustionSkip
+}
+
+// member returns the name of the member of the id_request in its pool: its id, prefixed with its namespace when set.
+func (data IdRequestResourceModel) member() string {
+	if data.Namespace.IsNull() || data.Namespace.IsUnknown() {
+		return data.Id.ValueString()
+	}
+	return data.Namespace.ValueString() + namespaceSeparator + data.Id.ValueString()
+}
+
+// validateNamespace checks the value of namespace.
+func validateNamespace(value types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if value.IsNull() || value.IsUnknown() {
+		return diags
+	}
+	if v := value.ValueString(); v == "" || strings.Contains(v, namespaceSeparator) {
+		diags.AddAttributeError(path.Root("namespace"), "Invalid namespace", fmt.Sprintf("namespace must be a non empty string without %q, got: %q", namespaceSeparator, v))
+	}
+	return diags
+}
+
+// metadataValues decodes the metadata attribute of an id_request, nil when it is not set.
+func metadataValues(ctx context.Context, value types.Map) (map[string]string, diag.Diagnostics) {
+	if value.IsNull() || value.IsUnknown() {
+		return nil, nil
+	}
+	var metadata map[string]string
+	diags := value.ElementsAs(ctx, &metadata, false)
+	return metadata, diags
+}
+
+// allocateFromPool reserves an id for data in the given pool, batched with the concurrent creations made on it.
+// It returns NoID without error when the pool has no free id left.
+func (r *IdRequestResource) allocateFromPool(ctx context.Context, p *GCSReferentialProviderModel, poolName string, data *IdRequestResourceModel, filter idFilter, diags *diag.Diagnostics) IdPoolTools.ID {
+	metadata, metadataDiags := metadataValues(ctx, data.Metadata)
+	diags.Append(metadataDiags...)
+	if diags.HasError() {
+		return IdPoolTools.NoID
+	}
+	result := allocateInBatch(ctx, p, poolName, &allocationRequest{
+		member:             data.member(),
+		filter:             filter,
+		ttlMinutes:         data.TTLMinutes.ValueInt64(),
+		adoptExisting:      data.AdoptExisting.ValueBool(),
+		recoverInterrupted: data.RecoverInterrupted.ValueBool(),
+		allowBurst:         data.AllowBurst.ValueBool(),
+		partition:          data.Partition.ValueString(),
+		metadata:           metadata,
+	})
+	diags.Append(result.diags...)
+	if result.id != IdPoolTools.NoID {
+		data.Label = labelValue(result.label)
+		data.RequestedIdFormatted = types.StringValue(result.formatted)
+		data.PoolGeneration = types.Int64Value(result.generation)
+	}
+	return result.id
+}
+
+// labelValue returns the label attribute of a member label, null when it has none.
+func labelValue(label string) types.String {
+	if label == "" {
+		return types.StringNull()
+	}
+	return types.StringValue(label)
+}
+
+// candidatePools returns the pools to try in order, from either pool or pools.
+func candidatePools(ctx context.Context, data IdRequestResourceModel) ([]string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	hasPool := !data.Pool.IsNull() && !data.Pool.IsUnknown()
+	hasPools := !data.Pools.IsNull() && !data.Pools.IsUnknown()
+	if hasPool == hasPools {
+		diags.AddAttributeError(path.Root("pools"), "Invalid pool configuration", "Exactly one of pool or pools must be set")
+		return nil, diags
+	}
+	if hasPool {
+		return []string{data.Pool.ValueString()}, diags
+	}
+	var poolNames []string
+	diags.Append(data.Pools.ElementsAs(ctx, &poolNames, false)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	if len(poolNames) == 0 {
+		diags.AddAttributeError(path.Root("pools"), "Invalid pool configuration", "pools must contain at least one pool")
+	}
+	return poolNames, diags
+}
+
+// requiresReplaceIfAllocatedPoolRemoved replaces the id_request only when pools no longer contains the pool
+// the id was allocated from, so that adding an overflow pool keeps the current id.
+func requiresReplaceIfAllocatedPoolRemoved(ctx context.Context, req planmodifier.ListRequest, resp *listplanmodifier.RequiresReplaceIfFuncResponse) {
+	var allocatedPool types.String
+	resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("pool"), &allocatedPool)...)
+	if resp.Diagnostics.HasError() || allocatedPool.IsNull() || req.PlanValue.IsUnknown() {
+		return
+	}
+	if req.PlanValue.IsNull() {
+		// Back to the single pool attribute, which replaces the id_request itself if it differs.
+		return
+	}
+	var poolNames []string
+	resp.Diagnostics.Append(req.PlanValue.ElementsAs(ctx, &poolNames, false)...)
+	for _, poolName := range poolNames {
+		if poolName == allocatedPool.ValueString() {
+			return
+		}
+	}
+	resp.RequiresReplace = true
+}
+
+// poolRenamePlanModifier replaces the id_request when its pool changes, unless the change follows a rename of the pool:
+// the new pool already holds the id_request with the same id, or does not exist yet because the rename is planned in the same apply.
+// The rename is checked again by Update once the pool is renamed.
+type poolRenamePlanModifier struct {
+	resource *IdRequestResource
+}
+
+func (m poolRenamePlanModifier) Description(ctx context.Context) string {
+	return "The id_request is replaced when the pool changes, unless the change follows a rename of the pool."
+}
+
+func (m poolRenamePlanModifier) MarkdownDescription(ctx context.Context) string {
+	return m.Description(ctx)
+}
+
+func (m poolRenamePlanModifier) PlanModifyString(ctx context.Context, req planmodifier.StringRequest, resp *planmodifier.StringResponse) {
+	if req.State.Raw.IsNull() || req.Plan.Raw.IsNull() || req.PlanValue.IsUnknown() || req.PlanValue.Equal(req.StateValue) {
+		return
+	}
+	var data IdRequestResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if req.StateValue.IsNull() || data.RequestedId.IsNull() || m.resource.providerData == nil {
+		resp.RequiresReplace = true
+		return
+	}
+	poolName := req.PlanValue.ValueString()
+	gcpConnector := m.resource.providerData.idPoolConnector(poolName)
+	cachedPool, err := getAndCacheIdPool(ctx, m.resource.providerData, poolName, &gcpConnector)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		// The pool is expected to be renamed to poolName in the same apply.
+		return
+	}
+	if err != nil {
+		resp.Diagnostics.AddError("id_request plan error", objectDetail(&gcpConnector, "Cannot read pool %s to check the pool change of id_request %s: %s", poolName, data.member(), err.Error()))
+		return
+	}
+	if value, ok := cachedPool.Pool.Members[data.member()]; !ok || int64(value) != data.RequestedId.ValueInt64() {
+		resp.RequiresReplace = true
+	}
+}
+
 func (r *IdRequestResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
 	var data IdRequestResourceModel
 
@@ -145,31 +514,123 @@ func (r *IdRequestResource) Read(ctx context.Context, req resource.ReadRequest,
 		return
 	}
 
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Read)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	tflog.Debug(ctx, fmt.Sprintf("Start read id_request %s", data.Id))
+	if data.isSkipped() {
+		// Nothing is reserved in any pool.
+		return
+	}
+	if data.RequestedId.IsNull() && data.Pool.IsNull() {
+		// A skipped id_request whose on_exhaustion was set back to error, to be created again.
+		resp.State.RemoveResource(ctx)
+		return
+	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Pool.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	gcpConnector := p.idPoolConnector(data.Pool.ValueString())
 
-	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.Pool.ValueString(), &gcpConnector)
+	cachedPool, err := getAndCacheIdPool(ctx, p, data.Pool.ValueString(), &gcpConnector)
 	if err != nil {
-		resp.Diagnostics.AddError("id_request read error", fmt.Sprintf("Cannot find pool '%s' to make the id_request on: %s", data.Pool.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_request read error", objectDetail(&gcpConnector, "Cannot find pool '%s' to read id_request %s: %s", data.Pool.ValueString(), data.member(), err.Error()))
 		return
 	}
 	tflog.Debug(ctx, fmt.Sprintf("Get value %s", data.Id))
-	value, ok := cachedPool.Pool.Members[data.Id.ValueString()]
+	value, ok := cachedPool.Pool.Members[data.member()]
+	if !ok && data.ReclaimDrift.ValueBool() && data.TTLMinutes.IsNull() && !data.RequestedId.IsNull() {
+		value = r.reclaimMember(ctx, p, data, &resp.Diagnostics)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		ok = true
+	}
 	if !ok {
-		tflog.Warn(ctx, fmt.Sprintf("id_request %s not found in pool %s, removing from state.", data.Id.ValueString(), data.Pool.ValueString()))
+		tflog.Warn(ctx, fmt.Sprintf("id_request %s not found in pool %s, removing from state.", data.member(), data.Pool.ValueString()))
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	if cachedPool.Pool.isExpired(data.member(), time.Now()) {
+		tflog.Warn(ctx, fmt.Sprintf("id_request %s reservation in pool %s has expired, removing from state.", data.member(), data.Pool.ValueString()))
 		resp.State.RemoveResource(ctx)
 		return
 	}
 	tflog.Debug(ctx, fmt.Sprintf("SAVE THE ID %s", value))
 	data.RequestedId = types.Int64Value(int64(value))
+	data.RequestedIdFormatted = types.StringValue(cachedPool.Pool.formatId(value))
+	data.PoolGeneration = types.Int64Value(cachedPool.Generation)
+	data.Label = labelValue(cachedPool.Pool.memberLabel(data.member()))
+	checkRequestInRange(data, cachedPool.Pool, &resp.Diagnostics)
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 
 }
 
+// checkRequestInRange reports an id of the id_request of data out of the range of its pool, which only happens when the
+// pool object was edited outside of Terraform: the update of an id_pool refuses to strand its members. It is an error
+// with strict_range, a warning otherwise, the id stays reserved either way.
+func checkRequestInRange(data IdRequestResourceModel, pool *StoredIdPool, diags *diag.Diagnostics) {
+	value := IdPoolTools.ID(data.RequestedId.ValueInt64())
+	if value >= pool.StartFrom && value <= pool.EndTo {
+		return
+	}
+	detail := fmt.Sprintf("The id %d of id_request %s is out of the range [%d, %d] of pool %s, the pool was likely shrunk outside of Terraform. "+
+		"Replace the id_request, for example with `terraform apply -replace`, to allocate an id in the range, or restore the range of the pool", value, data.member(), pool.StartFrom, pool.EndTo, data.Pool.ValueString())
+	if data.StrictRange.ValueBool() {
+		diags.AddAttributeError(path.Root("requested_id"), "id_request out of pool range", detail)
+		return
+	}
+	diags.AddAttributeWarning(path.Root("requested_id"), "id_request out of pool range", detail)
+}
+
+// reclaimMember adds back to its pool the member of data, removed outside of Terraform, with the id recorded in the state.
+func (r *IdRequestResource) reclaimMember(ctx context.Context, p *GCSReferentialProviderModel, data IdRequestResourceModel, diags *diag.Diagnostics) IdPoolTools.ID {
+	poolName := data.Pool.ValueString()
+	gcpConnector := p.idPoolConnector(poolName)
+
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError("id_request read error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s to add back id_request %s: %s", poolName, data.member(), err.Error()))
+		return IdPoolTools.NoID
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), diags)
+
+	cachedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
+	if err != nil {
+		diags.AddError("id_request read error", objectDetail(&gcpConnector, "Cannot find pool '%s' to add back id_request %s: %s", poolName, data.member(), err.Error()))
+		return IdPoolTools.NoID
+	}
+	if value, ok := cachedPool.Pool.Members[data.member()]; ok {
+		// Added back by another run since the first read.
+		return value
+	}
+
+	value := IdPoolTools.ID(data.RequestedId.ValueInt64())
+	if err := cachedPool.Pool.reclaim(data.member(), value, time.Now()); err != nil {
+		diags.AddError("id_request read error", objectDetail(&gcpConnector, "id_request %s was removed from pool %s outside of Terraform and cannot be added back with reclaim_on_drift: %s. "+
+			"Replace the id_request, for example with `terraform apply -replace`, to allocate another id", data.member(), poolName, err.Error()))
+		return IdPoolTools.NoID
+	}
+	if err := gcpConnector.Write(ctx, cachedPool.Pool); err != nil {
+		addPoolWriteError(diags, "id_request read error", poolName, &gcpConnector, err)
+		return IdPoolTools.NoID
+	}
+	// Keep the written pool in cache, Write updated the connector's generation.
+	storeCachedIdPool(p, poolName, cachedPool.Pool, &gcpConnector)
+	writePoolMirror(ctx, p, poolName, cachedPool.Pool, diags)
+	tflog.Warn(ctx, fmt.Sprintf("id_request %s was removed from pool %s outside of Terraform, added it back with id %d since reclaim_on_drift is set.", data.member(), poolName, value))
+	return value
+}
+
 func (r *IdRequestResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data IdRequestResourceModel
 	var newData IdRequestResourceModel
@@ -180,49 +641,98 @@ func (r *IdRequestResource) Update(ctx context.Context, req resource.UpdateReque
 		return
 	}
 
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, newData.Timeouts.Update)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(newData.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	resp.Diagnostics.Append(validateOnExhaustion(newData.OnExhaustion)...)
+	resp.Diagnostics.Append(validateNamespace(newData.Namespace)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if data.isSkipped() {
+		// Nothing is reserved in any pool, only the configuration changes.
+		newData.RequestedId = types.Int64Null()
+		newData.RequestedIdFormatted = types.StringNull()
+		newData.PoolGeneration = types.Int64Null()
+		resp.Diagnostics.Append(resp.State.Set(ctx, &newData)...)
+		return
+	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Pool.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	// A pool change that was not planned as a replacement follows a rename of the pool, the id is kept in the renamed pool.
+	poolName := newData.Pool.ValueString()
+	gcpConnector := p.idPoolConnector(poolName)
 
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("id_request update error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Pool.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_request update error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s to update id_request %s: %s", poolName, data.member(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Pool.ValueString(), err.Error()))
-		}
-	}()
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), &resp.Diagnostics)
 
-	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.Pool.ValueString(), &gcpConnector)
+	cachedPool, err := getIdPoolForUpdate(ctx, p, poolName, &gcpConnector)
 	if err != nil {
-		resp.Diagnostics.AddError("id_request update error", fmt.Sprintf("Cannot get id_pool from id_request.pool on the referential_bucket: %s", err.Error()))
+		resp.Diagnostics.AddError("id_request update error", objectDetail(&gcpConnector, "Cannot get id_pool %s of id_request %s on the referential_bucket: %s", poolName, data.member(), err.Error()))
 		return
 	}
+	if newData.WarnOnPoolChange.ValueBool() && newData.Pool.Equal(data.Pool) {
+		addPoolChangedWarning(data, cachedPool.Generation, &resp.Diagnostics)
+	}
 
-	value, ok := cachedPool.Pool.Members[data.Id.ValueString()]
+	if newData.TTLMinutes.ValueInt64() < 0 {
+		resp.Diagnostics.AddError("id_request update error", "ttl_minutes must be a positive number of minutes")
+		return
+	}
+
+	value, ok := cachedPool.Pool.Members[data.member()]
+	if !newData.Pool.Equal(data.Pool) && (!ok || int64(value) != data.RequestedId.ValueInt64()) {
+		resp.Diagnostics.AddAttributeError(path.Root("pool"), "id_request update error", objectDetail(&gcpConnector, "Cannot move id_request %s with id %d from pool %s to pool %s, which does not hold it with this id: the pool of an id_request can only change to follow a rename of its id_pool. "+
+			"Replace the id_request, for example with `terraform apply -replace`, to allocate an id from another pool", data.member(), data.RequestedId.ValueInt64(), data.Pool.ValueString(), poolName))
+		return
+	}
 	if !ok {
-		resp.Diagnostics.AddError("id_request update error", "Cannot find your id_request in the referential_bucket")
+		resp.Diagnostics.AddError("id_request update error", objectDetail(&gcpConnector, "Cannot find id_request %s in pool %s on the referential_bucket", data.member(), poolName))
 		return
 	}
-	cachedPool.Pool.Members[newData.Id.ValueString()] = value
-	delete(cachedPool.Pool.Members, data.Id.ValueString())
+	if newData.member() != data.member() {
+		if err := cachedPool.Pool.checkMemberName(newData.member()); err != nil {
+			resp.Diagnostics.AddAttributeError(path.Root("id"), "id_request update error", objectDetail(&gcpConnector, "Cannot rename the id_request %s to %s on pool %s: %s", data.member(), newData.member(), poolName, err.Error()))
+			return
+		}
+	}
+	metadata, metadataDiags := metadataValues(ctx, newData.Metadata)
+	resp.Diagnostics.Append(metadataDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	cachedPool.Pool.renameMember(data.member(), newData.member())
+	// Any update renews the reservation.
+	cachedPool.Pool.recordReservation(newData.member(), newData.TTLMinutes.ValueInt64(), time.Now())
+	cachedPool.Pool.setMemberMetadata(newData.member(), metadata)
 
 	err = gcpConnector.Write(ctx, cachedPool.Pool)
 	if err != nil {
-		resp.Diagnostics.AddError("id_request update error", fmt.Sprintf("Cannot update pool on the referential_bucket: %s", err.Error()))
+		addPoolWriteError(&resp.Diagnostics, "id_request update error", poolName, &gcpConnector, err)
 		return
 	}
-	// Invalidate the cache for this pool to force a re-read on the next operation.
-	r.providerData.CacheMutex.Lock()
-	delete(r.providerData.IdPoolsCache, data.Pool.ValueString())
-	r.providerData.CacheMutex.Unlock()
+	// Keep the written pool in cache, Write updated the connector's generation.
+	storeCachedIdPool(p, poolName, cachedPool.Pool, &gcpConnector)
+	writePoolMirror(ctx, p, poolName, cachedPool.Pool, &resp.Diagnostics)
+	newData.RequestedIdFormatted = types.StringValue(cachedPool.Pool.formatId(value))
+	newData.PoolGeneration = types.Int64Value(gcpConnector.GetGeneration())
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &newData)...)
@@ -237,55 +747,104 @@ func (r *IdRequestResource) Delete(ctx context.Context, req resource.DeleteReque
 		return
 	}
 
-	fullPath := fmt.Sprintf("%s/%s/%s", ProviderName, idPoolResourceName, data.Pool.ValueString())
-	gcpConnector := connector.NewGeneric(r.providerData.ReferentialBucket.ValueString(), fullPath)
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Delete)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if data.isSkipped() {
+		// Nothing is reserved in any pool.
+		return
+	}
+
+	gcpConnector := p.idPoolConnector(data.Pool.ValueString())
 
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("id_request delete error", fmt.Sprintf("Cannot acquire lock for pool %s: %s", data.Pool.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("id_request delete error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for pool %s to delete id_request %s: %s", data.Pool.ValueString(), data.member(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock pool %s, manual intervention may be required to remove lock file: %s", data.Pool.ValueString(), err.Error()))
-		}
-	}()
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("pool %s", data.Pool.ValueString()), &resp.Diagnostics)
 
-	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.Pool.ValueString(), &gcpConnector)
+	cachedPool, err := getIdPoolForUpdate(ctx, p, data.Pool.ValueString(), &gcpConnector)
 	if err != nil {
 		// If the pool doesn't exist, the request is already gone. Not an error.
 		tflog.Warn(ctx, fmt.Sprintf("Pool %s not found during id_request delete. Assuming request is already gone.", data.Pool.ValueString()))
 		return
 	}
+	if data.WarnOnPoolChange.ValueBool() {
+		addPoolChangedWarning(data, cachedPool.Generation, &resp.Diagnostics)
+	}
 
-	value, ok := cachedPool.Pool.Members[data.Id.ValueString()]
+	_, ok := cachedPool.Pool.Members[data.member()]
 	if !ok {
 		// If the member is not found, it's already been deleted. This is not an error.
-		tflog.Warn(ctx, fmt.Sprintf("id_request %s not found in pool %s during delete. It may have already been removed.", data.Id.ValueString(), data.Pool.ValueString()))
+		tflog.Warn(ctx, fmt.Sprintf("id_request %s not found in pool %s during delete. It may have already been removed.", data.member(), data.Pool.ValueString()))
 		return
 	}
-	cachedPool.Pool.Release(value)
+	cachedPool.Pool.release(data.member(), time.Now())
 
 	err = gcpConnector.Write(ctx, cachedPool.Pool)
 	if err != nil {
-		resp.Diagnostics.AddError("id_request delete error", fmt.Sprintf("Cannot update pool on the referential_bucket: %s", err.Error()))
+		addPoolWriteError(&resp.Diagnostics, "id_request delete error", data.Pool.ValueString(), &gcpConnector, err)
+		return
+	}
+	// Keep the written pool in cache, Write updated the connector's generation.
+	storeCachedIdPool(p, data.Pool.ValueString(), cachedPool.Pool, &gcpConnector)
+	writePoolMirror(ctx, p, data.Pool.ValueString(), cachedPool.Pool, &resp.Diagnostics)
+}
+
+// addPoolChangedWarning warns when the pool of the id_request in state has another generation than the one recorded
+// in its pool_generation, which predates the modification.
+func addPoolChangedWarning(data IdRequestResourceModel, generation int64, diags *diag.Diagnostics) {
+	if data.PoolGeneration.IsNull() || data.PoolGeneration.IsUnknown() || data.PoolGeneration.ValueInt64() == generation {
 		return
 	}
-	// Invalidate the cache for this pool to force a re-read on the next operation.
-	r.providerData.CacheMutex.Lock()
-	delete(r.providerData.IdPoolsCache, data.Pool.ValueString())
-	r.providerData.CacheMutex.Unlock()
+	diags.AddWarning("Pool changed since plan", fmt.Sprintf("The pool %s of id_request %s was modified since it was last read, by another process or by the other resources of this apply: its generation is %d, it was %d. "+
+		"The id_request is applied on the current pool", data.Pool.ValueString(), data.member(), generation, data.PoolGeneration.ValueInt64()))
+}
+
+// valueFilterFromModel validates the value_filter attribute and returns the matching filter, nil if it is not set.
+func valueFilterFromModel(ctx context.Context, value types.Object) (idFilter, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	if value.IsNull() || value.IsUnknown() {
+		return nil, diags
+	}
+	var model IdRequestValueFilterModel
+	diags.Append(value.As(ctx, &model, basetypes.ObjectAsOptions{})...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	mod := model.Mod.ValueInt64()
+	remainder := model.Remainder.ValueInt64()
+	if mod <= 0 || remainder < 0 || remainder >= mod {
+		diags.AddAttributeError(path.Root("value_filter"), "Invalid value_filter", fmt.Sprintf("mod must be greater than 0 and remainder between 0 and mod - 1, got mod = %d and remainder = %d", mod, remainder))
+		return nil, diags
+	}
+	return func(id IdPoolTools.ID) bool {
+		return uint64(id)%uint64(mod) == uint64(remainder)
+	}, diags
 }
 
 func (r *IdRequestResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
 	idParts := strings.Split(req.ID, "/")
-	if len(idParts) != 2 || idParts[0] == "" || idParts[1] == "" {
+	if (len(idParts) != 2 && len(idParts) != 3) || slices.Contains(idParts, "") {
 		resp.Diagnostics.AddError(
 			"Unexpected Import Identifier",
-			fmt.Sprintf("Expected import identifier with format: pool_name/request_id. Got: %q", req.ID),
+			fmt.Sprintf("Expected import identifier with format: pool_name/request_id or pool_name/namespace/request_id. Got: %q", req.ID),
 		)
 		return
 	}
 	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("pool"), idParts[0])...)
-	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), idParts[1])...)
+	if len(idParts) == 3 {
+		resp.Diagnostics.Append(validateNamespace(types.StringValue(idParts[1]))...)
+		resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("namespace"), idParts[1])...)
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), idParts[len(idParts)-1])...)
 }
@@ -1,12 +1,24 @@
 package provider
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-testing/helper/resource"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
 )
 
 func TestAccIdRequestResource_LargeScale(t *testing.T) {
@@ -243,3 +255,561 @@ func testAccIdRequestResourceConfig2() string {
 
 	return returned
 }
+
+func TestIdRequestAllocateFromPool_fallback(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "primary", 1, 1)
+	createTestIdPool(t, p, "overflow", 100, 110)
+	r := &IdRequestResource{providerData: p}
+	ctx := context.Background()
+
+	var diags diag.Diagnostics
+	data := IdRequestResourceModel{Id: types.StringValue("a")}
+	if id := r.allocateFromPool(ctx, r.providerData, "primary", &data, nil, &diags); id != 1 || diags.HasError() {
+		t.Fatalf("expected id 1 from the primary pool, got %d: %v", id, diags)
+	}
+	if data.RequestedIdFormatted.ValueString() != "1" {
+		t.Fatalf("expected the id formatted in decimal without padding by default, got %s", data.RequestedIdFormatted)
+	}
+	data = IdRequestResourceModel{Id: types.StringValue("b")}
+	if id := r.allocateFromPool(ctx, r.providerData, "primary", &data, nil, &diags); id != IdPoolTools.NoID || diags.HasError() {
+		t.Fatalf("expected the primary pool to be exhausted without error, got %d: %v", id, diags)
+	}
+	if id := r.allocateFromPool(ctx, r.providerData, "overflow", &data, nil, &diags); id < 100 || id > 110 || diags.HasError() {
+		t.Fatalf("expected an id of the overflow pool, got %d: %v", id, diags)
+	}
+
+	gcpConnector := p.idPoolConnector("primary")
+	cachedPool, err := getAndCacheIdPool(ctx, p, "primary", &gcpConnector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cachedPool.Pool.Members["b"]; ok {
+		t.Fatal("an exhausted pool must not keep the member")
+	}
+}
+
+func TestIdRequestAllocateFromPool_adoptExisting(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 10)
+	r := &IdRequestResource{providerData: p}
+	ctx := context.Background()
+
+	// The member was created by an earlier run.
+	previous := &IdRequestResource{providerData: newTestProviderData()}
+	var diags diag.Diagnostics
+	existing := previous.allocateFromPool(ctx, previous.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("a")}, nil, &diags)
+	if existing == IdPoolTools.NoID || diags.HasError() {
+		t.Fatalf("expected an id, got %d: %v", existing, diags)
+	}
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("a")}, nil, &diags); id != IdPoolTools.NoID || !diags.HasError() {
+		t.Fatalf("expected an existing member to be refused by default, got %d: %v", id, diags)
+	}
+
+	gcpConnector := p.idPoolConnector("pool")
+	before, _ := server.Get(testBucket, gcpConnector.FullFilePath)
+	diags = nil
+	data := IdRequestResourceModel{Id: types.StringValue("a"), AdoptExisting: types.BoolValue(true)}
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", &data, nil, &diags); id != existing || diags.HasError() {
+		t.Fatalf("expected the id %d of the existing member to be adopted, got %d: %v", existing, id, diags)
+	}
+	if after, _ := server.Get(testBucket, gcpConnector.FullFilePath); after.Generation != before.Generation {
+		t.Fatal("expected the pool not to be written when adopting")
+	}
+}
+
+func TestIdRequestAllocateFromPool_retriedCreate(t *testing.T) {
+	server := gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "pool", 1, 10)
+	r := &IdRequestResource{providerData: p}
+	ctx := context.Background()
+	metadata, _ := types.MapValueFrom(ctx, types.StringType, map[string]string{"team": "payments"})
+
+	interrupted := &IdRequestResource{providerData: newTestProviderData()}
+	var diags diag.Diagnostics
+	first := interrupted.allocateFromPool(ctx, interrupted.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("a"), Metadata: metadata}, nil, &diags)
+	if first == IdPoolTools.NoID || diags.HasError() {
+		t.Fatalf("expected an id, got %d: %v", first, diags)
+	}
+
+	// Without opting in, the same id declared again is refused, as it may be declared by another configuration.
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("a"), Metadata: metadata}, nil, &diags); id != IdPoolTools.NoID || !diags.HasError() || !strings.Contains(diags.Errors()[0].Detail(), "already present") {
+		t.Fatalf("expected the id to be refused as already present by default, got %d: %v", id, diags)
+	}
+
+	// The apply was interrupted before the state was saved, the same creation is run again by another provider process.
+	diags = nil
+	gcpConnector := p.idPoolConnector("pool")
+	before, _ := server.Get(testBucket, gcpConnector.FullFilePath)
+	retried := &IdRequestResourceModel{Id: types.StringValue("a"), Metadata: metadata, RecoverInterrupted: types.BoolValue(true)}
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", retried, nil, &diags); id != first || diags.HasError() {
+		t.Fatalf("expected the retried creation to take back the id %d, got %d: %v", first, id, diags)
+	}
+	if after, _ := server.Get(testBucket, gcpConnector.FullFilePath); after.Generation != before.Generation {
+		t.Fatal("expected the pool not to be written when taking back the id")
+	}
+
+	// A value_filter the id does not pass shows another id_request.
+	odd := func(id IdPoolTools.ID) bool { return id%2 == 1 }
+	even := func(id IdPoolTools.ID) bool { return id%2 == 0 }
+	filter := odd
+	if odd(first) {
+		filter = even
+	}
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", retried, filter, &diags); id != IdPoolTools.NoID || !diags.HasError() {
+		t.Fatalf("expected a creation with another value_filter to be refused, got %d: %v", id, diags)
+	}
+}
+
+func TestCandidatePools(t *testing.T) {
+	ctx := context.Background()
+	pools, _ := types.ListValueFrom(ctx, types.StringType, []string{"primary", "overflow"})
+
+	poolNames, diags := candidatePools(ctx, IdRequestResourceModel{Pool: types.StringUnknown(), Pools: pools})
+	if diags.HasError() || len(poolNames) != 2 || poolNames[0] != "primary" {
+		t.Fatalf("unexpected pools %v: %v", poolNames, diags)
+	}
+	poolNames, diags = candidatePools(ctx, IdRequestResourceModel{Pool: types.StringValue("single"), Pools: types.ListNull(types.StringType)})
+	if diags.HasError() || len(poolNames) != 1 || poolNames[0] != "single" {
+		t.Fatalf("unexpected pools %v: %v", poolNames, diags)
+	}
+	if _, diags = candidatePools(ctx, IdRequestResourceModel{Pool: types.StringValue("single"), Pools: pools}); !diags.HasError() {
+		t.Fatal("expected an error when both pool and pools are set")
+	}
+	if _, diags = candidatePools(ctx, IdRequestResourceModel{Pool: types.StringNull(), Pools: types.ListNull(types.StringType)}); !diags.HasError() {
+		t.Fatal("expected an error when neither pool nor pools are set")
+	}
+}
+
+// newIdRequestModel returns the plan of a new id_request of member on the given pool, with the default settings and
+// its computed attributes unknown.
+func newIdRequestModel(member string, pool string) *IdRequestResourceModel {
+	return &IdRequestResourceModel{
+		Id:                   types.StringValue(member),
+		Pool:                 types.StringValue(pool),
+		Pools:                types.ListNull(types.StringType),
+		RequestedId:          types.Int64Unknown(),
+		RequestedIdFormatted: types.StringUnknown(),
+		Label:                types.StringUnknown(),
+		TTLMinutes:           types.Int64Null(),
+		OnExhaustion:         types.StringValue(onExhaustionError),
+		ReclaimDrift:         types.BoolValue(false),
+		AdoptExisting:        types.BoolValue(false),
+		Metadata:             types.MapNull(types.StringType),
+		PoolGeneration:       types.Int64Unknown(),
+		ValueFilter:          types.ObjectNull(map[string]attr.Type{"mod": types.Int64Type, "remainder": types.Int64Type}),
+		Timeouts:             nullTimeouts,
+	}
+}
+
+func TestIdRequestCreate_onExhaustion(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	createTestIdPool(t, p, "full", 1, 1)
+	r := &IdRequestResource{providerData: p}
+	ctx := context.Background()
+	var diags diag.Diagnostics
+	if id := r.allocateFromPool(ctx, r.providerData, "full", &IdRequestResourceModel{Id: types.StringValue("a")}, nil, &diags); id != 1 {
+		t.Fatalf("expected id 1, got %d: %v", id, diags)
+	}
+
+	create := func(onExhaustion string) *fwresource.CreateResponse {
+		model := newIdRequestModel("b", "")
+		model.Pool = types.StringUnknown()
+		model.Pools, _ = types.ListValueFrom(ctx, types.StringType, []string{"full"})
+		model.OnExhaustion = types.StringValue(onExhaustion)
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, resp)
+		return resp
+	}
+
+	if resp := create(onExhaustionError); !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error on a full pool by default")
+	}
+
+	resp := create(onExhaustionSkip)
+	if resp.Diagnostics.HasError() || resp.Diagnostics.WarningsCount() != 1 {
+		t.Fatalf("expected only a warning when skipping, got %v", resp.Diagnostics)
+	}
+	var created IdRequestResourceModel
+	resp.State.Get(ctx, &created)
+	if !created.RequestedId.IsNull() || !created.Pool.IsNull() || !created.isSkipped() {
+		t.Errorf("expected a skipped id_request without id nor pool, got requested_id %s and pool %s", created.RequestedId, created.Pool)
+	}
+}
+
+func TestIdRequestAllocateFromPool_idPattern(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	pool := newStoredIdPool(1, 10)
+	pool.IdPattern = "^svc-[a-z0-9]+$"
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(context.Background(), pool); err != nil {
+		t.Fatal(err)
+	}
+	r := &IdRequestResource{providerData: p}
+	ctx := context.Background()
+
+	var diags diag.Diagnostics
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("Bad_Name")}, nil, &diags); id != IdPoolTools.NoID || !diags.HasError() {
+		t.Fatalf("expected an id not matching id_pattern to be rejected, got %d", id)
+	}
+	diags = nil
+	if id := r.allocateFromPool(ctx, r.providerData, "pool", &IdRequestResourceModel{Id: types.StringValue("svc-api")}, nil, &diags); id == IdPoolTools.NoID || diags.HasError() {
+		t.Fatalf("expected a matching id to be allocated, got %d: %v", id, diags)
+	}
+}
+
+func TestIdRequestPool_followsRename(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	renamed := newStoredIdPool(1, 10)
+	if err := renamed.seedMembers(map[string]IdPoolTools.ID{"a": 3}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	gcpConnector := p.idPoolConnector("renamed")
+	if err := gcpConnector.Write(ctx, renamed); err != nil {
+		t.Fatal(err)
+	}
+	createTestIdPool(t, p, "other", 1, 10)
+
+	r := &IdRequestResource{providerData: p}
+	model := func(pool string) *IdRequestResourceModel {
+		model := newIdRequestModel("a", pool)
+		model.RequestedId = types.Int64Value(3)
+		model.Label = types.StringNull()
+		return model
+	}
+	state := newState(t, r, model("old"))
+	plan := func(pool string) tfsdk.Plan {
+		return newPlan(t, r, model(pool))
+	}
+	requiresReplace := func(pool string) bool {
+		resp := &planmodifier.StringResponse{PlanValue: types.StringValue(pool)}
+		poolRenamePlanModifier{resource: r}.PlanModifyString(ctx, planmodifier.StringRequest{
+			Path:       path.Root("pool"),
+			Plan:       plan(pool),
+			PlanValue:  types.StringValue(pool),
+			State:      state,
+			StateValue: types.StringValue("old"),
+		}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		return resp.RequiresReplace
+	}
+	if requiresReplace("renamed") {
+		t.Error("expected no replacement when the new pool holds the id_request with the same id")
+	}
+	if requiresReplace("not-yet-renamed") {
+		t.Error("expected no replacement when the new pool is not created yet")
+	}
+	if !requiresReplace("other") {
+		t.Error("expected a replacement when the new pool does not hold the id_request")
+	}
+
+	update := func(pool string) *fwresource.UpdateResponse {
+		resp := &fwresource.UpdateResponse{State: state}
+		r.Update(ctx, fwresource.UpdateRequest{Plan: plan(pool), State: state}, resp)
+		return resp
+	}
+	if resp := update("other"); !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error when moving to a pool that does not hold the id_request")
+	}
+	resp := update("renamed")
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var updated IdRequestResourceModel
+	resp.State.Get(ctx, &updated)
+	if updated.Pool.ValueString() != "renamed" || updated.RequestedId.ValueInt64() != 3 {
+		t.Errorf("expected the id to be kept in the renamed pool, got %s in %s", updated.RequestedId, updated.Pool)
+	}
+}
+
+func TestIdRequestUpdate_expiredReservation(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	pool := newStoredIdPool(1, 10)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 3}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	pool.recordReservation("a", 60, time.Now().Add(-2*time.Hour))
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the creations release the expired reservations, an update renews its own.
+	r := &IdRequestResource{providerData: p}
+	model := newIdRequestModel("a", "pool")
+	model.RequestedId = types.Int64Value(3)
+	model.Label = types.StringNull()
+	model.TTLMinutes = types.Int64Value(60)
+	state := newState(t, r, model)
+	resp := &fwresource.UpdateResponse{State: state}
+	r.Update(ctx, fwresource.UpdateRequest{Plan: newPlan(t, r, model), State: state}, resp)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Members["a"] != 3 || stored.Records["a"].expired(time.Now()) {
+		t.Errorf("expected the reservation of a to be renewed with id 3, got %v and %+v", stored.Members, stored.Records["a"])
+	}
+}
+
+func TestIdRequestRead_reclaimOnDrift(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	gcpConnector := p.idPoolConnector("pool")
+
+	r := &IdRequestResource{providerData: p}
+	read := func(reclaim bool) *fwresource.ReadResponse {
+		model := newIdRequestModel("a", "pool")
+		model.RequestedId = types.Int64Value(3)
+		model.Label = types.StringNull()
+		model.ReclaimDrift = types.BoolValue(reclaim)
+		state := newState(t, r, model)
+		resp := &fwresource.ReadResponse{State: state}
+		r.Read(ctx, fwresource.ReadRequest{State: state}, resp)
+		return resp
+	}
+
+	if resp := read(false); resp.Diagnostics.HasError() || !resp.State.Raw.IsNull() {
+		t.Fatalf("expected the id_request to be removed from the state by default, got %v", resp.Diagnostics)
+	}
+
+	resp := read(true)
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var reclaimed IdRequestResourceModel
+	resp.State.Get(ctx, &reclaimed)
+	if reclaimed.RequestedId.ValueInt64() != 3 {
+		t.Fatalf("expected the id_request to keep its id, got %s", reclaimed.RequestedId)
+	}
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Members["a"] != 3 {
+		t.Fatalf("expected the member to be added back to the pool, got %v", stored.Members)
+	}
+
+	// The id was taken by another member in the meantime.
+	stored.Members["b"] = 3
+	delete(stored.Members, "a")
+	if err := gcpConnector.Write(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if resp := read(true); !resp.Diagnostics.HasError() {
+		t.Fatal("expected an error when the id is held by another member")
+	}
+}
+
+func TestIdRequestRead_strictRange(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	// The pool object was shrunk by hand below the id of its member.
+	pool := newStoredIdPool(1, 20)
+	if err := pool.seedMembers(map[string]IdPoolTools.ID{"a": 15}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	if err := gcpConnector.Write(ctx, pool.rebuild(1, 10)); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &IdRequestResource{providerData: p}
+	read := func(strict bool) *fwresource.ReadResponse {
+		model := newIdRequestModel("a", "pool")
+		model.RequestedId = types.Int64Value(15)
+		model.Label = types.StringNull()
+		model.StrictRange = types.BoolValue(strict)
+		state := newState(t, r, model)
+		resp := &fwresource.ReadResponse{State: state}
+		r.Read(ctx, fwresource.ReadRequest{State: state}, resp)
+		return resp
+	}
+
+	resp := read(false)
+	if resp.Diagnostics.HasError() || resp.Diagnostics.WarningsCount() != 1 || resp.State.Raw.IsNull() {
+		t.Fatalf("expected a single warning keeping the id_request, got %v", resp.Diagnostics)
+	}
+	if resp = read(true); !resp.Diagnostics.HasError() || !strings.Contains(resp.Diagnostics.Errors()[0].Detail(), "out of the range [1, 10]") {
+		t.Fatalf("expected an out of range error with strict_range, got %v", resp.Diagnostics)
+	}
+}
+
+func TestIdRequestResource_createDelete(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 2)
+	r := &IdRequestResource{providerData: p}
+	create := func(member string) *fwresource.CreateResponse {
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newIdRequestModel(member, "pool"))}, resp)
+		return resp
+	}
+	storedMembers := func() map[string]IdPoolTools.ID {
+		gcpConnector := p.idPoolConnector("pool")
+		var stored StoredIdPool
+		if err := gcpConnector.Read(ctx, &stored); err != nil {
+			t.Fatal(err)
+		}
+		return stored.Members
+	}
+
+	first := create("a")
+	second := create("b")
+	if first.Diagnostics.HasError() || second.Diagnostics.HasError() {
+		t.Fatal(first.Diagnostics, second.Diagnostics)
+	}
+	var a, b IdRequestResourceModel
+	first.State.Get(ctx, &a)
+	second.State.Get(ctx, &b)
+	if a.RequestedId.ValueInt64() == b.RequestedId.ValueInt64() {
+		t.Fatalf("expected distinct ids, got %s twice", a.RequestedId)
+	}
+	if members := storedMembers(); len(members) != 2 || int64(members["a"]) != a.RequestedId.ValueInt64() {
+		t.Fatalf("unexpected stored members: %v", members)
+	}
+	if resp := create("c"); !resp.Diagnostics.HasError() {
+		t.Fatal("expected the creation to fail on a full pool")
+	}
+
+	deleteResp := &fwresource.DeleteResponse{State: first.State}
+	r.Delete(ctx, fwresource.DeleteRequest{State: first.State}, deleteResp)
+	if deleteResp.Diagnostics.HasError() {
+		t.Fatal(deleteResp.Diagnostics)
+	}
+	if members := storedMembers(); len(members) != 1 {
+		t.Fatalf("expected the member of a to be released, got %v", members)
+	}
+
+	third := create("c")
+	if third.Diagnostics.HasError() {
+		t.Fatal(third.Diagnostics)
+	}
+	var c IdRequestResourceModel
+	third.State.Get(ctx, &c)
+	if c.RequestedId.ValueInt64() != a.RequestedId.ValueInt64() {
+		t.Fatalf("expected the released id %s to be allocated again, got %s", a.RequestedId, c.RequestedId)
+	}
+}
+
+func TestIdRequestResourceDelete_warnOnPoolChange(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	r := &IdRequestResource{providerData: p}
+	create := func(member string) tfsdk.State {
+		model := newIdRequestModel(member, "pool")
+		model.WarnOnPoolChange = types.BoolValue(true)
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		return resp.State
+	}
+	deleteWarnings := func(state tfsdk.State) int {
+		resp := &fwresource.DeleteResponse{State: state}
+		r.Delete(ctx, fwresource.DeleteRequest{State: state}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		return resp.Diagnostics.WarningsCount()
+	}
+
+	a := create("a")
+	var created IdRequestResourceModel
+	a.Get(ctx, &created)
+	gcpConnector := p.idPoolConnector("pool")
+	if attrs, err := gcpConnector.GetAttrs(ctx); err != nil || created.PoolGeneration.ValueInt64() != attrs.Generation {
+		t.Fatalf("expected pool_generation to be the generation written by the creation, got %s: %v", created.PoolGeneration, err)
+	}
+
+	// Another id_request changes the pool after a was applied.
+	b := create("b")
+	if warnings := deleteWarnings(b); warnings != 0 {
+		t.Fatalf("expected no warning when the pool did not change since b was created, got %d", warnings)
+	}
+	if warnings := deleteWarnings(a); warnings != 1 {
+		t.Fatalf("expected a warning when the pool changed since a was created, got %d", warnings)
+	}
+}
+
+func TestIdRequestResource_namespace(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	createTestIdPool(t, p, "pool", 1, 10)
+	r := &IdRequestResource{providerData: p}
+	create := func(namespace types.String) IdRequestResourceModel {
+		model := newIdRequestModel("frontend", "pool")
+		model.Namespace = namespace
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, model)}, resp)
+		if resp.Diagnostics.HasError() {
+			t.Fatal(resp.Diagnostics)
+		}
+		var data IdRequestResourceModel
+		resp.Diagnostics.Append(resp.State.Get(ctx, &data)...)
+		return data
+	}
+
+	prod := create(types.StringValue("prod"))
+	dev := create(types.StringValue("dev"))
+	plain := create(types.StringNull())
+	if prod.RequestedId.Equal(dev.RequestedId) || prod.RequestedId.Equal(plain.RequestedId) || dev.RequestedId.Equal(plain.RequestedId) {
+		t.Fatalf("expected independent ids, got %s, %s and %s", prod.RequestedId, dev.RequestedId, plain.RequestedId)
+	}
+	gcpConnector := p.idPoolConnector("pool")
+	var stored StoredIdPool
+	if err := gcpConnector.Read(ctx, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Members) != 3 || int64(stored.Members["prod:frontend"]) != prod.RequestedId.ValueInt64() || int64(stored.Members["dev:frontend"]) != dev.RequestedId.ValueInt64() ||
+		int64(stored.Members["frontend"]) != plain.RequestedId.ValueInt64() {
+		t.Fatalf("expected the members to be named after the namespace and the id, got %v", stored.Members)
+	}
+
+	// An import with the namespace reads the member of the composite key.
+	importResp := &fwresource.ImportStateResponse{State: newState(t, r, nil)}
+	r.ImportState(ctx, fwresource.ImportStateRequest{ID: "pool/prod/frontend"}, importResp)
+	if importResp.Diagnostics.HasError() {
+		t.Fatal(importResp.Diagnostics)
+	}
+	readResp := &fwresource.ReadResponse{State: importResp.State}
+	r.Read(ctx, fwresource.ReadRequest{State: importResp.State}, readResp)
+	var imported IdRequestResourceModel
+	readResp.Diagnostics.Append(readResp.State.Get(ctx, &imported)...)
+	if readResp.Diagnostics.HasError() {
+		t.Fatal(readResp.Diagnostics)
+	}
+	if imported.Namespace.ValueString() != "prod" || imported.Id.ValueString() != "frontend" || !imported.RequestedId.Equal(prod.RequestedId) {
+		t.Fatalf("expected the import to read prod:frontend with id %s, got %s:%s with %s", prod.RequestedId, imported.Namespace, imported.Id, imported.RequestedId)
+	}
+
+	for _, id := range []string{"pool/a:b/frontend", "pool//frontend", "pool/prod/frontend/x"} {
+		importResp := &fwresource.ImportStateResponse{State: newState(t, r, nil)}
+		r.ImportState(ctx, fwresource.ImportStateRequest{ID: id}, importResp)
+		if !importResp.Diagnostics.HasError() {
+			t.Fatalf("expected the import of %q to be rejected", id)
+		}
+	}
+}
@@ -0,0 +1,270 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ resource.Resource = &IdTransferResource{}
+var _ resource.ResourceWithValidateConfig = &IdTransferResource{}
+
+func NewIdTransferResource() resource.Resource {
+	return &IdTransferResource{}
+}
+
+type IdTransferResource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type IdTransferResourceModel struct {
+	Id       types.String   `tfsdk:"id"`
+	FromPool types.String   `tfsdk:"from_pool"`
+	ToPool   types.String   `tfsdk:"to_pool"`
+	Value    types.Int64    `tfsdk:"value"`
+	Timeouts timeouts.Value `tfsdk:"timeouts"`
+}
+
+func (r *IdTransferResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_id_transfer"
+}
+
+func (r *IdTransferResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This resource allow you to move the reservation of a member from an id_pool to another one keeping its id, for example when a service moves between two logical pools. " +
+			"Both pools are locked during the transfer: the member is added to to_pool with the same id, failing if it is taken there, then removed from from_pool, and to_pool is restored if the removal cannot be written. " +
+			"Once transferred, point the id_request of the member to to_pool with adopt_existing to keep the id. Destroying the id_transfer does not move the reservation back, it stays in to_pool",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The id of the member to transfer, the id of its id_request. If you change it, the id_transfer will be destroyed and recreate, transferring the new member",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"from_pool": schema.StringAttribute{
+				MarkdownDescription: "The name of the pool holding the member. If you change it, the id_transfer will be destroyed and recreate",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"to_pool": schema.StringAttribute{
+				MarkdownDescription: "The name of the pool to transfer the member to, the id must be in its range and free there. If you change it, the id_transfer will be destroyed and recreate",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"value": schema.Int64Attribute{
+				MarkdownDescription: "The id of the member, held in to_pool once transferred, it is a readonly field",
+				Computed:            true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
+	}
+}
+
+func (r *IdTransferResource) ValidateConfig(ctx context.Context, req resource.ValidateConfigRequest, resp *resource.ValidateConfigResponse) {
+	var data IdTransferResourceModel
+	resp.Diagnostics.Append(req.Config.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() || data.FromPool.IsUnknown() || data.ToPool.IsUnknown() {
+		return
+	}
+	if data.FromPool.Equal(data.ToPool) {
+		resp.Diagnostics.AddAttributeError(path.Root("to_pool"), "Invalid id_transfer", fmt.Sprintf("to_pool must differ from from_pool, got %s for both", data.ToPool.ValueString()))
+	}
+}
+
+func (r *IdTransferResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	r.providerData = providerData
+}
+
+func (r *IdTransferResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data IdTransferResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	fromPool := data.FromPool.ValueString()
+	toPool := data.ToPool.ValueString()
+	if fromPool == toPool {
+		resp.Diagnostics.AddAttributeError(path.Root("to_pool"), "id_transfer creation error", fmt.Sprintf("to_pool must differ from from_pool, got %s for both", toPool))
+		return
+	}
+	fromConnector := r.providerData.idPoolConnector(fromPool)
+	toConnector := r.providerData.idPoolConnector(toPool)
+
+	// The locks are always taken in the order of the pool names, so that two transfers in opposite directions
+	// cannot each hold the lock the other one waits for.
+	connectors := map[string]*connector.GcpConnectorGeneric{fromPool: &fromConnector, toPool: &toConnector}
+	poolNames := []string{fromPool, toPool}
+	sort.Strings(poolNames)
+	for _, poolName := range poolNames {
+		gcpConnector := connectors[poolName]
+		lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, r.providerData), r.providerData.BackoffMultiplier.ValueFloat32())
+		if err != nil {
+			resp.Diagnostics.AddError("id_transfer creation error", lockDetail(ctx, gcpConnector, "Cannot acquire lock for pool %s: %s", poolName, err.Error()))
+			return
+		}
+		defer releaseLock(ctx, gcpConnector, lockId, fmt.Sprintf("pool %s", poolName), &resp.Diagnostics)
+	}
+
+	value, err := transferPoolMember(ctx, r.providerData, data.Id.ValueString(), fromPool, &fromConnector, toPool, &toConnector, time.Now())
+	if err != nil {
+		resp.Diagnostics.AddError("id_transfer creation error", fmt.Sprintf("Cannot transfer %s from pool %s to pool %s: %s", data.Id.ValueString(), fromPool, toPool, err.Error()))
+		return
+	}
+	tflog.Info(ctx, "Transferred pool member", map[string]interface{}{"member": data.Id.ValueString(), "from_pool": fromPool, "to_pool": toPool, "value": uint64(value)})
+	// Both pools were just written and cached, under their locks still held.
+	for _, poolName := range poolNames {
+		if cachedPool, err := getAndCacheIdPool(ctx, r.providerData, poolName, connectors[poolName]); err == nil {
+			writePoolMirror(ctx, r.providerData, poolName, cachedPool.Pool, &resp.Diagnostics)
+		}
+	}
+	data.Value = types.Int64Value(int64(value))
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// transferPoolMember moves the member name from the pool fromPool to the pool toPool with the same id and returns it.
+// The member is written in toPool first then removed from fromPool, toPool being written back as it was when the
+// removal fails, so that the id is never held by the member in both pools nor lost. Both pool locks must be held.
+func transferPoolMember(ctx context.Context, p *GCSReferentialProviderModel, name string, fromPool string, from idPoolObject, toPool string, to idPoolObject, now time.Time) (IdPoolTools.ID, error) {
+	fromCached, err := getIdPoolForUpdate(ctx, p, fromPool, from)
+	if err != nil {
+		return IdPoolTools.NoID, fmt.Errorf("cannot read pool %s: %w", fromPool, err)
+	}
+	toCached, err := getAndCacheIdPool(ctx, p, toPool, to)
+	if err != nil {
+		return IdPoolTools.NoID, fmt.Errorf("cannot read pool %s: %w", toPool, err)
+	}
+	value, ok := fromCached.Pool.Members[name]
+	if !ok {
+		return IdPoolTools.NoID, fmt.Errorf("the pool %s holds no member %q", fromPool, name)
+	}
+	if _, ok := toCached.Pool.Members[name]; ok {
+		return IdPoolTools.NoID, fmt.Errorf("the pool %s already holds a member %q", toPool, name)
+	}
+	original := toCached.Pool
+	transferred := original.clone()
+	if err := transferred.checkMemberName(name); err != nil {
+		return IdPoolTools.NoID, err
+	}
+	// reclaim lets a member take back an id it consumed in a no_reuse pool, which the transferred member never held there.
+	if transferred.NoReuse && !transferred.take(value) {
+		return IdPoolTools.NoID, fmt.Errorf("the id %d was already consumed in the no_reuse pool %s", value, toPool)
+	}
+	if err := transferred.reclaim(name, value, now); err != nil {
+		return IdPoolTools.NoID, err
+	}
+	// The member keeps its reservation time and lifetime, its label is the one of the new pool.
+	if record, ok := fromCached.Pool.Records[name]; ok {
+		transferred.Records[name].ReservedAt = record.ReservedAt
+		transferred.Records[name].TTLMinutes = record.TTLMinutes
+	}
+	fromCached.Pool.release(name, now)
+
+	if err := to.Write(ctx, transferred); err != nil {
+		return IdPoolTools.NoID, fmt.Errorf("cannot write pool %s: %w", toPool, err)
+	}
+	if err := from.Write(ctx, fromCached.Pool); err != nil {
+		invalidateCachedIdPool(p, toPool)
+		if rollbackErr := to.Write(ctx, original); rollbackErr != nil {
+			return IdPoolTools.NoID, fmt.Errorf("cannot write pool %s: %w, and cannot restore pool %s, where %q now also holds %d: %s", fromPool, err, toPool, name, value, rollbackErr.Error())
+		}
+		return IdPoolTools.NoID, fmt.Errorf("cannot write pool %s, pool %s was restored: %w", fromPool, toPool, err)
+	}
+	storeCachedIdPool(p, toPool, transferred, to)
+	storeCachedIdPool(p, fromPool, fromCached.Pool, from)
+	return value, nil
+}
+
+// Read follows the member in to_pool: the id_transfer is removed from the state when to_pool no longer holds it.
+func (r *IdTransferResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data IdTransferResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Read)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := r.providerData.idPoolConnector(data.ToPool.ValueString())
+	cachedPool, err := getAndCacheIdPool(ctx, r.providerData, data.ToPool.ValueString(), &gcpConnector)
+	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		resp.Diagnostics.AddError("id_transfer read error", objectDetail(&gcpConnector, "Cannot read pool %s: %s", data.ToPool.ValueString(), err.Error()))
+		return
+	}
+	var value IdPoolTools.ID
+	var ok bool
+	if err == nil {
+		value, ok = cachedPool.Pool.Members[data.Id.ValueString()]
+	}
+	if !ok {
+		tflog.Warn(ctx, fmt.Sprintf("Member %s not found in pool %s, removing id_transfer from state", data.Id.ValueString(), data.ToPool.ValueString()))
+		resp.State.RemoveResource(ctx)
+		return
+	}
+	data.Value = types.Int64Value(int64(value))
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// Update only records the new timeouts, every other attribute replaces the id_transfer.
+func (r *IdTransferResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var data IdTransferResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// Delete leaves the member in to_pool, the transfer is not undone.
+func (r *IdTransferResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+}
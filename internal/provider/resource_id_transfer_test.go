@@ -0,0 +1,99 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	fwresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	IdPoolTools "github.com/public-cloud-wl/tools/idPoolTools"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+// failingWriteObject is an idPoolObject whose writes fail, to test the rollbacks.
+type failingWriteObject struct {
+	idPoolObject
+}
+
+func (f failingWriteObject) Write(ctx context.Context, data interface{}) error {
+	return errors.New("write refused")
+}
+
+// newIdTransferModel returns the plan of a new id_transfer of member between the given pools, its value unknown.
+func newIdTransferModel(member string, fromPool string, toPool string) *IdTransferResourceModel {
+	return &IdTransferResourceModel{
+		Id:       types.StringValue(member),
+		FromPool: types.StringValue(fromPool),
+		ToPool:   types.StringValue(toPool),
+		Value:    types.Int64Unknown(),
+		Timeouts: nullTimeouts,
+	}
+}
+
+func TestIdTransferResource(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+	seed := func(poolName string, members map[string]IdPoolTools.ID) {
+		pool := newStoredIdPool(1, 10)
+		if err := pool.seedMembers(members, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		gcpConnector := p.idPoolConnector(poolName)
+		if err := gcpConnector.Write(ctx, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	members := func(poolName string) map[string]IdPoolTools.ID {
+		gcpConnector := p.idPoolConnector(poolName)
+		var pool StoredIdPool
+		if err := gcpConnector.Read(ctx, &pool); err != nil {
+			t.Fatal(err)
+		}
+		return pool.Members
+	}
+	seed("from", map[string]IdPoolTools.ID{"svc": 5, "taken": 7, "rollback": 3})
+	seed("to", map[string]IdPoolTools.ID{"other": 7})
+
+	r := &IdTransferResource{providerData: p}
+	create := func(member string) *fwresource.CreateResponse {
+		resp := &fwresource.CreateResponse{State: newState(t, r, nil)}
+		r.Create(ctx, fwresource.CreateRequest{Plan: newPlan(t, r, newIdTransferModel(member, "from", "to"))}, resp)
+		return resp
+	}
+
+	resp := create("svc")
+	if resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+	var data IdTransferResourceModel
+	resp.State.Get(ctx, &data)
+	if data.Value.ValueInt64() != 5 {
+		t.Fatalf("expected the value 5 to be kept, got %s", data.Value)
+	}
+	if _, ok := members("from")["svc"]; ok || members("to")["svc"] != 5 {
+		t.Fatalf("expected svc to be moved, got %v and %v", members("from"), members("to"))
+	}
+
+	if resp := create("taken"); !resp.Diagnostics.HasError() {
+		t.Fatal("expected the transfer of a value taken in to_pool to fail")
+	}
+	if members("from")["taken"] != 7 || members("to")["other"] != 7 {
+		t.Fatalf("expected the pools to be left unchanged, got %v and %v", members("from"), members("to"))
+	}
+
+	// The member is written in to_pool first, it is removed again when from_pool cannot be written.
+	fromConnector := p.idPoolConnector("from")
+	toConnector := p.idPoolConnector("to")
+	if _, err := transferPoolMember(ctx, p, "rollback", "from", failingWriteObject{&fromConnector}, "to", &toConnector, time.Now()); err == nil {
+		t.Fatal("expected the transfer to fail")
+	}
+	if _, ok := members("to")["rollback"]; ok || members("from")["rollback"] != 3 {
+		t.Fatalf("expected the transfer to be rolled back, got %v and %v", members("from"), members("to"))
+	}
+	if resp := create("rollback"); resp.Diagnostics.HasError() {
+		t.Fatal(resp.Diagnostics)
+	}
+}
@@ -4,21 +4,31 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"time"
+	"net"
+	"slices"
+	"strings"
 
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	"github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/booldefault"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/boolplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/listplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
 	"github.com/hashicorp/terraform-plugin-framework/types"
 	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
 
 	"cloud.google.com/go/storage"
-	cidrCalculator "github.com/public-cloud-wl/tools/cidrCalculator"
-	"github.com/terraform-provider-gcsreferential/internal/provider/connector"
 )
 
+var _ resource.ResourceWithModifyPlan = &networkRequestResource{}
+
 type networkRequestResource struct {
 	providerData *GCSReferentialProviderModel
 }
@@ -29,14 +39,71 @@ func (r *networkRequestResource) Metadata(ctx context.Context, req resource.Meta
 }
 
 type networkRequestResourceModel struct {
-	PrefixLength types.Int64  `tfsdk:"prefix_length"`
-	BaseCidr     types.String `tfsdk:"base_cidr"`
-	Netmask      types.String `tfsdk:"netmask"`
-	Id           types.String `tfsdk:"id"`
+	PrefixLength     types.Int64    `tfsdk:"prefix_length"`
+	MinPrefixLength  types.Int64    `tfsdk:"min_prefix_length"`
+	MaxPrefixLength  types.Int64    `tfsdk:"max_prefix_length"`
+	BaseCidr         types.String   `tfsdk:"base_cidr"`
+	BaseCidrs        types.List     `tfsdk:"base_cidrs"`
+	Referential      types.String   `tfsdk:"referential"`
+	Netmask          types.String   `tfsdk:"netmask"`
+	Id               types.String   `tfsdk:"id"`
+	SkipFirstSubnet  types.Bool     `tfsdk:"skip_first_subnet"`
+	AlignmentPrefix  types.Int64    `tfsdk:"alignment_prefix"`
+	SubnetCount      types.Int64    `tfsdk:"subnet_count"`
+	Summarizable     types.Bool     `tfsdk:"summarizable"`
+	Netmasks         types.List     `tfsdk:"netmasks"`
+	SummaryCidr      types.String   `tfsdk:"summary_cidr"`
+	VerifyAllocation types.Bool     `tfsdk:"verify_allocation"`
+	VerifyRelease    types.Bool     `tfsdk:"verify_release"`
+	ContentHash      types.String   `tfsdk:"content_hash"`
+	Timeouts         timeouts.Value `tfsdk:"timeouts"`
+	// ExcludedAddresses is derived from the netmasks, it is only stored in the network config with persist_exclusions.
+	ExcludedAddresses types.List `tfsdk:"excluded_addresses"`
+	ExcludeGateway    types.Bool `tfsdk:"exclude_gateway"`
+	PersistExclusions types.Bool `tfsdk:"persist_exclusions"`
+	// Deterministic only drives the allocation, it is not stored in the network config.
+	Deterministic types.Bool `tfsdk:"deterministic"`
 }
 
+// maxAllocationVerifications is the number of times a colliding subnet is allocated again before failing.
+const maxAllocationVerifications = 3
+
+// maxSubnetCount is the largest number of subnets a single network_request can reserve.
+const maxSubnetCount = 256
+
 type NetworkConfig struct {
-	Subnets map[string]string `json:"subnets"`
+	// SchemaVersion is the version of the format of the object, absent from the objects written before it was introduced.
+	SchemaVersion int               `json:"schema_version,omitempty"`
+	Subnets       map[string]string `json:"subnets"`
+	// SkippedSubnet is the first subnet of the base_cidr, never allocated once a request asked to skip it.
+	SkippedSubnet string `json:"skipped_subnet,omitempty"`
+	// AlignedBlocks holds, by id, the alignment block reserved as a whole by the requests made with an alignment_prefix.
+	AlignedBlocks map[string]string `json:"aligned_blocks,omitempty"`
+	// SubnetCounts holds, by id, the number of subnets of the requests reserving more than one, their aligned block is then made of them.
+	SubnetCounts map[string]int `json:"subnet_counts,omitempty"`
+	// Exclusions holds, by id, the addresses of the subnets of the requests made with persist_exclusions that must not
+	// be leased, such as their network and broadcast addresses, for the DHCP tooling reading the object.
+	Exclusions map[string][]string `json:"exclusions,omitempty"`
+}
+
+// isEmpty reports whether networkConfig holds nothing worth keeping: no reservation, and no first subnet skipped
+// by a past request, which must stay out of the allocations.
+func (networkConfig *NetworkConfig) isEmpty() bool {
+	return len(networkConfig.Subnets) == 0 && networkConfig.SkippedSubnet == ""
+}
+
+// networkConfigSchemaVersion is the version of the format of the network config objects written by the provider.
+const networkConfigSchemaVersion = 1
+
+// CurrentSchemaVersion implements connector.VersionedDocument.
+func (networkConfig *NetworkConfig) CurrentSchemaVersion() int {
+	return networkConfigSchemaVersion
+}
+
+// MigrateSchema implements connector.VersionedDocument, the version 1 only added the schema_version field to version 0.
+func (networkConfig *NetworkConfig) MigrateSchema(fromVersion int) error {
+	networkConfig.SchemaVersion = networkConfigSchemaVersion
+	return nil
 }
 
 func NewNetworkRequestResource() resource.Resource {
@@ -48,26 +115,312 @@ func (r *networkRequestResource) Schema(ctx context.Context, req resource.Schema
 		MarkdownDescription: "network_request",
 		Attributes: map[string]schema.Attribute{
 			"prefix_length": schema.Int64Attribute{
-				MarkdownDescription: "The prefix of the requested network for example with 24 a /24 subnet will be booked by the network_request",
-				Required:            true,
+				MarkdownDescription: "The prefix of the requested network for example with 24 a /24 subnet will be booked by the network_request. It must be greater than or equal to the prefix of base_cidr, or of every base_cidrs, which is checked at plan time. " +
+					"Required unless min_prefix_length and max_prefix_length are set, it is then the prefix length of the subnet reserved",
+				Optional: true,
+				Computed: true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.UseStateForUnknown(),
+				},
+			},
+			"min_prefix_length": schema.Int64Attribute{
+				MarkdownDescription: "The prefix length of the largest subnet to reserve, instead of prefix_length, for an opportunistic allocation: the largest free subnet with a prefix length between min_prefix_length and max_prefix_length is reserved, for example the biggest one from a /20 down to a /24. " +
+					"It must be set with max_prefix_length and not with prefix_length, the creation fails when no subnet of the range is free. With skip_first_subnet, the first subnet of max_prefix_length is skipped. If you change it, the network_request will be destroyed and recreate",
+				Optional: true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+			},
+			"max_prefix_length": schema.Int64Attribute{
+				MarkdownDescription: "The prefix length of the smallest subnet to reserve, with min_prefix_length. If you change it, the network_request will be destroyed and recreate",
+				Optional:            true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
 			},
 			"base_cidr": schema.StringAttribute{
-				MarkdownDescription: "The supernet where to do the network_request, for example 10.0.0.0/8",
-				Required:            true,
+				MarkdownDescription: "The supernet where to do the network_request, for example 10.0.0.0/8. If you change it, the network_request will be destroyed and recreate. When base_cidrs is set instead, it is the supernet the subnet was reserved in",
+				Optional:            true,
+				Computed:            true,
 				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
 					stringplanmodifier.RequiresReplace(),
 				},
 			},
+			"referential": schema.StringAttribute{
+				MarkdownDescription: "The name of one of the referentials of the provider to reserve the subnet in, instead of referential_bucket. If you change it, the network_request will be destroyed and recreate. Default to referential_bucket",
+				Optional:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"base_cidrs": schema.ListAttribute{
+				MarkdownDescription: "An ordered list of non-overlapping supernets to do the network_request in, instead of base_cidr, by priority: the subnet is reserved in the first one that still has room for it, the next ones are only used once it is full. " +
+					"If you change it so that it no longer contains the supernet the subnet was reserved in, the network_request will be destroyed and recreate",
+				ElementType: types.StringType,
+				Optional:    true,
+				PlanModifiers: []planmodifier.List{
+					listplanmodifier.RequiresReplaceIf(requiresReplaceIfAllocatedBaseCidrRemoved, "The supernet the subnet was reserved in is no longer in base_cidrs", "The supernet the subnet was reserved in is no longer in `base_cidrs`"),
+				},
+			},
 			"netmask": schema.StringAttribute{
 				MarkdownDescription: "The reserved netmask as full cidr, for example 10.12.13.0/24",
 				Computed:            true,
 			},
+			"content_hash": schema.StringAttribute{
+				MarkdownDescription: "The SHA-256 of the canonical JSON of the network config of the base_cidr, shared by all its network_request. It changes on refresh whenever the network config was modified, by another network_request or by hand, a single value to watch for drift",
+				Computed:            true,
+			},
 			"id": schema.StringAttribute{
 				MarkdownDescription: "The id associate to your network_request",
 				Required:            true,
 			},
+			"skip_first_subnet": schema.BoolAttribute{
+				MarkdownDescription: "If true, the first subnet of the base_cidr with this prefix_length is excluded from allocation. The policy is persisted for the base_cidr: once set, the first subnet is never allocated to any network_request of this base_cidr, even after other reservations are deleted. Default to false",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+				PlanModifiers: []planmodifier.Bool{
+					boolplanmodifier.RequiresReplace(),
+				},
+			},
+			"alignment_prefix": schema.Int64Attribute{
+				MarkdownDescription: "An optional prefix length, between the one of base_cidr and prefix_length, of the block the subnet must be aligned on: the subnet is the first one of a free block of this size, " +
+					"and the whole block is held by the network_request so that no other reservation shares it, for example a /24 starting a /20 dedicated to a zone. If you change it, the network_request will be destroyed and recreate",
+				Optional: true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+			},
+			"subnet_count": schema.Int64Attribute{
+				MarkdownDescription: fmt.Sprintf("The number of contiguous subnets of prefix_length to reserve, between 1 and %d. A subnet_count greater than 1 requires summarizable. If you change it, the network_request will be destroyed and recreate. Default to 1", maxSubnetCount),
+				Optional:            true,
+				Computed:            true,
+				Default:             int64default.StaticInt64(1),
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.RequiresReplace(),
+				},
+			},
+			"summarizable": schema.BoolAttribute{
+				MarkdownDescription: "If true, the subnet_count subnets must together form a single aligned supernet, so that they can be summarized in routing, for example 4 /24 making up a /22. " +
+					"subnet_count must then be a power of two, the whole supernet is held by the network_request and the creation fails if no such block is free. It cannot be combined with alignment_prefix. " +
+					"If you change it, the network_request will be destroyed and recreate. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+				PlanModifiers: []planmodifier.Bool{
+					boolplanmodifier.RequiresReplace(),
+				},
+			},
+			"netmasks": schema.ListAttribute{
+				MarkdownDescription: "The reserved subnets as full cidrs in address order, the first one being netmask",
+				ElementType:         types.StringType,
+				Computed:            true,
+			},
+			"summary_cidr": schema.StringAttribute{
+				MarkdownDescription: "The aligned supernet made of the netmasks when summarizable is true, for example 10.12.12.0/22, null otherwise",
+				Computed:            true,
+			},
+			"excluded_addresses": schema.ListAttribute{
+				MarkdownDescription: "The addresses of the netmasks that must not be leased, for example as DHCP exclusions, in address order: the network address, the gateway with exclude_gateway, and the broadcast address of each subnet. " +
+					"A /31 or /32 has none, all its addresses being usable (RFC 3021), as a /127 or /128, and an IPv6 subnet has no broadcast address",
+				ElementType: types.StringType,
+				Computed:    true,
+			},
+			"exclude_gateway": schema.BoolAttribute{
+				MarkdownDescription: "If true, the first host address of each subnet, such as 10.12.13.1 for 10.12.13.0/24, is added to the excluded_addresses as the gateway. Default to false",
+				Optional:            true,
+				Computed:            true,
+				Default:             booldefault.StaticBool(false),
+			},
+			"persist_exclusions": schema.BoolAttribute{
+				MarkdownDescription: "If true, the excluded_addresses are also written, by id, in the `exclusions` map of the network config object of the base_cidr, for the downstream tooling reading it, such as a DHCP server configuration. " +
+					"They are removed with the reservation, or when it is set back to false. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"deterministic": schema.BoolAttribute{
+				MarkdownDescription: "If true, the subnet is placed at a position of the base_cidr derived from the hash of the id rather than in the next free one, so that destroying and creating the network_request again gives it the same subnet as long as it is free. " +
+					"When that subnet overlaps another reservation, or the subnet skipped by skip_first_subnet, the next free subnet is reserved instead, as without it: two ids whose hashes land on the same subnet get it in turn, the second one falls back. " +
+					"With alignment_prefix or subnet_count, it is the aligned block that is placed by the hash, and with min_prefix_length each prefix length of the range is tried at its own hashed position. " +
+					"It only applies when the subnet is allocated, changing it does not move the current one. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"verify_allocation": schema.BoolAttribute{
+				MarkdownDescription: "If true, the network config is read again after the reservation is written to confirm that no other network_request holds an overlapping subnet, and the subnet is allocated again if one does. " +
+					"It is a safety net against misbehaving locks, for example a lock removed manually while an apply was running, and costs an extra read. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
+			"verify_release": schema.BoolAttribute{
+				MarkdownDescription: "If true, the network config is read again after the reservation is deleted to confirm that it is gone, and the deletion fails if it reappeared, for example written back by a concurrent run under a broken lock. " +
+					"It is the safety net of verify_allocation for the deletions, and costs an extra read. Default to false",
+				Optional: true,
+				Computed: true,
+				Default:  booldefault.StaticBool(false),
+			},
 		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
+	}
+}
+
+// allocationAlignment returns the prefix length of the block the reservation of data is aligned on: its summary
+// supernet when it reserves several subnets, its alignment_prefix otherwise, 0 meaning no alignment.
+func (data *networkRequestResourceModel) allocationAlignment() (int64, error) {
+	count := data.SubnetCount.ValueInt64()
+	if data.SubnetCount.IsNull() || data.SubnetCount.IsUnknown() {
+		count = 1
+	}
+	if count < 1 || count > maxSubnetCount {
+		return 0, fmt.Errorf("subnet_count %d must be between 1 and %d", count, maxSubnetCount)
+	}
+	if !data.Summarizable.ValueBool() {
+		if count > 1 {
+			return 0, fmt.Errorf("subnet_count %d requires summarizable, the subnets of a network_request are reserved as a single aligned supernet", count)
+		}
+		return data.AlignmentPrefix.ValueInt64(), nil
 	}
+	if !data.AlignmentPrefix.IsNull() {
+		return 0, errors.New("alignment_prefix cannot be set with summarizable, the subnets are aligned on their summary supernet")
+	}
+	if count == 1 {
+		return 0, nil
+	}
+	return summaryPrefix(data.PrefixLength.ValueInt64(), count)
+}
+
+// prefixLengthRange returns the shortest and the longest prefix length the subnet of data may have: its prefix_length
+// twice, or its min_prefix_length and max_prefix_length. A prefix_length known along with a range is rejected, so it
+// must be the configured value, not the one computed at creation.
+func (data *networkRequestResourceModel) prefixLengthRange() (int64, int64, error) {
+	hasPrefixLength := !data.PrefixLength.IsNull() && !data.PrefixLength.IsUnknown()
+	if data.MinPrefixLength.IsNull() && data.MaxPrefixLength.IsNull() {
+		if !hasPrefixLength {
+			return 0, 0, errors.New("prefix_length, or min_prefix_length and max_prefix_length, must be set")
+		}
+		return data.PrefixLength.ValueInt64(), data.PrefixLength.ValueInt64(), nil
+	}
+	if data.MinPrefixLength.IsNull() || data.MaxPrefixLength.IsNull() {
+		return 0, 0, errors.New("min_prefix_length and max_prefix_length must be set together")
+	}
+	if hasPrefixLength {
+		return 0, 0, errors.New("prefix_length cannot be set with min_prefix_length and max_prefix_length, it is the prefix length chosen in their range")
+	}
+	low, high := data.MinPrefixLength.ValueInt64(), data.MaxPrefixLength.ValueInt64()
+	if low > high {
+		return 0, 0, fmt.Errorf("min_prefix_length (%d) must be <= max_prefix_length (%d)", low, high)
+	}
+	return low, high, nil
+}
+
+// requestedPrefix describes the prefix length requested by data in the error messages.
+func (data *networkRequestResourceModel) requestedPrefix() string {
+	if !data.MinPrefixLength.IsNull() && !data.MaxPrefixLength.IsNull() && (data.PrefixLength.IsNull() || data.PrefixLength.IsUnknown()) {
+		return fmt.Sprintf("a prefix between %d and %d", data.MinPrefixLength.ValueInt64(), data.MaxPrefixLength.ValueInt64())
+	}
+	return fmt.Sprintf("prefix %d", data.PrefixLength.ValueInt64())
+}
+
+// allocateLargestRequest reserves in networkConfig the subnets requested by data, with the shortest prefix length of
+// its range that still has room, which is set as the prefix_length of data. Without range, it is allocateRequest.
+func allocateLargestRequest(networkConfig *NetworkConfig, data *networkRequestResourceModel, baseCidr string) (string, error) {
+	if data.MinPrefixLength.IsNull() || data.MaxPrefixLength.IsNull() {
+		return allocateRequest(networkConfig, data, baseCidr)
+	}
+	var err error
+	for prefixLength := data.MinPrefixLength.ValueInt64(); prefixLength <= data.MaxPrefixLength.ValueInt64(); prefixLength++ {
+		candidate := *data
+		candidate.PrefixLength = types.Int64Value(prefixLength)
+		var netmask string
+		netmask, err = allocateRequest(networkConfig, &candidate, baseCidr)
+		if err == nil {
+			data.PrefixLength = candidate.PrefixLength
+			return netmask, nil
+		}
+		if !isExhaustedError(err) {
+			return "", err
+		}
+	}
+	return "", err
+}
+
+// allocateRequest reserves in networkConfig the subnets requested by data and returns the first one.
+func allocateRequest(networkConfig *NetworkConfig, data *networkRequestResourceModel, baseCidr string) (string, error) {
+	alignmentPrefix, err := data.allocationAlignment()
+	if err != nil {
+		return "", err
+	}
+	id := data.Id.ValueString()
+	netmask, err := allocateSubnet(networkConfig, id, data.PrefixLength.ValueInt64(), alignmentPrefix, baseCidr, data.Deterministic.ValueBool())
+	if err != nil {
+		return "", err
+	}
+	if count := data.SubnetCount.ValueInt64(); count > 1 {
+		if networkConfig.SubnetCounts == nil {
+			networkConfig.SubnetCounts = make(map[string]int)
+		}
+		networkConfig.SubnetCounts[id] = int(count)
+	}
+	return netmask, nil
+}
+
+// setReservation sets the attributes of data describing the reservation of its id in networkConfig.
+func (data *networkRequestResourceModel) setReservation(ctx context.Context, networkConfig *NetworkConfig) diag.Diagnostics {
+	var diags diag.Diagnostics
+	id := data.Id.ValueString()
+	netmask := networkConfig.Subnets[id]
+	netmasks, err := networkConfig.reservationSubnets(id)
+	if err != nil {
+		diags.AddError("network_request read error", err.Error())
+		return diags
+	}
+	exclusions, err := networkConfig.reservationExclusions(id, data.ExcludeGateway.ValueBool())
+	if err != nil {
+		diags.AddError("network_request read error", fmt.Sprintf("Cannot compute the excluded addresses of %s: %s", id, err.Error()))
+		return diags
+	}
+	data.Netmask = types.StringValue(netmask)
+	data.AlignmentPrefix = types.Int64Null()
+	data.SummaryCidr = types.StringNull()
+	data.SubnetCount = types.Int64Value(1)
+	// The states written before exclude_gateway, persist_exclusions and deterministic were introduced, or imported, have
+	// no value for them, an imported reservation keeps persisting its exclusions.
+	data.ExcludeGateway = types.BoolValue(data.ExcludeGateway.ValueBool())
+	data.Deterministic = types.BoolValue(data.Deterministic.ValueBool())
+	if data.PersistExclusions.IsNull() {
+		_, persisted := networkConfig.Exclusions[id]
+		data.PersistExclusions = types.BoolValue(persisted)
+	}
+	block, aligned := networkConfig.AlignedBlocks[id]
+	if count := networkConfig.SubnetCounts[id]; count > 1 && aligned {
+		data.SubnetCount = types.Int64Value(int64(count))
+		data.Summarizable = types.BoolValue(true)
+		data.SummaryCidr = types.StringValue(block)
+	} else {
+		if aligned {
+			if _, blockNet, err := net.ParseCIDR(block); err == nil {
+				alignmentPrefix, _ := blockNet.Mask.Size()
+				data.AlignmentPrefix = types.Int64Value(int64(alignmentPrefix))
+			}
+		}
+		// The states written before summarizable was introduced have no value for it.
+		data.Summarizable = types.BoolValue(data.Summarizable.ValueBool())
+		if data.Summarizable.ValueBool() {
+			data.SummaryCidr = types.StringValue(netmask)
+		}
+	}
+	var listDiags diag.Diagnostics
+	data.Netmasks, listDiags = types.ListValueFrom(ctx, types.StringType, netmasks)
+	diags.Append(listDiags...)
+	data.ExcludedAddresses, listDiags = types.ListValueFrom(ctx, types.StringType, exclusions)
+	diags.Append(listDiags...)
+	return diags
 }
 
 func (r *networkRequestResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
@@ -89,23 +442,173 @@ func (r *networkRequestResource) Create(ctx context.Context, req resource.Create
 	if resp.Diagnostics.HasError() {
 		return
 	}
-	gcpConnector := connector.NewNetwork(r.providerData.ReferentialBucket.ValueString(), data.BaseCidr.ValueString())
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	shortestPrefixLength, _, err := data.prefixLengthRange()
+	if err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("prefix_length"), "network_request creation error", fmt.Sprintf("Invalid prefix length requested for network_request %s: %s", data.Id.ValueString(), err.Error()))
+		return
+	}
+	if _, err := data.allocationAlignment(); err != nil {
+		resp.Diagnostics.AddAttributeError(path.Root("subnet_count"), "network_request creation error", fmt.Sprintf("Invalid subnets requested for network_request %s: %s", data.Id.ValueString(), err.Error()))
+		return
+	}
+	baseCidrs, diags := candidateBaseCidrs(ctx, data)
+	resp.Diagnostics.Append(diags...)
+	// Checked again for the values unknown at plan time.
+	for _, baseCidr := range baseCidrs {
+		resp.Diagnostics.Append(checkPrefixLength(shortestPrefixLength, types.StringValue(baseCidr))...)
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Each base_cidr is tried under its own lock, the next one only when it is full.
+	for _, baseCidr := range baseCidrs {
+		reserved := r.reserveInBaseCidr(ctx, p, baseCidr, &data, &resp.Diagnostics)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+		if !reserved {
+			tflog.Info(ctx, fmt.Sprintf("base_cidr %s is full for network_request %s", baseCidr, data.Id.ValueString()))
+			continue
+		}
+		data.BaseCidr = types.StringValue(baseCidr)
+
+		// Save data into Terraform state
+		resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+		return
+	}
+	resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Cannot find any available subnet with %s for network_request %s in %s", data.requestedPrefix(), data.Id.ValueString(), strings.Join(baseCidrs, ", ")))
+}
+
+// candidateBaseCidrs returns the base_cidrs to try in order, from either base_cidr or base_cidrs.
+// The base_cidrs must not overlap, so that a subnet is only ever reserved in one of them.
+func candidateBaseCidrs(ctx context.Context, data networkRequestResourceModel) ([]string, diag.Diagnostics) {
+	var diags diag.Diagnostics
+	hasBaseCidr := !data.BaseCidr.IsNull() && !data.BaseCidr.IsUnknown()
+	hasBaseCidrs := !data.BaseCidrs.IsNull() && !data.BaseCidrs.IsUnknown()
+	if hasBaseCidr == hasBaseCidrs {
+		diags.AddAttributeError(path.Root("base_cidrs"), "Invalid base_cidr configuration", "Exactly one of base_cidr or base_cidrs must be set")
+		return nil, diags
+	}
+	if hasBaseCidr {
+		return []string{data.BaseCidr.ValueString()}, diags
+	}
+	var baseCidrs []string
+	diags.Append(data.BaseCidrs.ElementsAs(ctx, &baseCidrs, false)...)
+	if diags.HasError() {
+		return nil, diags
+	}
+	if len(baseCidrs) == 0 {
+		diags.AddAttributeError(path.Root("base_cidrs"), "Invalid base_cidr configuration", "base_cidrs must contain at least one base_cidr")
+	}
+	for i, baseCidr := range baseCidrs {
+		if _, _, err := net.ParseCIDR(baseCidr); err != nil {
+			diags.AddAttributeError(path.Root("base_cidrs"), "Invalid base_cidr configuration", fmt.Sprintf("%q is not a cidr: %s", baseCidr, err.Error()))
+			continue
+		}
+		for _, other := range baseCidrs[:i] {
+			if subnetsOverlap(baseCidr, other) {
+				diags.AddAttributeError(path.Root("base_cidrs"), "Invalid base_cidr configuration", fmt.Sprintf("The base_cidrs %s and %s overlap", other, baseCidr))
+			}
+		}
+	}
+	return baseCidrs, diags
+}
+
+// checkPrefixLength checks that prefixLength is not shorter than the prefix of baseCidr, which holds no subnet of it.
+// A null, unknown or invalid baseCidr is left to the other checks.
+func checkPrefixLength(prefixLength int64, baseCidr types.String) diag.Diagnostics {
+	var diags diag.Diagnostics
+	if baseCidr.IsNull() || baseCidr.IsUnknown() {
+		return diags
+	}
+	_, ipNet, err := net.ParseCIDR(baseCidr.ValueString())
+	if err != nil {
+		return diags
+	}
+	if basePrefix, _ := ipNet.Mask.Size(); prefixLength < int64(basePrefix) {
+		diags.AddAttributeError(path.Root("prefix_length"), "Invalid prefix_length", fmt.Sprintf("prefix_length (%d) must be >= base_cidr prefix (%d)", prefixLength, basePrefix))
+	}
+	return diags
+}
+
+// ModifyPlan rejects at plan time a prefix_length shorter than the prefix of a base_cidr, instead of failing the
+// apply with no available subnet, and a prefix_length combined with a range of prefix lengths.
+func (r *networkRequestResource) ModifyPlan(ctx context.Context, req resource.ModifyPlanRequest, resp *resource.ModifyPlanResponse) {
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+	var data networkRequestResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	// The planned prefix_length is the one of the state once computed from the range, the configured one is checked.
+	resp.Diagnostics.Append(req.Config.GetAttribute(ctx, path.Root("prefix_length"), &data.PrefixLength)...)
+	if resp.Diagnostics.HasError() || data.PrefixLength.IsUnknown() || data.MinPrefixLength.IsUnknown() || data.MaxPrefixLength.IsUnknown() {
+		return
+	}
+	shortestPrefixLength, _, err := data.prefixLengthRange()
 	if err != nil {
-		resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Cannot acquire lock for base_cidr %s: %s", data.BaseCidr.ValueString(), err.Error()))
+		resp.Diagnostics.AddAttributeError(path.Root("prefix_length"), "Invalid prefix_length", err.Error())
+		return
+	}
+	baseCidrs := []types.String{data.BaseCidr}
+	if !data.BaseCidrs.IsNull() && !data.BaseCidrs.IsUnknown() {
+		resp.Diagnostics.Append(data.BaseCidrs.ElementsAs(ctx, &baseCidrs, false)...)
+	}
+	for _, baseCidr := range baseCidrs {
+		resp.Diagnostics.Append(checkPrefixLength(shortestPrefixLength, baseCidr)...)
+	}
+}
+
+// requiresReplaceIfAllocatedBaseCidrRemoved replaces the network_request only when base_cidrs no longer contains the supernet
+// the subnet was reserved in, so that adding a lower priority supernet keeps the current subnet.
+func requiresReplaceIfAllocatedBaseCidrRemoved(ctx context.Context, req planmodifier.ListRequest, resp *listplanmodifier.RequiresReplaceIfFuncResponse) {
+	var allocatedBaseCidr types.String
+	resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("base_cidr"), &allocatedBaseCidr)...)
+	if resp.Diagnostics.HasError() || allocatedBaseCidr.IsNull() || req.PlanValue.IsUnknown() {
+		return
+	}
+	if req.PlanValue.IsNull() {
+		// Back to the single base_cidr attribute, which replaces the network_request itself if it differs.
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock network config for %s, manual intervention may be required to remove lock file: %s", data.BaseCidr.ValueString(), err.Error()))
+	var baseCidrs []string
+	resp.Diagnostics.Append(req.PlanValue.ElementsAs(ctx, &baseCidrs, false)...)
+	for _, baseCidr := range baseCidrs {
+		if baseCidr == allocatedBaseCidr.ValueString() {
+			return
 		}
-	}()
+	}
+	resp.RequiresReplace = true
+}
+
+// reserveInBaseCidr reserves the subnets requested by data in the given base_cidr under its lock and sets the reservation
+// attributes of data. It returns false without error when the base_cidr has no room left for them.
+func (r *networkRequestResource) reserveInBaseCidr(ctx context.Context, p *GCSReferentialProviderModel, baseCidr string, data *networkRequestResourceModel, diags *diag.Diagnostics) bool {
+	gcpConnector := p.networkConnector(baseCidr)
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError("network_request creation error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for base_cidr %s: %s", baseCidr, err.Error()))
+		return false
+	}
+	defer releaseLock(ctx, &gcpConnector.GcpConnectorGeneric, lockId, fmt.Sprintf("network config for %s", baseCidr), diags)
 
 	var networkConfig NetworkConfig
 	err = gcpConnector.Read(ctx, &networkConfig)
 	if err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
-		resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Failed to read network config for %s: %s", data.BaseCidr.ValueString(), err.Error()))
-		return
+		diags.AddError("network_request creation error", objectDetail(&gcpConnector, "Failed to read network config for %s: %s", baseCidr, err.Error()))
+		return false
 	}
 
 	if networkConfig.Subnets == nil {
@@ -113,30 +616,110 @@ func (r *networkRequestResource) Create(ctx context.Context, req resource.Create
 	}
 
 	if _, contains := networkConfig.Subnets[data.Id.ValueString()]; contains {
-		resp.Diagnostics.AddError("network_request already exist with this id : %s, check your config or consider to import", data.Id.ValueString())
-		return
+		diags.AddError("network_request creation error", objectDetail(&gcpConnector, "network_request already exist with this id : %s, check your config or consider to import", data.Id.ValueString()))
+		return false
 	}
 
-	cidrCalc, err := cidrCalculator.New(&networkConfig.Subnets, int8(data.PrefixLength.ValueInt64()), gcpConnector.BaseCidrRange)
-	if err != nil {
-		resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Fail to get the subnet calculator for the network_request: %s", err.Error()))
-		return
+	if data.SkipFirstSubnet.ValueBool() && networkConfig.SkippedSubnet == "" {
+		skippedPrefixLength := data.PrefixLength.ValueInt64()
+		if !data.MaxPrefixLength.IsNull() {
+			skippedPrefixLength = data.MaxPrefixLength.ValueInt64()
+		}
+		networkConfig.SkippedSubnet, err = firstSubnet(gcpConnector.BaseCidrRange, skippedPrefixLength)
+		if err != nil {
+			diags.AddError("network_request creation error", fmt.Sprintf("Fail to compute the first subnet to skip for the network_request: %s", err.Error()))
+			return false
+		}
+	}
+
+	_, err = allocateLargestRequest(&networkConfig, data, gcpConnector.BaseCidrRange)
+	if isExhaustedError(err) {
+		return false
 	}
-	netmask, err := cidrCalc.GetNextNetmask()
 	if err != nil {
-		resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Cannot find any available subnet in %s with prefix %d: %s", gcpConnector.BaseCidrRange, data.PrefixLength.ValueInt64(), err.Error()))
-		return
+		if count := data.SubnetCount.ValueInt64(); count > 1 {
+			diags.AddError("network_request creation error", objectDetail(&gcpConnector, "Cannot find any aligned block of %d subnets in %s with %s for network_request %s: %s", count, gcpConnector.BaseCidrRange, data.requestedPrefix(), data.Id.ValueString(), err.Error()))
+		} else {
+			diags.AddError("network_request creation error", objectDetail(&gcpConnector, "Cannot find any available subnet in %s with %s for network_request %s: %s", gcpConnector.BaseCidrRange, data.requestedPrefix(), data.Id.ValueString(), err.Error()))
+		}
+		return false
+	}
+	if err := networkConfig.persistExclusions(data.Id.ValueString(), data.PersistExclusions.ValueBool(), data.ExcludeGateway.ValueBool()); err != nil {
+		diags.AddError("network_request creation error", fmt.Sprintf("Cannot compute the excluded addresses of network_request %s: %s", data.Id.ValueString(), err.Error()))
+		return false
+	}
+	if err := p.approveAllocation(ctx, p.subnetProposal(&networkConfig, gcpConnector.BaseCidrRange, data.Id.ValueString())); err != nil {
+		diags.AddError("network_request creation error", fmt.Sprintf("Cannot reserve the subnet %s of %s for network_request %s: %s", networkConfig.Subnets[data.Id.ValueString()], gcpConnector.BaseCidrRange, data.Id.ValueString(), err.Error()))
+		return false
 	}
-	networkConfig.Subnets[data.Id.ValueString()] = netmask
 	err = gcpConnector.Write(ctx, &networkConfig)
 	if err != nil {
-		resp.Diagnostics.AddError("network_request creation error", fmt.Sprintf("Cannot write network config for %s in %s: %s", gcpConnector.BaseCidrRange, r.providerData.ReferentialBucket.ValueString(), err.Error()))
+		addNetworkConfigWriteError(diags, "network_request creation error", &gcpConnector, data.Id.ValueString(), err)
+		return false
+	}
+	if data.VerifyAllocation.ValueBool() {
+		networkConfig, err = r.verifyAllocation(ctx, p, &gcpConnector, *data)
+		if errors.Is(err, connector.ErrGenerationConflict) {
+			addNetworkConfigWriteError(diags, "network_request creation error", &gcpConnector, data.Id.ValueString(), err)
+			return false
+		}
+		if err != nil {
+			diags.AddError("network_request creation error", objectDetail(&gcpConnector, "Cannot verify the reservation of %s in %s: %s", data.Id.ValueString(), gcpConnector.BaseCidrRange, err.Error()))
+			return false
+		}
+	}
+	recordAllocations(ctx, p, allocationKindSubnet, 1, diags)
+	diags.Append(data.setReservation(ctx, &networkConfig)...)
+	data.ContentHash = types.StringValue(gcpConnector.ContentHash)
+	return true
+}
+
+// addNetworkConfigWriteError adds the diagnostic of a failed write of the network config of gcpConnector for the
+// network_request id. As with addPoolWriteError, a generation conflict gets its own message since running the apply
+// again is safe.
+func addNetworkConfigWriteError(diags *diag.Diagnostics, summary string, gcpConnector *connector.GcpConnectorNetwork, id string, err error) {
+	if errors.Is(err, connector.ErrGenerationConflict) {
+		diags.AddError(summary, objectDetail(gcpConnector, "network config for %s was modified concurrently (generation conflict) while writing network_request %s; re-run apply", gcpConnector.BaseCidrRange, id))
 		return
 	}
-	data.Netmask = types.StringValue(netmask)
+	diags.AddError(summary, objectDetail(gcpConnector, "Cannot write network config for %s for network_request %s: %s", gcpConnector.BaseCidrRange, id, err.Error()))
+}
 
-	// Save data into Terraform state
-	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+// verifyAllocation reads the network config again after the reservation of data was written, and allocates
+// other subnets while the reserved ones overlap the reservation of another id. It returns the verified network config.
+func (r *networkRequestResource) verifyAllocation(ctx context.Context, p *GCSReferentialProviderModel, gcpConnector *connector.GcpConnectorNetwork, data networkRequestResourceModel) (NetworkConfig, error) {
+	id := data.Id.ValueString()
+	for attempt := 0; ; attempt++ {
+		var networkConfig NetworkConfig
+		if err := gcpConnector.Read(ctx, &networkConfig); err != nil {
+			return NetworkConfig{}, err
+		}
+		if _, ok := networkConfig.Subnets[id]; !ok {
+			return NetworkConfig{}, fmt.Errorf("the reservation of %s was removed from the network config", id)
+		}
+		colliding := collidingReservations(&networkConfig, id)
+		if len(colliding) == 0 {
+			return networkConfig, nil
+		}
+		reserved := networkConfig.reservedArea(id)
+		if attempt == maxAllocationVerifications {
+			return NetworkConfig{}, fmt.Errorf("the subnet %s still overlaps the reservation of %s after %d attempts", reserved, strings.Join(colliding, ", "), attempt)
+		}
+		tflog.Warn(ctx, fmt.Sprintf("Subnet %s reserved for %s overlaps the reservation of %s, allocating another one", reserved, id, strings.Join(colliding, ", ")))
+		releaseSubnet(&networkConfig, id)
+		if _, err := allocateRequest(&networkConfig, &data, gcpConnector.BaseCidrRange); err != nil {
+			return NetworkConfig{}, err
+		}
+		if err := networkConfig.persistExclusions(id, data.PersistExclusions.ValueBool(), data.ExcludeGateway.ValueBool()); err != nil {
+			return NetworkConfig{}, err
+		}
+		if err := p.approveAllocation(ctx, p.subnetProposal(&networkConfig, gcpConnector.BaseCidrRange, id)); err != nil {
+			return NetworkConfig{}, err
+		}
+		if err := gcpConnector.Write(ctx, &networkConfig); err != nil {
+			return NetworkConfig{}, err
+		}
+	}
 }
 
 func (r *networkRequestResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
@@ -146,7 +729,19 @@ func (r *networkRequestResource) Read(ctx context.Context, req resource.ReadRequ
 		return
 	}
 
-	gcpConnector := connector.NewNetwork(r.providerData.ReferentialBucket.ValueString(), data.BaseCidr.ValueString())
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Read)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gcpConnector := p.networkConnector(data.BaseCidr.ValueString())
 	var networkConfig NetworkConfig
 	err := gcpConnector.Read(ctx, &networkConfig)
 	if err != nil {
@@ -154,18 +749,18 @@ func (r *networkRequestResource) Read(ctx context.Context, req resource.ReadRequ
 			tflog.Warn(ctx, fmt.Sprintf("Network config for %s not found, removing resource from state", data.BaseCidr.ValueString()))
 			resp.State.RemoveResource(ctx)
 		} else {
-			resp.Diagnostics.AddError("network_request read error", fmt.Sprintf("Cannot Read %s in %s: %s", gcpConnector.BaseCidrRange, r.providerData.ReferentialBucket.ValueString(), err.Error()))
+			resp.Diagnostics.AddError("network_request read error", objectDetail(&gcpConnector, "Cannot read network config for %s: %s", gcpConnector.BaseCidrRange, err.Error()))
 		}
 		return
 	}
 
-	reservedSubnet, contains := networkConfig.Subnets[data.Id.ValueString()]
-	if !contains {
+	if _, contains := networkConfig.Subnets[data.Id.ValueString()]; !contains {
 		tflog.Warn(ctx, fmt.Sprintf("Network request %s not found in %s, removing resource from state", data.Id.ValueString(), data.BaseCidr.ValueString()))
 		resp.State.RemoveResource(ctx)
 		return
 	}
-	data.Netmask = types.StringValue(reservedSubnet)
+	resp.Diagnostics.Append(data.setReservation(ctx, &networkConfig)...)
+	data.ContentHash = types.StringValue(gcpConnector.ContentHash)
 
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
@@ -173,31 +768,102 @@ func (r *networkRequestResource) Read(ctx context.Context, req resource.ReadRequ
 
 func (r *networkRequestResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
 	var data networkRequestResourceModel
+	var newData networkRequestResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &newData)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
+	// The settings that only drive the operations are taken from the plan, the reservation is left unchanged.
+	data.VerifyAllocation = newData.VerifyAllocation
+	data.VerifyRelease = newData.VerifyRelease
+	data.BaseCidrs = newData.BaseCidrs
+	data.Timeouts = newData.Timeouts
+	data.Deterministic = newData.Deterministic
+	if data.ExcludeGateway.ValueBool() != newData.ExcludeGateway.ValueBool() || data.PersistExclusions.ValueBool() != newData.PersistExclusions.ValueBool() {
+		data.ExcludeGateway = newData.ExcludeGateway
+		data.PersistExclusions = newData.PersistExclusions
+		r.updateExclusions(ctx, &data, &resp.Diagnostics)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
 	// Save data into Terraform state
 	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
 }
 
+// updateExclusions computes the excluded_addresses of data again after exclude_gateway or persist_exclusions changed,
+// and writes them to, or removes them from, the exclusions of the network config. The reservation is left unchanged.
+func (r *networkRequestResource) updateExclusions(ctx context.Context, data *networkRequestResourceModel, diags *diag.Diagnostics) {
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Update)
+	defer cancel()
+	diags.Append(timeoutDiags...)
+	if diags.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	diags.Append(referentialDiags...)
+	if diags.HasError() {
+		return
+	}
+	gcpConnector := p.networkConnector(data.BaseCidr.ValueString())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError("network_request update error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for base_cidr %s to update network_request %s: %s", data.BaseCidr.ValueString(), data.Id.ValueString(), err.Error()))
+		return
+	}
+	defer releaseLock(ctx, &gcpConnector.GcpConnectorGeneric, lockId, fmt.Sprintf("network config for %s", data.BaseCidr.ValueString()), diags)
+
+	var networkConfig NetworkConfig
+	if err := gcpConnector.Read(ctx, &networkConfig); err != nil {
+		diags.AddError("network_request update error", objectDetail(&gcpConnector, "Cannot read network config for %s to update network_request %s: %s", gcpConnector.BaseCidrRange, data.Id.ValueString(), err.Error()))
+		return
+	}
+	id := data.Id.ValueString()
+	if _, contains := networkConfig.Subnets[id]; !contains {
+		diags.AddError("network_request update error", objectDetail(&gcpConnector, "network_request %s is no longer reserved in %s, refresh the state to reserve it again", id, gcpConnector.BaseCidrRange))
+		return
+	}
+	persisted, wasPersisted := networkConfig.Exclusions[id]
+	if err := networkConfig.persistExclusions(id, data.PersistExclusions.ValueBool(), data.ExcludeGateway.ValueBool()); err != nil {
+		diags.AddError("network_request update error", fmt.Sprintf("Cannot compute the excluded addresses of network_request %s: %s", id, err.Error()))
+		return
+	}
+	if exclusions, persist := networkConfig.Exclusions[id]; persist != wasPersisted || !slices.Equal(exclusions, persisted) {
+		if err := gcpConnector.Write(ctx, &networkConfig); err != nil {
+			addNetworkConfigWriteError(diags, "network_request update error", &gcpConnector, id, err)
+			return
+		}
+	}
+	diags.Append(data.setReservation(ctx, &networkConfig)...)
+	data.ContentHash = types.StringValue(gcpConnector.ContentHash)
+}
+
 func (r *networkRequestResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
 	var data networkRequestResourceModel
 	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
-	gcpConnector := connector.NewNetwork(r.providerData.ReferentialBucket.ValueString(), data.BaseCidr.ValueString())
-	lockId, err := gcpConnector.WaitForlock(ctx, time.Minute*time.Duration(r.providerData.TimeoutInMinutes.ValueInt32()), r.providerData.BackoffMultiplier.ValueFloat32())
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Delete)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	p, referentialDiags := r.providerData.referential(data.Referential)
+	resp.Diagnostics.Append(referentialDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	gcpConnector := p.networkConnector(data.BaseCidr.ValueString())
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
 	if err != nil {
-		resp.Diagnostics.AddError("network_request delete error", fmt.Sprintf("Cannot acquire lock for base_cidr %s: %s", data.BaseCidr.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("network_request delete error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for base_cidr %s to delete network_request %s: %s", data.BaseCidr.ValueString(), data.Id.ValueString(), err.Error()))
 		return
 	}
-	defer func() {
-		if err := gcpConnector.Unlock(ctx, lockId); err != nil {
-			tflog.Warn(ctx, fmt.Sprintf("Failed to unlock network config for %s, manual intervention may be required to remove lock file: %s", data.BaseCidr.ValueString(), err.Error()))
-		}
-	}()
+	defer releaseLock(ctx, &gcpConnector.GcpConnectorGeneric, lockId, fmt.Sprintf("network config for %s", data.BaseCidr.ValueString()), &resp.Diagnostics)
 
 	var networkConfig NetworkConfig
 	err = gcpConnector.Read(ctx, &networkConfig)
@@ -206,7 +872,7 @@ func (r *networkRequestResource) Delete(ctx context.Context, req resource.Delete
 			// File doesn't exist, so the reservation is already gone.
 			return
 		}
-		resp.Diagnostics.AddError("network_request delete error", fmt.Sprintf("Cannot Read %s in %s: %s", gcpConnector.BaseCidrRange, r.providerData.ReferentialBucket.ValueString(), err.Error()))
+		resp.Diagnostics.AddError("network_request delete error", objectDetail(&gcpConnector, "Cannot read network config for %s to delete network_request %s: %s", gcpConnector.BaseCidrRange, data.Id.ValueString(), err.Error()))
 		return
 	}
 
@@ -215,12 +881,37 @@ func (r *networkRequestResource) Delete(ctx context.Context, req resource.Delete
 		// Reservation doesn't exist, nothing to do.
 		return
 	}
-	delete(networkConfig.Subnets, data.Id.ValueString())
+	releaseSubnet(&networkConfig, data.Id.ValueString())
+	if p.CleanupEmpty.ValueBool() && networkConfig.isEmpty() {
+		// The last reservation is gone, the next network_request on the base_cidr creates the object again.
+		if err := gcpConnector.DeleteIfUnchanged(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+			resp.Diagnostics.AddError("network_request delete error", objectDetail(&gcpConnector, "Cannot delete the empty network config for %s after deleting network_request %s: %s", gcpConnector.BaseCidrRange, data.Id.ValueString(), err.Error()))
+		}
+		return
+	}
 	err = gcpConnector.Write(ctx, &networkConfig)
 	if err != nil {
-		resp.Diagnostics.AddError("network_request delete error", fmt.Sprintf("Cannot Write %s in %s: %s", gcpConnector.BaseCidrRange, r.providerData.ReferentialBucket.ValueString(), err.Error()))
+		addNetworkConfigWriteError(&resp.Diagnostics, "network_request delete error", &gcpConnector, data.Id.ValueString(), err)
 		return
 	}
+	if data.VerifyRelease.ValueBool() {
+		if err := r.verifyRelease(ctx, &gcpConnector, data); err != nil {
+			resp.Diagnostics.AddError("network_request delete error", objectDetail(&gcpConnector, "Cannot verify the release of network_request %s in %s: %s", data.Id.ValueString(), gcpConnector.BaseCidrRange, err.Error()))
+		}
+	}
+}
+
+// verifyRelease reads the network config again after the reservation of data was deleted, and fails when the
+// reservation is still there, written back by another writer.
+func (r *networkRequestResource) verifyRelease(ctx context.Context, gcpConnector *connector.GcpConnectorNetwork, data networkRequestResourceModel) error {
+	var networkConfig NetworkConfig
+	if err := gcpConnector.Read(ctx, &networkConfig); err != nil {
+		return err
+	}
+	if netmask, ok := networkConfig.Subnets[data.Id.ValueString()]; ok {
+		return fmt.Errorf("the reservation of %s (%s) reappeared in the network config, another run may write it concurrently under a broken lock", data.Id.ValueString(), netmask)
+	}
+	return nil
 }
 
 func (r *networkRequestResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
@@ -0,0 +1,208 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64default"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/int64planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/planmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema/stringplanmodifier"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// Ensure provider defined types fully satisfy framework interfaces.
+var _ resource.Resource = &SequenceResource{}
+var _ resource.ResourceWithImportState = &SequenceResource{}
+
+const sequenceResourceName = "sequence"
+
+func NewSequenceResource() resource.Resource {
+	return &SequenceResource{}
+}
+
+type SequenceResource struct {
+	providerData *GCSReferentialProviderModel
+}
+
+type SequenceResourceModel struct {
+	Id        types.String   `tfsdk:"id"`
+	Name      types.String   `tfsdk:"name"`
+	StartFrom types.Int64    `tfsdk:"start_from"`
+	Value     types.Int64    `tfsdk:"value"`
+	Timeouts  timeouts.Value `tfsdk:"timeouts"`
+}
+
+// StoredSequence is the JSON document stored in the referential bucket for a sequence.
+type StoredSequence struct {
+	// Next is the value the next reservation of the sequence gets.
+	Next int64 `json:"next"`
+}
+
+func (r *SequenceResource) Metadata(ctx context.Context, req resource.MetadataRequest, resp *resource.MetadataResponse) {
+	resp.TypeName = req.ProviderTypeName + "_" + sequenceResourceName
+}
+
+func (r *SequenceResource) Schema(ctx context.Context, req resource.SchemaRequest, resp *resource.SchemaResponse) {
+	resp.Schema = schema.Schema{
+		MarkdownDescription: "This resource allow you to reserve the next value of a monotonic counter, for example a build number, a much simpler primitive than id_pool. " +
+			"Every sequence resource with the same name reserves a new value of the same counter, destroying it never gives its value back",
+		Attributes: map[string]schema.Attribute{
+			"id": schema.StringAttribute{
+				MarkdownDescription: "The terraform id of the resource, `<name>/<value>`",
+				Computed:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.UseStateForUnknown(),
+				},
+			},
+			"name": schema.StringAttribute{
+				MarkdownDescription: "The name of the sequence to reserve a value of, it is created by its first reservation. If you change it, the sequence resource will be destroyed and recreate with a value of the new sequence",
+				Required:            true,
+				PlanModifiers: []planmodifier.String{
+					stringplanmodifier.RequiresReplace(),
+				},
+			},
+			"start_from": schema.Int64Attribute{
+				MarkdownDescription: "The first value of the sequence, if you not set it it will be set to 1. It is only used by the reservation that creates the sequence",
+				Optional:            true,
+				Default:             int64default.StaticInt64(1),
+				Computed:            true,
+			},
+			"value": schema.Int64Attribute{
+				MarkdownDescription: "The value reserved from the sequence, it is a readonly field",
+				Computed:            true,
+				PlanModifiers: []planmodifier.Int64{
+					int64planmodifier.UseStateForUnknown(),
+				},
+			},
+		},
+		Blocks: map[string]schema.Block{
+			"timeouts": timeoutsBlock(ctx),
+		},
+	}
+}
+
+func (r *SequenceResource) Configure(ctx context.Context, req resource.ConfigureRequest, resp *resource.ConfigureResponse) {
+	// Prevent panic if the provider has not been configured.
+	if req.ProviderData == nil {
+		return
+	}
+	providerData, ok := req.ProviderData.(*GCSReferentialProviderModel)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected Resource Configure Type", fmt.Sprintf("Expected *GCSReferentialProviderModel, got: %T. Please report this issue to the provider developers.", req.ProviderData))
+		return
+	}
+	r.providerData = providerData
+}
+
+func (r *SequenceResource) Create(ctx context.Context, req resource.CreateRequest, resp *resource.CreateResponse) {
+	var data SequenceResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	ctx, cancel, timeoutDiags := withOperationTimeout(ctx, data.Timeouts.Create)
+	defer cancel()
+	resp.Diagnostics.Append(timeoutDiags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	value := reserveSequenceValue(ctx, r.providerData, data.Name.ValueString(), data.StartFrom.ValueInt64(), &resp.Diagnostics)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	data.Value = types.Int64Value(value)
+	data.Id = types.StringValue(fmt.Sprintf("%s/%d", data.Name.ValueString(), value))
+
+	// Save data into Terraform state
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// reserveSequenceValue returns the next value of the given sequence and increments it under lock,
+// the sequence is created from startFrom when it does not exist yet.
+func reserveSequenceValue(ctx context.Context, p *GCSReferentialProviderModel, sequenceName string, startFrom int64, diags *diag.Diagnostics) int64 {
+	gcpConnector := p.genericConnector(p.sequencePath(sequenceName))
+	lockId, err := gcpConnector.WaitForlock(ctx, lockWaitTimeout(ctx, p), p.BackoffMultiplier.ValueFloat32())
+	if err != nil {
+		diags.AddError("sequence creation error", lockDetail(ctx, &gcpConnector, "Cannot acquire lock for sequence %s: %s", sequenceName, err.Error()))
+		return 0
+	}
+	defer releaseLock(ctx, &gcpConnector, lockId, fmt.Sprintf("sequence %s", sequenceName), diags)
+
+	var sequence StoredSequence
+	err = gcpConnector.Read(ctx, &sequence)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		// The connector's generation is -1, the write creates the sequence only if nobody else did.
+		sequence.Next = startFrom
+	} else if err != nil {
+		diags.AddError("sequence creation error", objectDetail(&gcpConnector, "Cannot read sequence %s: %s", sequenceName, err.Error()))
+		return 0
+	}
+	if sequence.Next == math.MaxInt64 {
+		diags.AddError("sequence creation error", fmt.Sprintf("The sequence %s is exhausted", sequenceName))
+		return 0
+	}
+	value := sequence.Next
+	sequence.Next++
+	if err := gcpConnector.Write(ctx, &sequence); err != nil {
+		diags.AddError("sequence creation error", objectDetail(&gcpConnector, "Cannot save sequence %s on referential_bucket: %s", sequenceName, err.Error()))
+		return 0
+	}
+	return value
+}
+
+// Read keeps the state as is: the reserved values are not stored in the sequence object, which only holds the next one.
+func (r *SequenceResource) Read(ctx context.Context, req resource.ReadRequest, resp *resource.ReadResponse) {
+	var data SequenceResourceModel
+	resp.Diagnostics.Append(req.State.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// Update only records the new start_from, which does not apply to an existing sequence.
+func (r *SequenceResource) Update(ctx context.Context, req resource.UpdateRequest, resp *resource.UpdateResponse) {
+	var data SequenceResourceModel
+	resp.Diagnostics.Append(req.Plan.Get(ctx, &data)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	resp.Diagnostics.Append(resp.State.Set(ctx, &data)...)
+}
+
+// Delete does not give the value back to the sequence, it only moves forward.
+func (r *SequenceResource) Delete(ctx context.Context, req resource.DeleteRequest, resp *resource.DeleteResponse) {
+}
+
+func (r *SequenceResource) ImportState(ctx context.Context, req resource.ImportStateRequest, resp *resource.ImportStateResponse) {
+	separator := strings.LastIndex(req.ID, "/")
+	var value int64
+	var err error
+	if separator > 0 {
+		value, err = strconv.ParseInt(req.ID[separator+1:], 10, 64)
+	}
+	if separator <= 0 || err != nil {
+		resp.Diagnostics.AddError(
+			"Unexpected Import Identifier",
+			fmt.Sprintf("Expected import identifier with format: sequence_name/value. Got: %q", req.ID),
+		)
+		return
+	}
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("id"), req.ID)...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("name"), req.ID[:separator])...)
+	resp.Diagnostics.Append(resp.State.SetAttribute(ctx, path.Root("value"), value)...)
+}
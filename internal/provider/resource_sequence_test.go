@@ -0,0 +1,47 @@
+package provider
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/terraform-provider-gcsreferential/internal/gcstest"
+)
+
+func TestReserveSequenceValue(t *testing.T) {
+	gcstest.NewServer(t)
+	p := newTestProviderData()
+	ctx := context.Background()
+
+	values := make([]int64, 5)
+	var wg sync.WaitGroup
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var diags diag.Diagnostics
+			values[i] = reserveSequenceValue(ctx, p, "build", 100, &diags)
+			if diags.HasError() {
+				t.Error(diags)
+			}
+		}(i)
+	}
+	wg.Wait()
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	for i, value := range values {
+		if value != int64(100+i) {
+			t.Fatalf("expected the concurrent reservations to get consecutive values from 100, got %v", values)
+		}
+	}
+
+	// start_from only applies to the reservation that creates the sequence.
+	var diags diag.Diagnostics
+	if value := reserveSequenceValue(ctx, p, "build", 1, &diags); value != 105 || diags.HasError() {
+		t.Fatalf("expected 105, got %d: %v", value, diags)
+	}
+	if value := reserveSequenceValue(ctx, p, "other", 1, &diags); value != 1 || diags.HasError() {
+		t.Fatalf("expected another sequence to start from 1, got %d: %v", value, diags)
+	}
+}
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/resource/schema"
+)
+
+// timeoutsBlock returns the standard timeouts block of terraform-plugin-framework-timeouts. An operation without
+// timeout has no deadline, its lock being waited for up to the timeout_in_minutes of the provider.
+func timeoutsBlock(ctx context.Context) schema.Block {
+	description := func(operation string) string {
+		return fmt.Sprintf("The deadline of the %s operation, as a duration such as \"30s\" or \"10m\". The lock is waited for at most three quarters of it, the rest being left to the operation. "+
+			"Default to no deadline, the lock being waited for up to the timeout_in_minutes of the provider", operation)
+	}
+	block := timeouts.Block(ctx, timeouts.Opts{
+		Create:            true,
+		Read:              true,
+		Update:            true,
+		Delete:            true,
+		CreateDescription: description("create"),
+		ReadDescription:   description("read"),
+		UpdateDescription: description("update"),
+		DeleteDescription: description("delete"),
+	}).(schema.SingleNestedBlock)
+	block.MarkdownDescription = "The timeouts of the operations of the resource"
+	return block
+}
+
+// withOperationTimeout returns a context bounded by the timeout given by operation, one of the getters of
+// timeouts.Value such as its Create method. Without timeout set, the context has no deadline.
+func withOperationTimeout(ctx context.Context, operation func(context.Context, time.Duration) (time.Duration, diag.Diagnostics)) (context.Context, context.CancelFunc, diag.Diagnostics) {
+	timeout, diags := operation(ctx, 0)
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(ctx)
+		return ctx, cancel, diags
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, cancel, diags
+}
+
+// lockWaitTimeout returns how long a lock may be waited for: three quarters of the time left before the deadline of
+// the operation, so that it still has time to run and release the lock once acquired, or the provider default.
+func lockWaitTimeout(ctx context.Context, p *GCSReferentialProviderModel) time.Duration {
+	if deadline, ok := ctx.Deadline(); ok {
+		return time.Until(deadline) * 3 / 4
+	}
+	return time.Minute * time.Duration(p.TimeoutInMinutes.ValueInt32())
+}
@@ -0,0 +1,55 @@
+package provider
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-framework-timeouts/resource/timeouts"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func TestWithOperationTimeout(t *testing.T) {
+	ctx := context.Background()
+	p := newTestProviderData()
+	value := timeouts.Value{Object: types.ObjectValueMust(nullTimeouts.AttributeTypes(ctx), map[string]attr.Value{
+		"create": types.StringValue("90s"),
+		"read":   types.StringNull(),
+		"update": types.StringValue("soon"),
+		"delete": types.StringNull(),
+	})}
+
+	createCtx, cancel, diags := withOperationTimeout(ctx, value.Create)
+	defer cancel()
+	if diags.HasError() {
+		t.Fatalf("unexpected error: %v", diags)
+	}
+	if deadline, ok := createCtx.Deadline(); !ok || time.Until(deadline) > 90*time.Second {
+		t.Errorf("expected the create timeout of the block as deadline, got %s", time.Until(deadline))
+	}
+	if wait := lockWaitTimeout(createCtx, p); wait <= 60*time.Second || wait > 68*time.Second {
+		t.Errorf("expected the lock wait to end before the create deadline, got %s", wait)
+	}
+
+	for name, operation := range map[string]func(context.Context, time.Duration) (time.Duration, diag.Diagnostics){"read": value.Read, "unset block": nullTimeouts.Delete} {
+		readCtx, cancel, diags := withOperationTimeout(ctx, operation)
+		defer cancel()
+		if diags.HasError() {
+			t.Fatalf("%s: unexpected error: %v", name, diags)
+		}
+		if _, ok := readCtx.Deadline(); ok {
+			t.Errorf("%s: expected no deadline without timeout", name)
+		}
+		if wait := lockWaitTimeout(readCtx, p); wait != time.Minute {
+			t.Errorf("%s: expected the lock wait of the provider without timeout, got %s", name, wait)
+		}
+	}
+
+	_, cancel, diags = withOperationTimeout(ctx, value.Update)
+	cancel()
+	if !diags.HasError() {
+		t.Error("expected an error for an invalid duration")
+	}
+}